@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"usermanagement/config"
+	"usermanagement/controllers"
+	"usermanagement/data"
+	"usermanagement/pipeline"
+	"usermanagement/router"
+	"usermanagement/scheduler"
+	"usermanagement/trash"
+	"usermanagement/webhook"
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flag.Parse()
+
+	if err := run(*configPath); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(configPath string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return err
+	}
+	if !cfg.Features.DebugMode {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	repos, err := data.InitMongoDB(ctx, cfg.Mongo, cfg.Phone)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout.Duration)
+		defer cancel()
+		if err := data.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting from MongoDB: %v", err)
+		}
+	}()
+
+	if err := controllers.FailAbandonedImports(ctx, repos.Imports); err != nil {
+		log.Printf("Error failing abandoned import jobs: %v", err)
+	}
+
+	var background sync.WaitGroup
+	defer background.Wait()
+	if cfg.Features.FollowupScheduler {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			scheduler.New(repos, cfg.Scheduler).Run(ctx)
+		}()
+	}
+	if cfg.Features.TrashPurge {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			trash.NewPurger(repos, cfg.Trash).Run(ctx)
+		}()
+	}
+	if cfg.Features.Webhooks {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			webhook.NewDispatcher(repos, cfg.Webhooks).Run(ctx)
+		}()
+	}
+
+	machine, err := pipeline.NewMachine(cfg.Pipeline.Transitions)
+	if err != nil {
+		return err
+	}
+
+	// live update streams are ended as soon as shutdown starts, since
+	// draining waits for every request to finish
+	streams, stopStreams := context.WithCancel(context.Background())
+	defer stopStreams()
+	srv := &http.Server{
+		Addr:         cfg.Server.Addr,
+		Handler:      router.InitRouter(ctx, &background, cfg, repos, machine),
+		ReadTimeout:  cfg.Server.ReadTimeout.Duration,
+		WriteTimeout: cfg.Server.WriteTimeout.Duration,
+		IdleTimeout:  cfg.Server.IdleTimeout.Duration,
+		BaseContext: func(net.Listener) context.Context {
+			return controllers.WithShutdown(context.Background(), streams.Done())
+		},
+	}
+	srv.RegisterOnShutdown(stopStreams)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", cfg.Server.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+		close(serveErr)
+	}()
+
+	select {
+	case err := <-serveErr:
+		stop()
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down, draining in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout.Duration)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
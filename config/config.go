@@ -0,0 +1,436 @@
+// Package config builds the application configuration from defaults, an
+// optional YAML or TOML file and environment variables, in that order.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v3"
+	"usermanagement/integrity"
+	"usermanagement/phone"
+	"usermanagement/pipeline"
+)
+
+// Config is the complete, validated application configuration
+type Config struct {
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Mongo     MongoConfig     `yaml:"mongo" toml:"mongo"`
+	Features  FeatureConfig   `yaml:"features" toml:"features"`
+	Scheduler SchedulerConfig `yaml:"scheduler" toml:"scheduler"`
+	Pipeline  PipelineConfig  `yaml:"pipeline" toml:"pipeline"`
+	Calendar  CalendarConfig  `yaml:"calendar" toml:"calendar"`
+	Phone     PhoneConfig     `yaml:"phone" toml:"phone"`
+	Integrity IntegrityConfig `yaml:"integrity" toml:"integrity"`
+	Trash     TrashConfig     `yaml:"trash" toml:"trash"`
+	Webhooks  WebhookConfig   `yaml:"webhooks" toml:"webhooks"`
+	Stream    StreamConfig    `yaml:"stream" toml:"stream"`
+}
+
+// ServerConfig controls the HTTP listener
+type ServerConfig struct {
+	Addr            string   `yaml:"addr" toml:"addr" env:"LISTEN_ADDR"`
+	ReadTimeout     Duration `yaml:"read_timeout" toml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout    Duration `yaml:"write_timeout" toml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout     Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+}
+
+// MongoConfig controls the MongoDB connection
+type MongoConfig struct {
+	URI            string      `yaml:"uri" toml:"uri" env:"MONGODB_URI"`
+	Database       string      `yaml:"database" toml:"database" env:"MONGODB_DATABASE"`
+	Collections    Collections `yaml:"collections" toml:"collections"`
+	MinPoolSize    uint64      `yaml:"min_pool_size" toml:"min_pool_size" env:"MONGODB_MIN_POOL_SIZE"`
+	MaxPoolSize    uint64      `yaml:"max_pool_size" toml:"max_pool_size" env:"MONGODB_MAX_POOL_SIZE"`
+	ConnectTimeout Duration    `yaml:"connect_timeout" toml:"connect_timeout" env:"MONGODB_CONNECT_TIMEOUT"`
+	// QueryTimeout bounds the database work of each API request
+	QueryTimeout Duration `yaml:"query_timeout" toml:"query_timeout" env:"MONGODB_QUERY_TIMEOUT"`
+}
+
+// Collections names the collection backing each resource
+type Collections struct {
+	Users       string `yaml:"users" toml:"users" env:"MONGODB_USERS_COLLECTION"`
+	Emojis      string `yaml:"emojis" toml:"emojis" env:"MONGODB_EMOJIS_COLLECTION"`
+	Contacts    string `yaml:"contacts" toml:"contacts" env:"MONGODB_CONTACTS_COLLECTION"`
+	Businesses  string `yaml:"businesses" toml:"businesses" env:"MONGODB_BUSINESSES_COLLECTION"`
+	Calls       string `yaml:"calls" toml:"calls" env:"MONGODB_CALLS_COLLECTION"`
+	Activities  string `yaml:"activities" toml:"activities" env:"MONGODB_ACTIVITIES_COLLECTION"`
+	Followups   string `yaml:"followups" toml:"followups" env:"MONGODB_FOLLOWUPS_COLLECTION"`
+	Transitions string `yaml:"transitions" toml:"transitions" env:"MONGODB_TRANSITIONS_COLLECTION"`
+	Imports     string `yaml:"imports" toml:"imports" env:"MONGODB_IMPORTS_COLLECTION"`
+	Locks       string `yaml:"locks" toml:"locks" env:"MONGODB_LOCKS_COLLECTION"`
+	Trash       string `yaml:"trash" toml:"trash" env:"MONGODB_TRASH_COLLECTION"`
+	History     string `yaml:"history" toml:"history" env:"MONGODB_HISTORY_COLLECTION"`
+	Webhooks    string `yaml:"webhooks" toml:"webhooks" env:"MONGODB_WEBHOOKS_COLLECTION"`
+	Outbox      string `yaml:"outbox" toml:"outbox" env:"MONGODB_OUTBOX_COLLECTION"`
+	Deliveries  string `yaml:"deliveries" toml:"deliveries" env:"MONGODB_DELIVERIES_COLLECTION"`
+	// StreamTokens holds the points live update streams can resume from
+	StreamTokens string `yaml:"stream_tokens" toml:"stream_tokens" env:"MONGODB_STREAM_TOKENS_COLLECTION"`
+}
+
+// FeatureConfig switches optional behaviour on and off
+type FeatureConfig struct {
+	DebugMode         bool `yaml:"debug_mode" toml:"debug_mode" env:"FEATURE_DEBUG_MODE"`
+	FollowupScheduler bool `yaml:"followup_scheduler" toml:"followup_scheduler" env:"FEATURE_FOLLOWUP_SCHEDULER"`
+	TrashPurge        bool `yaml:"trash_purge" toml:"trash_purge" env:"FEATURE_TRASH_PURGE"`
+	// Webhooks runs the dispatcher posting events to the webhook subscriptions
+	Webhooks bool `yaml:"webhooks" toml:"webhooks" env:"FEATURE_WEBHOOKS"`
+	// RequireIfMatch refuses PUT, PATCH and DELETE requests without an If-Match header
+	RequireIfMatch bool `yaml:"require_if_match" toml:"require_if_match" env:"FEATURE_REQUIRE_IF_MATCH"`
+}
+
+// SchedulerConfig tunes the background follow-up scheduler
+type SchedulerConfig struct {
+	// Interval is how often due businesses are looked up
+	Interval Duration `yaml:"interval" toml:"interval" env:"SCHEDULER_INTERVAL"`
+	// Cadence is added to a business's follow-up date after each follow-up fires
+	Cadence Duration `yaml:"cadence" toml:"cadence" env:"SCHEDULER_CADENCE"`
+	// LockTTL bounds how long a crashed leader blocks the other instances
+	LockTTL   Duration `yaml:"lock_ttl" toml:"lock_ttl" env:"SCHEDULER_LOCK_TTL"`
+	BatchSize int      `yaml:"batch_size" toml:"batch_size" env:"SCHEDULER_BATCH_SIZE"`
+}
+
+// PipelineConfig overrides the business status transition table. Transitions
+// maps a stage name to the stage names it may move to; when empty the
+// built-in table is used.
+type PipelineConfig struct {
+	Transitions map[string][]string `yaml:"transitions" toml:"transitions"`
+}
+
+// CalendarConfig controls the per-user iCalendar follow-up feeds
+type CalendarConfig struct {
+	// Secret signs the feed tokens; the feeds are disabled while it is empty.
+	// Changing it invalidates every subscribed feed URL.
+	Secret string `yaml:"secret" toml:"secret" env:"CALENDAR_SECRET"`
+	// BaseURL is the public address of the API used in feed URLs and event
+	// links. When empty it is taken from each request.
+	BaseURL string `yaml:"base_url" toml:"base_url" env:"PUBLIC_BASE_URL"`
+}
+
+// PhoneConfig controls how contact phone numbers are read
+type PhoneConfig struct {
+	// DefaultRegion is the ISO 3166 region numbers without a country code are
+	// dialed from, for users who have not set their own
+	DefaultRegion string `yaml:"default_region" toml:"default_region" env:"PHONE_DEFAULT_REGION"`
+}
+
+// IntegrityConfig controls what deleting a user, emoji, contact or business
+// does to the documents referencing it
+type IntegrityConfig struct {
+	// Policies overrides the policy of relations named like businesses.user_id
+	// with restrict, cascade, set_null or reassign
+	Policies integrity.Policies `yaml:"policies" toml:"policies"`
+	// ReassignTo is the user the reassign policy hands references to when a
+	// delete request does not name one
+	ReassignTo string `yaml:"reassign_to" toml:"reassign_to" env:"INTEGRITY_REASSIGN_TO"`
+}
+
+// TrashConfig controls how long deleted documents can be restored
+type TrashConfig struct {
+	// Retention is how long a delete stays in the trash before it is purged for good
+	Retention Duration `yaml:"retention" toml:"retention" env:"TRASH_RETENTION"`
+	// PurgeInterval is how often the trash is checked for deletes past retention
+	PurgeInterval Duration `yaml:"purge_interval" toml:"purge_interval" env:"TRASH_PURGE_INTERVAL"`
+	// LockTTL bounds how long a crashed purging instance blocks the others
+	LockTTL Duration `yaml:"lock_ttl" toml:"lock_ttl" env:"TRASH_LOCK_TTL"`
+}
+
+// WebhookConfig tunes the dispatcher posting events to webhook subscriptions
+type WebhookConfig struct {
+	// Interval is how often the outbox and the due deliveries are looked up
+	Interval Duration `yaml:"interval" toml:"interval" env:"WEBHOOK_INTERVAL"`
+	// LockTTL bounds how long a crashed dispatching instance blocks the others
+	LockTTL   Duration `yaml:"lock_ttl" toml:"lock_ttl" env:"WEBHOOK_LOCK_TTL"`
+	BatchSize int      `yaml:"batch_size" toml:"batch_size" env:"WEBHOOK_BATCH_SIZE"`
+	// MaxAttempts is how many times a delivery is tried before it is failed
+	MaxAttempts int `yaml:"max_attempts" toml:"max_attempts" env:"WEBHOOK_MAX_ATTEMPTS"`
+	// Backoff is the wait after the first failed try, doubling after each
+	// further one up to MaxBackoff
+	Backoff    Duration `yaml:"backoff" toml:"backoff" env:"WEBHOOK_BACKOFF"`
+	MaxBackoff Duration `yaml:"max_backoff" toml:"max_backoff" env:"WEBHOOK_MAX_BACKOFF"`
+	// Timeout bounds each post to a webhook URL
+	Timeout Duration `yaml:"timeout" toml:"timeout" env:"WEBHOOK_TIMEOUT"`
+}
+
+// StreamConfig tunes the live update stream served at GET /stream
+type StreamConfig struct {
+	// PollInterval is how often changes are looked for when the server has no
+	// change streams, as a standalone server does not
+	PollInterval Duration `yaml:"poll_interval" toml:"poll_interval" env:"STREAM_POLL_INTERVAL"`
+	// PollLookback is how far back each poll reads the history again for
+	// versions another instance recorded late or with its clock behind. It
+	// must exceed the clock skew between instances.
+	PollLookback Duration `yaml:"poll_lookback" toml:"poll_lookback" env:"STREAM_POLL_LOOKBACK"`
+	// Heartbeat is how long a quiet stream waits before sending a comment to
+	// keep proxies from closing it
+	Heartbeat Duration `yaml:"heartbeat" toml:"heartbeat" env:"STREAM_HEARTBEAT"`
+	// TokenRetention is how long after an event a client can still resume from it
+	TokenRetention Duration `yaml:"token_retention" toml:"token_retention" env:"STREAM_TOKEN_RETENTION"`
+}
+
+// Default returns the configuration used when nothing overrides it
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ReadTimeout:     Duration{15 * time.Second},
+			WriteTimeout:    Duration{15 * time.Second},
+			IdleTimeout:     Duration{60 * time.Second},
+			ShutdownTimeout: Duration{30 * time.Second},
+		},
+		Mongo: MongoConfig{
+			Database: "testdb",
+			Collections: Collections{
+				Users:        "users",
+				Emojis:       "emojis",
+				Contacts:     "contacts",
+				Businesses:   "businesses",
+				Calls:        "calls",
+				Activities:   "activities",
+				Followups:    "followups",
+				Transitions:  "status_transitions",
+				Imports:      "import_jobs",
+				Locks:        "locks",
+				Trash:        "trash",
+				History:      "history",
+				Webhooks:     "webhooks",
+				Outbox:       "webhook_outbox",
+				Deliveries:   "webhook_deliveries",
+				StreamTokens: "stream_tokens",
+			},
+			MaxPoolSize:    100,
+			ConnectTimeout: Duration{10 * time.Second},
+			QueryTimeout:   Duration{10 * time.Second},
+		},
+		Features: FeatureConfig{
+			DebugMode:         true,
+			FollowupScheduler: true,
+			TrashPurge:        true,
+			Webhooks:          true,
+		},
+		Scheduler: SchedulerConfig{
+			Interval:  Duration{time.Minute},
+			Cadence:   Duration{7 * 24 * time.Hour},
+			LockTTL:   Duration{5 * time.Minute},
+			BatchSize: 100,
+		},
+		Phone: PhoneConfig{
+			DefaultRegion: "US",
+		},
+		Trash: TrashConfig{
+			Retention:     Duration{30 * 24 * time.Hour},
+			PurgeInterval: Duration{time.Hour},
+			LockTTL:       Duration{2 * time.Hour},
+		},
+		Webhooks: WebhookConfig{
+			Interval:    Duration{10 * time.Second},
+			LockTTL:     Duration{5 * time.Minute},
+			BatchSize:   100,
+			MaxAttempts: 8,
+			Backoff:     Duration{30 * time.Second},
+			MaxBackoff:  Duration{time.Hour},
+			Timeout:     Duration{10 * time.Second},
+		},
+		Stream: StreamConfig{
+			PollInterval:   Duration{5 * time.Second},
+			PollLookback:   Duration{time.Minute},
+			Heartbeat:      Duration{15 * time.Second},
+			TokenRetention: Duration{24 * time.Hour},
+		},
+	}
+}
+
+// Load merges the defaults, the file at path (skipped when path is empty) and
+// the environment, then validates the result. A .env file in the working
+// directory is read into the environment when present.
+func Load(path string) (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("loading .env file: %w", err)
+	}
+
+	cfg := Default()
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(&cfg, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, cfg)
+	case ".toml":
+		err = toml.Unmarshal(raw, cfg)
+	default:
+		return fmt.Errorf("config file %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every problem with the configuration at once
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr must be set"))
+	}
+	for name, d := range map[string]Duration{
+		"server.read_timeout":     c.Server.ReadTimeout,
+		"server.write_timeout":    c.Server.WriteTimeout,
+		"server.idle_timeout":     c.Server.IdleTimeout,
+		"server.shutdown_timeout": c.Server.ShutdownTimeout,
+		"mongo.connect_timeout":   c.Mongo.ConnectTimeout,
+		"mongo.query_timeout":     c.Mongo.QueryTimeout,
+		"scheduler.interval":      c.Scheduler.Interval,
+		"scheduler.cadence":       c.Scheduler.Cadence,
+		"scheduler.lock_ttl":      c.Scheduler.LockTTL,
+		"trash.retention":         c.Trash.Retention,
+		"trash.purge_interval":    c.Trash.PurgeInterval,
+		"trash.lock_ttl":          c.Trash.LockTTL,
+		"webhooks.interval":       c.Webhooks.Interval,
+		"webhooks.lock_ttl":       c.Webhooks.LockTTL,
+		"webhooks.backoff":        c.Webhooks.Backoff,
+		"webhooks.max_backoff":    c.Webhooks.MaxBackoff,
+		"webhooks.timeout":        c.Webhooks.Timeout,
+		"stream.poll_interval":    c.Stream.PollInterval,
+		"stream.poll_lookback":    c.Stream.PollLookback,
+		"stream.heartbeat":        c.Stream.Heartbeat,
+		"stream.token_retention":  c.Stream.TokenRetention,
+	} {
+		if d.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", name))
+		}
+	}
+
+	if c.Scheduler.LockTTL.Duration <= c.Scheduler.Interval.Duration {
+		errs = append(errs, errors.New("scheduler.lock_ttl must be longer than scheduler.interval"))
+	}
+	if c.Scheduler.BatchSize < 1 {
+		errs = append(errs, errors.New("scheduler.batch_size must be at least 1"))
+	}
+	if c.Trash.LockTTL.Duration <= c.Trash.PurgeInterval.Duration {
+		errs = append(errs, errors.New("trash.lock_ttl must be longer than trash.purge_interval"))
+	}
+	if c.Webhooks.LockTTL.Duration <= c.Webhooks.Interval.Duration {
+		errs = append(errs, errors.New("webhooks.lock_ttl must be longer than webhooks.interval"))
+	}
+	if c.Webhooks.BatchSize < 1 {
+		errs = append(errs, errors.New("webhooks.batch_size must be at least 1"))
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		errs = append(errs, errors.New("webhooks.max_attempts must be at least 1"))
+	}
+	if c.Webhooks.MaxBackoff.Duration < c.Webhooks.Backoff.Duration {
+		errs = append(errs, errors.New("webhooks.max_backoff must not be shorter than webhooks.backoff"))
+	}
+
+	if _, err := pipeline.NewMachine(c.Pipeline.Transitions); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Calendar.Secret != "" && len(c.Calendar.Secret) < 16 {
+		errs = append(errs, errors.New("calendar.secret must be at least 16 characters"))
+	}
+	if c.Calendar.BaseURL != "" {
+		if u, err := url.Parse(c.Calendar.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, errors.New("calendar.base_url must be an absolute http or https URL"))
+		}
+	}
+
+	if !phone.KnownRegion(c.Phone.DefaultRegion) {
+		errs = append(errs, fmt.Errorf("phone.default_region %q is not a supported region", c.Phone.DefaultRegion))
+	}
+
+	if _, err := integrity.DefaultPolicies.With(c.Integrity.Policies); err != nil {
+		errs = append(errs, fmt.Errorf("integrity.policies: %w", err))
+	}
+	if c.Integrity.ReassignTo != "" && !primitive.IsValidObjectID(c.Integrity.ReassignTo) {
+		errs = append(errs, errors.New("integrity.reassign_to must be a user ID"))
+	}
+
+	if c.Mongo.URI == "" {
+		errs = append(errs, errors.New("mongo.uri must be set (MONGODB_URI)"))
+	} else if !strings.HasPrefix(c.Mongo.URI, "mongodb://") && !strings.HasPrefix(c.Mongo.URI, "mongodb+srv://") {
+		errs = append(errs, errors.New("mongo.uri must start with mongodb:// or mongodb+srv://"))
+	}
+	if c.Mongo.Database == "" {
+		errs = append(errs, errors.New("mongo.database must be set"))
+	}
+	if c.Mongo.MaxPoolSize != 0 && c.Mongo.MinPoolSize > c.Mongo.MaxPoolSize {
+		errs = append(errs, errors.New("mongo.min_pool_size must not exceed mongo.max_pool_size"))
+	}
+
+	seen := make(map[string]string)
+	for field, name := range map[string]string{
+		"users":         c.Mongo.Collections.Users,
+		"emojis":        c.Mongo.Collections.Emojis,
+		"contacts":      c.Mongo.Collections.Contacts,
+		"businesses":    c.Mongo.Collections.Businesses,
+		"calls":         c.Mongo.Collections.Calls,
+		"activities":    c.Mongo.Collections.Activities,
+		"followups":     c.Mongo.Collections.Followups,
+		"transitions":   c.Mongo.Collections.Transitions,
+		"imports":       c.Mongo.Collections.Imports,
+		"locks":         c.Mongo.Collections.Locks,
+		"trash":         c.Mongo.Collections.Trash,
+		"history":       c.Mongo.Collections.History,
+		"webhooks":      c.Mongo.Collections.Webhooks,
+		"outbox":        c.Mongo.Collections.Outbox,
+		"deliveries":    c.Mongo.Collections.Deliveries,
+		"stream_tokens": c.Mongo.Collections.StreamTokens,
+	} {
+		if name == "" {
+			errs = append(errs, fmt.Errorf("mongo.collections.%s must be set", field))
+			continue
+		}
+		if other, ok := seen[name]; ok {
+			errs = append(errs, fmt.Errorf("mongo.collections.%s and mongo.collections.%s both use %q", other, field, name))
+		}
+		seen[name] = field
+	}
+
+	return errors.Join(errs...)
+}
+
+// Duration is a time.Duration that decodes from strings such as "15s"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes a config file into a temporary directory
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadMerges checks a file overrides the defaults, the environment
+// overrides the file, and what neither sets keeps its default
+func TestLoadMerges(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+server:
+  addr: ":9000"
+  read_timeout: 20s
+mongo:
+  uri: mongodb://file:27017
+  database: filedb
+  collections:
+    users: people
+pipeline:
+  transitions:
+    new: [won]
+`,
+		"config.toml": `
+[server]
+addr = ":9000"
+read_timeout = "20s"
+
+[mongo]
+uri = "mongodb://file:27017"
+database = "filedb"
+
+[mongo.collections]
+users = "people"
+
+[pipeline.transitions]
+new = ["won"]
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("MONGODB_URI", "mongodb://env:27017")
+			t.Setenv("SERVER_WRITE_TIMEOUT", "45s")
+			t.Setenv("FEATURE_WEBHOOKS", "false")
+			t.Setenv("SCHEDULER_BATCH_SIZE", "7")
+
+			cfg, err := Load(writeFile(t, name, content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Default()
+			want.Server.Addr = ":9000"
+			want.Server.ReadTimeout = Duration{20 * time.Second}
+			want.Server.WriteTimeout = Duration{45 * time.Second}
+			want.Mongo.URI = "mongodb://env:27017"
+			want.Mongo.Database = "filedb"
+			want.Mongo.Collections.Users = "people"
+			want.Pipeline.Transitions = map[string][]string{"new": {"won"}}
+			want.Features.Webhooks = false
+			want.Scheduler.BatchSize = 7
+			if !reflect.DeepEqual(*cfg, want) {
+				t.Errorf("Load gave\n%+v\nwant\n%+v", *cfg, want)
+			}
+		})
+	}
+}
+
+func TestLoadExample(t *testing.T) {
+	t.Setenv("MONGODB_URI", "mongodb://localhost:27017")
+	if _, err := Load(filepath.Join("..", "config.example.yaml")); err != nil {
+		t.Errorf("config.example.yaml: %v", err)
+	}
+}
+
+func TestLoadFails(t *testing.T) {
+	tests := []struct{ name, file, content, env, want string }{
+		{"unsupported format", "config.json", `{}`, "", "unsupported format"},
+		{"bad yaml", "config.yaml", "server: [", "", "parsing config file"},
+		{"bad duration in file", "config.yaml", "server:\n  read_timeout: soon\n", "", "parsing config file"},
+		{"bad duration in environment", "config.yaml", "", "SERVER_READ_TIMEOUT=soon", "SERVER_READ_TIMEOUT"},
+		{"bad bool in environment", "config.yaml", "", "FEATURE_WEBHOOKS=maybe", "FEATURE_WEBHOOKS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MONGODB_URI", "mongodb://localhost:27017")
+			if name, value, ok := strings.Cut(tt.env, "="); ok {
+				t.Setenv(name, value)
+			}
+			_, err := Load(writeFile(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load: %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestValidate checks every problem is reported at once
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Mongo.URI = "mongodb://localhost:27017"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults with a URI: %v", err)
+	}
+
+	cfg.Mongo.URI = "http://localhost"
+	cfg.Scheduler.LockTTL = Duration{time.Second}
+	cfg.Phone.DefaultRegion = "XX"
+	cfg.Mongo.Collections.Calls = cfg.Mongo.Collections.Users
+	cfg.Pipeline.Transitions = map[string][]string{"new": {"closed"}}
+	cfg.Calendar.Secret = "short"
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate: want an error")
+	}
+	for _, want := range []string{
+		"mongo.uri must start with",
+		"scheduler.lock_ttl must be longer",
+		`phone.default_region "XX"`,
+		`both use "users"`,
+		`unknown stage "closed"`,
+		"calendar.secret must be at least",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate: %v\nwant it to mention %q", err, want)
+		}
+	}
+}
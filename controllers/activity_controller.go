@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/data"
+	"usermanagement/models"
+)
+
+// recordActivity appends an entry to the activity feed. A failure is logged
+// rather than reported because the change it describes is already saved.
+func recordActivity(ctx context.Context, activities data.ActivityRepository, activity models.Activity) {
+	ctx, cancel := afterSave(ctx)
+	defer cancel()
+	activity = stampActivity(activity)
+	if err := activities.Insert(ctx, activity); err != nil {
+		log.Printf("recording %s activity: %v", activity.Type, err)
+	}
+}
+
+// stampActivity gives an activity its ID and, unless it has one, its time,
+// leaving one already stamped as it is
+func stampActivity(activity models.Activity) models.Activity {
+	if activity.ID.IsZero() {
+		activity.ID = primitive.NewObjectID()
+	}
+	if activity.CreatedDate.IsZero() {
+		activity.CreatedDate = time.Now()
+	}
+	return activity
+}
+
+// changeFeed publishes the activities of the writes it saves. Their webhook
+// events go to the outbox in the transaction of the write, so an event is
+// queued exactly when its change is saved. The activity feed follows once
+// the transaction has committed.
+type changeFeed struct {
+	tx         data.Transactor
+	outbox     data.OutboxRepository
+	activities data.ActivityRepository
+}
+
+func newChangeFeed(repos *data.Repositories) changeFeed {
+	return changeFeed{tx: repos.Tx, outbox: repos.Outbox, activities: repos.Activities}
+}
+
+// save runs write in a transaction and queues the events of the activities
+// it returns in the same one, then adds those activities to the feed
+func (f changeFeed) save(ctx context.Context, write func(ctx context.Context) ([]models.Activity, error)) error {
+	var activities []models.Activity
+	err := f.tx.Atomically(ctx, func(ctx context.Context) error {
+		var err error
+		if activities, err = write(ctx); err != nil {
+			return err
+		}
+		for i := range activities {
+			activities[i] = stampActivity(activities[i])
+			if err := f.outbox.Insert(ctx, models.NewEvent(activities[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	f.record(ctx, activities...)
+	return nil
+}
+
+// record adds activities whose events are already queued to the feed
+func (f changeFeed) record(ctx context.Context, activities ...models.Activity) {
+	for _, activity := range activities {
+		recordActivity(ctx, f.activities, activity)
+	}
+}
+
+// TimelineController serves the activity timelines of businesses and contacts
+type TimelineController struct {
+	Activities data.ActivityRepository
+}
+
+func NewTimelineController(repos *data.Repositories) *TimelineController {
+	return &TimelineController{Activities: repos.Activities}
+}
+
+// GetBusinessTimeline retrieves a page of everything that happened to a business and its contacts, newest first
+func (tc *TimelineController) GetBusinessTimeline(c *gin.Context) {
+	tc.timeline(c, "business_id")
+}
+
+// GetContactTimeline retrieves a page of everything that happened to a contact, newest first
+func (tc *TimelineController) GetContactTimeline(c *gin.Context) {
+	tc.timeline(c, "contact_id")
+}
+
+func (tc *TimelineController) timeline(c *gin.Context, field string) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	opts, err := parseListOptions(c, data.ActivityFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+	if opts.Sort == "" {
+		opts.Sort = "created_date"
+		opts.Desc = true
+	}
+	opts.Filters = append(opts.Filters, data.Filter{Field: field, Op: data.OpEq, Value: objID})
+
+	page, err := tc.Activities.List(c.Request.Context(), opts)
+	if err == data.ErrInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	respondPage(c, opts, page.Items, page.NextCursor, page.Total)
+}
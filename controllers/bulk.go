@@ -0,0 +1,407 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"usermanagement/data"
+	"usermanagement/integrity"
+	"usermanagement/models"
+	"usermanagement/patch"
+)
+
+// maxBulkOperations caps how many operations one bulk request may carry
+const maxBulkOperations = 1000
+
+// bulkOperation is one create, update or delete of a bulk request
+type bulkOperation struct {
+	Op string `json:"op"`
+	// ID names the document to update or delete
+	ID string `json:"id"`
+	// Document is the document to create, or a JSON Merge Patch of the
+	// fields to change for an update
+	Document json.RawMessage `json:"document"`
+	// Revision is the revision an update or delete is made against, as the
+	// If-Match header names it for a single one
+	Revision *int64 `json:"revision"`
+}
+
+// bulkRequest is the body of a bulk request
+type bulkRequest struct {
+	// Ordered stops at the first operation that fails; it is the default
+	Ordered *bool `json:"ordered"`
+	// Atomic saves every operation or none, and implies ordered
+	Atomic     bool            `json:"atomic"`
+	Operations []bulkOperation `json:"operations"`
+}
+
+// bulkError says why one operation of a bulk request was not saved
+type bulkError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// bulkItem is the outcome of one operation of a bulk request, at the same
+// index as the operation
+type bulkItem struct {
+	Index  int         `json:"index"`
+	Op     string      `json:"op"`
+	ID     string      `json:"id,omitempty"`
+	Status int         `json:"status"`
+	Error  *bulkError  `json:"error,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// bulkCodes name the error of a failed operation by its status
+var bulkCodes = map[int]string{
+	http.StatusBadRequest:          "invalid",
+	http.StatusNotFound:            "not_found",
+	http.StatusConflict:            "conflict",
+	http.StatusPreconditionFailed:  "revision_conflict",
+	http.StatusInternalServerError: "internal",
+}
+
+// fail records why the operation was not saved
+func (item *bulkItem) fail(status int, err error) {
+	code := bulkCodes[status]
+	var restricted *integrity.RestrictError
+	switch {
+	case errors.Is(err, data.ErrSkipped):
+		code = "skipped"
+	case errors.Is(err, data.ErrRolledBack):
+		code = "rolled_back"
+	case errors.As(err, &restricted):
+		code = "restricted"
+	}
+	if code == "" {
+		code = "invalid"
+	}
+	item.Status = status
+	item.Error = &bulkError{Code: code, Message: err.Error()}
+	item.Data = nil
+}
+
+// bulkStatus is the HTTP status to report for a write that was not saved
+func bulkStatus(err error) int {
+	var restricted *integrity.RestrictError
+	switch {
+	case errors.Is(err, data.ErrSkipped), errors.Is(err, data.ErrRolledBack):
+		return http.StatusFailedDependency
+	case errors.Is(err, data.ErrRevisionConflict):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, data.ErrNotFound), errors.Is(err, integrity.ErrNotFound):
+		return http.StatusNotFound
+	case errors.As(err, &restricted), mongo.IsDuplicateKeyError(err):
+		return http.StatusConflict
+	case errors.Is(err, integrity.ErrNoReassignTarget), errors.Is(err, integrity.ErrReassignTarget):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// bulkStep is an operation checked and ready to write
+type bulkStep[T any] struct {
+	write    data.BulkWrite
+	doc      T
+	existing T
+	changed  map[string]bool
+	// activities are what the write publishes once saved
+	activities []models.Activity
+	// unchanged marks an update that changes nothing, so nothing is written
+	unchanged bool
+}
+
+// bulkHandler serves POST /{resource}/bulk for one resource, checking each
+// operation the way its single endpoint does
+type bulkHandler[T any] struct {
+	writes   data.BulkWriter
+	deletes  deletePolicy
+	feed     changeFeed
+	history  *versionLog
+	resource string
+	// name is the resource's model as messages show it
+	name string
+	// hard deletes for good rather than through the trash
+	hard bool
+	find func(ctx context.Context, id primitive.ObjectID) (T, error)
+	// ref points at a document's ID and revision
+	ref       func(doc *T) (*primitive.ObjectID, *int64)
+	immutable []string
+	// create checks a new document and fills in the fields the server sets,
+	// n being how many creates came before it in the request
+	create func(ctx context.Context, doc *T, n int) (int, error)
+	// update checks the fields of existing a patch changed
+	update func(ctx context.Context, doc *T, existing T, changed map[string]bool) (int, error)
+	// publishes names the activities a checked create or update publishes;
+	// existing and changed are zero for a create
+	publishes func(doc T, existing T, changed map[string]bool) []models.Activity
+	// transitions names the status moves a checked update userID made
+	// records, which the bulk writer saves with the write
+	transitions func(userID primitive.ObjectID, doc T, existing T) []models.StatusTransition
+	// created and updated run once a create or update userID made is saved
+	created func(ctx context.Context, userID primitive.ObjectID, doc T) error
+	updated func(ctx context.Context, userID primitive.ObjectID, doc T, existing T, changed map[string]bool) error
+}
+
+// run reads the operations of a bulk request, writes those that pass their
+// checks and responds with the outcome of each. It responds 200 when every
+// operation succeeded and 207 otherwise.
+func (b bulkHandler[T]) run(c *gin.Context) {
+	var req bulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+	if len(req.Operations) == 0 || len(req.Operations) > maxBulkOperations {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": fmt.Sprintf("Send between 1 and %d operations", maxBulkOperations),
+			"data":    []interface{}{},
+		})
+		return
+	}
+	ordered := req.Ordered == nil || *req.Ordered || req.Atomic
+	userID := actingUser(c)
+
+	items := make([]bulkItem, len(req.Operations))
+	steps := make([]bulkStep[T], len(req.Operations))
+	var writes []data.BulkWrite
+	var written []int
+	seen := make(map[primitive.ObjectID]bool)
+	creates := 0
+	stopped := false
+	for i, op := range req.Operations {
+		items[i] = bulkItem{Index: i, Op: op.Op, ID: op.ID}
+		if stopped {
+			items[i].fail(http.StatusFailedDependency, data.ErrSkipped)
+			continue
+		}
+		step, status, err := b.prepare(c.Request.Context(), op, userID, creates, seen)
+		if err != nil {
+			items[i].fail(status, err)
+			stopped = ordered
+			continue
+		}
+		if op.Op == data.BulkCreate {
+			creates++
+			items[i].ID = step.write.ID.Hex()
+		}
+		if step.unchanged {
+			items[i].Status = http.StatusOK
+			items[i].Data = step.existing
+			continue
+		}
+		steps[i] = step
+		writes = append(writes, step.write)
+		written = append(written, i)
+	}
+
+	if req.Atomic && stopped {
+		// an operation failed its checks, so none is written
+		for _, i := range written {
+			items[i].fail(http.StatusFailedDependency, data.ErrRolledBack)
+		}
+		written = nil
+	}
+	if len(written) > 0 {
+		results, err := b.writes.Bulk(c.Request.Context(), b.resource, writes, data.BulkOptions{Ordered: ordered, Atomic: req.Atomic})
+		if err == data.ErrNoTransactions {
+			c.JSON(http.StatusNotImplemented, gin.H{
+				"status":  http.StatusNotImplemented,
+				"message": err.Error(),
+				"data":    []interface{}{},
+			})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":  http.StatusInternalServerError,
+				"message": err.Error(),
+				"data":    []interface{}{},
+			})
+			return
+		}
+		b.saved(c, items, steps, written, results)
+	}
+
+	succeeded := 0
+	for _, item := range items {
+		if item.Error == nil {
+			succeeded++
+		}
+	}
+	status := http.StatusOK
+	if succeeded < len(items) {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{
+		"status":  status,
+		"message": fmt.Sprintf("%d of %d operations succeeded", succeeded, len(items)),
+		"data":    items,
+	})
+}
+
+// prepare checks an operation and builds its write. It returns the HTTP
+// status to report alongside any error.
+func (b bulkHandler[T]) prepare(ctx context.Context, op bulkOperation, userID primitive.ObjectID, creates int, seen map[primitive.ObjectID]bool) (bulkStep[T], int, error) {
+	var step bulkStep[T]
+	switch op.Op {
+	case data.BulkCreate:
+		if op.ID != "" {
+			return step, http.StatusBadRequest, errors.New("A create takes no id; one is assigned")
+		}
+		if len(op.Document) == 0 {
+			return step, http.StatusBadRequest, errors.New("Send the document to create")
+		}
+		if err := json.Unmarshal(op.Document, &step.doc); err != nil {
+			return step, http.StatusBadRequest, err
+		}
+		if status, err := b.create(ctx, &step.doc, creates); err != nil {
+			return step, status, err
+		}
+		id, revision := b.ref(&step.doc)
+		*id = primitive.NewObjectID()
+		*revision = 1
+		step.write = data.BulkWrite{Kind: data.BulkCreate, Doc: step.doc, ID: *id}
+		b.publish(&step)
+		return step, http.StatusOK, nil
+	case data.BulkUpdate, data.BulkDelete:
+	default:
+		return step, http.StatusBadRequest, errors.New("op must be create, update or delete")
+	}
+
+	id, err := primitive.ObjectIDFromHex(op.ID)
+	if err != nil {
+		return step, http.StatusBadRequest, errors.New("Invalid ID format")
+	}
+	if seen[id] {
+		return step, http.StatusBadRequest, errors.New("A document can only be changed once per bulk request")
+	}
+	seen[id] = true
+
+	step.existing, err = b.find(ctx, id)
+	if err == data.ErrNotFound {
+		return step, http.StatusNotFound, errors.New(b.name + " not found")
+	} else if err != nil {
+		return step, http.StatusInternalServerError, err
+	}
+	_, revision := b.ref(&step.existing)
+	if op.Revision != nil && *op.Revision != *revision {
+		return step, http.StatusPreconditionFailed, fmt.Errorf("It is at revision %d, not %d; reload it and retry", *revision, *op.Revision)
+	}
+
+	if op.Op == data.BulkDelete {
+		step.write = data.BulkWrite{
+			Kind:     data.BulkDelete,
+			ID:       id,
+			Revision: *revision,
+			Delete:   integrity.Options{Policies: b.deletes.policies, ReassignTo: b.deletes.reassignTo},
+			Hard:     b.hard,
+		}
+		return step, http.StatusOK, nil
+	}
+
+	if len(op.Document) == 0 {
+		return step, http.StatusBadRequest, errors.New("Send the fields to change as a merge patch")
+	}
+	doc, changed, status, err := patchDocument(step.existing, patch.MergePatchType, op.Document, b.immutable...)
+	if err != nil {
+		return step, status, err
+	}
+	if status, err := b.update(ctx, &doc, step.existing, changed); err != nil {
+		return step, status, err
+	}
+	if len(changed) == 0 {
+		step.unchanged = true
+		return step, http.StatusOK, nil
+	}
+	step.doc, step.changed = doc, changed
+	step.write = data.BulkWrite{Kind: data.BulkUpdate, Doc: doc, ID: id, Revision: *revision}
+	if b.transitions != nil {
+		step.write.Transitions = b.transitions(userID, doc, step.existing)
+	}
+	b.publish(&step)
+	return step, http.StatusOK, nil
+}
+
+// publish gives a checked create or update the activities it publishes and
+// their webhook events, which the bulk writer queues with the write
+func (b bulkHandler[T]) publish(step *bulkStep[T]) {
+	if b.publishes == nil {
+		return
+	}
+	for _, activity := range b.publishes(step.doc, step.existing, step.changed) {
+		activity = stampActivity(activity)
+		step.activities = append(step.activities, activity)
+		step.write.Events = append(step.write.Events, models.NewEvent(activity))
+	}
+}
+
+// saved records the outcome of each write, at the index in items written
+// gives it, and runs what follows each saved one
+func (b bulkHandler[T]) saved(c *gin.Context, items []bulkItem, steps []bulkStep[T], written []int, results []data.BulkResult) {
+	ctx := c.Request.Context()
+	userID := actingUser(c)
+	// the documents as written, which their versions record
+	var created, updated []interface{}
+	for k, result := range results {
+		i := written[k]
+		step := steps[i]
+		if result.Err != nil {
+			items[i].fail(bulkStatus(result.Err), result.Err)
+			continue
+		}
+		b.feed.record(ctx, step.activities...)
+
+		var err error
+		switch step.write.Kind {
+		case data.BulkCreate:
+			items[i].Status = http.StatusCreated
+			items[i].Data = step.doc
+			created = append(created, step.doc)
+			if b.created != nil {
+				err = b.created(ctx, userID, step.doc)
+			}
+		case data.BulkUpdate:
+			_, revision := b.ref(&step.doc)
+			*revision++
+			items[i].Status = http.StatusOK
+			items[i].Data = step.doc
+			updated = append(updated, step.doc)
+			if b.updated != nil {
+				err = b.updated(ctx, userID, step.doc, step.existing, step.changed)
+			}
+		case data.BulkDelete:
+			items[i].Status = http.StatusOK
+			if !b.hard {
+				items[i].Data = result.Report
+			}
+			if b.history != nil {
+				b.history.recordDelete(ctx, userID, result.Report)
+			}
+		}
+		if err != nil {
+			// the write is saved, so the operation still succeeded
+			log.Printf("bulk %s: after saving %s %s: %v", b.resource, b.name, step.write.ID.Hex(), err)
+		}
+	}
+
+	if b.history != nil {
+		if len(created) > 0 {
+			b.history.snapshot(ctx, userID, models.VersionCreated, created...)
+		}
+		if len(updated) > 0 {
+			b.history.snapshot(ctx, userID, models.VersionUpdated, updated...)
+		}
+	}
+}
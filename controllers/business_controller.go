@@ -2,189 +2,246 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
+	"usermanagement/config"
 	"usermanagement/data"
+	"usermanagement/integrity"
 	"usermanagement/models"
+	"usermanagement/pipeline"
 )
 
-// Check if a user exists in the database
-func userExists(userID primitive.ObjectID) (bool, error) {
-	count, err := data.UserCollection.CountDocuments(context.TODO(), bson.M{"_id": userID})
-	if err != nil {
-		return false, err
-	}
-	return count > 0, nil
+// BusinessController serves the /businesses endpoints
+type BusinessController struct {
+	Businesses  data.BusinessRepository
+	Users       data.UserRepository
+	Contacts    data.ContactRepository
+	Emojis      data.EmojiRepository
+	Transitions data.TransitionRepository
+	Pipeline    *pipeline.Machine
+	feed        changeFeed
+	deletes     deletePolicy
+	history     versionLog
+	bulk        bulkHandler[models.Business]
 }
 
-// Check if a contact exists in the database
-func contactExists(contactID primitive.ObjectID) (bool, error) {
-	count, err := data.ContactCollection.CountDocuments(context.TODO(), bson.M{"_id": contactID})
-	if err != nil {
-		return false, err
+func NewBusinessController(repos *data.Repositories, machine *pipeline.Machine, integrityCfg config.IntegrityConfig) *BusinessController {
+	bc := &BusinessController{
+		Businesses:  repos.Businesses,
+		Users:       repos.Users,
+		Contacts:    repos.Contacts,
+		Emojis:      repos.Emojis,
+		Transitions: repos.Transitions,
+		Pipeline:    machine,
+		feed:        newChangeFeed(repos),
+		deletes:     newDeletePolicy(repos, integrityCfg),
+		history:     newVersionLog[models.Business](repos, integrity.Businesses),
+	}
+	bc.bulk = bulkHandler[models.Business]{
+		writes:   repos.Bulk,
+		deletes:  bc.deletes,
+		feed:     bc.feed,
+		history:  &bc.history,
+		resource: integrity.Businesses,
+		name:     "Business",
+		find: func(ctx context.Context, id primitive.ObjectID) (models.Business, error) {
+			return bc.Businesses.FindByID(ctx, id)
+		},
+		ref:       func(business *models.Business) (*primitive.ObjectID, *int64) { return &business.ID, &business.Revision },
+		immutable: []string{"created_date"},
+		create: func(ctx context.Context, business *models.Business, n int) (int, error) {
+			if status, err := bc.checkNewBusiness(ctx, business); err != nil {
+				return status, err
+			}
+			business.CreatedDate = time.Now()
+			return http.StatusOK, nil
+		},
+		update: bc.checkBusinessChanges,
+		publishes: func(business, existing models.Business, changed map[string]bool) []models.Activity {
+			if changed == nil {
+				return []models.Activity{businessCreated(business)}
+			}
+			return businessUpdated(business, pipeline.Stage(existing.Status), changed)
+		},
+		transitions: func(userID primitive.ObjectID, business, existing models.Business) []models.StatusTransition {
+			from, to := pipeline.Stage(existing.Status), pipeline.Stage(business.Status)
+			if from == to {
+				return nil
+			}
+			return []models.StatusTransition{newTransition(business.ID, userID, from, to, "")}
+		},
 	}
-	return count > 0, nil
+	return bc
 }
 
-// Check if an emoji exists in the database
-func emojiExists(emojiID primitive.ObjectID) (bool, error) {
-	count, err := data.EmojiCollection.CountDocuments(context.TODO(), bson.M{"_id": emojiID})
-	if err != nil {
-		return false, err
+// load reads the business with the given ID
+func (bc *BusinessController) load(id primitive.ObjectID) loader {
+	return func(ctx context.Context) (interface{}, int64, error) {
+		business, err := bc.Businesses.FindByID(ctx, id)
+		return business, business.Revision, err
 	}
-	return count > 0, nil
 }
 
-// GetBusinesses retrieves all businesses
-func GetBusinesses(c *gin.Context) {
-	var businesses []models.Business
-	cur, err := data.BusinessCollection.Find(context.TODO(), bson.D{})
+// checkNewBusiness validates a business about to be created, filling in
+// placeholder emoji and contact IDs when they are left out. It returns the
+// HTTP status to report alongside any error.
+func (bc *BusinessController) checkNewBusiness(ctx context.Context, business *models.Business) (int, error) {
+	if business.UserID.IsZero() {
+		return http.StatusBadRequest, errors.New("Enter UserID")
+	}
+	userExists, err := bc.Users.Exists(ctx, business.UserID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": err.Error(),
-			"data":    []interface{}{},
-		})
-		return
+		return http.StatusInternalServerError, err
+	}
+	if !userExists {
+		return http.StatusBadRequest, errors.New("Incorrect UserID")
 	}
-	defer cur.Close(context.TODO())
 
-	for cur.Next(context.TODO()) {
-		var business models.Business
-		err := cur.Decode(&business)
+	if !business.EmojiID.IsZero() {
+		emojiExists, err := bc.Emojis.Exists(ctx, business.EmojiID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status":  http.StatusInternalServerError,
-				"message": err.Error(),
-				"data":    []interface{}{},
-			})
-			return
+			return http.StatusInternalServerError, err
 		}
-		businesses = append(businesses, business)
+		if !emojiExists {
+			return http.StatusBadRequest, errors.New("Incorrect EmojiID")
+		}
+	} else {
+		business.EmojiID = primitive.NewObjectID() // Set a default value if EmojiID is not provided
 	}
 
-	if err := cur.Err(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": err.Error(),
-			"data":    []interface{}{},
-		})
-		return
+	if !business.ContactID.IsZero() {
+		contactExists, err := bc.Contacts.Exists(ctx, business.ContactID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !contactExists {
+			return http.StatusBadRequest, errors.New("Incorrect ContactID")
+		}
+	} else {
+		business.ContactID = primitive.NewObjectID() // Set a default value if ContactID is not provided
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"status":  http.StatusOK,
-		"message": "success",
-		"data":    businesses,
+	if !pipeline.Stage(business.Status).Valid() {
+		return http.StatusBadRequest, errors.New("Status must be a pipeline stage between 0 and 9")
+	}
+	return http.StatusOK, nil
+}
+
+// insertBusiness saves a checked business under a new ID and records its
+// creation by userID
+func (bc *BusinessController) insertBusiness(ctx context.Context, business *models.Business, userID primitive.ObjectID) error {
+	business.ID = primitive.NewObjectID()
+	business.Revision = 1
+	business.CreatedDate = time.Now()
+
+	err := bc.feed.save(ctx, func(ctx context.Context) ([]models.Activity, error) {
+		if err := bc.Businesses.Insert(ctx, *business); err != nil {
+			return nil, err
+		}
+		return []models.Activity{businessCreated(*business)}, nil
 	})
+	if err != nil {
+		return err
+	}
+	bc.history.snapshot(ctx, userID, models.VersionCreated, *business)
+	return nil
 }
 
-// PostBusiness creates a new business
-func PostBusiness(c *gin.Context) {
-	var newBusiness models.Business
+// businessCreated is the activity of a saved new business
+func businessCreated(business models.Business) models.Activity {
+	return models.Activity{
+		Type:       models.ActivityBusinessCreated,
+		BusinessID: business.ID,
+		UserID:     business.UserID,
+		Summary:    "Business " + business.BusinessName + " created",
+	}
+}
 
-	if err := c.ShouldBindJSON(&newBusiness); err != nil {
+// businessUpdated is the activity of a saved change to a business that was
+// at from, after that of its status change if it made one. changed names
+// the fields a patch changed, and is nil for a whole update.
+func businessUpdated(business models.Business, from pipeline.Stage, changed map[string]bool) []models.Activity {
+	var activities []models.Activity
+	if to := pipeline.Stage(business.Status); from != to {
+		activities = append(activities, statusChanged(business.UserID, models.StatusTransition{
+			BusinessID: business.ID,
+			FromStatus: int(from),
+			ToStatus:   int(to),
+		}))
+	}
+	updated := models.Activity{
+		Type:       models.ActivityBusinessUpdated,
+		BusinessID: business.ID,
+		UserID:     business.UserID,
+		Summary:    "Business " + business.BusinessName + " updated",
+	}
+	if changed != nil {
+		updated.Details = map[string]interface{}{"fields": sortedKeys(changed)}
+	}
+	return append(activities, updated)
+}
+
+// GetBusinesses retrieves a filtered, sorted page of businesses
+func (bc *BusinessController) GetBusinesses(c *gin.Context) {
+	opts, err := parseListOptions(c, data.BusinessFields)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  http.StatusBadRequest,
 			"message": err.Error(),
-			"data":    map[string]interface{}{},
+			"data":    []interface{}{},
 		})
 		return
 	}
 
-	if newBusiness.UserID.IsZero() {
+	page, err := bc.Businesses.List(c.Request.Context(), opts)
+	if err == data.ErrInvalidCursor {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  http.StatusBadRequest,
-			"message": "Enter UserID",
-			"data":    map[string]interface{}{},
+			"message": err.Error(),
+			"data":    []interface{}{},
 		})
 		return
-	}
-
-	userExists, err := userExists(newBusiness.UserID)
-	if err != nil {
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
-			"data":    map[string]interface{}{},
+			"data":    []interface{}{},
 		})
 		return
 	}
-	if !userExists {
+
+	respondPage(c, opts, page.Items, page.NextCursor, page.Total)
+}
+
+// PostBusiness creates a new business
+func (bc *BusinessController) PostBusiness(c *gin.Context) {
+	var newBusiness models.Business
+
+	if err := c.ShouldBindJSON(&newBusiness); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  http.StatusBadRequest,
-			"message": "Incorrect UserID",
+			"message": err.Error(),
 			"data":    map[string]interface{}{},
 		})
 		return
 	}
 
-	if !newBusiness.EmojiID.IsZero() {
-		emojiExists, err := emojiExists(newBusiness.EmojiID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status":  http.StatusInternalServerError,
-				"message": err.Error(),
-				"data":    map[string]interface{}{},
-			})
-			return
-		}
-		if !emojiExists {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Incorrect EmojiID",
-				"data":    map[string]interface{}{},
-			})
-			return
-		}
-	} else {
-		newBusiness.EmojiID = primitive.NewObjectID() // Set a default value if EmojiID is not provided
-	}
-
-	if !newBusiness.ContactID.IsZero() {
-		contactExists, err := contactExists(newBusiness.ContactID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status":  http.StatusInternalServerError,
-				"message": err.Error(),
-				"data":    map[string]interface{}{},
-			})
-			return
-		}
-		if !contactExists {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Incorrect ContactID",
-				"data":    map[string]interface{}{},
-			})
-			return
-		}
-	} else {
-		newBusiness.ContactID = primitive.NewObjectID() // Set a default value if ContactID is not provided
-	}
-	if newBusiness.Status < 0 || newBusiness.Status > 9 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "Status must be between 0 and 9",
+	if status, err := bc.checkNewBusiness(c.Request.Context(), &newBusiness); err != nil {
+		c.JSON(status, gin.H{
+			"status":  status,
+			"message": err.Error(),
 			"data":    map[string]interface{}{},
 		})
 		return
 	}
-	
-	if newBusiness.Status == 0 {
-		newBusiness.Status = 0 // Default value if not provided
-	}
-	
 
-	newBusiness.ID = primitive.NewObjectID()
-	newBusiness.CreatedDate = time.Now()
-
-	if _, err := data.BusinessCollection.InsertOne(context.TODO(), newBusiness); err != nil {
+	if err := bc.insertBusiness(c.Request.Context(), &newBusiness, actingUser(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
@@ -192,6 +249,7 @@ func PostBusiness(c *gin.Context) {
 		})
 		return
 	}
+	c.Header("ETag", etag(newBusiness.Revision))
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
@@ -201,7 +259,7 @@ func PostBusiness(c *gin.Context) {
 }
 
 // GetBusinessByID retrieves a business by ID
-func GetBusinessByID(c *gin.Context) {
+func (bc *BusinessController) GetBusinessByID(c *gin.Context) {
 	id := c.Param("id")
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -213,9 +271,8 @@ func GetBusinessByID(c *gin.Context) {
 		return
 	}
 
-	var business models.Business
-	err = data.BusinessCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&business)
-	if err == mongo.ErrNoDocuments {
+	business, err := bc.Businesses.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
 		c.JSON(http.StatusNotFound, gin.H{
 			"status":  http.StatusNotFound,
 			"message": "Business not found",
@@ -230,6 +287,9 @@ func GetBusinessByID(c *gin.Context) {
 		})
 		return
 	}
+	if notModified(c, business.Revision) {
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
@@ -238,8 +298,8 @@ func GetBusinessByID(c *gin.Context) {
 	})
 }
 
-// RemoveBusiness deletes a business by ID
-func RemoveBusiness(c *gin.Context) {
+// RemoveBusiness moves a business to the trash by ID
+func (bc *BusinessController) RemoveBusiness(c *gin.Context) {
 	id := c.Param("id")
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -251,33 +311,59 @@ func RemoveBusiness(c *gin.Context) {
 		return
 	}
 
-	res, err := data.BusinessCollection.DeleteOne(context.TODO(), bson.M{"_id": objID})
+	report, ok := bc.deletes.run(c, integrity.Businesses, objID, "Business not found", bc.load(objID), func(ctx context.Context, report integrity.Report) ([]models.Activity, error) {
+		return []models.Activity{{
+			Type:       models.ActivityBusinessDeleted,
+			BusinessID: objID,
+			Summary:    "Business deleted",
+		}}, nil
+	})
+	if !ok {
+		return
+	}
+	bc.history.recordDelete(c.Request.Context(), actingUser(c), report)
+
+	respondDeleted(c, "Business deleted", report)
+}
+
+// RestoreBusiness brings a business back from the trash
+func (bc *BusinessController) RestoreBusiness(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": err.Error(),
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
 			"data":    map[string]interface{}{},
 		})
 		return
 	}
 
-	if res.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{
-			"status":  http.StatusNotFound,
-			"message": "Business not found",
-			"data":    map[string]interface{}{},
-		})
+	entry, ok := bc.deletes.restore(c, integrity.Businesses, objID, "Business not found in the trash", func(ctx context.Context, entry models.TrashEntry) ([]models.Activity, error) {
+		business, err := bc.Businesses.FindByID(ctx, objID)
+		if err != nil {
+			return nil, err
+		}
+		return []models.Activity{{
+			Type:       models.ActivityBusinessRestored,
+			BusinessID: business.ID,
+			UserID:     business.UserID,
+			Summary:    "Business " + business.BusinessName + " restored",
+		}}, nil
+	})
+	if !ok {
 		return
 	}
+	bc.history.recordRestore(c.Request.Context(), actingUser(c), entry)
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
-		"message": "Business deleted",
-		"data":    map[string]interface{}{},
+		"message": "Business restored",
+		"data":    entry,
 	})
 }
 
-func UpdateBusiness(c *gin.Context) {
+// UpdateBusiness modifies a business by ID
+func (bc *BusinessController) UpdateBusiness(c *gin.Context) {
 	id := c.Param("id")
 	var updatedBusiness models.Business
 	if err := c.ShouldBindJSON(&updatedBusiness); err != nil {
@@ -308,7 +394,7 @@ func UpdateBusiness(c *gin.Context) {
 		return
 	}
 
-	userExists, err := userExists(updatedBusiness.UserID)
+	userExists, err := bc.Users.Exists(c.Request.Context(), updatedBusiness.UserID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
@@ -327,7 +413,7 @@ func UpdateBusiness(c *gin.Context) {
 	}
 
 	if !updatedBusiness.EmojiID.IsZero() {
-		emojiExists, err := emojiExists(updatedBusiness.EmojiID)
+		emojiExists, err := bc.Emojis.Exists(c.Request.Context(), updatedBusiness.EmojiID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"status":  http.StatusInternalServerError,
@@ -349,7 +435,7 @@ func UpdateBusiness(c *gin.Context) {
 	}
 
 	if !updatedBusiness.ContactID.IsZero() {
-		contactExists, err := contactExists(updatedBusiness.ContactID)
+		contactExists, err := bc.Contacts.Exists(c.Request.Context(), updatedBusiness.ContactID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"status":  http.StatusInternalServerError,
@@ -370,39 +456,60 @@ func UpdateBusiness(c *gin.Context) {
 		updatedBusiness.ContactID = primitive.NilObjectID // Set a default value if ContactID is not provided
 	}
 
-	if updatedBusiness.Status < 0 || updatedBusiness.Status > 9 {
+	if !pipeline.Stage(updatedBusiness.Status).Valid() {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  http.StatusBadRequest,
-			"message": "Status must be between 0 and 9",
+			"message": "Status must be a pipeline stage between 0 and 9",
 			"data":    map[string]interface{}{},
 		})
 		return
 	}
 
-	if updatedBusiness.Status == 0 {
-		updatedBusiness.Status = 0 // Default value if not provided
+	existingBusiness, err := bc.Businesses.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Business not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if !ifMatch(c, existingBusiness.Revision, existingBusiness) {
+		return
 	}
 
-	// Exclude _id from the update
-	update := bson.M{
-		"$set": bson.M{
-			"user_id":     updatedBusiness.UserID,
-			"emoji_id":    updatedBusiness.EmojiID,
-			"contact_id":  updatedBusiness.ContactID,
-			"status":      updatedBusiness.Status,
-			"business_name":      updatedBusiness.BusinessName,
-			"business_tagline":   updatedBusiness.BusinessTagline,
-			"website":            updatedBusiness.Website,
-			"auto_followup":      updatedBusiness.AutoFollowup,
-			"last_viewed_date":   updatedBusiness.LastViewedDate,
-			"last_followup_date": updatedBusiness.LastFollowupDate,
-			"next_followup_date": updatedBusiness.NextFollowupDate,
-			// Add other fields as necessary
-		},
+	from, to := pipeline.Stage(existingBusiness.Status), pipeline.Stage(updatedBusiness.Status)
+	if err := bc.Pipeline.Check(from, to); err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"status":  http.StatusConflict,
+			"message": err.Error(),
+			"data":    gin.H{"status": from, "allowed": bc.Pipeline.Next(from)},
+		})
+		return
 	}
 
-	_, err = data.BusinessCollection.UpdateOne(context.TODO(), bson.M{"_id": objID}, update)
-	if err != nil {
+	updatedBusiness.ID = objID
+	updatedBusiness.Revision = existingBusiness.Revision
+	updatedBusiness.CreatedDate = existingBusiness.CreatedDate
+	saved, err := bc.update(c.Request.Context(), updatedBusiness, actingUser(c), from, nil)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Business not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err == data.ErrRevisionConflict {
+		bc.load(objID).conflict(c, "Business not found")
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
@@ -410,6 +517,9 @@ func UpdateBusiness(c *gin.Context) {
 		})
 		return
 	}
+	updatedBusiness = saved
+	c.Header("ETag", etag(updatedBusiness.Revision))
+	bc.history.snapshot(c.Request.Context(), actingUser(c), models.VersionUpdated, updatedBusiness)
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
@@ -417,3 +527,348 @@ func UpdateBusiness(c *gin.Context) {
 		"data":    updatedBusiness,
 	})
 }
+
+// update saves a business that was at from, and the transition userID made
+// it go through if its status changed, in one transaction so a status never
+// moves without a record of who moved it. changed names the fields a patch
+// changed, and is nil for a whole update. It returns the business as saved.
+func (bc *BusinessController) update(ctx context.Context, business models.Business, userID primitive.ObjectID, from pipeline.Stage, changed map[string]bool) (models.Business, error) {
+	var saved models.Business
+	err := bc.feed.save(ctx, func(ctx context.Context) ([]models.Activity, error) {
+		var err error
+		if saved, err = bc.Businesses.Update(ctx, business); err != nil {
+			return nil, err
+		}
+		if to := pipeline.Stage(business.Status); from != to {
+			if err := bc.Transitions.Insert(ctx, newTransition(business.ID, userID, from, to, "")); err != nil {
+				return nil, err
+			}
+		}
+		return businessUpdated(saved, from, changed), nil
+	})
+	return saved, err
+}
+
+// newTransition records userID moving a business from one status to another
+func newTransition(id, userID primitive.ObjectID, from, to pipeline.Stage, reason string) models.StatusTransition {
+	return models.StatusTransition{
+		ID:          primitive.NewObjectID(),
+		BusinessID:  id,
+		UserID:      userID,
+		FromStatus:  int(from),
+		ToStatus:    int(to),
+		Reason:      reason,
+		CreatedDate: time.Now(),
+	}
+}
+
+// statusChanged is the activity of a saved transition of a business, in the
+// feed of userID
+func statusChanged(userID primitive.ObjectID, transition models.StatusTransition) models.Activity {
+	from, to := pipeline.Stage(transition.FromStatus), pipeline.Stage(transition.ToStatus)
+	details := map[string]interface{}{"from_status": int(from), "to_status": int(to)}
+	if transition.Reason != "" {
+		details["reason"] = transition.Reason
+	}
+	return models.Activity{
+		Type:       models.ActivityStatusChanged,
+		BusinessID: transition.BusinessID,
+		UserID:     userID,
+		Summary:    "Status changed from " + from.String() + " to " + to.String(),
+		Details:    details,
+	}
+}
+
+// checkBusinessChanges validates the changed fields of a patched business
+// the way an update checks them all: the user must exist, an emoji or
+// contact must exist unless cleared, and a new status must be a stage the
+// pipeline allows moving to from existing's.
+func (bc *BusinessController) checkBusinessChanges(ctx context.Context, business *models.Business, existing models.Business, changed map[string]bool) (int, error) {
+	if touched(changed, "user_id") {
+		if business.UserID.IsZero() {
+			return http.StatusBadRequest, errors.New("Enter UserID")
+		}
+		userExists, err := bc.Users.Exists(ctx, business.UserID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !userExists {
+			return http.StatusBadRequest, errors.New("Incorrect UserID")
+		}
+	}
+
+	if touched(changed, "emoji_id") && !business.EmojiID.IsZero() {
+		emojiExists, err := bc.Emojis.Exists(ctx, business.EmojiID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !emojiExists {
+			return http.StatusBadRequest, errors.New("Incorrect EmojiID")
+		}
+	}
+
+	if touched(changed, "contact_id") && !business.ContactID.IsZero() {
+		contactExists, err := bc.Contacts.Exists(ctx, business.ContactID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !contactExists {
+			return http.StatusBadRequest, errors.New("Incorrect ContactID")
+		}
+	}
+
+	if touched(changed, "status") {
+		if !pipeline.Stage(business.Status).Valid() {
+			return http.StatusBadRequest, errors.New("Status must be a pipeline stage between 0 and 9")
+		}
+		if err := bc.Pipeline.Check(pipeline.Stage(existing.Status), pipeline.Stage(business.Status)); err != nil {
+			return http.StatusConflict, err
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// PatchBusiness changes only the fields of a business a merge patch or JSON
+// Patch sends; a field set to null is cleared. References are checked only
+// when they change, and a status change is checked against the pipeline.
+func (bc *BusinessController) PatchBusiness(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	existingBusiness, err := bc.Businesses.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Business not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if !ifMatch(c, existingBusiness.Revision, existingBusiness) {
+		return
+	}
+
+	business, changed, ok := applyPatch(c, existingBusiness, "created_date")
+	if !ok {
+		return
+	}
+	from := pipeline.Stage(existingBusiness.Status)
+	if status, err := bc.checkBusinessChanges(c.Request.Context(), &business, existingBusiness, changed); status == http.StatusConflict {
+		c.JSON(http.StatusConflict, gin.H{
+			"status":  http.StatusConflict,
+			"message": err.Error(),
+			"data":    gin.H{"status": from, "allowed": bc.Pipeline.Next(from)},
+		})
+		return
+	} else if err != nil {
+		c.JSON(status, gin.H{
+			"status":  status,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if len(changed) == 0 {
+		respondUnchanged(c, existingBusiness.Revision, existingBusiness)
+		return
+	}
+
+	saved, err := bc.update(c.Request.Context(), business, actingUser(c), from, changed)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Business not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err == data.ErrRevisionConflict {
+		bc.load(objID).conflict(c, "Business not found")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	business = saved
+	c.Header("ETag", etag(business.Revision))
+	bc.history.snapshot(c.Request.Context(), actingUser(c), models.VersionUpdated, business)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Business updated",
+		"data":    business,
+	})
+}
+
+// BulkBusinesses creates, updates and deletes many businesses at once, such
+// as moving hundreds to a new status or followup date
+func (bc *BusinessController) BulkBusinesses(c *gin.Context) {
+	bc.bulk.run(c)
+}
+
+// GetBusinessHistory retrieves a page of a business's versions, newest first
+func (bc *BusinessController) GetBusinessHistory(c *gin.Context) {
+	bc.history.list(c)
+}
+
+// GetBusinessVersionDiff retrieves the fields a version of a business changed
+func (bc *BusinessController) GetBusinessVersionDiff(c *gin.Context) {
+	bc.history.diff(c)
+}
+
+// RevertBusiness puts a business back the way it was at one of its versions.
+// Its status and follow-up dates stay as they are, since status only moves
+// through the pipeline and follow-ups belong to the scheduler. Its user must
+// still exist, as must any emoji or contact it refers to that differs from
+// the current one.
+func (bc *BusinessController) RevertBusiness(c *gin.Context) {
+	version, ok := bc.history.find(c)
+	if !ok {
+		return
+	}
+
+	var business models.Business
+	if err := bson.Unmarshal(version.Snapshot, &business); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	existingBusiness, err := bc.Businesses.FindByID(c.Request.Context(), version.DocumentID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Business not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if !ifMatch(c, existingBusiness.Revision, existingBusiness) {
+		return
+	}
+
+	if status, err := bc.checkRevertRefs(c.Request.Context(), business, existingBusiness); status == http.StatusConflict {
+		revertConflict(c, err)
+		return
+	} else if err != nil {
+		c.JSON(status, gin.H{
+			"status":  status,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	business.ID = existingBusiness.ID
+	business.Revision = existingBusiness.Revision
+	business.CreatedDate = existingBusiness.CreatedDate
+	business.Status = existingBusiness.Status
+	business.LastFollowupDate = existingBusiness.LastFollowupDate
+	business.NextFollowupDate = existingBusiness.NextFollowupDate
+	business.DeletedAt = nil
+
+	message := "Business " + business.BusinessName + " reverted to version " + strconv.Itoa(version.Version)
+	var saved models.Business
+	err = bc.feed.save(c.Request.Context(), func(ctx context.Context) ([]models.Activity, error) {
+		var err error
+		if saved, err = bc.Businesses.Update(ctx, business); err != nil {
+			return nil, err
+		}
+		return []models.Activity{{
+			Type:       models.ActivityBusinessUpdated,
+			BusinessID: saved.ID,
+			UserID:     saved.UserID,
+			Summary:    message,
+			Details:    map[string]interface{}{"reverted_from": version.Version},
+		}}, nil
+	})
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Business not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err == data.ErrRevisionConflict {
+		bc.load(business.ID).conflict(c, "Business not found")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	business = saved
+	c.Header("ETag", etag(business.Revision))
+	bc.history.recordRevert(c.Request.Context(), actingUser(c), business, version.Version)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": message,
+		"data":    business,
+	})
+}
+
+// checkRevertRefs checks that the references of a business being reverted
+// point at documents that exist. References left as they are need no check,
+// as they may hold the placeholders set at creation. It returns the HTTP
+// status to report alongside any error.
+func (bc *BusinessController) checkRevertRefs(ctx context.Context, business, existing models.Business) (int, error) {
+	userExists, err := bc.Users.Exists(ctx, business.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !userExists {
+		return http.StatusConflict, errors.New("its user no longer exists")
+	}
+
+	if business.EmojiID != existing.EmojiID && !business.EmojiID.IsZero() {
+		emojiExists, err := bc.Emojis.Exists(ctx, business.EmojiID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !emojiExists {
+			return http.StatusConflict, errors.New("its emoji no longer exists")
+		}
+	}
+
+	if business.ContactID != existing.ContactID && !business.ContactID.IsZero() {
+		contactExists, err := bc.Contacts.Exists(ctx, business.ContactID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !contactExists {
+			return http.StatusConflict, errors.New("its contact no longer exists")
+		}
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/config"
+	"usermanagement/data"
+	"usermanagement/ical"
+	"usermanagement/models"
+)
+
+// followupEventLength is how long each follow-up event blocks in the calendar
+const followupEventLength = 30 * time.Minute
+
+// CalendarController serves the per-user iCalendar feeds of upcoming follow-ups.
+// Calendar apps cannot send headers, so each feed URL carries a token signed
+// with the configured secret.
+type CalendarController struct {
+	Users      data.UserRepository
+	Businesses data.BusinessRepository
+	Contacts   data.ContactRepository
+	Secret     []byte
+	BaseURL    string
+}
+
+func NewCalendarController(repos *data.Repositories, cfg config.CalendarConfig) *CalendarController {
+	return &CalendarController{
+		Users:      repos.Users,
+		Businesses: repos.Businesses,
+		Contacts:   repos.Contacts,
+		Secret:     []byte(cfg.Secret),
+		BaseURL:    strings.TrimRight(cfg.BaseURL, "/"),
+	}
+}
+
+// feedLink is where a calendar app subscribes to a user's follow-up feed
+type feedLink struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// GetFollowupFeedLink retrieves the subscription URL of the caller's follow-up
+// feed
+func (cc *CalendarController) GetFollowupFeedLink(c *gin.Context) {
+	user, ok := cc.feedOwner(c)
+	if !ok {
+		return
+	}
+	cc.respondLink(c, user)
+}
+
+// RevokeFollowupFeedLink replaces the caller's feed token, so the URLs handed
+// out so far stop working, and retrieves the new subscription URL
+func (cc *CalendarController) RevokeFollowupFeedLink(c *gin.Context) {
+	user, ok := cc.feedOwner(c)
+	if !ok {
+		return
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	user.CalendarSalt = base64.RawURLEncoding.EncodeToString(salt)
+	if err := cc.Users.SetCalendarSalt(c.Request.Context(), user.ID, user.CalendarSalt); err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "User not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	cc.respondLink(c, user)
+}
+
+func (cc *CalendarController) respondLink(c *gin.Context, user models.User) {
+	token := cc.token(user)
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"data": feedLink{
+			URL:   cc.baseURL(c) + "/users/" + user.ID.Hex() + "/followups.ics?token=" + url.QueryEscape(token),
+			Token: token,
+		},
+	})
+}
+
+// GetFollowupFeed retrieves an iCalendar feed with an event for every business
+// the user owns whose next follow-up is still ahead
+func (cc *CalendarController) GetFollowupFeed(c *gin.Context) {
+	user, ok := cc.feedUser(c)
+	if !ok {
+		return
+	}
+	if !hmac.Equal([]byte(c.Query("token")), []byte(cc.token(user))) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  http.StatusUnauthorized,
+			"message": "Invalid calendar token",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	now := time.Now()
+	base := cc.baseURL(c)
+	host := base
+	if u, err := url.Parse(base); err == nil {
+		host = u.Host
+	}
+
+	var events []ical.Event
+	opts := data.ListOptions{
+		Filters: []data.Filter{
+			{Field: "user_id", Op: data.OpEq, Value: user.ID},
+			{Field: "next_followup_date", Op: data.OpGt, Value: now},
+		},
+		Sort: "next_followup_date",
+	}
+	err := cc.Businesses.Each(c.Request.Context(), opts, func(business models.Business) error {
+		contact, err := cc.Contacts.FindByID(c.Request.Context(), business.ContactID)
+		if err != nil && err != data.ErrNotFound {
+			return err
+		}
+		link := base + "/businesses/" + business.ID.Hex()
+		events = append(events, ical.Event{
+			UID:         "followup-" + business.ID.Hex() + "@" + host,
+			Start:       business.NextFollowupDate,
+			Duration:    followupEventLength,
+			Summary:     "Follow up: " + business.BusinessName,
+			Description: followupDescription(business, contact, link),
+			URL:         link,
+		})
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/calendar; charset=utf-8")
+	c.Header("Content-Disposition", `inline; filename="followups.ics"`)
+	c.Status(http.StatusOK)
+	cal := ical.Calendar{
+		ProdID: "-//usermanagement//followups//EN",
+		Name:   "Follow-ups for " + user.Name,
+		Events: events,
+	}
+	if err := ical.Write(c.Writer, cal, now); err != nil {
+		log.Printf("writing follow-up feed for user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+// feedUser checks that feeds are enabled and loads the user named in the
+// path, responding with an error when it returns false
+func (cc *CalendarController) feedUser(c *gin.Context) (models.User, bool) {
+	if len(cc.Secret) == 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Calendar feeds are not configured",
+			"data":    map[string]interface{}{},
+		})
+		return models.User{}, false
+	}
+
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return models.User{}, false
+	}
+
+	user, err := cc.Users.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "User not found",
+			"data":    map[string]interface{}{},
+		})
+		return user, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return user, false
+	}
+	return user, true
+}
+
+// feedOwner is feedUser for the links to a feed, which only the user owning
+// it may see
+func (cc *CalendarController) feedOwner(c *gin.Context) (models.User, bool) {
+	caller, err := callerID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  http.StatusUnauthorized,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return models.User{}, false
+	}
+	if caller.Hex() != c.Param("id") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status":  http.StatusForbidden,
+			"message": "Only the user can see their calendar link",
+			"data":    map[string]interface{}{},
+		})
+		return models.User{}, false
+	}
+	return cc.feedUser(c)
+}
+
+// token signs the user's ID and calendar salt, so a feed URL cannot be
+// guessed from another and stops working once the salt is replaced
+func (cc *CalendarController) token(user models.User) string {
+	mac := hmac.New(sha256.New, cc.Secret)
+	mac.Write([]byte("followups.ics:" + user.ID.Hex() + ":" + user.CalendarSalt))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// baseURL is the configured public address, or the one the request came in on
+func (cc *CalendarController) baseURL(c *gin.Context) string {
+	if cc.BaseURL != "" {
+		return cc.BaseURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+func followupDescription(business models.Business, contact models.Contact, link string) string {
+	var lines []string
+	if business.BusinessTagline != "" {
+		lines = append(lines, business.BusinessTagline)
+	}
+	phone := contact.CellPhone
+	if phone == "" {
+		phone = contact.WorkPhone
+	}
+	switch {
+	case phone != "" && contact.Name != "":
+		lines = append(lines, "Call "+contact.Name+" at "+phone)
+	case phone != "":
+		lines = append(lines, "Call "+phone)
+	}
+	return strings.Join(append(lines, link), "\n")
+}
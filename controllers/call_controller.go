@@ -0,0 +1,644 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/data"
+	"usermanagement/integrity"
+	"usermanagement/models"
+)
+
+// CallController serves the /calls endpoints
+type CallController struct {
+	Calls      data.CallRepository
+	Users      data.UserRepository
+	Contacts   data.ContactRepository
+	Businesses data.BusinessRepository
+	feed       changeFeed
+	bulk       bulkHandler[models.Call]
+	// businessHistory records the businesses a call moves the last
+	// follow-up of
+	businessHistory versionLog
+}
+
+func NewCallController(repos *data.Repositories) *CallController {
+	cc := &CallController{
+		Calls:      repos.Calls,
+		Users:      repos.Users,
+		Contacts:   repos.Contacts,
+		Businesses: repos.Businesses,
+		feed:       newChangeFeed(repos),
+
+		businessHistory: newVersionLog[models.Business](repos, integrity.Businesses),
+	}
+	// calls skip the trash and keep no history
+	cc.bulk = bulkHandler[models.Call]{
+		writes:   repos.Bulk,
+		feed:     cc.feed,
+		resource: integrity.Calls,
+		name:     "Call",
+		hard:     true,
+		find: func(ctx context.Context, id primitive.ObjectID) (models.Call, error) {
+			return cc.Calls.FindByID(ctx, id)
+		},
+		ref:       func(call *models.Call) (*primitive.ObjectID, *int64) { return &call.ID, &call.Revision },
+		immutable: []string{"created_date", "updated_date"},
+		create: func(ctx context.Context, call *models.Call, n int) (int, error) {
+			if status, err := cc.checkCall(ctx, call); err != nil {
+				return status, err
+			}
+			call.CreatedDate = time.Now()
+			call.UpdatedDate = time.Now()
+			return http.StatusOK, nil
+		},
+		update: func(ctx context.Context, call *models.Call, existing models.Call, changed map[string]bool) (int, error) {
+			if status, err := cc.checkCallChanges(ctx, call, changed); err != nil {
+				return status, err
+			}
+			call.UpdatedDate = time.Now()
+			return http.StatusOK, nil
+		},
+		publishes: func(call, existing models.Call, changed map[string]bool) []models.Activity {
+			if changed == nil {
+				return []models.Activity{callLogged(call)}
+			}
+			return []models.Activity{callUpdated(call, changed)}
+		},
+		created: cc.touchBusiness,
+		updated: func(ctx context.Context, userID primitive.ObjectID, call, existing models.Call, changed map[string]bool) error {
+			if !movesBusiness(changed) {
+				return nil
+			}
+			return cc.touchBusiness(ctx, userID, call)
+		},
+	}
+	return cc
+}
+
+// load reads the call with the given ID
+func (cc *CallController) load(id primitive.ObjectID) loader {
+	return func(ctx context.Context) (interface{}, int64, error) {
+		call, err := cc.Calls.FindByID(ctx, id)
+		return call, call.Revision, err
+	}
+}
+
+// checkCall validates a call and its references, filling in whichever of
+// end time and duration was left out. It returns the HTTP status to report
+// alongside any error.
+func (cc *CallController) checkCall(ctx context.Context, call *models.Call) (int, error) {
+	return cc.checkCallChanges(ctx, call, nil)
+}
+
+// checkCallChanges is checkCall for a patched call, checking only what
+// depends on the changed fields
+func (cc *CallController) checkCallChanges(ctx context.Context, call *models.Call, changed map[string]bool) (int, error) {
+	if touched(changed, "user_id") {
+		if call.UserID.IsZero() {
+			return http.StatusBadRequest, errors.New("Enter UserID")
+		}
+		userExists, err := cc.Users.Exists(ctx, call.UserID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !userExists {
+			return http.StatusBadRequest, errors.New("Incorrect UserID")
+		}
+	}
+
+	if touched(changed, "business_id") {
+		if call.BusinessID.IsZero() {
+			return http.StatusBadRequest, errors.New("Enter BusinessID")
+		}
+		businessExists, err := cc.Businesses.Exists(ctx, call.BusinessID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !businessExists {
+			return http.StatusBadRequest, errors.New("Incorrect BusinessID")
+		}
+	}
+
+	if touched(changed, "contact_id", "business_id") && !call.ContactID.IsZero() {
+		contact, err := cc.Contacts.FindByID(ctx, call.ContactID)
+		if err == data.ErrNotFound {
+			return http.StatusBadRequest, errors.New("Incorrect ContactID")
+		} else if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if contact.BusinessID != call.BusinessID {
+			return http.StatusBadRequest, errors.New("Contact does not belong to the business")
+		}
+	}
+
+	if touched(changed, "direction") && call.Direction != models.CallInbound && call.Direction != models.CallOutbound {
+		return http.StatusBadRequest, fmt.Errorf("Direction must be %s or %s", models.CallInbound, models.CallOutbound)
+	}
+
+	if touched(changed, "outcome") && call.Outcome != "" {
+		known := false
+		for _, outcome := range models.CallOutcomes {
+			if call.Outcome == outcome {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return http.StatusBadRequest, fmt.Errorf("Outcome must be one of %v", models.CallOutcomes)
+		}
+	}
+
+	if !touched(changed, "start_time", "end_time", "duration") {
+		return http.StatusOK, nil
+	}
+	if changed != nil && changed["duration"] && !changed["end_time"] {
+		// a new duration moves the end of the call instead of being
+		// recomputed from the old end
+		call.EndTime = time.Time{}
+	}
+	if call.StartTime.IsZero() {
+		return http.StatusBadRequest, errors.New("Enter StartTime")
+	}
+	if call.Duration < 0 {
+		return http.StatusBadRequest, errors.New("Duration must not be negative")
+	}
+	if !call.EndTime.IsZero() {
+		if call.EndTime.Before(call.StartTime) {
+			return http.StatusBadRequest, errors.New("EndTime must not be before StartTime")
+		}
+		call.Duration = int(call.EndTime.Sub(call.StartTime) / time.Second)
+	} else if call.Duration > 0 {
+		call.EndTime = call.StartTime.Add(time.Duration(call.Duration) * time.Second)
+	}
+
+	return http.StatusOK, nil
+}
+
+// callSummary describes a call for the activity feed
+func callSummary(call models.Call) string {
+	summary := strings.ToUpper(call.Direction[:1]) + call.Direction[1:] + " call"
+	if call.Outcome != "" {
+		summary += " (" + strings.ReplaceAll(call.Outcome, "_", " ") + ")"
+	}
+	return summary
+}
+
+// listCalls responds with a page of calls matching the query string and the extra filters
+func (cc *CallController) listCalls(c *gin.Context, extra ...data.Filter) {
+	opts, err := parseListOptions(c, data.CallFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+	opts.Filters = append(opts.Filters, extra...)
+
+	page, err := cc.Calls.List(c.Request.Context(), opts)
+	if err == data.ErrInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	respondPage(c, opts, page.Items, page.NextCursor, page.Total)
+}
+
+// GetCalls retrieves a filtered, sorted page of calls
+func (cc *CallController) GetCalls(c *gin.Context) {
+	cc.listCalls(c)
+}
+
+// GetBusinessCalls retrieves a page of the calls logged against a business
+func (cc *CallController) GetBusinessCalls(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    []interface{}{},
+		})
+		return
+	}
+	cc.listCalls(c, data.Filter{Field: "business_id", Op: data.OpEq, Value: objID})
+}
+
+// GetContactCalls retrieves a page of the calls logged against a contact
+func (cc *CallController) GetContactCalls(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    []interface{}{},
+		})
+		return
+	}
+	cc.listCalls(c, data.Filter{Field: "contact_id", Op: data.OpEq, Value: objID})
+}
+
+// PostCall logs a new call and marks the business as followed up
+func (cc *CallController) PostCall(c *gin.Context) {
+	var newCall models.Call
+	if err := c.ShouldBindJSON(&newCall); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	if status, err := cc.checkCall(c.Request.Context(), &newCall); err != nil {
+		c.JSON(status, gin.H{
+			"status":  status,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	newCall.ID = primitive.NewObjectID()
+	newCall.Revision = 1
+	newCall.CreatedDate = time.Now()
+	newCall.UpdatedDate = time.Now()
+
+	err := cc.save(c.Request.Context(), actingUser(c), newCall, true, callLogged(newCall), func(ctx context.Context) error {
+		return cc.Calls.Insert(ctx, newCall)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	c.Header("ETag", etag(newCall.Revision))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  http.StatusCreated,
+		"message": "Call created",
+		"data":    newCall,
+	})
+}
+
+// GetCallByID retrieves a call by ID
+func (cc *CallController) GetCallByID(c *gin.Context) {
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	call, err := cc.Calls.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Call not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if notModified(c, call.Revision) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"data":    call,
+	})
+}
+
+// UpdateCall modifies a call by ID
+func (cc *CallController) UpdateCall(c *gin.Context) {
+	id := c.Param("id")
+	var updatedCall models.Call
+	if err := c.ShouldBindJSON(&updatedCall); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	existingCall, err := cc.Calls.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Call not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if !ifMatch(c, existingCall.Revision, existingCall) {
+		return
+	}
+
+	if status, err := cc.checkCall(c.Request.Context(), &updatedCall); err != nil {
+		c.JSON(status, gin.H{
+			"status":  status,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	updatedCall.ID = objID
+	updatedCall.Revision = existingCall.Revision
+	updatedCall.CreatedDate = existingCall.CreatedDate
+	updatedCall.UpdatedDate = time.Now()
+
+	var saved models.Call
+	err = cc.save(c.Request.Context(), actingUser(c), updatedCall, true, callUpdated(updatedCall, nil), func(ctx context.Context) error {
+		var err error
+		saved, err = cc.Calls.Update(ctx, updatedCall)
+		return err
+	})
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Call not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err == data.ErrRevisionConflict {
+		cc.load(objID).conflict(c, "Call not found")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	c.Header("ETag", etag(saved.Revision))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Call updated",
+		"data":    saved,
+	})
+}
+
+// PatchCall changes only the fields of a call a merge patch or JSON Patch
+// sends; a field set to null is cleared. References are checked only when
+// they change.
+func (cc *CallController) PatchCall(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	existingCall, err := cc.Calls.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Call not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if !ifMatch(c, existingCall.Revision, existingCall) {
+		return
+	}
+
+	call, changed, ok := applyPatch(c, existingCall, "created_date", "updated_date")
+	if !ok {
+		return
+	}
+	if status, err := cc.checkCallChanges(c.Request.Context(), &call, changed); err != nil {
+		c.JSON(status, gin.H{
+			"status":  status,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if len(changed) == 0 {
+		respondUnchanged(c, existingCall.Revision, existingCall)
+		return
+	}
+	call.UpdatedDate = time.Now()
+
+	var saved models.Call
+	err = cc.save(c.Request.Context(), actingUser(c), call, movesBusiness(changed), callUpdated(call, changed), func(ctx context.Context) error {
+		var err error
+		saved, err = cc.Calls.Update(ctx, call)
+		return err
+	})
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Call not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err == data.ErrRevisionConflict {
+		cc.load(objID).conflict(c, "Call not found")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	c.Header("ETag", etag(saved.Revision))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Call updated",
+		"data":    saved,
+	})
+}
+
+// save runs write, which saves call, in one transaction with publishing
+// activity and, when touch is set, bringing the last followup of the call's
+// business up to it. The version of the business userID's call made is
+// recorded once that is committed, if it moved.
+func (cc *CallController) save(ctx context.Context, userID primitive.ObjectID, call models.Call, touch bool, activity models.Activity, write func(ctx context.Context) error) error {
+	var business models.Business
+	var moved bool
+	err := cc.feed.save(ctx, func(ctx context.Context) ([]models.Activity, error) {
+		if err := write(ctx); err != nil {
+			return nil, err
+		}
+		if touch {
+			var err error
+			if business, moved, err = cc.Businesses.TouchLastFollowup(ctx, call.BusinessID, call.StartTime); err != nil {
+				return nil, err
+			}
+		}
+		return []models.Activity{activity}, nil
+	})
+	if err != nil {
+		return err
+	}
+	if moved {
+		cc.businessHistory.snapshot(ctx, userID, models.VersionUpdated, business)
+	}
+	return nil
+}
+
+// touchBusiness brings the last followup of a saved call's business up to
+// it, recording the version of the business userID's call made when it moved
+func (cc *CallController) touchBusiness(ctx context.Context, userID primitive.ObjectID, call models.Call) error {
+	business, moved, err := cc.Businesses.TouchLastFollowup(ctx, call.BusinessID, call.StartTime)
+	if err != nil {
+		return err
+	}
+	if moved {
+		cc.businessHistory.snapshot(ctx, userID, models.VersionUpdated, business)
+	}
+	return nil
+}
+
+// movesBusiness reports whether a change to the named fields of a call can
+// move the last followup of a business
+func movesBusiness(changed map[string]bool) bool {
+	return touched(changed, "business_id", "start_time")
+}
+
+// callLogged is the activity of a saved new call
+func callLogged(call models.Call) models.Activity {
+	return models.Activity{
+		Type:        models.ActivityCallLogged,
+		BusinessID:  call.BusinessID,
+		ContactID:   call.ContactID,
+		UserID:      call.UserID,
+		Summary:     callSummary(call),
+		Details:     map[string]interface{}{"call_id": call.ID, "outcome": call.Outcome},
+		CreatedDate: call.StartTime,
+	}
+}
+
+// callUpdated is the activity of a saved change to a call. changed names the
+// fields a patch changed, and is nil for a whole update.
+func callUpdated(call models.Call, changed map[string]bool) models.Activity {
+	details := map[string]interface{}{"call_id": call.ID, "outcome": call.Outcome}
+	if changed != nil {
+		details["fields"] = sortedKeys(changed)
+	}
+	return models.Activity{
+		Type:       models.ActivityCallUpdated,
+		BusinessID: call.BusinessID,
+		ContactID:  call.ContactID,
+		UserID:     call.UserID,
+		Summary:    callSummary(call) + " updated",
+		Details:    details,
+	}
+}
+
+// BulkCalls creates, updates and deletes many calls at once
+func (cc *CallController) BulkCalls(c *gin.Context) {
+	cc.bulk.run(c)
+}
+
+// RemoveCall deletes a call by ID
+func (cc *CallController) RemoveCall(c *gin.Context) {
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	// calls skip the trash, so If-Match is checked against the call as read
+	// just before it goes
+	current, revision, ok := cc.load(objID).run(c, "Call not found")
+	if !ok || !ifMatch(c, revision, current) {
+		return
+	}
+
+	err = cc.Calls.Delete(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Call not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Call deleted",
+		"data":    map[string]interface{}{},
+	})
+}
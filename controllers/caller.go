@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// callerHeader names the user a request is made on behalf of. The API has no
+// authentication yet, so the header is trusted as sent.
+const callerHeader = "X-User-ID"
+
+// callerID reads the calling user's ID from the X-User-ID header
+func callerID(c *gin.Context) (primitive.ObjectID, error) {
+	raw := c.GetHeader(callerHeader)
+	if raw == "" {
+		return primitive.NilObjectID, errors.New("Send the calling user's ID in the " + callerHeader + " header")
+	}
+	id, err := primitive.ObjectIDFromHex(raw)
+	if err != nil {
+		return primitive.NilObjectID, errors.New(callerHeader + " must be a user ID")
+	}
+	return id, nil
+}
+
+// actingUser is the user named by the X-User-ID header, for recording who
+// made a change. It is the zero ID, an unknown user, when the header is
+// missing or malformed.
+func actingUser(c *gin.Context) primitive.ObjectID {
+	id, _ := callerID(c)
+	return id
+}
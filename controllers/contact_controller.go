@@ -2,64 +2,275 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
+	"usermanagement/config"
 	"usermanagement/data"
+	"usermanagement/integrity"
 	"usermanagement/models"
+	"usermanagement/phone"
 )
 
-// Check if a user exists in the database
-func contactUserExist(userID primitive.ObjectID) (bool, error) {
-	count, err := data.UserCollection.CountDocuments(context.TODO(), bson.M{"_id": userID})
-	if err != nil {
-		return false, err
+// ContactController serves the /contacts endpoints
+type ContactController struct {
+	Contacts   data.ContactRepository
+	Users      data.UserRepository
+	Businesses data.BusinessRepository
+	Calls      data.CallRepository
+	feed       changeFeed
+	// PhoneRegion reads numbers typed without a country code for users who
+	// have not set their own region
+	PhoneRegion string
+	deletes     deletePolicy
+	history     versionLog
+	// businessHistory records the businesses a merge points at the survivor
+	businessHistory versionLog
+	bulk            bulkHandler[models.Contact]
+}
+
+func NewContactController(repos *data.Repositories, cfg config.PhoneConfig, integrityCfg config.IntegrityConfig) *ContactController {
+	cc := &ContactController{
+		Contacts:    repos.Contacts,
+		Users:       repos.Users,
+		Businesses:  repos.Businesses,
+		Calls:       repos.Calls,
+		feed:        newChangeFeed(repos),
+		PhoneRegion: cfg.DefaultRegion,
+		deletes:     newDeletePolicy(repos, integrityCfg),
+		history:     newVersionLog[models.Contact](repos, integrity.Contacts),
+
+		businessHistory: newVersionLog[models.Business](repos, integrity.Businesses),
+	}
+	cc.bulk = bulkHandler[models.Contact]{
+		writes:   repos.Bulk,
+		deletes:  cc.deletes,
+		feed:     cc.feed,
+		history:  &cc.history,
+		resource: integrity.Contacts,
+		name:     "Contact",
+		find: func(ctx context.Context, id primitive.ObjectID) (models.Contact, error) {
+			return cc.Contacts.FindByID(ctx, id)
+		},
+		ref:       func(contact *models.Contact) (*primitive.ObjectID, *int64) { return &contact.ID, &contact.Revision },
+		immutable: []string{"created_date", "updated_date", "location", "cell_phone_e164", "work_phone_e164"},
+		create: func(ctx context.Context, contact *models.Contact, n int) (int, error) {
+			if status, err := cc.checkContact(ctx, contact); err != nil {
+				return status, err
+			}
+			contact.CreatedDate = time.Now()
+			contact.UpdatedDate = time.Now()
+			return http.StatusOK, nil
+		},
+		update: func(ctx context.Context, contact *models.Contact, existing models.Contact, changed map[string]bool) (int, error) {
+			if status, err := cc.checkContactChanges(ctx, contact, changed); err != nil {
+				return status, err
+			}
+			contact.UpdatedDate = time.Now()
+			return http.StatusOK, nil
+		},
+		publishes: func(contact, existing models.Contact, changed map[string]bool) []models.Activity {
+			if changed == nil {
+				return []models.Activity{contactCreated(contact)}
+			}
+			return []models.Activity{contactUpdated(contact, changed)}
+		},
+	}
+	return cc
+}
+
+// checkContact validates a contact's coordinates, phone numbers and references
+// and derives its location and E.164 numbers. It returns the HTTP status to
+// report alongside any error.
+func (cc *ContactController) checkContact(ctx context.Context, contact *models.Contact) (int, error) {
+	return cc.checkContactChanges(ctx, contact, nil)
+}
+
+// checkContactChanges is checkContact for a patched contact, checking only
+// what depends on the changed fields. A contact whose user is gone keeps
+// reading its numbers in the configured region.
+func (cc *ContactController) checkContactChanges(ctx context.Context, contact *models.Contact, changed map[string]bool) (int, error) {
+	if touched(changed, "latitude", "longitude") {
+		if err := checkCoordinates(contact.Latitude, contact.Longitude); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+	contact.SyncLocation()
+
+	if touched(changed, "user_id") && contact.UserID.IsZero() {
+		return http.StatusBadRequest, errors.New("Enter UserID")
+	}
+	if touched(changed, "user_id", "cell_phone", "work_phone") {
+		user, err := cc.Users.FindByID(ctx, contact.UserID)
+		if err == data.ErrNotFound && touched(changed, "user_id") {
+			return http.StatusBadRequest, errors.New("UserID does not exist")
+		} else if err != nil && err != data.ErrNotFound {
+			return http.StatusInternalServerError, err
+		}
+		if err := normalizePhones(contact, cc.phoneRegion(user)); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	if touched(changed, "business_id") {
+		if contact.BusinessID.IsZero() {
+			return http.StatusBadRequest, errors.New("Enter BusinessID")
+		}
+		businessExists, err := cc.Businesses.Exists(ctx, contact.BusinessID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !businessExists {
+			return http.StatusBadRequest, errors.New("BusinessID does not exist")
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// phoneRegion is the region the user's contact numbers are read in
+func (cc *ContactController) phoneRegion(user models.User) string {
+	if user.PhoneRegion != "" {
+		return user.PhoneRegion
+	}
+	return cc.PhoneRegion
+}
+
+// normalizePhones stores the E.164 form of each of the contact's numbers
+// alongside the number as typed
+func normalizePhones(contact *models.Contact, region string) error {
+	for _, number := range []struct {
+		field         string
+		display, e164 *string
+	}{
+		{"cell_phone", &contact.CellPhone, &contact.CellPhoneE164},
+		{"work_phone", &contact.WorkPhone, &contact.WorkPhoneE164},
+	} {
+		*number.display = strings.TrimSpace(*number.display)
+		if *number.display == "" {
+			*number.e164 = ""
+			continue
+		}
+		e164, err := phone.Parse(*number.display, region)
+		if err != nil {
+			return fmt.Errorf("%s %w", number.field, err)
+		}
+		*number.e164 = e164
+	}
+	return nil
+}
+
+// load reads the contact with the given ID
+func (cc *ContactController) load(id primitive.ObjectID) loader {
+	return func(ctx context.Context) (interface{}, int64, error) {
+		contact, err := cc.Contacts.FindByID(ctx, id)
+		return contact, contact.Revision, err
 	}
-	return count > 0, nil
 }
 
-// Check if a business exists in the database
-func businessExists(businessID primitive.ObjectID) (bool, error) {
-	count, err := data.BusinessCollection.CountDocuments(context.TODO(), bson.M{"_id": businessID})
+// insertContact saves a checked contact under a new ID and records its
+// creation by userID
+func (cc *ContactController) insertContact(ctx context.Context, contact *models.Contact, userID primitive.ObjectID) error {
+	contact.ID = primitive.NewObjectID()
+	contact.Revision = 1
+	contact.CreatedDate = time.Now()
+	contact.UpdatedDate = time.Now()
+
+	err := cc.feed.save(ctx, func(ctx context.Context) ([]models.Activity, error) {
+		if err := cc.Contacts.Insert(ctx, *contact); err != nil {
+			return nil, err
+		}
+		return []models.Activity{contactCreated(*contact)}, nil
+	})
 	if err != nil {
-		return false, err
+		return err
+	}
+	cc.history.snapshot(ctx, userID, models.VersionCreated, contact)
+	return nil
+}
+
+// update saves a contact and publishes the activity describe makes of it as
+// saved, in one transaction. It returns the contact as saved.
+func (cc *ContactController) update(ctx context.Context, contact models.Contact, describe func(saved models.Contact) models.Activity) (models.Contact, error) {
+	var saved models.Contact
+	err := cc.feed.save(ctx, func(ctx context.Context) ([]models.Activity, error) {
+		var err error
+		if saved, err = cc.Contacts.Update(ctx, contact); err != nil {
+			return nil, err
+		}
+		return []models.Activity{describe(saved)}, nil
+	})
+	return saved, err
+}
+
+// contactCreated is the activity of a saved new contact
+func contactCreated(contact models.Contact) models.Activity {
+	return models.Activity{
+		Type:       models.ActivityContactCreated,
+		BusinessID: contact.BusinessID,
+		ContactID:  contact.ID,
+		UserID:     contact.UserID,
+		Summary:    "Contact " + contact.Name + " created",
 	}
-	return count > 0, nil
 }
 
-// GetContacts retrieves all contacts
-func GetContacts(c *gin.Context) {
-	var contacts []models.Contact
-	cur, err := data.ContactCollection.Find(context.TODO(), bson.D{})
+// contactUpdated is the activity of a saved change to a contact. changed
+// names the fields a patch changed, and is nil for a whole update.
+func contactUpdated(contact models.Contact, changed map[string]bool) models.Activity {
+	activity := models.Activity{
+		Type:       models.ActivityContactUpdated,
+		BusinessID: contact.BusinessID,
+		ContactID:  contact.ID,
+		UserID:     contact.UserID,
+		Summary:    "Contact " + contact.Name + " updated",
+	}
+	if changed != nil {
+		activity.Details = map[string]interface{}{"fields": sortedKeys(changed)}
+	}
+	return activity
+}
+
+// GetContacts retrieves a filtered, sorted page of contacts. phone matches
+// either number of a contact however it was typed, read in region or else in
+// the region of the user_id filter.
+func (cc *ContactController) GetContacts(c *gin.Context) {
+	opts, err := parseListOptions(c, data.ContactFields, "phone", "region")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
 			"message": err.Error(),
 			"data":    []interface{}{},
 		})
 		return
 	}
-	defer cur.Close(context.TODO())
 
-	for cur.Next(context.TODO()) {
-		var contact models.Contact
-		err := cur.Decode(&contact)
+	if raw := c.Query("phone"); raw != "" {
+		status, err := cc.phoneFilter(c, raw, &opts)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status":  http.StatusInternalServerError,
+			c.JSON(status, gin.H{
+				"status":  status,
 				"message": err.Error(),
 				"data":    []interface{}{},
 			})
 			return
 		}
-		contacts = append(contacts, contact)
 	}
 
-	if err := cur.Err(); err != nil {
+	page, err := cc.Contacts.List(c.Request.Context(), opts)
+	if err == data.ErrInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
@@ -68,85 +279,58 @@ func GetContacts(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"status":  http.StatusOK,
-		"message": "success",
-		"data":    contacts,
-	})
+	respondPage(c, opts, page.Items, page.NextCursor, page.Total)
 }
 
-// PostContact creates a new contact
-func PostContact(c *gin.Context) {
-	var newContact models.Contact
-
-	if err := c.ShouldBindJSON(&newContact); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": err.Error(),
-			"data":    map[string]interface{}{},
-		})
-		return
-	}
-
-	if newContact.UserID.IsZero() {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "Enter UserID",
-			"data":    map[string]interface{}{},
-		})
-		return
+// phoneFilter narrows opts to contacts with the number raw as either of their
+// numbers. It returns the HTTP status to report alongside any error.
+func (cc *ContactController) phoneFilter(c *gin.Context, raw string, opts *data.ListOptions) (int, error) {
+	region := strings.ToUpper(c.Query("region"))
+	if region == "" {
+		region = cc.PhoneRegion
+		if userID, err := primitive.ObjectIDFromHex(c.Query("user_id")); err == nil {
+			user, err := cc.Users.FindByID(c.Request.Context(), userID)
+			if err != nil && err != data.ErrNotFound {
+				return http.StatusInternalServerError, err
+			}
+			region = cc.phoneRegion(user)
+		}
 	}
 
-	contactUserExist, err := contactUserExist(newContact.UserID)
+	e164, err := phone.Parse(raw, region)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": err.Error(),
-			"data":    map[string]interface{}{},
-		})
-		return
+		return http.StatusBadRequest, fmt.Errorf("phone %w", err)
 	}
-	if !contactUserExist {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "UserID does not exist",
-			"data":    map[string]interface{}{},
-		})
-		return
+	opts.AnyOf = []data.Filter{
+		{Field: "cell_phone_e164", Op: data.OpEq, Value: e164},
+		{Field: "work_phone_e164", Op: data.OpEq, Value: e164},
 	}
+	return http.StatusOK, nil
+}
 
-	if newContact.BusinessID.IsZero() {
+// PostContact creates a new contact
+func (cc *ContactController) PostContact(c *gin.Context) {
+	var newContact models.Contact
+
+	if err := c.ShouldBindJSON(&newContact); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  http.StatusBadRequest,
-			"message": "Enter BusinessID",
+			"message": err.Error(),
 			"data":    map[string]interface{}{},
 		})
 		return
 	}
 
-	businessExists, err := businessExists(newContact.BusinessID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
+	if status, err := cc.checkContact(c.Request.Context(), &newContact); err != nil {
+		c.JSON(status, gin.H{
+			"status":  status,
 			"message": err.Error(),
 			"data":    map[string]interface{}{},
 		})
 		return
 	}
-	if !businessExists {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "BusinessID does not exist",
-			"data":    map[string]interface{}{},
-		})
-		return
-	}
 
-	newContact.ID = primitive.NewObjectID()
-	newContact.CreatedDate = time.Now()
-	newContact.UpdatedDate = time.Now()
-
-	if _, err := data.ContactCollection.InsertOne(context.TODO(), newContact); err != nil {
+	if err := cc.insertContact(c.Request.Context(), &newContact, actingUser(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
@@ -154,6 +338,7 @@ func PostContact(c *gin.Context) {
 		})
 		return
 	}
+	c.Header("ETag", etag(newContact.Revision))
 
 	c.JSON(http.StatusCreated, gin.H{
 		"status":  http.StatusCreated,
@@ -163,7 +348,7 @@ func PostContact(c *gin.Context) {
 }
 
 // GetContactByID retrieves a contact by ID
-func GetContactByID(c *gin.Context) {
+func (cc *ContactController) GetContactByID(c *gin.Context) {
 	id := c.Param("id")
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -175,9 +360,8 @@ func GetContactByID(c *gin.Context) {
 		return
 	}
 
-	var contact models.Contact
-	err = data.ContactCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&contact)
-	if err == mongo.ErrNoDocuments {
+	contact, err := cc.Contacts.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
 		c.JSON(http.StatusNotFound, gin.H{
 			"status":  http.StatusNotFound,
 			"message": "Contact not found",
@@ -192,6 +376,9 @@ func GetContactByID(c *gin.Context) {
 		})
 		return
 	}
+	if notModified(c, contact.Revision) {
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
@@ -200,8 +387,8 @@ func GetContactByID(c *gin.Context) {
 	})
 }
 
-// RemoveContact deletes a contact by ID
-func RemoveContact(c *gin.Context) {
+// RemoveContact moves a contact to the trash by ID
+func (cc *ContactController) RemoveContact(c *gin.Context) {
 	id := c.Param("id")
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -213,34 +400,66 @@ func RemoveContact(c *gin.Context) {
 		return
 	}
 
-	res, err := data.ContactCollection.DeleteOne(context.TODO(), bson.M{"_id": objID})
+	var contact models.Contact
+	report, ok := cc.deletes.run(c, integrity.Contacts, objID, "Contact not found", func(ctx context.Context) (interface{}, int64, error) {
+		contact, err = cc.Contacts.FindByID(ctx, objID)
+		return contact, contact.Revision, err
+	}, func(ctx context.Context, report integrity.Report) ([]models.Activity, error) {
+		return []models.Activity{{
+			Type:       models.ActivityContactDeleted,
+			BusinessID: contact.BusinessID,
+			ContactID:  contact.ID,
+			UserID:     contact.UserID,
+			Summary:    "Contact " + contact.Name + " deleted",
+		}}, nil
+	})
+	if !ok {
+		return
+	}
+	cc.history.recordDelete(c.Request.Context(), actingUser(c), report)
+
+	respondDeleted(c, "Contact deleted", report)
+}
+
+// RestoreContact brings a contact back from the trash
+func (cc *ContactController) RestoreContact(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
-			"message": err.Error(),
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
 			"data":    map[string]interface{}{},
 		})
 		return
 	}
 
-	if res.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{
-			"status":  http.StatusNotFound,
-			"message": "Contact not found",
-			"data":    map[string]interface{}{},
-		})
+	entry, ok := cc.deletes.restore(c, integrity.Contacts, objID, "Contact not found in the trash", func(ctx context.Context, entry models.TrashEntry) ([]models.Activity, error) {
+		contact, err := cc.Contacts.FindByID(ctx, objID)
+		if err != nil {
+			return nil, err
+		}
+		return []models.Activity{{
+			Type:       models.ActivityContactRestored,
+			BusinessID: contact.BusinessID,
+			ContactID:  contact.ID,
+			UserID:     contact.UserID,
+			Summary:    "Contact " + contact.Name + " restored",
+		}}, nil
+	})
+	if !ok {
 		return
 	}
+	cc.history.recordRestore(c.Request.Context(), actingUser(c), entry)
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
-		"message": "Contact deleted",
-		"data":    map[string]interface{}{},
+		"message": "Contact restored",
+		"data":    entry,
 	})
 }
 
 // UpdateContact modifies a contact by ID
-func UpdateContact(c *gin.Context) {
+func (cc *ContactController) UpdateContact(c *gin.Context) {
 	id := c.Param("id")
 	var updatedContact models.Contact
 	if err := c.ShouldBindJSON(&updatedContact); err != nil {
@@ -262,17 +481,54 @@ func UpdateContact(c *gin.Context) {
 		return
 	}
 
-	if updatedContact.UserID.IsZero() {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "Enter UserID",
+	existingContact, err := cc.Contacts.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Contact not found",
 			"data":    map[string]interface{}{},
 		})
 		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if !ifMatch(c, existingContact.Revision, existingContact) {
+		return
 	}
 
-	contactUserExist, err := contactUserExist(updatedContact.UserID)
-	if err != nil {
+	if status, err := cc.checkContact(c.Request.Context(), &updatedContact); err != nil {
+		c.JSON(status, gin.H{
+			"status":  status,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	updatedContact.ID = objID
+	updatedContact.Revision = existingContact.Revision
+	updatedContact.CreatedDate = existingContact.CreatedDate
+	updatedContact.UpdatedDate = time.Now()
+
+	saved, err := cc.update(c.Request.Context(), updatedContact, func(saved models.Contact) models.Activity {
+		return contactUpdated(saved, nil)
+	})
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Contact not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err == data.ErrRevisionConflict {
+		cc.load(objID).conflict(c, "Contact not found")
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
@@ -280,26 +536,84 @@ func UpdateContact(c *gin.Context) {
 		})
 		return
 	}
-	if !contactUserExist {
+	updatedContact = saved
+	c.Header("ETag", etag(updatedContact.Revision))
+	cc.history.snapshot(c.Request.Context(), actingUser(c), models.VersionUpdated, updatedContact)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Contact updated",
+		"data":    updatedContact,
+	})
+}
+
+// PatchContact changes only the fields of a contact a merge patch or JSON
+// Patch sends; a field set to null is cleared. References are checked only
+// when they change.
+func (cc *ContactController) PatchContact(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  http.StatusBadRequest,
-			"message": "UserID does not exist",
+			"message": "Invalid ID format",
 			"data":    map[string]interface{}{},
 		})
 		return
 	}
 
-	if updatedContact.BusinessID.IsZero() {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "Enter BusinessID",
+	existingContact, err := cc.Contacts.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Contact not found",
 			"data":    map[string]interface{}{},
 		})
 		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if !ifMatch(c, existingContact.Revision, existingContact) {
+		return
 	}
 
-	businessExists, err := businessExists(updatedContact.BusinessID)
-	if err != nil {
+	contact, changed, ok := applyPatch(c, existingContact,
+		"created_date", "updated_date", "location", "cell_phone_e164", "work_phone_e164")
+	if !ok {
+		return
+	}
+	if status, err := cc.checkContactChanges(c.Request.Context(), &contact, changed); err != nil {
+		c.JSON(status, gin.H{
+			"status":  status,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if len(changed) == 0 {
+		respondUnchanged(c, existingContact.Revision, existingContact)
+		return
+	}
+	contact.UpdatedDate = time.Now()
+
+	saved, err := cc.update(c.Request.Context(), contact, func(saved models.Contact) models.Activity {
+		return contactUpdated(saved, changed)
+	})
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Contact not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err == data.ErrRevisionConflict {
+		cc.load(objID).conflict(c, "Contact not found")
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
@@ -307,21 +621,60 @@ func UpdateContact(c *gin.Context) {
 		})
 		return
 	}
-	if !businessExists {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": "BusinessID does not exist",
+	contact = saved
+	c.Header("ETag", etag(contact.Revision))
+	cc.history.snapshot(c.Request.Context(), actingUser(c), models.VersionUpdated, contact)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Contact updated",
+		"data":    contact,
+	})
+}
+
+// BulkContacts creates, updates and deletes many contacts at once
+func (cc *ContactController) BulkContacts(c *gin.Context) {
+	cc.bulk.run(c)
+}
+
+// GetContactHistory retrieves a page of a contact's versions, newest first
+func (cc *ContactController) GetContactHistory(c *gin.Context) {
+	cc.history.list(c)
+}
+
+// GetContactVersionDiff retrieves the fields a version of a contact changed
+func (cc *ContactController) GetContactVersionDiff(c *gin.Context) {
+	cc.history.diff(c)
+}
+
+// RevertContact puts a contact back the way it was at one of its versions.
+// The version is checked like an update, so it must still refer to a user
+// and a business that exist.
+func (cc *ContactController) RevertContact(c *gin.Context) {
+	version, ok := cc.history.find(c)
+	if !ok {
+		return
+	}
+
+	var contact models.Contact
+	if err := bson.Unmarshal(version.Snapshot, &contact); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
 			"data":    map[string]interface{}{},
 		})
 		return
 	}
 
-	updatedContact.UpdatedDate = time.Now()
-	filter := bson.M{"_id": objID}
-	update := bson.M{"$set": updatedContact}
-
-	result, err := data.ContactCollection.UpdateOne(context.TODO(), filter, update)
-	if err != nil {
+	existingContact, err := cc.Contacts.FindByID(c.Request.Context(), version.DocumentID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Contact not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
@@ -329,19 +682,64 @@ func UpdateContact(c *gin.Context) {
 		})
 		return
 	}
+	if !ifMatch(c, existingContact.Revision, existingContact) {
+		return
+	}
+
+	if status, err := cc.checkContact(c.Request.Context(), &contact); status == http.StatusBadRequest {
+		revertConflict(c, err)
+		return
+	} else if err != nil {
+		c.JSON(status, gin.H{
+			"status":  status,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
 
-	if result.MatchedCount == 0 {
+	contact.ID = existingContact.ID
+	contact.Revision = existingContact.Revision
+	contact.CreatedDate = existingContact.CreatedDate
+	contact.UpdatedDate = time.Now()
+	contact.DeletedAt = nil
+
+	message := "Contact " + contact.Name + " reverted to version " + strconv.Itoa(version.Version)
+	saved, err := cc.update(c.Request.Context(), contact, func(saved models.Contact) models.Activity {
+		return models.Activity{
+			Type:       models.ActivityContactUpdated,
+			BusinessID: saved.BusinessID,
+			ContactID:  saved.ID,
+			UserID:     saved.UserID,
+			Summary:    message,
+			Details:    map[string]interface{}{"reverted_from": version.Version},
+		}
+	})
+	if err == data.ErrNotFound {
 		c.JSON(http.StatusNotFound, gin.H{
 			"status":  http.StatusNotFound,
 			"message": "Contact not found",
 			"data":    map[string]interface{}{},
 		})
 		return
+	} else if err == data.ErrRevisionConflict {
+		cc.load(contact.ID).conflict(c, "Contact not found")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
 	}
+	contact = saved
+	c.Header("ETag", etag(contact.Revision))
+	cc.history.recordRevert(c.Request.Context(), actingUser(c), contact, version.Version)
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
-		"message": "Contact updated",
-		"data":    updatedContact,
+		"message": message,
+		"data":    contact,
 	})
 }
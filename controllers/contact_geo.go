@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"usermanagement/data"
+	"usermanagement/models"
+)
+
+// maxRadius is half the Earth's circumference, beyond which every point matches
+const maxRadius = 20037508.0
+
+// checkCoordinates validates a latitude and longitude pair
+func checkCoordinates(lat, lng float64) error {
+	if lat < -90 || lat > 90 {
+		return errors.New("Latitude must be between -90 and 90")
+	}
+	if lng < -180 || lng > 180 {
+		return errors.New("Longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// parseFloatParam reads a required float query parameter
+func parseFloatParam(c *gin.Context, name string) (float64, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return 0, fmt.Errorf("Enter %s", name)
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number", name)
+	}
+	return value, nil
+}
+
+// parsePoint reads the lat and lng query parameters
+func parsePoint(c *gin.Context) (lat, lng float64, err error) {
+	if lat, err = parseFloatParam(c, "lat"); err != nil {
+		return 0, 0, err
+	}
+	if lng, err = parseFloatParam(c, "lng"); err != nil {
+		return 0, 0, err
+	}
+	return lat, lng, checkCoordinates(lat, lng)
+}
+
+// parseShape reads either bbox=minLng,minLat,maxLng,maxLat or
+// polygon=lng,lat,lng,lat,... and returns it as a closed ring
+func parseShape(c *gin.Context) ([][]float64, error) {
+	var ring [][]float64
+
+	switch {
+	case c.Query("bbox") != "":
+		parts := strings.Split(c.Query("bbox"), ",")
+		if len(parts) != 4 {
+			return nil, errors.New("bbox must be minLng,minLat,maxLng,maxLat")
+		}
+		var box [4]float64
+		for i, part := range parts {
+			value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return nil, errors.New("bbox must contain numbers")
+			}
+			box[i] = value
+		}
+		if box[0] >= box[2] || box[1] >= box[3] {
+			return nil, errors.New("bbox minimums must be below its maximums")
+		}
+		ring = [][]float64{{box[0], box[1]}, {box[2], box[1]}, {box[2], box[3]}, {box[0], box[3]}}
+	case c.Query("polygon") != "":
+		parts := strings.Split(c.Query("polygon"), ",")
+		if len(parts)%2 != 0 {
+			return nil, errors.New("polygon must be a list of lng,lat pairs")
+		}
+		for i := 0; i < len(parts); i += 2 {
+			lng, errLng := strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+			lat, errLat := strconv.ParseFloat(strings.TrimSpace(parts[i+1]), 64)
+			if errLng != nil || errLat != nil {
+				return nil, errors.New("polygon must contain numbers")
+			}
+			ring = append(ring, []float64{lng, lat})
+		}
+		first, last := ring[0], ring[len(ring)-1]
+		if first[0] == last[0] && first[1] == last[1] {
+			ring = ring[:len(ring)-1]
+		}
+		if len(ring) < 3 {
+			return nil, errors.New("polygon needs at least 3 distinct points")
+		}
+	default:
+		return nil, errors.New("Enter bbox or polygon")
+	}
+
+	for _, point := range ring {
+		if err := checkCoordinates(point[1], point[0]); err != nil {
+			return nil, err
+		}
+	}
+	return append(ring, ring[0]), nil
+}
+
+// GetNearbyContacts retrieves the contacts within radius_m meters of lat,lng, nearest first
+func (cc *ContactController) GetNearbyContacts(c *gin.Context) {
+	lat, lng, err := parsePoint(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	radius, err := parseFloatParam(c, "radius_m")
+	if err == nil && (radius <= 0 || radius > maxRadius) {
+		err = fmt.Errorf("radius_m must be between 0 and %.0f", maxRadius)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	cc.respondNear(c, data.GeoQuery{Lat: lat, Lng: lng, MaxDistance: radius})
+}
+
+// GetContactsWithin retrieves the contacts inside a bbox or polygon, ordered by
+// distance from lat,lng when given and from the shape's centre otherwise
+func (cc *ContactController) GetContactsWithin(c *gin.Context) {
+	ring, err := parseShape(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	query := data.GeoQuery{Within: ring}
+	if c.Query("lat") != "" || c.Query("lng") != "" {
+		query.Lat, query.Lng, err = parsePoint(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": err.Error(),
+				"data":    []interface{}{},
+			})
+			return
+		}
+	} else {
+		vertices := ring[:len(ring)-1]
+		for _, point := range vertices {
+			query.Lng += point[0] / float64(len(vertices))
+			query.Lat += point[1] / float64(len(vertices))
+		}
+	}
+
+	cc.respondNear(c, query)
+}
+
+func (cc *ContactController) respondNear(c *gin.Context, query data.GeoQuery) {
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > data.MaxListLimit {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": fmt.Sprintf("limit must be between 1 and %d", data.MaxListLimit),
+				"data":    []interface{}{},
+			})
+			return
+		}
+		query.Limit = limit
+	}
+
+	contacts, err := cc.Contacts.Near(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+	if contacts == nil {
+		contacts = []models.ContactDistance{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"data":    contacts,
+	})
+}
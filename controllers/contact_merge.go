@@ -0,0 +1,352 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/data"
+	"usermanagement/dedupe"
+	"usermanagement/integrity"
+	"usermanagement/models"
+)
+
+// contactMergeFields copies each field that can be chosen in a merge from one
+// contact to another, keyed by its JSON name
+var contactMergeFields = map[string]func(dst *models.Contact, src models.Contact){
+	"name":        func(dst *models.Contact, src models.Contact) { dst.Name = src.Name },
+	"job_title":   func(dst *models.Contact, src models.Contact) { dst.JobTitle = src.JobTitle },
+	"description": func(dst *models.Contact, src models.Contact) { dst.Description = src.Description },
+	"cell_phone": func(dst *models.Contact, src models.Contact) {
+		dst.CellPhone, dst.CellPhoneE164 = src.CellPhone, src.CellPhoneE164
+	},
+	"work_phone": func(dst *models.Contact, src models.Contact) {
+		dst.WorkPhone, dst.WorkPhoneE164 = src.WorkPhone, src.WorkPhoneE164
+	},
+	"email":  func(dst *models.Contact, src models.Contact) { dst.Email = src.Email },
+	"street": func(dst *models.Contact, src models.Contact) { dst.Street = src.Street },
+	"city":   func(dst *models.Contact, src models.Contact) { dst.City = src.City },
+	"state":  func(dst *models.Contact, src models.Contact) { dst.State = src.State },
+	"zip":    func(dst *models.Contact, src models.Contact) { dst.Zip = src.Zip },
+	// latitude and longitude only make sense together
+	"location": func(dst *models.Contact, src models.Contact) {
+		dst.Latitude, dst.Longitude = src.Latitude, src.Longitude
+	},
+}
+
+// contactFieldEmpty reports whether a contact has no value for a merge field
+func contactFieldEmpty(contact models.Contact, field string) bool {
+	var probe models.Contact
+	contactMergeFields[field](&probe, contact)
+	return probe == models.Contact{}
+}
+
+// mergeRequest is the body of POST /contacts/merge. Fields maps a field's JSON
+// name to the contact whose value the survivor keeps.
+type mergeRequest struct {
+	SurvivorID primitive.ObjectID            `json:"survivor_id"`
+	MergedIDs  []primitive.ObjectID          `json:"merged_ids"`
+	Fields     map[string]primitive.ObjectID `json:"fields"`
+}
+
+// mergeResult is the outcome of a merge
+type mergeResult struct {
+	Contact             models.Contact       `json:"contact"`
+	MergedIDs           []primitive.ObjectID `json:"merged_ids"`
+	BusinessesRepointed int64                `json:"businesses_repointed"`
+	CallsRepointed      int64                `json:"calls_repointed"`
+}
+
+// GetDuplicateContacts retrieves clusters of contacts under the same business
+// that are likely the same person, optionally narrowed by user_id or
+// business_id. min_score sets how alike two contacts must be, between 0 and 1.
+func (cc *ContactController) GetDuplicateContacts(c *gin.Context) {
+	minScore := dedupe.DefaultMinScore
+	if raw := c.Query("min_score"); raw != "" {
+		score, err := strconv.ParseFloat(raw, 64)
+		if err != nil || score <= 0 || score > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "min_score must be a number above 0 and at most 1",
+				"data":    []interface{}{},
+			})
+			return
+		}
+		minScore = score
+	}
+
+	var opts data.ListOptions
+	for _, field := range []string{"user_id", "business_id"} {
+		raw := c.Query(field)
+		if raw == "" {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "Invalid " + field + " format",
+				"data":    []interface{}{},
+			})
+			return
+		}
+		opts.Filters = append(opts.Filters, data.Filter{Field: field, Op: data.OpEq, Value: id})
+	}
+
+	var contacts []models.Contact
+	err := cc.Contacts.Each(c.Request.Context(), opts, func(contact models.Contact) error {
+		contacts = append(contacts, contact)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	clusters := dedupe.Clusters(contacts, minScore)
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"data":    clusters,
+		"total":   len(clusters),
+	})
+}
+
+// MergeContacts folds duplicate contacts into a survivor. Each field takes the
+// value of the contact chosen for it in fields; a field left unchosen keeps the
+// survivor's value, or the first merged contact's when the survivor has none.
+// Businesses and calls pointing at the merged contacts move to the survivor,
+// and the merged contacts go to the trash, all in one transaction.
+func (cc *ContactController) MergeContacts(c *gin.Context) {
+	var req mergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	survivor, merged, status, err := cc.loadMerge(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(status, gin.H{
+			"status":  status,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	byID := map[primitive.ObjectID]models.Contact{survivor.ID: survivor}
+	for _, contact := range merged {
+		byID[contact.ID] = contact
+	}
+	result := survivor
+	sources := make(map[string]string)
+	for _, field := range sortedKeys(contactMergeFields) {
+		source := survivor
+		if chosen, ok := req.Fields[field]; ok {
+			source = byID[chosen]
+		} else if contactFieldEmpty(survivor, field) {
+			for _, contact := range merged {
+				if !contactFieldEmpty(contact, field) {
+					source = contact
+					break
+				}
+			}
+		}
+		contactMergeFields[field](&result, source)
+		if source.ID != survivor.ID {
+			sources[field] = source.ID.Hex()
+		}
+	}
+	result.SyncLocation()
+	result.UpdatedDate = time.Now()
+
+	mergedIDs := make([]primitive.ObjectID, len(merged))
+	mergedHex := make([]string, len(merged))
+	for i, contact := range merged {
+		mergedIDs[i] = contact.ID
+		mergedHex[i] = contact.ID.Hex()
+	}
+
+	names := make([]string, len(merged))
+	for i, contact := range merged {
+		names[i] = contact.Name
+	}
+
+	// the survivor is saved, the references moved and the merged contacts
+	// trashed in one transaction, so a failure part way leaves no contact
+	// half merged. conflicted names the contact that moved on meanwhile.
+	var (
+		saved      models.Contact
+		repointed  []models.Business
+		calls      int64
+		reports    []integrity.Report
+		conflicted primitive.ObjectID
+	)
+	err = cc.feed.save(c.Request.Context(), func(ctx context.Context) ([]models.Activity, error) {
+		var err error
+		saved, err = cc.Contacts.Update(ctx, result)
+		if err == data.ErrRevisionConflict {
+			conflicted = result.ID
+		}
+		if err != nil {
+			return nil, err
+		}
+		if repointed, err = cc.Businesses.ReassignContact(ctx, mergedIDs, survivor.ID); err != nil {
+			return nil, err
+		}
+		if calls, err = cc.Calls.ReassignContact(ctx, mergedIDs, survivor.ID); err != nil {
+			return nil, err
+		}
+		// nothing refers to the merged contacts any more, so they go to the
+		// trash alone and can be restored from there. A retried transaction
+		// starts the reports over.
+		reports = reports[:0]
+		for _, contact := range merged {
+			revision := contact.Revision
+			opts := data.DeleteOptions{
+				Options:  integrity.Options{Policies: cc.deletes.policies, ReassignTo: cc.deletes.reassignTo},
+				Revision: &revision,
+			}
+			report, err := cc.deletes.deletes.Delete(ctx, integrity.Contacts, contact.ID, opts)
+			if errors.Is(err, data.ErrRevisionConflict) {
+				conflicted = contact.ID
+			}
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, report)
+		}
+		return []models.Activity{{
+			Type:       models.ActivityContactMerged,
+			BusinessID: saved.BusinessID,
+			ContactID:  saved.ID,
+			UserID:     saved.UserID,
+			Summary:    "Merged " + strings.Join(names, ", ") + " into " + saved.Name,
+			Details: map[string]interface{}{
+				"merged_ids":           mergedHex,
+				"merged":               merged,
+				"fields":               sources,
+				"businesses_repointed": int64(len(repointed)),
+				"calls_repointed":      calls,
+			},
+		}}, nil
+	})
+	if errors.Is(err, data.ErrRevisionConflict) {
+		cc.load(conflicted).conflict(c, "Contact not found")
+		return
+	} else if errors.Is(err, integrity.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Contact not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	result = saved
+	ctx, userID := c.Request.Context(), actingUser(c)
+	cc.history.snapshot(ctx, userID, models.VersionUpdated, result)
+	for _, business := range repointed {
+		cc.businessHistory.snapshot(ctx, userID, models.VersionUpdated, business)
+	}
+	for _, report := range reports {
+		cc.history.recordDelete(ctx, userID, report)
+	}
+	businesses := int64(len(repointed))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Contacts merged",
+		"data": mergeResult{
+			Contact:             result,
+			MergedIDs:           mergedIDs,
+			BusinessesRepointed: businesses,
+			CallsRepointed:      calls,
+		},
+	})
+}
+
+// loadMerge validates a merge request and loads the contacts it names. It
+// returns the HTTP status to report alongside any error.
+func (cc *ContactController) loadMerge(ctx context.Context, req mergeRequest) (models.Contact, []models.Contact, int, error) {
+	if req.SurvivorID.IsZero() {
+		return models.Contact{}, nil, http.StatusBadRequest, errors.New("Enter survivor_id")
+	}
+	if len(req.MergedIDs) == 0 {
+		return models.Contact{}, nil, http.StatusBadRequest, errors.New("Enter merged_ids")
+	}
+
+	seen := map[primitive.ObjectID]bool{req.SurvivorID: true}
+	for _, id := range req.MergedIDs {
+		if seen[id] {
+			return models.Contact{}, nil, http.StatusBadRequest, fmt.Errorf("Contact %s is named more than once", id.Hex())
+		}
+		seen[id] = true
+	}
+	for field, id := range req.Fields {
+		if _, ok := contactMergeFields[field]; !ok {
+			return models.Contact{}, nil, http.StatusBadRequest, fmt.Errorf("%s cannot be merged; choose from %s", field, strings.Join(sortedKeys(contactMergeFields), ", "))
+		}
+		if !seen[id] {
+			return models.Contact{}, nil, http.StatusBadRequest, fmt.Errorf("%s must come from the survivor or a merged contact", field)
+		}
+	}
+
+	load := func(id primitive.ObjectID) (models.Contact, int, error) {
+		contact, err := cc.Contacts.FindByID(ctx, id)
+		if err == data.ErrNotFound {
+			return contact, http.StatusNotFound, fmt.Errorf("Contact %s not found", id.Hex())
+		} else if err != nil {
+			return contact, http.StatusInternalServerError, err
+		}
+		return contact, http.StatusOK, nil
+	}
+
+	survivor, status, err := load(req.SurvivorID)
+	if err != nil {
+		return survivor, nil, status, err
+	}
+	var merged []models.Contact
+	for _, id := range req.MergedIDs {
+		contact, status, err := load(id)
+		if err != nil {
+			return survivor, nil, status, err
+		}
+		if contact.BusinessID != survivor.BusinessID {
+			return survivor, nil, http.StatusBadRequest, errors.New("Contacts to merge must belong to the same business")
+		}
+		merged = append(merged, contact)
+	}
+	return survivor, merged, http.StatusOK, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/data"
+	"usermanagement/models"
+	"usermanagement/vcard"
+)
+
+// vcardContentType is the media type of .vcf files
+const vcardContentType = "text/vcard; charset=utf-8"
+
+// GetContactVCard retrieves a contact as a vCard 4.0 file
+func (cc *ContactController) GetContactVCard(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	contact, err := cc.Contacts.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Contact not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	business, err := cc.Businesses.FindByID(c.Request.Context(), contact.BusinessID)
+	if err != nil && err != data.ErrNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	c.Header("Content-Type", vcardContentType)
+	c.Header("Content-Disposition", `attachment; filename="contact-`+contact.ID.Hex()+`.vcf"`)
+	c.Status(http.StatusOK)
+	if err := vcard.Encode(c.Writer, contact, business.BusinessName); err != nil {
+		log.Printf("writing vCard for contact %s: %v", contact.ID.Hex(), err)
+	}
+}
+
+// GetBusinessVCards streams every contact of a business as one multi-card .vcf file
+func (cc *ContactController) GetBusinessVCards(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	business, err := cc.Businesses.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Business not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	c.Header("Content-Type", vcardContentType)
+	c.Header("Content-Disposition", `attachment; filename="business-`+business.ID.Hex()+`.vcf"`)
+	c.Header("Trailer", exportErrorTrailer)
+	c.Status(http.StatusOK)
+
+	opts := data.ListOptions{Filters: []data.Filter{{Field: "business_id", Op: data.OpEq, Value: business.ID}}}
+	err = cc.Contacts.Each(c.Request.Context(), opts, func(contact models.Contact) error {
+		return vcard.Encode(c.Writer, contact, business.BusinessName)
+	})
+	if err != nil {
+		log.Printf("writing vCards for business %s: %v", business.ID.Hex(), err)
+		c.Writer.Header().Set(exportErrorTrailer, err.Error())
+	}
+}
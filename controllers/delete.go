@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/config"
+	"usermanagement/data"
+	"usermanagement/integrity"
+	"usermanagement/models"
+)
+
+// deletePolicy moves documents to the trash under the configured relation
+// policies and restores them
+type deletePolicy struct {
+	deletes    data.Deleter
+	feed       changeFeed
+	policies   integrity.Policies
+	reassignTo primitive.ObjectID
+}
+
+func newDeletePolicy(repos *data.Repositories, cfg config.IntegrityConfig) deletePolicy {
+	// both were checked when the configuration was loaded
+	policies, _ := integrity.DefaultPolicies.With(cfg.Policies)
+	reassignTo, _ := primitive.ObjectIDFromHex(cfg.ReassignTo)
+	return deletePolicy{deletes: repos.Deletes, feed: newChangeFeed(repos), policies: policies, reassignTo: reassignTo}
+}
+
+// run deletes the document of resource with the given ID and whatever its
+// relations take with it. ?dry_run=true only reports what would change and
+// ?reassign_to= names the user reassigned references go to. An If-Match
+// header must match the revision load reads, and the delete fails with 412 if
+// the document moves on before it is trashed. On failure it responds itself
+// and returns false, using notFound as the 404 message. publish, if given,
+// names the activities a delete that is not a dry run publishes, and runs
+// in its transaction.
+func (d deletePolicy) run(c *gin.Context, resource string, id primitive.ObjectID, notFound string, load loader,
+	publish func(ctx context.Context, report integrity.Report) ([]models.Activity, error)) (integrity.Report, bool) {
+	current, revision, ok := load.run(c, notFound)
+	if !ok || !ifMatch(c, revision, current) {
+		return integrity.Report{}, false
+	}
+	opts := data.DeleteOptions{Options: integrity.Options{Policies: d.policies, ReassignTo: d.reassignTo}}
+	if c.GetHeader("If-Match") != "" {
+		opts.Revision = &revision
+	}
+
+	if raw := c.Query("dry_run"); raw != "" {
+		dryRun, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "dry_run must be true or false",
+				"data":    map[string]interface{}{},
+			})
+			return integrity.Report{}, false
+		}
+		opts.DryRun = dryRun
+	}
+	if raw := c.Query("reassign_to"); raw != "" {
+		reassignTo, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "reassign_to must be a user ID",
+				"data":    map[string]interface{}{},
+			})
+			return integrity.Report{}, false
+		}
+		opts.ReassignTo = reassignTo
+	}
+
+	var report integrity.Report
+	err := d.feed.save(c.Request.Context(), func(ctx context.Context) ([]models.Activity, error) {
+		var err error
+		if report, err = d.deletes.Delete(ctx, resource, id, opts); err != nil || report.DryRun || publish == nil {
+			return nil, err
+		}
+		return publish(ctx, report)
+	})
+	var restricted *integrity.RestrictError
+	switch {
+	case err == nil:
+		return report, true
+	case errors.Is(err, data.ErrRevisionConflict):
+		load.conflict(c, notFound)
+	case errors.Is(err, integrity.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": notFound,
+			"data":    map[string]interface{}{},
+		})
+	case errors.As(err, &restricted):
+		c.JSON(http.StatusConflict, gin.H{
+			"status":  http.StatusConflict,
+			"message": "Cannot delete: " + err.Error(),
+			"data":    gin.H{"relation": restricted.Relation, "count": restricted.Count},
+		})
+	case errors.Is(err, integrity.ErrNoReassignTarget), errors.Is(err, integrity.ErrReassignTarget):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+	}
+	return report, false
+}
+
+// restore brings the document of resource with the given ID back from the
+// trash with everything deleted along with it. On failure it responds itself
+// and returns false, using notFound as the 404 message. publish, if given,
+// names the activities the restore publishes, and runs in its transaction.
+func (d deletePolicy) restore(c *gin.Context, resource string, id primitive.ObjectID, notFound string,
+	publish func(ctx context.Context, entry models.TrashEntry) ([]models.Activity, error)) (models.TrashEntry, bool) {
+	var entry models.TrashEntry
+	err := d.feed.save(c.Request.Context(), func(ctx context.Context) ([]models.Activity, error) {
+		var err error
+		if entry, err = d.deletes.Restore(ctx, resource, id); err != nil || publish == nil {
+			return nil, err
+		}
+		return publish(ctx, entry)
+	})
+	var deletedWith *data.DeletedWithError
+	var trashedRef *data.TrashedRefError
+	switch {
+	case err == nil:
+		return entry, true
+	case err == data.ErrNotFound:
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": notFound,
+			"data":    map[string]interface{}{},
+		})
+	case errors.As(err, &deletedWith):
+		c.JSON(http.StatusConflict, gin.H{
+			"status":  http.StatusConflict,
+			"message": "Cannot restore: " + err.Error(),
+			"data":    gin.H{"resource": deletedWith.Resource, "id": deletedWith.ID},
+		})
+	case errors.As(err, &trashedRef):
+		c.JSON(http.StatusConflict, gin.H{
+			"status":  http.StatusConflict,
+			"message": "Cannot restore: " + err.Error(),
+			"data":    gin.H{"relation": trashedRef.Relation, "resource": trashedRef.Resource, "id": trashedRef.ID},
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+	}
+	return entry, false
+}
+
+// respondDeleted reports a finished delete, or what a dry run would have done
+func respondDeleted(c *gin.Context, message string, report integrity.Report) {
+	if report.DryRun {
+		message = "Dry run: nothing was deleted"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": message,
+		"data":    report,
+	})
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"usermanagement/openapi"
+)
+
+// apiInfo describes the API at the top of its OpenAPI document
+var apiInfo = openapi.Info{
+	Title:   "User management API",
+	Version: "1.0.0",
+	Description: "Every JSON response is an envelope of status, the HTTP status, message, saying what happened " +
+		"or what went wrong, and data, the result. Lists add next_cursor and total.\n\n" +
+		"Documents carry a revision, served as their ETag. Send it in If-Match to change a document only " +
+		"if nobody else has since; the server may be configured to require it on every PUT, PATCH and DELETE.",
+}
+
+// DocsController serves the OpenAPI document describing the routes and a
+// page to browse it
+type DocsController struct {
+	doc *openapi.Document
+}
+
+func NewDocsController() *DocsController {
+	return &DocsController{}
+}
+
+// Describe builds the document from the routes, which are every route the
+// router registered. A route the endpoints table leaves out is logged and
+// missing from the document.
+func (dc *DocsController) Describe(routes gin.RoutesInfo) {
+	doc, undocumented := openapi.Build(apiInfo, routes, endpoints())
+	for _, route := range undocumented {
+		log.Printf("openapi: %s is not documented", route)
+	}
+	dc.doc = doc
+}
+
+// GetOpenAPI retrieves the OpenAPI document
+func (dc *DocsController) GetOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, dc.doc)
+}
+
+// GetDocs retrieves a page rendering the OpenAPI document
+func (dc *DocsController) GetDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", openapi.Page)
+}
+
+// GetDocsAsset retrieves a script or stylesheet the docs page loads
+func (dc *DocsController) GetDocsAsset(c *gin.Context) {
+	c.FileFromFS(c.Param("filepath"), http.FS(openapi.Assets))
+}
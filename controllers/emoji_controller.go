@@ -3,44 +3,87 @@ package controllers
 import (
 	"context"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
+	"usermanagement/config"
 	"usermanagement/data"
+	"usermanagement/integrity"
 	"usermanagement/models"
 )
 
-func GetEmojis(c *gin.Context) {
-	var emojis []models.Emoji
-	cur, err := data.EmojiCollection.Find(context.TODO(), bson.D{})
+// EmojiController serves the /emojis endpoints
+type EmojiController struct {
+	Emojis  data.EmojiRepository
+	deletes deletePolicy
+	history versionLog
+	bulk    bulkHandler[models.Emoji]
+}
+
+func NewEmojiController(repos *data.Repositories, integrityCfg config.IntegrityConfig) *EmojiController {
+	ec := &EmojiController{
+		Emojis:  repos.Emojis,
+		deletes: newDeletePolicy(repos, integrityCfg),
+		history: newVersionLog[models.Emoji](repos, integrity.Emojis),
+	}
+	ec.bulk = bulkHandler[models.Emoji]{
+		writes:   repos.Bulk,
+		deletes:  ec.deletes,
+		history:  &ec.history,
+		resource: integrity.Emojis,
+		name:     "Emoji",
+		find: func(ctx context.Context, id primitive.ObjectID) (models.Emoji, error) {
+			return ec.Emojis.FindByID(ctx, id)
+		},
+		ref:       func(emoji *models.Emoji) (*primitive.ObjectID, *int64) { return &emoji.ID, &emoji.Revision },
+		immutable: []string{"created_date"},
+		create: func(ctx context.Context, emoji *models.Emoji, n int) (int, error) {
+			count, err := ec.Emojis.Count(ctx)
+			if err != nil {
+				return http.StatusInternalServerError, err
+			}
+			emoji.Created_Date = time.Now()
+			emoji.Emoji_Index = int(count) + n + 1
+			return http.StatusOK, nil
+		},
+		update: func(ctx context.Context, emoji *models.Emoji, existing models.Emoji, changed map[string]bool) (int, error) {
+			return http.StatusOK, nil
+		},
+	}
+	return ec
+}
+
+// load reads the emoji with the given ID
+func (ec *EmojiController) load(id primitive.ObjectID) loader {
+	return func(ctx context.Context) (interface{}, int64, error) {
+		emoji, err := ec.Emojis.FindByID(ctx, id)
+		return emoji, emoji.Revision, err
+	}
+}
+
+func (ec *EmojiController) GetEmojis(c *gin.Context) {
+	opts, err := parseListOptions(c, data.EmojiFields)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
 			"message": err.Error(),
 			"data":    []interface{}{},
 		})
 		return
 	}
-	defer cur.Close(context.TODO())
 
-	for cur.Next(context.TODO()) {
-		var emoji models.Emoji
-		err := cur.Decode(&emoji)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status":  http.StatusInternalServerError,
-				"message": err.Error(),
-				"data":    []interface{}{},
-			})
-			return
-		}
-		emojis = append(emojis, emoji)
-	}
-
-	if err := cur.Err(); err != nil {
+	page, err := ec.Emojis.List(c.Request.Context(), opts)
+	if err == data.ErrInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
@@ -49,14 +92,10 @@ func GetEmojis(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"status":  http.StatusOK,
-		"message": "success",
-		"data":    emojis,
-	})
+	respondPage(c, opts, page.Items, page.NextCursor, page.Total)
 }
 
-func PostEmoji(c *gin.Context) {
+func (ec *EmojiController) PostEmoji(c *gin.Context) {
 	var newEmoji models.Emoji
 
 	if err := c.ShouldBindJSON(&newEmoji); err != nil {
@@ -69,8 +108,9 @@ func PostEmoji(c *gin.Context) {
 	}
 
 	newEmoji.ID = primitive.NewObjectID()
+	newEmoji.Revision = 1
 	newEmoji.Created_Date = time.Now()
-    count, err := data.EmojiCollection.CountDocuments(context.TODO(), bson.D{})
+	count, err := ec.Emojis.Count(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
@@ -80,7 +120,7 @@ func PostEmoji(c *gin.Context) {
 		return
 	}
 	newEmoji.Emoji_Index = int(count) + 1
-	if _, err := data.EmojiCollection.InsertOne(context.TODO(), newEmoji); err != nil {
+	if err := ec.Emojis.Insert(c.Request.Context(), newEmoji); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
@@ -88,6 +128,8 @@ func PostEmoji(c *gin.Context) {
 		})
 		return
 	}
+	c.Header("ETag", etag(newEmoji.Revision))
+	ec.history.snapshot(c.Request.Context(), actingUser(c), models.VersionCreated, newEmoji)
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
@@ -96,44 +138,46 @@ func PostEmoji(c *gin.Context) {
 	})
 }
 
-func GetEmojiByID(c *gin.Context) {
-    id := c.Param("id")
-    objID, err := primitive.ObjectIDFromHex(id)
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{
+func (ec *EmojiController) GetEmojiByID(c *gin.Context) {
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  http.StatusBadRequest,
 			"message": "Invalid ID format",
 			"data":    map[string]interface{}{},
 		})
-        return
-    }
+		return
+	}
 
-    var emoji models.Emoji
-    err = data.EmojiCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&emoji)
-    if err == mongo.ErrNoDocuments {
-        c.JSON(http.StatusNotFound, gin.H{
+	emoji, err := ec.Emojis.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
 			"status":  http.StatusNotFound,
 			"message": "Emoji not found",
 			"data":    map[string]interface{}{},
 		})
-        return
-    } else if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
 			"data":    map[string]interface{}{},
 		})
-        return
-    }
+		return
+	}
+	if notModified(c, emoji.Revision) {
+		return
+	}
 
-    c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
 		"message": "success",
 		"data":    emoji,
 	})
 }
 
-func RemoveEmoji(c *gin.Context) {
+func (ec *EmojiController) RemoveEmoji(c *gin.Context) {
 	id := c.Param("id")
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -145,8 +189,148 @@ func RemoveEmoji(c *gin.Context) {
 		return
 	}
 
-	res, err := data.EmojiCollection.DeleteOne(context.TODO(), bson.M{"_id": objID})
+	report, ok := ec.deletes.run(c, integrity.Emojis, objID, "Emoji not found", ec.load(objID), nil)
+	if !ok {
+		return
+	}
+	ec.history.recordDelete(c.Request.Context(), actingUser(c), report)
+
+	respondDeleted(c, "Emoji removed", report)
+}
+
+// RestoreEmoji brings a emoji back from the trash
+func (ec *EmojiController) RestoreEmoji(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	entry, ok := ec.deletes.restore(c, integrity.Emojis, objID, "Emoji not found in the trash", nil)
+	if !ok {
+		return
+	}
+	ec.history.recordRestore(c.Request.Context(), actingUser(c), entry)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Emoji restored",
+		"data":    entry,
+	})
+}
+
+func (ec *EmojiController) UpdateEmoji(c *gin.Context) {
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	var updatedEmoji models.Emoji
+	if err := c.ShouldBindJSON(&updatedEmoji); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	// Fetch the existing emoji to retain fields that are not being updated
+	existingEmoji, err := ec.Emojis.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Emoji not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if !ifMatch(c, existingEmoji.Revision, existingEmoji) {
+		return
+	}
+
+	// Update only the fields provided, keeping other fields unchanged
+	if updatedEmoji.Emoji != "" {
+		existingEmoji.Emoji = updatedEmoji.Emoji
+	}
+	if updatedEmoji.Emoji_Name != "" {
+		existingEmoji.Emoji_Name = updatedEmoji.Emoji_Name
+	}
+	if updatedEmoji.Emoji_Index != 0 {
+		existingEmoji.Emoji_Index = updatedEmoji.Emoji_Index
+	}
+	existingEmoji.Created_Date = updatedEmoji.Created_Date // or keep it unchanged if needed
+
+	saved, err := ec.Emojis.Update(c.Request.Context(), existingEmoji)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Emoji not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err == data.ErrRevisionConflict {
+		ec.load(objID).conflict(c, "Emoji not found")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	existingEmoji = saved
+	c.Header("ETag", etag(existingEmoji.Revision))
+	ec.history.snapshot(c.Request.Context(), actingUser(c), models.VersionUpdated, existingEmoji)
+
+	// Return the updated emoji data
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Emoji updated",
+		"data":    existingEmoji,
+	})
+}
+
+// PatchEmoji changes only the fields of an emoji a merge patch or JSON Patch
+// sends; a field set to null is cleared
+func (ec *EmojiController) PatchEmoji(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	existingEmoji, err := ec.Emojis.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Emoji not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
@@ -154,103 +338,132 @@ func RemoveEmoji(c *gin.Context) {
 		})
 		return
 	}
+	if !ifMatch(c, existingEmoji.Revision, existingEmoji) {
+		return
+	}
 
-	if res.DeletedCount == 0 {
+	emoji, changed, ok := applyPatch(c, existingEmoji, "created_date")
+	if !ok {
+		return
+	}
+	if len(changed) == 0 {
+		respondUnchanged(c, existingEmoji.Revision, existingEmoji)
+		return
+	}
+
+	saved, err := ec.Emojis.Update(c.Request.Context(), emoji)
+	if err == data.ErrNotFound {
 		c.JSON(http.StatusNotFound, gin.H{
 			"status":  http.StatusNotFound,
 			"message": "Emoji not found",
 			"data":    map[string]interface{}{},
 		})
 		return
+	} else if err == data.ErrRevisionConflict {
+		ec.load(objID).conflict(c, "Emoji not found")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
 	}
+	emoji = saved
+	c.Header("ETag", etag(emoji.Revision))
+	ec.history.snapshot(c.Request.Context(), actingUser(c), models.VersionUpdated, emoji)
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
-		"message": "Emoji removed",
-		"data":    map[string]interface{}{},
+		"message": "Emoji updated",
+		"data":    emoji,
 	})
 }
 
-func UpdateEmoji(c *gin.Context) {
-    id := c.Param("id")
-    objID, err := primitive.ObjectIDFromHex(id)
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{
-            "status":  http.StatusBadRequest,
-            "message": "Invalid ID format",
-            "data":    map[string]interface{}{},
-        })
-        return
-    }
-
-    var updatedEmoji models.Emoji
-    if err := c.ShouldBindJSON(&updatedEmoji); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{
-            "status":  http.StatusBadRequest,
-            "message": err.Error(),
-            "data":    map[string]interface{}{},
-        })
-        return
-    }
-
-    // Fetch the existing emoji to retain fields that are not being updated
-    var existingEmoji models.Emoji
-    err = data.EmojiCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&existingEmoji)
-    if err == mongo.ErrNoDocuments {
-        c.JSON(http.StatusNotFound, gin.H{
-            "status":  http.StatusNotFound,
-            "message": "Emoji not found",
-            "data":    map[string]interface{}{},
-        })
-        return
-    } else if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{
-            "status":  http.StatusInternalServerError,
-            "message": err.Error(),
-            "data":    map[string]interface{}{},
-        })
-        return
-    }
-
-    // Update only the fields provided, keeping other fields unchanged
-    if updatedEmoji.Emoji != "" {
-        existingEmoji.Emoji = updatedEmoji.Emoji
-    }
-    if updatedEmoji.Emoji_Name != "" {
-        existingEmoji.Emoji_Name = updatedEmoji.Emoji_Name
-    }
-    if updatedEmoji.Emoji_Index != 0 {
-        existingEmoji.Emoji_Index = updatedEmoji.Emoji_Index
-    }
-    existingEmoji.Created_Date = updatedEmoji.Created_Date // or keep it unchanged if needed
-
-    update := bson.M{
-        "$set": existingEmoji,
-    }
-
-    res, err := data.EmojiCollection.UpdateOne(context.TODO(), bson.M{"_id": objID}, update)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{
-            "status":  http.StatusInternalServerError,
-            "message": err.Error(),
-            "data":    map[string]interface{}{},
-        })
-        return
-    }
-
-    if res.MatchedCount == 0 {
-        c.JSON(http.StatusNotFound, gin.H{
-            "status":  http.StatusNotFound,
-            "message": "Emoji not found",
-            "data":    map[string]interface{}{},
-        })
-        return
-    }
-
-    // Return the updated emoji data
-    c.JSON(http.StatusOK, gin.H{
-        "status":  http.StatusOK,
-        "message": "Emoji updated",
-        "data":    existingEmoji,
-    })
+// BulkEmojis creates, updates and deletes many emojis at once
+func (ec *EmojiController) BulkEmojis(c *gin.Context) {
+	ec.bulk.run(c)
+}
+
+// GetEmojiHistory retrieves a page of an emoji's versions, newest first
+func (ec *EmojiController) GetEmojiHistory(c *gin.Context) {
+	ec.history.list(c)
+}
+
+// GetEmojiVersionDiff retrieves the fields a version of an emoji changed
+func (ec *EmojiController) GetEmojiVersionDiff(c *gin.Context) {
+	ec.history.diff(c)
+}
+
+// RevertEmoji puts an emoji back the way it was at one of its versions
+func (ec *EmojiController) RevertEmoji(c *gin.Context) {
+	version, ok := ec.history.find(c)
+	if !ok {
+		return
+	}
+
+	var emoji models.Emoji
+	if err := bson.Unmarshal(version.Snapshot, &emoji); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	existingEmoji, err := ec.Emojis.FindByID(c.Request.Context(), version.DocumentID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Emoji not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if !ifMatch(c, existingEmoji.Revision, existingEmoji) {
+		return
+	}
+
+	emoji.ID = existingEmoji.ID
+	emoji.Revision = existingEmoji.Revision
+	emoji.Created_Date = existingEmoji.Created_Date
+	emoji.DeletedAt = nil
+
+	saved, err := ec.Emojis.Update(c.Request.Context(), emoji)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Emoji not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err == data.ErrRevisionConflict {
+		ec.load(emoji.ID).conflict(c, "Emoji not found")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	emoji = saved
+	c.Header("ETag", etag(emoji.Revision))
+	ec.history.recordRevert(c.Request.Context(), actingUser(c), emoji, version.Version)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Emoji reverted to version " + strconv.Itoa(version.Version),
+		"data":    emoji,
+	})
 }
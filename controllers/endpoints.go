@@ -0,0 +1,410 @@
+package controllers
+
+import (
+	"net/http"
+
+	"usermanagement/data"
+	"usermanagement/exporter"
+	"usermanagement/integrity"
+	"usermanagement/models"
+	"usermanagement/openapi"
+	"usermanagement/patch"
+)
+
+// importForm is the form an import file may be uploaded as instead of the
+// raw request body
+type importForm struct {
+	File []byte `json:"file"`
+}
+
+var (
+	// recorded is the X-User-ID header of a change, which history records as
+	// who made it
+	recorded = openapi.Parameter{Name: callerHeader, Description: "The user making the change, recorded in its history", Schema: openapi.ObjectID()}
+	// caller is the X-User-ID header of a request that is refused without it
+	caller = openapi.Parameter{Name: callerHeader, Required: true, Description: "The calling user", Schema: openapi.ObjectID()}
+
+	versionParam = openapi.Parameter{Name: "version", Description: "A version number, counted from 1", Schema: &openapi.Schema{Type: "integer"}}
+
+	// deleteQuery are the query parameters of a delete through the trash
+	deleteQuery = []openapi.Parameter{
+		{Name: "dry_run", Description: "Only report what the delete would remove and change", Schema: &openapi.Schema{Type: "boolean"}},
+		{Name: "reassign_to", Description: "The user references under the reassign policy go to", Schema: openapi.ObjectID()},
+	}
+
+	// importQuery are the options of an import, read from the form or the query string
+	importQuery = []openapi.Parameter{
+		{Name: "mapping", Description: "A JSON object of field name to column header", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "user_id", Description: "The user of rows that leave it out", Schema: openapi.ObjectID()},
+		{Name: "business_id", Description: "The business of rows that leave it out", Schema: openapi.ObjectID()},
+		{Name: "dry_run", Description: "Only validate the rows", Schema: &openapi.Schema{Type: "boolean"}},
+		{Name: "background", Description: "Run the import as a job to poll; large files always do", Schema: &openapi.Schema{Type: "boolean"}},
+	}
+)
+
+// jsonBody is a JSON request body holding v
+func jsonBody(v interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": v}
+}
+
+// patchBody is a PATCH request body for a document like doc
+func patchBody(doc interface{}) map[string]interface{} {
+	return map[string]interface{}{patch.MergePatchType: doc, patch.JSONPatchType: []patch.Operation{}}
+}
+
+// uploadBody is an import file of the given media type, sent raw or as the
+// file field of a form
+func uploadBody(mediaType string) map[string]interface{} {
+	return map[string]interface{}{mediaType: "", "multipart/form-data": importForm{}}
+}
+
+// documentEndpoints documents the routes under base of a resource whose
+// documents go through the trash and keep a history. name is a document of
+// it as summaries read, such as "a user"; conflicts are the extra statuses a
+// replace fails with.
+func documentEndpoints[T any](tag, base, name string, fields data.Fields, created int, conflicts ...int) map[string]openapi.Endpoint {
+	var doc T
+	return map[string]openapi.Endpoint{
+		"GET " + base: {
+			Tag: tag, Summary: "List " + tag, Filters: fields,
+			Data: []T{}, Paged: true, Errors: []int{http.StatusBadRequest},
+		},
+		"POST " + base: {
+			Tag: tag, Summary: "Create " + name, Headers: []openapi.Parameter{recorded},
+			Body: jsonBody(doc), Status: []int{created}, Data: doc,
+			Errors: []int{http.StatusBadRequest},
+		},
+		"POST " + base + "/bulk": {
+			Tag: tag, Summary: "Create, update and delete many " + tag,
+			Description: "Responds 207 when an operation failed, with the outcome of each at its index.",
+			Headers:     []openapi.Parameter{recorded},
+			Body:        jsonBody(bulkRequest{}), Status: []int{http.StatusOK, http.StatusMultiStatus},
+			Data: []bulkItem{}, Errors: []int{http.StatusBadRequest},
+		},
+		"GET " + base + "/:id": {
+			Tag: tag, Summary: "Get " + name, Data: doc,
+			Errors: []int{http.StatusNotModified, http.StatusBadRequest, http.StatusNotFound},
+		},
+		"PUT " + base + "/:id": {
+			Tag: tag, Summary: "Replace " + name, Headers: []openapi.Parameter{recorded},
+			Body: jsonBody(doc), Data: doc,
+			Errors: append([]int{http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusPreconditionRequired}, conflicts...),
+		},
+		"PATCH " + base + "/:id": {
+			Tag: tag, Summary: "Change fields of " + name,
+			Description: "Takes a JSON Merge Patch or a JSON Patch; a failed test operation answers 409.",
+			Headers:     []openapi.Parameter{recorded}, Body: patchBody(doc), Data: doc,
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed, http.StatusUnsupportedMediaType, http.StatusPreconditionRequired},
+		},
+		"DELETE " + base + "/:id": {
+			Tag: tag, Summary: "Move " + name + " to the trash",
+			Description: "Relations follow their configured policies; 409 answers when a restrict relation still has references.",
+			Query:       deleteQuery, Headers: []openapi.Parameter{recorded}, Data: integrity.Report{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+		},
+		"POST " + base + "/:id/restore": {
+			Tag: tag, Summary: "Restore " + name + " from the trash", Headers: []openapi.Parameter{recorded},
+			Data: models.TrashEntry{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict},
+		},
+		"GET " + base + "/:id/history": {
+			Tag: tag, Summary: "List the versions of " + name, Filters: data.VersionFields,
+			Data: []versionView{}, Paged: true, Errors: []int{http.StatusBadRequest},
+		},
+		"GET " + base + "/:id/history/:version/diff": {
+			Tag: tag, Summary: "Compare a version of " + name + " with the one before",
+			Path:  []openapi.Parameter{versionParam},
+			Query: []openapi.Parameter{{Name: "against", Description: "The version to compare with instead", Schema: &openapi.Schema{Type: "integer"}}},
+			Data:  versionDiff{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		},
+		"POST " + base + "/:id/revert/:version": {
+			Tag: tag, Summary: "Put " + name + " back the way it was at a version",
+			Path: []openapi.Parameter{versionParam}, Headers: []openapi.Parameter{recorded}, Data: doc,
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed},
+		},
+	}
+}
+
+// endpoints documents every route the router registers, keyed as
+// openapi.Key keys them
+func endpoints() map[string]openapi.Endpoint {
+	all := map[string]openapi.Endpoint{
+		// Calendar
+		"GET /users/:id/calendar": {
+			Tag: "users", Summary: "Get the subscription link of the caller's follow-up calendar",
+			Headers: []openapi.Parameter{caller}, Data: feedLink{},
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusServiceUnavailable},
+		},
+		"DELETE /users/:id/calendar": {
+			Tag: "users", Summary: "Revoke the caller's follow-up calendar links and get a new one",
+			Headers: []openapi.Parameter{caller}, Data: feedLink{},
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusServiceUnavailable},
+		},
+		"GET /users/:id/followups.ics": {
+			Tag: "users", Summary: "Get a user's follow-up calendar",
+			Query:    []openapi.Parameter{{Name: "token", Required: true, Description: "The token of the subscription link", Schema: &openapi.Schema{Type: "string"}}},
+			Produces: []string{"text/calendar"},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusServiceUnavailable},
+		},
+
+		// Contacts
+		"GET /contacts/near": {
+			Tag: "contacts", Summary: "List the contacts near a point, nearest first",
+			Query: []openapi.Parameter{
+				{Name: "lat", Required: true, Schema: &openapi.Schema{Type: "number"}},
+				{Name: "lng", Required: true, Schema: &openapi.Schema{Type: "number"}},
+				{Name: "radius_m", Required: true, Description: "How far to look, in meters", Schema: &openapi.Schema{Type: "number"}},
+				{Name: "limit", Schema: &openapi.Schema{Type: "integer"}},
+			},
+			Data: []models.ContactDistance{}, Errors: []int{http.StatusBadRequest},
+		},
+		"GET /contacts/within": {
+			Tag: "contacts", Summary: "List the contacts inside a box or polygon",
+			Description: "Ordered by distance from lat,lng when given and from the shape's centre otherwise.",
+			Query: []openapi.Parameter{
+				{Name: "bbox", Description: "minLng,minLat,maxLng,maxLat", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "polygon", Description: "lng,lat,lng,lat,... with at least 3 points", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "lat", Schema: &openapi.Schema{Type: "number"}},
+				{Name: "lng", Schema: &openapi.Schema{Type: "number"}},
+				{Name: "limit", Schema: &openapi.Schema{Type: "integer"}},
+			},
+			Data: []models.ContactDistance{}, Errors: []int{http.StatusBadRequest},
+		},
+		"GET /contacts/duplicates": {
+			Tag: "contacts", Summary: "List clusters of contacts that are likely the same person",
+			Query: []openapi.Parameter{
+				{Name: "min_score", Description: "How alike two contacts must be, above 0 and up to 1", Schema: &openapi.Schema{Type: "number"}},
+				{Name: "user_id", Schema: openapi.ObjectID()},
+				{Name: "business_id", Schema: openapi.ObjectID()},
+			},
+			Data: []models.DuplicateCluster{}, Counted: true, Errors: []int{http.StatusBadRequest},
+		},
+		"POST /contacts/merge": {
+			Tag: "contacts", Summary: "Merge duplicate contacts into a survivor",
+			Description: "The merged contacts go to the trash, from where each can be restored.",
+			Headers:     []openapi.Parameter{recorded}, Body: jsonBody(mergeRequest{}),
+			Data: mergeResult{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed},
+		},
+		"GET /contacts/:id/vcard": {
+			Tag: "contacts", Summary: "Get a contact as a vCard",
+			Produces: []string{vcardContentType}, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		},
+
+		// Businesses
+		"GET /businesses/:id/vcard": {
+			Tag: "businesses", Summary: "Get the contacts of a business as vCards",
+			Produces: []string{vcardContentType}, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		},
+		"POST /businesses/:id/transition": {
+			Tag: "businesses", Summary: "Move a business to another pipeline stage",
+			Description: "409 answers a move the pipeline does not allow.",
+			Body:        jsonBody(transitionRequest{}), Data: models.StatusTransition{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict},
+		},
+		"GET /businesses/:id/transitions": {
+			Tag: "businesses", Summary: "List the status history of a business", Filters: data.TransitionFields,
+			Data: []models.StatusTransition{}, Paged: true, Errors: []int{http.StatusBadRequest},
+		},
+		"GET /pipeline": {
+			Tag: "businesses", Summary: "Describe the pipeline stages and the moves between them",
+			Data: []pipelineStage{},
+		},
+
+		// Calls
+		"GET /calls": {
+			Tag: "calls", Summary: "List calls", Filters: data.CallFields,
+			Data: []models.Call{}, Paged: true, Errors: []int{http.StatusBadRequest},
+		},
+		"POST /calls": {
+			Tag: "calls", Summary: "Log a call", Body: jsonBody(models.Call{}),
+			Status: []int{http.StatusCreated}, Data: models.Call{}, Errors: []int{http.StatusBadRequest},
+		},
+		"POST /calls/bulk": {
+			Tag: "calls", Summary: "Log, update and delete many calls",
+			Description: "Responds 207 when an operation failed, with the outcome of each at its index.",
+			Body:        jsonBody(bulkRequest{}), Status: []int{http.StatusOK, http.StatusMultiStatus},
+			Data: []bulkItem{}, Errors: []int{http.StatusBadRequest},
+		},
+		"GET /calls/:id": {
+			Tag: "calls", Summary: "Get a call", Data: models.Call{},
+			Errors: []int{http.StatusNotModified, http.StatusBadRequest, http.StatusNotFound},
+		},
+		"PUT /calls/:id": {
+			Tag: "calls", Summary: "Replace a call", Body: jsonBody(models.Call{}), Data: models.Call{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+		},
+		"PATCH /calls/:id": {
+			Tag: "calls", Summary: "Change fields of a call",
+			Description: "Takes a JSON Merge Patch or a JSON Patch; a failed test operation answers 409.",
+			Body:        patchBody(models.Call{}), Data: models.Call{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed, http.StatusUnsupportedMediaType, http.StatusPreconditionRequired},
+		},
+		"DELETE /calls/:id": {
+			Tag: "calls", Summary: "Delete a call", Data: map[string]interface{}{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+		},
+		"GET /businesses/:id/calls": {
+			Tag: "calls", Summary: "List the calls with a business", Filters: data.CallFields,
+			Data: []models.Call{}, Paged: true, Errors: []int{http.StatusBadRequest},
+		},
+		"GET /contacts/:id/calls": {
+			Tag: "calls", Summary: "List the calls with a contact", Filters: data.CallFields,
+			Data: []models.Call{}, Paged: true, Errors: []int{http.StatusBadRequest},
+		},
+
+		// Timelines
+		"GET /businesses/:id/timeline": {
+			Tag: "timelines", Summary: "List what happened to a business and its contacts, newest first", Filters: data.ActivityFields,
+			Data: []models.Activity{}, Paged: true, Errors: []int{http.StatusBadRequest},
+		},
+		"GET /contacts/:id/timeline": {
+			Tag: "timelines", Summary: "List what happened to a contact, newest first", Filters: data.ActivityFields,
+			Data: []models.Activity{}, Paged: true, Errors: []int{http.StatusBadRequest},
+		},
+
+		// Imports
+		"POST /import/contacts": {
+			Tag: "imports", Summary: "Import contacts from a CSV file",
+			Description: "Large files, and any with background=true, run as a job to poll at the Location header, answering 202.",
+			Query:       importQuery, Headers: []openapi.Parameter{recorded}, Body: uploadBody("text/csv"),
+			Status: []int{http.StatusOK, http.StatusAccepted}, Data: models.ImportJob{}, Errors: []int{http.StatusBadRequest},
+		},
+		"POST /import/businesses": {
+			Tag: "imports", Summary: "Import businesses from a CSV file",
+			Description: "Large files, and any with background=true, run as a job to poll at the Location header, answering 202.",
+			Query:       importQuery, Headers: []openapi.Parameter{recorded}, Body: uploadBody("text/csv"),
+			Status: []int{http.StatusOK, http.StatusAccepted}, Data: models.ImportJob{}, Errors: []int{http.StatusBadRequest},
+		},
+		"POST /import/vcard": {
+			Tag: "imports", Summary: "Import contacts from a vCard file",
+			Description: "Every card goes to the business and user named by business_id and user_id, which are required.",
+			Query:       importQuery, Headers: []openapi.Parameter{recorded}, Body: uploadBody("text/vcard"),
+			Status: []int{http.StatusOK, http.StatusAccepted}, Data: models.ImportJob{}, Errors: []int{http.StatusBadRequest},
+		},
+		"GET /import/jobs/:id": {
+			Tag: "imports", Summary: "Get the progress and row errors of an import",
+			Data: models.ImportJob{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		},
+
+		// Exports
+		"GET /export/:resource": {
+			Tag: "exports", Summary: "Export every user, contact or business matching the list filters",
+			Description: "The filters are those of the resource's list. A failure after the file started streaming is reported in the " + exportErrorTrailer + " trailer.",
+			Path:        []openapi.Parameter{{Name: "resource", Schema: &openapi.Schema{Type: "string", Enum: []interface{}{"users", "contacts", "businesses"}}}},
+			Query: []openapi.Parameter{{Name: "format", Description: "csv by default",
+				Schema: &openapi.Schema{Type: "string", Enum: []interface{}{exporter.CSV, exporter.NDJSON, exporter.XLSX}}}},
+			Produces: []string{exporter.ContentTypes[exporter.CSV], exporter.ContentTypes[exporter.NDJSON], exporter.ContentTypes[exporter.XLSX]},
+			Errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+		},
+
+		// Search
+		"GET /search": {
+			Tag: "search", Summary: "Search the caller's businesses and contacts, best match first",
+			Query: []openapi.Parameter{
+				{Name: "q", Required: true, Schema: &openapi.Schema{Type: "string"}},
+				{Name: "type", Schema: &openapi.Schema{Type: "string", Enum: []interface{}{models.SearchBusiness, models.SearchContact}}},
+				{Name: "limit", Description: "At most 100", Schema: &openapi.Schema{Type: "integer"}},
+			},
+			Headers: []openapi.Parameter{caller},
+			Data:    []models.SearchResult{}, Counted: true, Errors: []int{http.StatusBadRequest, http.StatusUnauthorized},
+		},
+
+		// Follow-ups
+		"GET /followups": {
+			Tag: "followups", Summary: "List follow-up tasks", Filters: data.FollowupFields,
+			Data: []models.Followup{}, Paged: true, Errors: []int{http.StatusBadRequest},
+		},
+		"GET /followups/:id": {
+			Tag: "followups", Summary: "Get a follow-up task",
+			Data: models.Followup{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		},
+
+		// Trash
+		"GET /trash": {
+			Tag: "trash", Summary: "List the deletes that can still be restored, newest first", Filters: data.TrashFields,
+			Data: []models.TrashEntry{}, Paged: true, Errors: []int{http.StatusBadRequest},
+		},
+
+		// Webhooks
+		"GET /webhooks": {
+			Tag: "webhooks", Summary: "List webhook subscriptions", Filters: data.WebhookFields,
+			Data: []models.Webhook{}, Paged: true, Errors: []int{http.StatusBadRequest},
+		},
+		"POST /webhooks": {
+			Tag: "webhooks", Summary: "Subscribe a webhook to events",
+			Description: "The response shows the signing secret, which is generated when none is sent; no other does. " +
+				"The URL must reach a public address, and redirects it answers with are not followed.",
+			Body: jsonBody(webhookRequest{}), Status: []int{http.StatusCreated}, Data: models.Webhook{},
+			Errors: []int{http.StatusBadRequest},
+		},
+		"GET /webhooks/:id": {
+			Tag: "webhooks", Summary: "Get a webhook subscription", Data: models.Webhook{},
+			Errors: []int{http.StatusNotModified, http.StatusBadRequest, http.StatusNotFound},
+		},
+		"PUT /webhooks/:id": {
+			Tag: "webhooks", Summary: "Replace a webhook subscription", Body: jsonBody(webhookRequest{}), Data: models.Webhook{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+		},
+		"DELETE /webhooks/:id": {
+			Tag: "webhooks", Summary: "Delete a webhook subscription", Data: map[string]interface{}{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+		},
+		"GET /webhooks/:id/deliveries": {
+			Tag: "webhooks", Summary: "List the deliveries to a webhook, newest first", Filters: data.DeliveryFields,
+			Data: []models.Delivery{}, Paged: true, Errors: []int{http.StatusBadRequest},
+		},
+		"GET /webhooks/:id/deliveries/:delivery": {
+			Tag: "webhooks", Summary: "Get a delivery with the log of its tries",
+			Data: models.Delivery{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		},
+		"POST /webhooks/:id/deliveries/:delivery/redeliver": {
+			Tag: "webhooks", Summary: "Queue a delivery to be sent again",
+			Status: []int{http.StatusAccepted}, Data: models.Delivery{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict},
+		},
+
+		// Live updates
+		"GET /stream": {
+			Tag: "stream", Summary: "Stream changes to what the caller may see as Server-Sent Events",
+			Description: "Events are ready, reset, insert, update and delete; a change's data is {resource, id, document}. " +
+				"Reconnecting with Last-Event-ID, or last_event_id, resumes after that event.",
+			Query:    []openapi.Parameter{{Name: "last_event_id", Schema: &openapi.Schema{Type: "string"}}},
+			Headers:  []openapi.Parameter{caller, {Name: "Last-Event-ID", Schema: &openapi.Schema{Type: "string"}}},
+			Produces: []string{"text/event-stream"},
+			Errors:   []int{http.StatusUnauthorized},
+		},
+
+		// API description
+		"GET /openapi.json": {
+			Tag: "docs", Summary: "Get this OpenAPI document", Produces: []string{"application/json"},
+		},
+		"GET /docs": {
+			Tag: "docs", Summary: "Browse this OpenAPI document", Produces: []string{"text/html"},
+		},
+		"GET /docs/assets/*filepath": {
+			Tag: "docs", Summary: "Get a script or stylesheet of the docs page",
+			Produces: []string{"text/javascript", "text/css"},
+			Path:     []openapi.Parameter{{Name: "filepath", Description: "Path of the file in the Swagger UI bundle", Schema: &openapi.Schema{Type: "string"}}},
+			Errors:   []int{http.StatusNotFound},
+		},
+	}
+
+	for _, resource := range []map[string]openapi.Endpoint{
+		documentEndpoints[models.User]("users", "/users", "a user", data.UserFields, http.StatusCreated),
+		documentEndpoints[models.Emoji]("emojis", "/emojis", "an emoji", data.EmojiFields, http.StatusOK),
+		documentEndpoints[models.Contact]("contacts", "/contacts", "a contact", data.ContactFields, http.StatusCreated),
+		documentEndpoints[models.Business]("businesses", "/businesses", "a business", data.BusinessFields, http.StatusOK, http.StatusConflict),
+	} {
+		for key, endpoint := range resource {
+			all[key] = endpoint
+		}
+	}
+
+	// phone and region narrow the contact list by number
+	contacts := all["GET /contacts"]
+	contacts.Query = []openapi.Parameter{
+		{Name: "phone", Description: "Either number of a contact, however it was typed", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "region", Description: "The region phone is read in; by default that of the user_id filter's user, or the configured one", Schema: &openapi.Schema{Type: "string"}},
+	}
+	all["GET /contacts"] = contacts
+	return all
+}
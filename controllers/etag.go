@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"usermanagement/data"
+)
+
+// etag is the entity tag of a document at revision
+func etag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// headerMatches reports whether an If-Match or If-None-Match header lists the
+// tag of revision or is *. Weak tags only count when weak is set, as
+// If-None-Match allows and If-Match does not.
+func headerMatches(header string, revision int64, weak bool) bool {
+	want := etag(revision)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == want {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag of a document read at revision and, when the
+// If-None-Match header already holds it, responds 304 itself and returns true
+func notModified(c *gin.Context, revision int64) bool {
+	c.Header("ETag", etag(revision))
+	if header := c.GetHeader("If-None-Match"); header != "" && headerMatches(header, revision, true) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// ifMatch reports whether a document at revision meets the If-Match header,
+// which a request without the header always does. Otherwise it responds 412
+// with current, the document as it is now, and returns false.
+func ifMatch(c *gin.Context, revision int64, current interface{}) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" || headerMatches(header, revision, false) {
+		return true
+	}
+	preconditionFailed(c, revision, current)
+	return false
+}
+
+// preconditionFailed reports a write made against a revision the document
+// has moved on from, with current, the document at revision
+func preconditionFailed(c *gin.Context, revision int64, current interface{}) {
+	c.Header("ETag", etag(revision))
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"status":  http.StatusPreconditionFailed,
+		"message": "It was changed since it was read; reload it and retry with its ETag",
+		"data":    current,
+	})
+}
+
+// loader reads the document a request changes, with its revision
+type loader func(ctx context.Context) (interface{}, int64, error)
+
+// run loads the document. On failure it responds itself and returns false,
+// using notFound as the 404 message.
+func (load loader) run(c *gin.Context, notFound string) (interface{}, int64, bool) {
+	current, revision, err := load(c.Request.Context())
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": notFound,
+			"data":    map[string]interface{}{},
+		})
+		return nil, 0, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return nil, 0, false
+	}
+	return current, revision, true
+}
+
+// conflict answers a write that lost the race to another one with the
+// document as that left it
+func (load loader) conflict(c *gin.Context, notFound string) {
+	if current, revision, ok := load.run(c, notFound); ok {
+		preconditionFailed(c, revision, current)
+	}
+}
+
+// RequireIfMatch refuses writes to a document that do not name the revision
+// they were made against in If-Match, so none can overwrite a change it has
+// not seen
+func RequireIfMatch(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodPut, http.MethodPatch, http.MethodDelete:
+		if c.GetHeader("If-Match") == "" {
+			c.AbortWithStatusJSON(http.StatusPreconditionRequired, gin.H{
+				"status":  http.StatusPreconditionRequired,
+				"message": "Send the ETag of the revision you are changing in the If-Match header",
+				"data":    map[string]interface{}{},
+			})
+			return
+		}
+	}
+	c.Next()
+}
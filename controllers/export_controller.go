@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/data"
+	"usermanagement/exporter"
+	"usermanagement/models"
+)
+
+// exportErrorTrailer reports a failure that happened after the export started
+// streaming, when the status code can no longer change
+const exportErrorTrailer = "X-Export-Error"
+
+// contactExportRow is a contact with its references resolved to names
+type contactExportRow struct {
+	models.Contact
+	UserName     string `json:"user_name"`
+	BusinessName string `json:"business_name"`
+}
+
+// businessExportRow is a business with its references resolved to names
+type businessExportRow struct {
+	models.Business
+	UserName    string `json:"user_name"`
+	Emoji       string `json:"emoji"`
+	ContactName string `json:"contact_name"`
+}
+
+// ExportController serves the /export endpoints
+type ExportController struct {
+	Users      data.UserRepository
+	Contacts   data.ContactRepository
+	Businesses data.BusinessRepository
+	Emojis     data.EmojiRepository
+}
+
+func NewExportController(repos *data.Repositories) *ExportController {
+	return &ExportController{
+		Users:      repos.Users,
+		Contacts:   repos.Contacts,
+		Businesses: repos.Businesses,
+		Emojis:     repos.Emojis,
+	}
+}
+
+// Export streams every user, contact or business matching the list filters as
+// CSV (the default), NDJSON or XLSX, picked with ?format=
+func (ec *ExportController) Export(c *gin.Context) {
+	switch c.Param("resource") {
+	case "users":
+		streamExport(c, "users", data.UserFields, ec.Users.Each, func(ctx context.Context, user models.User) (models.User, error) {
+			return user, nil
+		})
+	case "contacts":
+		userNames, businessNames := ec.userNames(), ec.businessNames()
+		streamExport(c, "contacts", data.ContactFields, ec.Contacts.Each, func(ctx context.Context, contact models.Contact) (contactExportRow, error) {
+			row := contactExportRow{Contact: contact}
+			var err error
+			if row.UserName, err = userNames.name(ctx, contact.UserID); err != nil {
+				return row, err
+			}
+			row.BusinessName, err = businessNames.name(ctx, contact.BusinessID)
+			return row, err
+		})
+	case "businesses":
+		userNames, emojis, contactNames := ec.userNames(), ec.emojis(), ec.contactNames()
+		streamExport(c, "businesses", data.BusinessFields, ec.Businesses.Each, func(ctx context.Context, business models.Business) (businessExportRow, error) {
+			row := businessExportRow{Business: business}
+			var err error
+			if row.UserName, err = userNames.name(ctx, business.UserID); err != nil {
+				return row, err
+			}
+			if row.Emoji, err = emojis.name(ctx, business.EmojiID); err != nil {
+				return row, err
+			}
+			row.ContactName, err = contactNames.name(ctx, business.ContactID)
+			return row, err
+		})
+	default:
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Export resource must be users, contacts or businesses",
+			"data":    map[string]interface{}{},
+		})
+	}
+}
+
+func (ec *ExportController) userNames() *nameCache {
+	return newNameCache(func(ctx context.Context, id primitive.ObjectID) (string, error) {
+		user, err := ec.Users.FindByID(ctx, id)
+		return user.Name, err
+	})
+}
+
+func (ec *ExportController) businessNames() *nameCache {
+	return newNameCache(func(ctx context.Context, id primitive.ObjectID) (string, error) {
+		business, err := ec.Businesses.FindByID(ctx, id)
+		return business.BusinessName, err
+	})
+}
+
+func (ec *ExportController) contactNames() *nameCache {
+	return newNameCache(func(ctx context.Context, id primitive.ObjectID) (string, error) {
+		contact, err := ec.Contacts.FindByID(ctx, id)
+		return contact.Name, err
+	})
+}
+
+func (ec *ExportController) emojis() *nameCache {
+	return newNameCache(func(ctx context.Context, id primitive.ObjectID) (string, error) {
+		emoji, err := ec.Emojis.FindByID(ctx, id)
+		return emoji.Emoji, err
+	})
+}
+
+// streamExport writes each document passed on by each as a row built by
+// toRow. Rows go straight to the client as the cursor yields them; an error
+// after the first byte is sent is reported in the X-Export-Error trailer.
+func streamExport[T, R any](c *gin.Context, resource string, fields data.Fields,
+	each func(context.Context, data.ListOptions, func(T) error) error,
+	toRow func(context.Context, T) (R, error)) {
+
+	format := c.DefaultQuery("format", exporter.CSV)
+	contentType, ok := exporter.ContentTypes[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": fmt.Sprintf("format must be one of %s, %s or %s", exporter.CSV, exporter.NDJSON, exporter.XLSX),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	opts, err := parseListOptions(c, fields, "format")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", resource, time.Now().Format("20060102"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Trailer", exportErrorTrailer)
+	// the server's write timeout would cut a long export off
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	c.Status(http.StatusOK)
+
+	ctx := c.Request.Context()
+	writer, err := exporter.NewWriter[R](format, c.Writer)
+	if err == nil {
+		err = each(ctx, opts, func(doc T) error {
+			row, err := toRow(ctx, doc)
+			if err != nil {
+				return err
+			}
+			return writer.Write(row)
+		})
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Printf("exporting %s: %v", resource, err)
+		c.Writer.Header().Set(exportErrorTrailer, err.Error())
+	}
+}
+
+// nameCache resolves referenced IDs to display names during one export,
+// looking each ID up only once. Unset and dangling references resolve to "".
+type nameCache struct {
+	lookup func(context.Context, primitive.ObjectID) (string, error)
+	names  map[primitive.ObjectID]string
+}
+
+func newNameCache(lookup func(context.Context, primitive.ObjectID) (string, error)) *nameCache {
+	return &nameCache{lookup: lookup, names: make(map[primitive.ObjectID]string)}
+}
+
+func (n *nameCache) name(ctx context.Context, id primitive.ObjectID) (string, error) {
+	if id.IsZero() {
+		return "", nil
+	}
+	if name, ok := n.names[id]; ok {
+		return name, nil
+	}
+	name, err := n.lookup(ctx, id)
+	if err == data.ErrNotFound {
+		name, err = "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	n.names[id] = name
+	return name, nil
+}
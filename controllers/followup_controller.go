@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/data"
+)
+
+// FollowupController serves the follow-up tasks emitted by the scheduler
+type FollowupController struct {
+	Followups data.FollowupRepository
+}
+
+func NewFollowupController(repos *data.Repositories) *FollowupController {
+	return &FollowupController{Followups: repos.Followups}
+}
+
+// GetFollowups retrieves a filtered, sorted page of follow-up tasks
+func (fc *FollowupController) GetFollowups(c *gin.Context) {
+	opts, err := parseListOptions(c, data.FollowupFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	page, err := fc.Followups.List(c.Request.Context(), opts)
+	if err == data.ErrInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	respondPage(c, opts, page.Items, page.NextCursor, page.Total)
+}
+
+// GetFollowupByID retrieves a follow-up task by ID
+func (fc *FollowupController) GetFollowupByID(c *gin.Context) {
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	followup, err := fc.Followups.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Followup not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"data":    followup,
+	})
+}
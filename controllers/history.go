@@ -0,0 +1,383 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/data"
+	"usermanagement/integrity"
+	"usermanagement/models"
+)
+
+// versioned lists the resources whose documents keep a history
+var versioned = map[string]bool{
+	integrity.Users:      true,
+	integrity.Emojis:     true,
+	integrity.Contacts:   true,
+	integrity.Businesses: true,
+}
+
+// versionLog records the versions of one resource's documents and serves
+// their history
+type versionLog struct {
+	history  data.HistoryRepository
+	resource string
+	// decode reads a snapshot into the resource's model, as the API shows it
+	decode func(bson.Raw) (interface{}, error)
+}
+
+func newVersionLog[T any](repos *data.Repositories, resource string) versionLog {
+	return versionLog{history: repos.History, resource: resource, decode: func(raw bson.Raw) (interface{}, error) {
+		var doc T
+		err := bson.Unmarshal(raw, &doc)
+		return doc, err
+	}}
+}
+
+// versionView is a version with its snapshot decoded
+type versionView struct {
+	models.Version
+	Document interface{} `json:"document"`
+}
+
+// versionDiff is the fields that differ between two versions of a document
+type versionDiff struct {
+	Resource    string               `json:"resource"`
+	DocumentID  primitive.ObjectID   `json:"document_id"`
+	FromVersion int                  `json:"from_version"`
+	ToVersion   int                  `json:"to_version"`
+	Changes     []models.FieldChange `json:"changes"`
+}
+
+// record snapshots the given documents as they are stored now as their next
+// versions, made by userID. A failure is logged rather than reported because
+// the change is already saved.
+func (l versionLog) record(ctx context.Context, userID primitive.ObjectID, action string, ids ...primitive.ObjectID) {
+	l.recordAs(ctx, models.Version{Resource: l.resource, Action: action, UserID: userID}, ids...)
+}
+
+func (l versionLog) recordAs(ctx context.Context, change models.Version, ids ...primitive.ObjectID) {
+	ctx, cancel := afterSave(ctx)
+	defer cancel()
+	if err := l.history.Record(ctx, change, ids...); err != nil {
+		log.Printf("recording %s versions: %v", change.Resource, err)
+	}
+}
+
+// snapshot records the given documents, as the write that saved them
+// returned them, as their next versions made by userID. A failure is logged
+// like record's.
+func (l versionLog) snapshot(ctx context.Context, userID primitive.ObjectID, action string, docs ...interface{}) {
+	snapshotAs(ctx, l.history, models.Version{Resource: l.resource, Action: action, UserID: userID}, docs...)
+}
+
+func snapshotAs(ctx context.Context, history data.HistoryRepository, change models.Version, docs ...interface{}) {
+	ctx, cancel := afterSave(ctx)
+	defer cancel()
+	if err := history.Snapshot(ctx, change, docs...); err != nil {
+		log.Printf("recording %s versions: %v", change.Resource, err)
+	}
+}
+
+// recordRevert records the version a revert to an earlier one made, doc being
+// the document as the revert saved it
+func (l versionLog) recordRevert(ctx context.Context, userID primitive.ObjectID, doc interface{}, from int) {
+	snapshotAs(ctx, l.history, models.Version{Resource: l.resource, Action: models.VersionReverted, UserID: userID, RevertedFrom: from}, doc)
+}
+
+// recordDelete records a version of every document a delete trashed or
+// whose references it cleared or reassigned
+func (l versionLog) recordDelete(ctx context.Context, userID primitive.ObjectID, report integrity.Report) {
+	if report.DryRun {
+		return
+	}
+	for resource, ids := range report.Deleted {
+		if versioned[resource] {
+			l.recordAs(ctx, models.Version{Resource: resource, Action: models.VersionDeleted, UserID: userID}, ids...)
+		}
+	}
+	for _, changed := range []map[string][]primitive.ObjectID{report.SetNull, report.Reassigned} {
+		for name, ids := range changed {
+			if relation, _ := integrity.RelationNamed(name); versioned[relation.Resource] {
+				l.recordAs(ctx, models.Version{Resource: relation.Resource, Action: models.VersionUpdated, UserID: userID}, ids...)
+			}
+		}
+	}
+}
+
+// recordRestore records a version of every document a restore brought back
+// or whose references it pointed back
+func (l versionLog) recordRestore(ctx context.Context, userID primitive.ObjectID, entry models.TrashEntry) {
+	for resource, ids := range entry.Deleted {
+		if versioned[resource] {
+			l.recordAs(ctx, models.Version{Resource: resource, Action: models.VersionRestored, UserID: userID}, ids...)
+		}
+	}
+	changed := make(map[string][]primitive.ObjectID)
+	for _, ref := range entry.Changed {
+		relation, _ := integrity.RelationNamed(ref.Relation)
+		if versioned[relation.Resource] && !containsObjectID(changed[relation.Resource], ref.ID) {
+			changed[relation.Resource] = append(changed[relation.Resource], ref.ID)
+		}
+	}
+	for resource, ids := range changed {
+		l.recordAs(ctx, models.Version{Resource: resource, Action: models.VersionUpdated, UserID: userID}, ids...)
+	}
+}
+
+func containsObjectID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (l versionLog) view(version models.Version) (versionView, error) {
+	doc, err := l.decode(version.Snapshot)
+	return versionView{Version: version, Document: doc}, err
+}
+
+// list serves a page of a document's versions, newest first
+func (l versionLog) list(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	opts, err := parseListOptions(c, data.VersionFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+	if opts.Sort == "" {
+		opts.Sort = "version"
+		opts.Desc = true
+	}
+
+	page, err := l.history.List(c.Request.Context(), l.resource, objID, opts)
+	if err == data.ErrInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	views := make([]versionView, 0, len(page.Items))
+	for _, version := range page.Items {
+		view, err := l.view(version)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":  http.StatusInternalServerError,
+				"message": err.Error(),
+				"data":    []interface{}{},
+			})
+			return
+		}
+		views = append(views, view)
+	}
+
+	respondPage(c, opts, views, page.NextCursor, page.Total)
+}
+
+// parseVersion reads a version number, which starts at 1
+func parseVersion(raw string) (int, error) {
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		return 0, errors.New("version must be a whole number from 1")
+	}
+	return n, nil
+}
+
+// find looks up the version named by the :id and :version path parameters.
+// On failure it responds itself and returns false.
+func (l versionLog) find(c *gin.Context) (models.Version, bool) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return models.Version{}, false
+	}
+	number, err := parseVersion(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return models.Version{}, false
+	}
+	return l.lookup(c, objID, number)
+}
+
+func (l versionLog) lookup(c *gin.Context, id primitive.ObjectID, number int) (models.Version, bool) {
+	version, err := l.history.Find(c.Request.Context(), l.resource, id, number)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Version " + strconv.Itoa(number) + " not found",
+			"data":    map[string]interface{}{},
+		})
+		return version, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return version, false
+	}
+	return version, true
+}
+
+// diff serves the fields a version changed from the one before it, or from
+// the version named by ?against=. The first version is compared with nothing.
+func (l versionLog) diff(c *gin.Context) {
+	to, ok := l.find(c)
+	if !ok {
+		return
+	}
+
+	against := to.Version - 1
+	if raw := c.Query("against"); raw != "" {
+		n, err := parseVersion(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "against: " + err.Error(),
+				"data":    map[string]interface{}{},
+			})
+			return
+		}
+		against = n
+	}
+
+	var before interface{}
+	if against > 0 {
+		from, ok := l.lookup(c, to.DocumentID, against)
+		if !ok {
+			return
+		}
+		view, err := l.view(from)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":  http.StatusInternalServerError,
+				"message": err.Error(),
+				"data":    map[string]interface{}{},
+			})
+			return
+		}
+		before = view.Document
+	}
+	after, err := l.view(to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	changes, err := diffFields(before, after.Document)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"data": versionDiff{
+			Resource:    l.resource,
+			DocumentID:  to.DocumentID,
+			FromVersion: against,
+			ToVersion:   to.Version,
+			Changes:     changes,
+		},
+	})
+}
+
+// diffFields compares two documents as the API shows them, field by field in
+// name order. A nil document has no fields.
+func diffFields(before, after interface{}) ([]models.FieldChange, error) {
+	from, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	to, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for name := range from {
+		names[name] = true
+	}
+	for name := range to {
+		names[name] = true
+	}
+	changes := []models.FieldChange{}
+	for _, name := range sortedKeys(names) {
+		if !reflect.DeepEqual(from[name], to[name]) {
+			changes = append(changes, models.FieldChange{Field: name, From: from[name], To: to[name]})
+		}
+	}
+	return changes, nil
+}
+
+func jsonFields(doc interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if doc == nil {
+		return fields, nil
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return fields, json.Unmarshal(raw, &fields)
+}
+
+// revertConflict reports a version that cannot be reverted to because the
+// documents it refers to are gone
+func revertConflict(c *gin.Context, err error) {
+	c.JSON(http.StatusConflict, gin.H{
+		"status":  http.StatusConflict,
+		"message": "Cannot revert: " + err.Error(),
+		"data":    map[string]interface{}{},
+	})
+}
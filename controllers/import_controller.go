@@ -0,0 +1,505 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/data"
+	"usermanagement/importer"
+	"usermanagement/models"
+	"usermanagement/vcard"
+)
+
+const (
+	// maxImportBytes caps the size of an uploaded CSV file
+	maxImportBytes = 32 << 20
+	// importBackgroundRows is the row count above which an import always runs in the background
+	importBackgroundRows = 500
+	// importProgressRows is how many rows a background job processes between progress saves
+	importProgressRows = 100
+	// maxImportErrors caps the row errors kept in a job report; Failed still counts them all
+	maxImportErrors = 1000
+	// importHeartbeat is the longest a background job goes without saving its progress
+	importHeartbeat = 30 * time.Second
+	// importStaleAfter is how long a running job may go without saving its
+	// progress before it is taken to have stopped with the server running it
+	importStaleAfter = 5 * importHeartbeat
+	// importAbandoned is the message of a job its server stopped running
+	importAbandoned = "The server running the import stopped before it finished"
+)
+
+// importProtected lists the fields the server assigns, which a CSV file cannot set
+var importProtected = []string{"id", "revision", "location", "cell_phone_e164", "work_phone_e164", "created_date", "updated_date"}
+
+// rowImporter validates one CSV record and, unless dryRun is set, saves it.
+// It returns the HTTP status alongside any error so a failing database can be
+// told apart from a bad row.
+type rowImporter func(ctx context.Context, record []string, dryRun bool) (int, error)
+
+// importDefaults are references applied to rows that leave them empty
+type importDefaults struct {
+	UserID     primitive.ObjectID
+	BusinessID primitive.ObjectID
+}
+
+// ImportController serves the CSV import endpoints
+type ImportController struct {
+	Jobs       data.ImportJobRepository
+	contacts   *ContactController
+	businesses *BusinessController
+	// ctx is cancelled when the server shuts down, which waits on
+	// background for the jobs still running
+	ctx        context.Context
+	background *sync.WaitGroup
+}
+
+// NewImportController validates and saves imported rows through the given
+// contact and business controllers, so imports follow the same rules as the
+// API. Jobs stop when ctx is cancelled and are counted in background while
+// they run.
+func NewImportController(ctx context.Context, background *sync.WaitGroup, repos *data.Repositories, contacts *ContactController, businesses *BusinessController) *ImportController {
+	return &ImportController{
+		Jobs:       repos.Imports,
+		contacts:   contacts,
+		businesses: businesses,
+		ctx:        ctx,
+		background: background,
+	}
+}
+
+// FailAbandonedImports marks the jobs a stopped server left running as
+// failed. Jobs still saving their progress, on this server or another, are
+// left alone.
+func FailAbandonedImports(ctx context.Context, jobs data.ImportJobRepository) error {
+	n, err := jobs.FailStale(ctx, time.Now().Add(-importStaleAfter), importAbandoned)
+	if n > 0 {
+		log.Printf("Marked %d abandoned import jobs as failed", n)
+	}
+	return err
+}
+
+// ImportContacts creates contacts from an uploaded CSV file
+func (ic *ImportController) ImportContacts(c *gin.Context) {
+	userID := actingUser(c)
+	ic.start(c, "contacts", func(header []string, mapping importer.Mapping, defaults importDefaults) (rowImporter, error) {
+		decoder, err := importer.NewDecoder[models.Contact](header, mapping, importProtected...)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, record []string, dryRun bool) (int, error) {
+			contact, err := decoder.Decode(record)
+			if err != nil {
+				return http.StatusBadRequest, err
+			}
+			if contact.UserID.IsZero() {
+				contact.UserID = defaults.UserID
+			}
+			if contact.BusinessID.IsZero() {
+				contact.BusinessID = defaults.BusinessID
+			}
+			return ic.importContact(ctx, contact, userID, dryRun)
+		}, nil
+	})
+}
+
+// ImportVCard creates contacts from an uploaded .vcf file holding one or more
+// cards, all attached to the business and user named by business_id and
+// user_id. Cards are numbered from 1 in the error report.
+func (ic *ImportController) ImportVCard(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+	body, err := importUpload(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	defer body.Close()
+
+	opts, err := parseImportOptions(c)
+	if err == nil && opts.Defaults.UserID.IsZero() {
+		err = errors.New("Enter user_id")
+	}
+	if err == nil && opts.Defaults.BusinessID.IsZero() {
+		err = errors.New("Enter business_id")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	cards, err := vcard.Decode(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "reading vCard: " + err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	job := models.ImportJob{Resource: "contacts", DryRun: opts.DryRun, Background: opts.Background, TotalRows: len(cards)}
+	userID := actingUser(c)
+	ic.launch(c, job, 1, func(ctx context.Context, i int) (int, error) {
+		if cards[i].Err != nil {
+			return http.StatusBadRequest, cards[i].Err
+		}
+		contact := cards[i].Contact
+		contact.UserID = opts.Defaults.UserID
+		contact.BusinessID = opts.Defaults.BusinessID
+		return ic.importContact(ctx, contact, userID, job.DryRun)
+	})
+}
+
+// importContact validates a contact with the same rules as PostContact and,
+// unless dryRun is set, saves it as created by userID
+func (ic *ImportController) importContact(ctx context.Context, contact models.Contact, userID primitive.ObjectID, dryRun bool) (int, error) {
+	if status, err := ic.contacts.checkContact(ctx, &contact); err != nil {
+		return status, err
+	}
+	if dryRun {
+		return http.StatusOK, nil
+	}
+	if err := ic.contacts.insertContact(ctx, &contact, userID); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusCreated, nil
+}
+
+// ImportBusinesses creates businesses from an uploaded CSV file
+func (ic *ImportController) ImportBusinesses(c *gin.Context) {
+	userID := actingUser(c)
+	ic.start(c, "businesses", func(header []string, mapping importer.Mapping, defaults importDefaults) (rowImporter, error) {
+		decoder, err := importer.NewDecoder[models.Business](header, mapping, importProtected...)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, record []string, dryRun bool) (int, error) {
+			business, err := decoder.Decode(record)
+			if err != nil {
+				return http.StatusBadRequest, err
+			}
+			if business.UserID.IsZero() {
+				business.UserID = defaults.UserID
+			}
+			if status, err := ic.businesses.checkNewBusiness(ctx, &business); err != nil {
+				return status, err
+			}
+			if dryRun {
+				return http.StatusOK, nil
+			}
+			if err := ic.businesses.insertBusiness(ctx, &business, userID); err != nil {
+				return http.StatusInternalServerError, err
+			}
+			return http.StatusCreated, nil
+		}, nil
+	})
+}
+
+// GetImportJob retrieves the progress and row error report of an import
+func (ic *ImportController) GetImportJob(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	job, err := ic.Jobs.FindByID(c.Request.Context(), objID)
+	if err == nil && job.Status == models.ImportRunning && time.Since(job.UpdatedDate) > importStaleAfter {
+		// its server stopped after the abandoned jobs were last marked
+		if err = FailAbandonedImports(c.Request.Context(), ic.Jobs); err == nil {
+			job, err = ic.Jobs.FindByID(c.Request.Context(), objID)
+		}
+	}
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Import job not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"data":    job,
+	})
+}
+
+// importOptions are the settings shared by every import. They are read from
+// form fields or the query string: mapping is a JSON object of field name to
+// column header, and user_id and business_id fill in rows that leave them out.
+type importOptions struct {
+	Mapping    importer.Mapping
+	Defaults   importDefaults
+	DryRun     bool
+	Background bool
+}
+
+func parseImportOptions(c *gin.Context) (importOptions, error) {
+	var opts importOptions
+
+	if raw := importParam(c, "mapping"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts.Mapping); err != nil {
+			return opts, errors.New("mapping must be a JSON object of field names to column headers")
+		}
+	}
+
+	for name, id := range map[string]*primitive.ObjectID{"user_id": &opts.Defaults.UserID, "business_id": &opts.Defaults.BusinessID} {
+		if raw := importParam(c, name); raw != "" {
+			var err error
+			if *id, err = primitive.ObjectIDFromHex(raw); err != nil {
+				return opts, errors.New("Invalid " + name)
+			}
+		}
+	}
+
+	var errDryRun, errBackground error
+	opts.DryRun, errDryRun = importFlag(c, "dry_run")
+	opts.Background, errBackground = importFlag(c, "background")
+	return opts, errors.Join(errDryRun, errBackground)
+}
+
+// start reads an uploaded CSV file and imports it with the rows built by build
+func (ic *ImportController) start(c *gin.Context, resource string, build func([]string, importer.Mapping, importDefaults) (rowImporter, error)) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+	records, err := readImportCSV(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	opts, err := parseImportOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	row, err := build(records[0], opts.Mapping, opts.Defaults)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	records = records[1:]
+	job := models.ImportJob{Resource: resource, DryRun: opts.DryRun, Background: opts.Background, TotalRows: len(records)}
+	ic.launch(c, job, 2, func(ctx context.Context, i int) (int, error) {
+		return row(ctx, records[i], job.DryRun)
+	})
+}
+
+// launch saves a new job and runs it inline or, for large files or when
+// background=true, as a job the client polls at GET /import/jobs/:id. Row i
+// is reported as row first+i.
+func (ic *ImportController) launch(c *gin.Context, job models.ImportJob, first int, row func(ctx context.Context, i int) (int, error)) {
+	now := time.Now()
+	job.ID = primitive.NewObjectID()
+	job.Status = models.ImportRunning
+	job.Background = job.Background || job.TotalRows > importBackgroundRows
+	job.Errors = []models.ImportRowError{}
+	job.CreatedDate = now
+	job.UpdatedDate = now
+	if err := ic.Jobs.Insert(c.Request.Context(), job); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	if job.Background {
+		ic.background.Add(1)
+		go func() {
+			defer ic.background.Done()
+			ic.run(job, first, row)
+		}()
+		c.Header("Location", "/import/jobs/"+job.ID.Hex())
+		c.JSON(http.StatusAccepted, gin.H{
+			"status":  http.StatusAccepted,
+			"message": "Import started",
+			"data":    job,
+		})
+		return
+	}
+
+	job = ic.run(job, first, row)
+	if job.Status == models.ImportFailed {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": job.Message,
+			"data":    job,
+		})
+		return
+	}
+
+	message := "Import completed"
+	if job.DryRun {
+		message = "Dry run completed"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": message,
+		"data":    job,
+	})
+}
+
+// run imports every row, saving the job's progress as it goes. A database
+// failure or the server shutting down stops the job; rows that fail
+// validation are reported and skipped.
+func (ic *ImportController) run(job models.ImportJob, first int, row func(ctx context.Context, i int) (int, error)) models.ImportJob {
+	// a row that has started is saved whole even if the server shuts down
+	// meanwhile; the loop stops before the next one
+	ctx := context.WithoutCancel(ic.ctx)
+	saved := time.Now()
+	for i := 0; i < job.TotalRows; i++ {
+		if ic.ctx.Err() != nil {
+			job.Status = models.ImportFailed
+			job.Message = fmt.Sprintf("The server shut down before row %d", first+i)
+			return ic.finish(job)
+		}
+
+		status, err := row(ctx, i)
+		switch {
+		case err == nil:
+			job.Succeeded++
+		case status >= http.StatusInternalServerError:
+			job.Status = models.ImportFailed
+			job.Message = fmt.Sprintf("row %d: %v", first+i, err)
+			return ic.finish(job)
+		default:
+			job.Failed++
+			if len(job.Errors) < maxImportErrors {
+				job.Errors = append(job.Errors, models.ImportRowError{Row: first + i, Message: err.Error()})
+			} else {
+				job.ErrorsTruncated = true
+			}
+		}
+		job.Processed++
+
+		if job.Background && (job.Processed%importProgressRows == 0 || time.Since(saved) >= importHeartbeat) {
+			job.UpdatedDate = time.Now()
+			ic.save(job)
+			saved = job.UpdatedDate
+		}
+	}
+
+	job.Status = models.ImportCompleted
+	return ic.finish(job)
+}
+
+func (ic *ImportController) finish(job models.ImportJob) models.ImportJob {
+	job.UpdatedDate = time.Now()
+	job.FinishedDate = job.UpdatedDate
+	ic.save(job)
+	return job
+}
+
+// save records the job's progress. A failure is logged rather than reported
+// because the rows it describes are already saved.
+func (ic *ImportController) save(job models.ImportJob) {
+	ctx, cancel := afterSave(ic.ctx)
+	defer cancel()
+	if err := ic.Jobs.Update(ctx, job); err != nil {
+		log.Printf("saving import job %s: %v", job.ID.Hex(), err)
+	}
+}
+
+// importUpload opens the uploaded file, which comes either as the "file" field
+// of a multipart form or as the raw request body
+func importUpload(c *gin.Context) (io.ReadCloser, error) {
+	if !strings.HasPrefix(c.ContentType(), "multipart/") {
+		return c.Request.Body, nil
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		return nil, errors.New("Attach the upload as the file field")
+	}
+	return header.Open()
+}
+
+// readImportCSV reads every record of the uploaded CSV, which must have a
+// header row
+func readImportCSV(c *gin.Context) ([][]string, error) {
+	body, err := importUpload(c)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("CSV file is empty")
+	}
+	return records, nil
+}
+
+// importFlag reads a boolean import option, which defaults to false
+func importFlag(c *gin.Context, name string) (bool, error) {
+	raw := importParam(c, name)
+	if raw == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+	return value, nil
+}
+
+// importParam reads an import option from the form, falling back to the query string
+func importParam(c *gin.Context, name string) string {
+	if value, ok := c.GetPostForm(name); ok {
+		return value
+	}
+	return c.Query(name)
+}
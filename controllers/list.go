@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"usermanagement/data"
+)
+
+// filterParam matches query keys such as "status" or "next_followup_date[lte]"
+var filterParam = regexp.MustCompile(`^([A-Za-z_]+)(?:\[([a-z]+)\])?$`)
+
+// parseListOptions reads limit, cursor, sort and field filters from the query string.
+// sort takes a field name, prefixed with "-" for descending order. A field
+// given several values to equal, as in ?status=3&status=4, matches any of
+// them; any other operator can be given once per field. Keys in reserved are
+// left for the caller to read.
+func parseListOptions(c *gin.Context, fields data.Fields, reserved ...string) (data.ListOptions, error) {
+	var opts data.ListOptions
+	// equals holds the values each field is to equal, whichever spelling of
+	// eq named them
+	equals := make(map[string][]string)
+
+	for key, values := range c.Request.URL.Query() {
+		if slices.Contains(reserved, key) {
+			continue
+		}
+		switch key {
+		case "limit":
+			limit, err := strconv.Atoi(values[0])
+			if err != nil || limit < 1 || limit > data.MaxListLimit {
+				return opts, fmt.Errorf("limit must be between 1 and %d", data.MaxListLimit)
+			}
+			opts.Limit = limit
+		case "cursor":
+			opts.Cursor = values[0]
+		case "sort":
+			sort := values[0]
+			if strings.HasPrefix(sort, "-") {
+				opts.Desc = true
+				sort = sort[1:]
+			}
+			if field, ok := fields[sort]; !ok || !field.Sortable {
+				return opts, fmt.Errorf("cannot sort by %q", sort)
+			}
+			opts.Sort = sort
+		default:
+			match := filterParam.FindStringSubmatch(key)
+			if match == nil {
+				return opts, fmt.Errorf("invalid query parameter %q", key)
+			}
+			if match[2] == "" || match[2] == string(data.OpEq) {
+				equals[match[1]] = append(equals[match[1]], values...)
+				continue
+			}
+			if len(values) > 1 {
+				return opts, fmt.Errorf("%s can only be given once", key)
+			}
+			filter, err := fields.ParseFilter(match[1], match[2], values[0])
+			if err != nil {
+				return opts, err
+			}
+			opts.Filters = append(opts.Filters, filter)
+		}
+	}
+
+	for field, values := range equals {
+		filter, err := fields.ParseFilter(field, string(data.OpEq), values[0])
+		if err != nil {
+			return opts, err
+		}
+		if len(values) > 1 {
+			anyOf := []interface{}{filter.Value}
+			for _, value := range values[1:] {
+				other, err := fields.ParseFilter(field, string(data.OpEq), value)
+				if err != nil {
+					return opts, err
+				}
+				anyOf = append(anyOf, other.Value)
+			}
+			filter = data.Filter{Field: field, Op: data.OpIn, Value: anyOf}
+		}
+		opts.Filters = append(opts.Filters, filter)
+	}
+	// later pages skip counting every match again
+	opts.CountTotal = opts.Cursor == ""
+	return opts, nil
+}
+
+// respondPage serves a page of a list with the cursor of the next one and,
+// on the first page, how many match across every page
+func respondPage(c *gin.Context, opts data.ListOptions, items interface{}, nextCursor string, total int64) {
+	body := gin.H{
+		"status":      http.StatusOK,
+		"message":     "success",
+		"data":        items,
+		"next_cursor": nextCursor,
+	}
+	if opts.CountTotal {
+		body["total"] = total
+	}
+	c.JSON(http.StatusOK, body)
+}
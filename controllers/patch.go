@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"usermanagement/patch"
+)
+
+// serverFields are the fields of every resource a patch cannot change
+var serverFields = []string{"id", "revision", "deleted_at"}
+
+// applyPatch applies the body of a PATCH request to doc as the API shows it,
+// as a JSON Merge Patch or a JSON Patch depending on its content type. The
+// named immutable fields, and those of serverFields, keep their values
+// whatever the patch says. It returns the patched document and the fields
+// whose values changed. On failure it responds itself and returns false.
+func applyPatch[T any](c *gin.Context, doc T, immutable ...string) (T, map[string]bool, bool) {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return doc, nil, false
+	}
+	patched, changed, status, err := patchDocument(doc, c.ContentType(), body, immutable...)
+	if err != nil {
+		c.JSON(status, gin.H{
+			"status":  status,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return patched, nil, false
+	}
+	return patched, changed, true
+}
+
+// patchDocument is applyPatch for a patch of the given content type read
+// from elsewhere than the request body. It returns the HTTP status to report
+// alongside any error.
+func patchDocument[T any](doc T, contentType string, body []byte, immutable ...string) (T, map[string]bool, int, error) {
+	var patched T
+	before, err := jsonFields(doc)
+	if err != nil {
+		return patched, nil, http.StatusInternalServerError, err
+	}
+
+	var result interface{}
+	switch contentType {
+	case patch.MergePatchType:
+		var merge interface{}
+		if err := json.Unmarshal(body, &merge); err != nil {
+			return patched, nil, http.StatusBadRequest, err
+		}
+		result = patch.Merge(before, merge)
+	case patch.JSONPatchType:
+		var ops []patch.Operation
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return patched, nil, http.StatusBadRequest, err
+		}
+		result, err = patch.Apply(before, ops)
+		if errors.Is(err, patch.ErrTestFailed) {
+			return patched, nil, http.StatusConflict, err
+		} else if err != nil {
+			return patched, nil, http.StatusBadRequest, err
+		}
+	default:
+		return patched, nil, http.StatusUnsupportedMediaType, errors.New("PATCH takes " + patch.MergePatchType + " or " + patch.JSONPatchType)
+	}
+
+	after, ok := result.(map[string]interface{})
+	if !ok {
+		return patched, nil, http.StatusBadRequest, errors.New("The patched document must be an object")
+	}
+	for _, name := range append(serverFields, immutable...) {
+		if value, ok := before[name]; ok {
+			after[name] = value
+		} else {
+			delete(after, name)
+		}
+	}
+
+	raw, err := json.Marshal(after)
+	if err != nil {
+		return patched, nil, http.StatusBadRequest, err
+	}
+	if err := json.Unmarshal(raw, &patched); err != nil {
+		return patched, nil, http.StatusBadRequest, err
+	}
+
+	// compare the document as it will be shown, so a null read into a field
+	// that cannot hold one counts by the value it leaves
+	now, err := jsonFields(patched)
+	if err != nil {
+		return patched, nil, http.StatusInternalServerError, err
+	}
+	changed := make(map[string]bool)
+	for name := range before {
+		if !reflect.DeepEqual(before[name], now[name]) {
+			changed[name] = true
+		}
+	}
+	for name := range now {
+		if _, ok := before[name]; !ok {
+			changed[name] = true
+		}
+	}
+	return patched, changed, http.StatusOK, nil
+}
+
+// touched reports whether any of the named fields changed, going by the
+// fields applyPatch found changed. A nil set stands for a document written
+// whole, in which every field counts as changed.
+func touched(changed map[string]bool, names ...string) bool {
+	if changed == nil {
+		return true
+	}
+	for _, name := range names {
+		if changed[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// respondUnchanged reports a patch that left the document at revision as it
+// was, so nothing was saved
+func respondUnchanged(c *gin.Context, revision int64, doc interface{}) {
+	c.Header("ETag", etag(revision))
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Nothing to change",
+		"data":    doc,
+	})
+}
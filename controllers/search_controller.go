@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"usermanagement/data"
+	"usermanagement/models"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// snippetRunes is the longest field shown whole in a highlight; longer
+	// fields are cut to a window around the first match
+	snippetRunes = 160
+)
+
+// SearchController serves full-text search over the caller's businesses and contacts
+type SearchController struct {
+	Businesses data.BusinessRepository
+	Contacts   data.ContactRepository
+}
+
+func NewSearchController(repos *data.Repositories) *SearchController {
+	return &SearchController{Businesses: repos.Businesses, Contacts: repos.Contacts}
+}
+
+// Search retrieves the caller's businesses and contacts matching q, best match
+// first. type=business or type=contact narrows the search to one kind.
+func (sc *SearchController) Search(c *gin.Context) {
+	userID, err := callerID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  http.StatusUnauthorized,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	terms, _ := data.SearchTerms(query)
+	if len(terms) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Enter q",
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	limit := defaultSearchLimit
+	if raw := c.Query("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit),
+				"data":    []interface{}{},
+			})
+			return
+		}
+	}
+
+	kind := c.Query("type")
+	if kind != "" && kind != models.SearchBusiness && kind != models.SearchContact {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "type must be business or contact",
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	results := []models.SearchResult{}
+	if kind != models.SearchContact {
+		hits, err := sc.Businesses.Search(c.Request.Context(), userID, query, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":  http.StatusInternalServerError,
+				"message": err.Error(),
+				"data":    []interface{}{},
+			})
+			return
+		}
+		for _, hit := range hits {
+			b := hit.Item
+			results = append(results, models.SearchResult{
+				Type:  models.SearchBusiness,
+				ID:    b.ID,
+				Title: b.BusinessName,
+				Score: hit.Score,
+				Highlights: highlights(terms, data.BusinessSearchWeights, map[string]string{
+					"business_name":    b.BusinessName,
+					"business_tagline": b.BusinessTagline,
+					"website":          b.Website,
+				}),
+				Document: b,
+			})
+		}
+	}
+	if kind != models.SearchBusiness {
+		hits, err := sc.Contacts.Search(c.Request.Context(), userID, query, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":  http.StatusInternalServerError,
+				"message": err.Error(),
+				"data":    []interface{}{},
+			})
+			return
+		}
+		for _, hit := range hits {
+			ct := hit.Item
+			results = append(results, models.SearchResult{
+				Type:  models.SearchContact,
+				ID:    ct.ID,
+				Title: ct.Name,
+				Score: hit.Score,
+				Highlights: highlights(terms, data.ContactSearchWeights, map[string]string{
+					"name":        ct.Name,
+					"email":       ct.Email,
+					"job_title":   ct.JobTitle,
+					"city":        ct.City,
+					"description": ct.Description,
+				}),
+				Document: ct,
+			})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"data":    results,
+		"total":   len(results),
+	})
+}
+
+// highlights builds a snippet for every searched field that contains a match
+func highlights(terms []string, weights data.SearchWeights, values map[string]string) map[string]string {
+	out := make(map[string]string)
+	for _, field := range weights.Fields() {
+		if snippet := highlight(values[field], terms); snippet != "" {
+			out[field] = snippet
+		}
+	}
+	return out
+}
+
+// highlight HTML-escapes value and wraps every word starting with one of the
+// terms in <em>, returning "" when nothing matches. Long values are cut to a
+// window around the first match.
+func highlight(value string, terms []string) string {
+	text := []rune(value)
+
+	type span struct{ start, end int }
+	var matches []span
+	for i := 0; i < len(text); {
+		if !isWordRune(text[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(text) && isWordRune(text[i]) {
+			i++
+		}
+		word := strings.ToLower(string(text[start:i]))
+		for _, term := range terms {
+			if strings.HasPrefix(word, term) {
+				matches = append(matches, span{start, i})
+				break
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return ""
+	}
+
+	from, to := 0, len(text)
+	if len(text) > snippetRunes {
+		from = matches[0].start - snippetRunes/3
+		if from < 0 {
+			from = 0
+		}
+		to = from + snippetRunes
+		if to > len(text) {
+			to = len(text)
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, m := range matches {
+		if m.start < from || m.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(text[pos:m.start])))
+		b.WriteString("<em>" + html.EscapeString(string(text[m.start:m.end])) + "</em>")
+		pos = m.end
+	}
+	b.WriteString(html.EscapeString(string(text[pos:to])))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"usermanagement/config"
+	"usermanagement/data"
+	"usermanagement/stream"
+)
+
+type shutdownKey struct{}
+
+// streamFailed is the type of the event ending a stream that failed
+const streamFailed = "error"
+
+// WithShutdown returns ctx carrying done, whose closing ends the live update
+// streams of the requests served under ctx. Server.Shutdown waits for every
+// request to finish, which a stream never does on its own.
+func WithShutdown(ctx context.Context, done <-chan struct{}) context.Context {
+	return context.WithValue(ctx, shutdownKey{}, done)
+}
+
+// StreamController serves the live update stream at /stream
+type StreamController struct {
+	streams   *stream.Streamer
+	heartbeat time.Duration
+}
+
+func NewStreamController(repos *data.Repositories, cfg config.StreamConfig) *StreamController {
+	return &StreamController{streams: stream.NewStreamer(repos, cfg), heartbeat: cfg.Heartbeat.Duration}
+}
+
+// streamChange is the data of a change event
+type streamChange struct {
+	Resource string      `json:"resource"`
+	ID       string      `json:"id"`
+	Document interface{} `json:"document,omitempty"`
+}
+
+// Stream sends the changes to the users, emojis, contacts and businesses the
+// caller may see as Server-Sent Events, until the client goes away. A client
+// reconnecting with a Last-Event-ID header, or a last_event_id query
+// parameter, is sent what it missed.
+func (sc *StreamController) Stream(c *gin.Context) {
+	userID, err := callerID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  http.StatusUnauthorized,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	if done, ok := ctx.Value(shutdownKey{}).(<-chan struct{}); ok {
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	feed, first, err := sc.streams.Open(ctx, userID, lastEventID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		feed.Close(closeCtx)
+	}()
+
+	// the server's write timeout would cut the stream off
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	events := first
+	failed := false
+	for {
+		for _, event := range events {
+			if err := writeEvent(c.Writer, event); err != nil {
+				log.Printf("stream: writing event: %v", err)
+				return
+			}
+		}
+		if len(events) == 0 {
+			// a comment keeps proxies from closing a quiet stream
+			if _, err := io.WriteString(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+		if failed {
+			return
+		}
+
+		events, err = feed.Next(ctx, sc.heartbeat)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			// the changes read before the failure are still sent
+			log.Printf("stream: %v", err)
+			events = append(events, stream.Event{Type: streamFailed})
+			failed = true
+		}
+	}
+}
+
+// writeEvent writes one Server-Sent Event
+func writeEvent(w io.Writer, event stream.Event) error {
+	var payload interface{} = map[string]interface{}{}
+	switch event.Type {
+	case stream.EventReset:
+		payload = gin.H{"message": "The changes since Last-Event-ID are no longer available; reload"}
+	case streamFailed:
+		payload = gin.H{"message": "The stream failed; reconnect to resume"}
+	case data.ChangeInsert, data.ChangeUpdate, data.ChangeDelete:
+		payload = streamChange{
+			Resource: event.Change.Resource,
+			ID:       event.Change.ID.Hex(),
+			Document: event.Change.Document,
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if event.ID != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", event.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, body)
+	return err
+}
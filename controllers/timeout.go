@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queryTimeoutKey holds the query timeout QueryTimeout gave a request
+type queryTimeoutKey struct{}
+
+// QueryTimeout bounds the database work of each request to timeout, leaving
+// it unbounded when timeout is zero. Responses streamed for as long as the
+// client listens, such as /stream, are routed outside it.
+func QueryTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+		ctx := context.WithValue(c.Request.Context(), queryTimeoutKey{}, timeout)
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// afterSave is the context of the bookkeeping that follows a saved write,
+// such as its versions and activities. The write stands whatever happens to
+// the request, so this outlives its cancellation and deadline but gets a
+// query timeout of its own.
+func afterSave(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = context.WithoutCancel(ctx)
+	if timeout, ok := ctx.Value(queryTimeoutKey{}).(time.Duration); ok {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return ctx, func() {}
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestQueryTimeout checks a request gets the query timeout as its deadline
+// and the bookkeeping after its write a fresh one that outlives it
+func TestQueryTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	var request, after context.Context
+	r.GET("/", QueryTimeout(time.Minute), func(c *gin.Context) {
+		request = c.Request.Context()
+		var cancel context.CancelFunc
+		after, cancel = afterSave(request)
+		t.Cleanup(cancel)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if deadline, ok := request.Deadline(); !ok || time.Until(deadline) > time.Minute {
+		t.Errorf("request deadline %v, %v; want one within a minute", deadline, ok)
+	}
+	if request.Err() == nil {
+		t.Error("the request context outlived the request")
+	}
+	if after.Err() != nil {
+		t.Errorf("the context after the write ended with the request: %v", after.Err())
+	}
+	if _, ok := after.Deadline(); !ok {
+		t.Error("the context after the write has no deadline")
+	}
+}
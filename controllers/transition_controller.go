@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/data"
+	"usermanagement/models"
+	"usermanagement/pipeline"
+)
+
+// transitionRequest is the body of POST /businesses/:id/transition
+type transitionRequest struct {
+	To     string             `json:"to" binding:"required"`
+	UserID primitive.ObjectID `json:"user_id"`
+	Reason string             `json:"reason"`
+}
+
+// pipelineStage is a pipeline stage with the stages a business may move to from it
+type pipelineStage struct {
+	Status int              `json:"status"`
+	Name   pipeline.Stage   `json:"name"`
+	Next   []pipeline.Stage `json:"next"`
+}
+
+// TransitionBusiness moves a business to another pipeline stage, recording who moved it and why
+func (bc *BusinessController) TransitionBusiness(c *gin.Context) {
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	var req transitionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	to, err := pipeline.ParseStage(req.To)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    gin.H{"stages": pipeline.Stages()},
+		})
+		return
+	}
+
+	if req.UserID.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Enter UserID",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	userExists, err := bc.Users.Exists(c.Request.Context(), req.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if !userExists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Incorrect UserID",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	business, err := bc.Businesses.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Business not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	from := pipeline.Stage(business.Status)
+	if from == to {
+		c.JSON(http.StatusConflict, gin.H{
+			"status":  http.StatusConflict,
+			"message": "Business is already at stage " + to.String(),
+			"data":    gin.H{"status": from, "allowed": bc.Pipeline.Next(from)},
+		})
+		return
+	}
+	if err := bc.Pipeline.Check(from, to); err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"status":  http.StatusConflict,
+			"message": err.Error(),
+			"data":    gin.H{"status": from, "allowed": bc.Pipeline.Next(from)},
+		})
+		return
+	}
+
+	// The status filter makes the move a compare-and-set against concurrent
+	// moves, and the transition is saved with it so no move goes unrecorded
+	transition := newTransition(objID, req.UserID, from, to, req.Reason)
+	var moved models.Business
+	err = bc.feed.save(c.Request.Context(), func(ctx context.Context) ([]models.Activity, error) {
+		var err error
+		if moved, err = bc.Businesses.UpdateStatus(ctx, objID, int(from), int(to)); err != nil {
+			return nil, err
+		}
+		if err := bc.Transitions.Insert(ctx, transition); err != nil {
+			// without a transaction the move is undone by hand
+			if _, undoErr := bc.Businesses.UpdateStatus(ctx, objID, int(to), int(from)); undoErr != nil {
+				log.Printf("undoing the move of business %s to %s: %v", objID.Hex(), to, undoErr)
+			}
+			return nil, err
+		}
+		return []models.Activity{statusChanged(req.UserID, transition)}, nil
+	})
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusConflict, gin.H{
+			"status":  http.StatusConflict,
+			"message": "Business status changed concurrently, reload and retry",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	bc.history.snapshot(c.Request.Context(), actingUser(c), models.VersionUpdated, moved)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Business moved to " + to.String(),
+		"data":    transition,
+	})
+}
+
+// GetBusinessTransitions retrieves a page of a business's status history
+func (bc *BusinessController) GetBusinessTransitions(c *gin.Context) {
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	opts, err := parseListOptions(c, data.TransitionFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+	opts.Filters = append(opts.Filters, data.Filter{Field: "business_id", Op: data.OpEq, Value: objID})
+
+	page, err := bc.Transitions.List(c.Request.Context(), opts)
+	if err == data.ErrInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	respondPage(c, opts, page.Items, page.NextCursor, page.Total)
+}
+
+// GetPipeline describes the pipeline stages and the moves allowed between them
+func (bc *BusinessController) GetPipeline(c *gin.Context) {
+	var stages []pipelineStage
+	for _, stage := range pipeline.Stages() {
+		stages = append(stages, pipelineStage{
+			Status: int(stage),
+			Name:   stage,
+			Next:   bc.Pipeline.Next(stage),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"data":    stages,
+	})
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"usermanagement/config"
+	"usermanagement/data"
+)
+
+// TrashController serves the /trash endpoint
+type TrashController struct {
+	Trash data.TrashRepository
+	// Retention is how long a delete stays restorable
+	Retention time.Duration
+}
+
+func NewTrashController(repos *data.Repositories, cfg config.TrashConfig) *TrashController {
+	return &TrashController{Trash: repos.Trash, Retention: cfg.Retention.Duration}
+}
+
+// GetTrash retrieves a page of the deletes that can still be restored, newest first
+func (tc *TrashController) GetTrash(c *gin.Context) {
+	opts, err := parseListOptions(c, data.TrashFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+	if opts.Sort == "" {
+		opts.Sort = "deleted_at"
+		opts.Desc = true
+	}
+
+	page, err := tc.Trash.List(c.Request.Context(), opts)
+	if err == data.ErrInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	for i := range page.Items {
+		purgeAt := page.Items[i].DeletedAt.Add(tc.Retention)
+		page.Items[i].PurgeAt = &purgeAt
+	}
+
+	respondPage(c, opts, page.Items, page.NextCursor, page.Total)
+}
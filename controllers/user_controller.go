@@ -2,45 +2,112 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo" 
+	"usermanagement/config"
 	"usermanagement/data"
+	"usermanagement/integrity"
 	"usermanagement/models"
+	"usermanagement/phone"
 )
 
-func GetUsers(c *gin.Context) {
-	var users []models.User
-	cur, err := data.UserCollection.Find(context.TODO(), bson.D{})
+// UserController serves the /users endpoints
+type UserController struct {
+	Users   data.UserRepository
+	deletes deletePolicy
+	history versionLog
+	bulk    bulkHandler[models.User]
+}
+
+func NewUserController(repos *data.Repositories, integrityCfg config.IntegrityConfig) *UserController {
+	uc := &UserController{
+		Users:   repos.Users,
+		deletes: newDeletePolicy(repos, integrityCfg),
+		history: newVersionLog[models.User](repos, integrity.Users),
+	}
+	uc.bulk = bulkHandler[models.User]{
+		writes:   repos.Bulk,
+		deletes:  uc.deletes,
+		history:  &uc.history,
+		resource: integrity.Users,
+		name:     "User",
+		find: func(ctx context.Context, id primitive.ObjectID) (models.User, error) {
+			return uc.Users.FindByID(ctx, id)
+		},
+		ref:       func(user *models.User) (*primitive.ObjectID, *int64) { return &user.ID, &user.Revision },
+		immutable: []string{"createdDate", "updatedDate"},
+		create: func(ctx context.Context, user *models.User, n int) (int, error) {
+			if err := checkPhoneRegion(user); err != nil {
+				return http.StatusBadRequest, err
+			}
+			user.CreatedDate = time.Now()
+			user.UpdatedDate = time.Now()
+			return http.StatusOK, nil
+		},
+		update: func(ctx context.Context, user *models.User, existing models.User, changed map[string]bool) (int, error) {
+			if touched(changed, "phone_region") {
+				if err := checkPhoneRegion(user); err != nil {
+					return http.StatusBadRequest, err
+				}
+			}
+			// the bulk write replaces the whole document, and the salt is not
+			// part of what the request can send
+			user.CalendarSalt = existing.CalendarSalt
+			user.UpdatedDate = time.Now()
+			return http.StatusOK, nil
+		},
+	}
+	return uc
+}
+
+// load reads the user with the given ID
+func (uc *UserController) load(id primitive.ObjectID) loader {
+	return func(ctx context.Context) (interface{}, int64, error) {
+		user, err := uc.Users.FindByID(ctx, id)
+		return user, user.Revision, err
+	}
+}
+
+// checkPhoneRegion upper-cases the user's phone region and checks it is one
+// contact numbers can be read in
+func checkPhoneRegion(user *models.User) error {
+	if user.PhoneRegion == "" {
+		return nil
+	}
+	user.PhoneRegion = strings.ToUpper(user.PhoneRegion)
+	if !phone.KnownRegion(user.PhoneRegion) {
+		return fmt.Errorf("phone_region %q is not a supported region", user.PhoneRegion)
+	}
+	return nil
+}
+
+func (uc *UserController) GetUsers(c *gin.Context) {
+	opts, err := parseListOptions(c, data.UserFields)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  http.StatusInternalServerError,
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
 			"message": err.Error(),
 			"data":    []interface{}{},
 		})
 		return
 	}
-	defer cur.Close(context.TODO())
-
-	for cur.Next(context.TODO()) {
-		var user models.User
-		err := cur.Decode(&user)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status":  http.StatusInternalServerError,
-				"message": err.Error(),
-				"data":    []interface{}{},
-			})
-			return
-		}
-		users = append(users, user)
-	}
 
-	if err := cur.Err(); err != nil {
+	page, err := uc.Users.List(c.Request.Context(), opts)
+	if err == data.ErrInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
@@ -49,14 +116,10 @@ func GetUsers(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"status":  http.StatusOK,
-		"message": "success",
-		"data":    users,
-	})
+	respondPage(c, opts, page.Items, page.NextCursor, page.Total)
 }
 
-func PostUser(c *gin.Context) {
+func (uc *UserController) PostUser(c *gin.Context) {
 	var newUser models.User
 
 	if err := c.ShouldBindJSON(&newUser); err != nil {
@@ -68,19 +131,31 @@ func PostUser(c *gin.Context) {
 		return
 	}
 
+	if err := checkPhoneRegion(&newUser); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
 	newUser.ID = primitive.NewObjectID()
+	newUser.Revision = 1
 	newUser.CreatedDate = time.Now()
 	newUser.UpdatedDate = time.Now()
 
-	if _, err := data.UserCollection.InsertOne(context.TODO(), newUser); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{ // Corrected status
+	if err := uc.Users.Insert(c.Request.Context(), newUser); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
 			"data":    map[string]interface{}{},
 		})
 		return
 	}
-	
+	c.Header("ETag", etag(newUser.Revision))
+	uc.history.snapshot(c.Request.Context(), actingUser(c), models.VersionCreated, newUser)
+
 	c.JSON(http.StatusCreated, gin.H{
 		"status":  http.StatusCreated,
 		"message": "User created",
@@ -88,45 +163,46 @@ func PostUser(c *gin.Context) {
 	})
 }
 
-func GetUsersByID(c *gin.Context) {
-    id := c.Param("id")
-    objID, err := primitive.ObjectIDFromHex(id)
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{
+func (uc *UserController) GetUsersByID(c *gin.Context) {
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  http.StatusBadRequest,
 			"message": "Invalid ID format",
 			"data":    map[string]interface{}{},
 		})
-        return
-    }
+		return
+	}
 
-    var user models.User
-    err = data.UserCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&user)
-    if err == mongo.ErrNoDocuments {
-        c.JSON(http.StatusNotFound, gin.H{
+	user, err := uc.Users.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
 			"status":  http.StatusNotFound,
 			"message": "User not found",
 			"data":    map[string]interface{}{},
 		})
-        return
-    } else if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
 			"data":    map[string]interface{}{},
 		})
-        return
-    }
+		return
+	}
+	if notModified(c, user.Revision) {
+		return
+	}
 
-    c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
 		"message": "success",
 		"data":    user,
 	})
 }
 
-
-func RemoveUser(c *gin.Context) {
+func (uc *UserController) RemoveUser(c *gin.Context) {
 	id := c.Param("id")
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -138,8 +214,81 @@ func RemoveUser(c *gin.Context) {
 		return
 	}
 
-	res, err := data.UserCollection.DeleteOne(context.TODO(), bson.M{"_id": objID})
+	report, ok := uc.deletes.run(c, integrity.Users, objID, "User not found", uc.load(objID), nil)
+	if !ok {
+		return
+	}
+	uc.history.recordDelete(c.Request.Context(), actingUser(c), report)
+
+	respondDeleted(c, "User removed", report)
+}
+
+// RestoreUser brings a user back from the trash
+func (uc *UserController) RestoreUser(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	entry, ok := uc.deletes.restore(c, integrity.Users, objID, "User not found in the trash", nil)
+	if !ok {
+		return
+	}
+	uc.history.recordRestore(c.Request.Context(), actingUser(c), entry)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "User restored",
+		"data":    entry,
+	})
+}
+
+func (uc *UserController) UpdateUser(c *gin.Context) {
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	var updatedUser models.User
+	if err := c.ShouldBindJSON(&updatedUser); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	if err := checkPhoneRegion(&updatedUser); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	// Fetch the existing user to retain fields that are not being updated
+	existingUser, err := uc.Users.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "User not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  http.StatusInternalServerError,
 			"message": err.Error(),
@@ -147,101 +296,220 @@ func RemoveUser(c *gin.Context) {
 		})
 		return
 	}
+	if !ifMatch(c, existingUser.Revision, existingUser) {
+		return
+	}
 
-	if res.DeletedCount == 0 {
+	// Update only the fields provided, keeping other fields unchanged
+	if updatedUser.Name != "" {
+		existingUser.Name = updatedUser.Name
+	}
+	if updatedUser.Color_Code != "" {
+		existingUser.Color_Code = updatedUser.Color_Code
+	}
+	if updatedUser.PhoneRegion != "" {
+		existingUser.PhoneRegion = updatedUser.PhoneRegion
+	}
+	existingUser.UpdatedDate = time.Now()
+
+	saved, err := uc.Users.Update(c.Request.Context(), existingUser)
+	if err == data.ErrNotFound {
 		c.JSON(http.StatusNotFound, gin.H{
 			"status":  http.StatusNotFound,
 			"message": "User not found",
 			"data":    map[string]interface{}{},
 		})
 		return
+	} else if err == data.ErrRevisionConflict {
+		uc.load(objID).conflict(c, "User not found")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
 	}
+	existingUser = saved
+	c.Header("ETag", etag(existingUser.Revision))
+	uc.history.snapshot(c.Request.Context(), actingUser(c), models.VersionUpdated, existingUser)
 
+	// Return the updated user data
 	c.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
-		"message": "User removed",
-		"data":    map[string]interface{}{},
+		"message": "User updated",
+		"data":    existingUser,
+	})
+}
+
+// PatchUser changes only the fields of a user a merge patch or JSON Patch
+// sends; a field set to null is cleared
+func (uc *UserController) PatchUser(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	existingUser, err := uc.Users.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "User not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if !ifMatch(c, existingUser.Revision, existingUser) {
+		return
+	}
+
+	user, changed, ok := applyPatch(c, existingUser, "createdDate", "updatedDate")
+	if !ok {
+		return
+	}
+	if touched(changed, "phone_region") {
+		if err := checkPhoneRegion(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": err.Error(),
+				"data":    map[string]interface{}{},
+			})
+			return
+		}
+	}
+	if len(changed) == 0 {
+		respondUnchanged(c, existingUser.Revision, existingUser)
+		return
+	}
+	user.UpdatedDate = time.Now()
+
+	saved, err := uc.Users.Update(c.Request.Context(), user)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "User not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err == data.ErrRevisionConflict {
+		uc.load(objID).conflict(c, "User not found")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	user = saved
+	c.Header("ETag", etag(user.Revision))
+	uc.history.snapshot(c.Request.Context(), actingUser(c), models.VersionUpdated, user)
 
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "User updated",
+		"data":    user,
 	})
 }
 
-func UpdateUser(c *gin.Context) {
-    id := c.Param("id")
-    objID, err := primitive.ObjectIDFromHex(id)
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{
-            "status":  http.StatusBadRequest,
-            "message": "Invalid ID format",
-            "data":    map[string]interface{}{},
-        })
-        return
-    }
-
-    var updatedUser models.User
-    if err := c.ShouldBindJSON(&updatedUser); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{
-            "status":  http.StatusBadRequest,
-            "message": err.Error(),
-            "data":    map[string]interface{}{},
-        })
-        return
-    }
-
-    // Fetch the existing user to retain fields that are not being updated
-    var existingUser models.User
-    err = data.UserCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&existingUser)
-    if err == mongo.ErrNoDocuments {
-        c.JSON(http.StatusNotFound, gin.H{
-            "status":  http.StatusNotFound,
-            "message": "User not found",
-            "data":    map[string]interface{}{},
-        })
-        return
-    } else if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{
-            "status":  http.StatusInternalServerError,
-            "message": err.Error(),
-            "data":    map[string]interface{}{},
-        })
-        return
-    }
-
-    // Update only the fields provided, keeping other fields unchanged
-    if updatedUser.Name != "" {
-        existingUser.Name = updatedUser.Name
-    }
-    if updatedUser.Color_Code != "" {
-        existingUser.Color_Code = updatedUser.Color_Code
-    }
-    existingUser.UpdatedDate = time.Now()
-
-    update := bson.M{
-        "$set": existingUser,
-    }
-
-    res, err := data.UserCollection.UpdateOne(context.TODO(), bson.M{"_id": objID}, update)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{
-            "status":  http.StatusInternalServerError,
-            "message": err.Error(),
-            "data":    map[string]interface{}{},
-        })
-        return
-    }
-
-    if res.MatchedCount == 0 {
-        c.JSON(http.StatusNotFound, gin.H{
-            "status":  http.StatusNotFound,
-            "message": "User not found",
-            "data":    map[string]interface{}{},
-        })
-        return
-    }
-
-    // Return the updated user data
-    c.JSON(http.StatusOK, gin.H{
-        "status":  http.StatusOK,
-        "message": "User updated",
-        "data":    existingUser, 
-    })
+// BulkUsers creates, updates and deletes many users at once
+func (uc *UserController) BulkUsers(c *gin.Context) {
+	uc.bulk.run(c)
+}
+
+// GetUserHistory retrieves a page of a user's versions, newest first
+func (uc *UserController) GetUserHistory(c *gin.Context) {
+	uc.history.list(c)
+}
+
+// GetUserVersionDiff retrieves the fields a version of a user changed
+func (uc *UserController) GetUserVersionDiff(c *gin.Context) {
+	uc.history.diff(c)
+}
+
+// RevertUser puts a user back the way it was at one of its versions
+func (uc *UserController) RevertUser(c *gin.Context) {
+	version, ok := uc.history.find(c)
+	if !ok {
+		return
+	}
+
+	var user models.User
+	if err := bson.Unmarshal(version.Snapshot, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	existingUser, err := uc.Users.FindByID(c.Request.Context(), version.DocumentID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "User not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if !ifMatch(c, existingUser.Revision, existingUser) {
+		return
+	}
+
+	user.ID = existingUser.ID
+	user.Revision = existingUser.Revision
+	user.CreatedDate = existingUser.CreatedDate
+	user.UpdatedDate = time.Now()
+	user.DeletedAt = nil
+
+	saved, err := uc.Users.Update(c.Request.Context(), user)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "User not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err == data.ErrRevisionConflict {
+		uc.load(user.ID).conflict(c, "User not found")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	user = saved
+	c.Header("ETag", etag(user.Revision))
+	uc.history.recordRevert(c.Request.Context(), actingUser(c), user, version.Version)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "User reverted to version " + strconv.Itoa(version.Version),
+		"data":    user,
+	})
 }
@@ -0,0 +1,508 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/data"
+	"usermanagement/models"
+	"usermanagement/webhook"
+)
+
+// minWebhookSecret is the shortest secret a webhook may be given
+const minWebhookSecret = 16
+
+// webhookRequest is the body of a webhook create or update
+type webhookRequest struct {
+	URL         string `json:"url"`
+	Description string `json:"description"`
+	// Secret replaces the signing secret; one is generated on create when empty
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	// Active defaults to true
+	Active *bool `json:"active"`
+}
+
+// validate checks the URL and events, dropping repeated events. The URL
+// must reach a public address, so a webhook cannot be pointed at the
+// services next to this one.
+func (req *webhookRequest) validate(ctx context.Context) error {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if err := webhook.CheckURL(ctx, req.URL); err != nil {
+		return err
+	}
+	if req.Secret != "" && len(req.Secret) < minWebhookSecret {
+		return fmt.Errorf("secret must be at least %d characters", minWebhookSecret)
+	}
+	if len(req.Events) == 0 {
+		return errors.New("events must name at least one event type, or * for all of them")
+	}
+	known := map[string]bool{models.AllEvents: true}
+	for _, event := range models.ActivityTypes {
+		known[event] = true
+	}
+	seen := make(map[string]bool)
+	events := req.Events[:0]
+	for _, event := range req.Events {
+		if !known[event] {
+			return fmt.Errorf("events: unknown event type %q", event)
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	req.Events = events
+	return nil
+}
+
+// newWebhookSecret generates a random signing secret
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// WebhookController serves the /webhooks endpoints
+type WebhookController struct {
+	Webhooks   data.WebhookRepository
+	Deliveries data.DeliveryRepository
+}
+
+func NewWebhookController(repos *data.Repositories) *WebhookController {
+	return &WebhookController{Webhooks: repos.Webhooks, Deliveries: repos.Deliveries}
+}
+
+// load reads the webhook with the given ID, without its secret
+func (wc *WebhookController) load(id primitive.ObjectID) loader {
+	return func(ctx context.Context) (interface{}, int64, error) {
+		webhook, err := wc.Webhooks.FindByID(ctx, id)
+		webhook.Secret = ""
+		return webhook, webhook.Revision, err
+	}
+}
+
+// GetWebhooks retrieves a page of the webhook subscriptions
+func (wc *WebhookController) GetWebhooks(c *gin.Context) {
+	opts, err := parseListOptions(c, data.WebhookFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	page, err := wc.Webhooks.List(c.Request.Context(), opts)
+	if err == data.ErrInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	for i := range page.Items {
+		page.Items[i].Secret = ""
+	}
+	respondPage(c, opts, page.Items, page.NextCursor, page.Total)
+}
+
+// PostWebhook subscribes a URL to events. The response is the only one to
+// show the signing secret.
+func (wc *WebhookController) PostWebhook(c *gin.Context) {
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if err := req.validate(c.Request.Context()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if req.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":  http.StatusInternalServerError,
+				"message": err.Error(),
+				"data":    map[string]interface{}{},
+			})
+			return
+		}
+		req.Secret = secret
+	}
+
+	now := time.Now()
+	webhook := models.Webhook{
+		ID:          primitive.NewObjectID(),
+		URL:         req.URL,
+		Description: req.Description,
+		Secret:      req.Secret,
+		Events:      req.Events,
+		Active:      req.Active == nil || *req.Active,
+		Revision:    1,
+		CreatedDate: now,
+		UpdatedDate: now,
+	}
+	if err := wc.Webhooks.Insert(c.Request.Context(), webhook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	c.Header("ETag", etag(webhook.Revision))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  http.StatusCreated,
+		"message": "Webhook created",
+		"data":    webhook,
+	})
+}
+
+func (wc *WebhookController) GetWebhookByID(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	webhook, revision, ok := wc.load(objID).run(c, "Webhook not found")
+	if !ok || notModified(c, revision) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"data":    webhook,
+	})
+}
+
+// UpdateWebhook replaces a webhook's settings. The secret is kept unless a new
+// one is sent, in which case the response shows it.
+func (wc *WebhookController) UpdateWebhook(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if err := req.validate(c.Request.Context()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	webhook, err := wc.Webhooks.FindByID(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Webhook not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	current := webhook
+	current.Secret = ""
+	if !ifMatch(c, webhook.Revision, current) {
+		return
+	}
+
+	webhook.URL = req.URL
+	webhook.Description = req.Description
+	webhook.Events = req.Events
+	webhook.Active = req.Active == nil || *req.Active
+	if req.Secret != "" {
+		webhook.Secret = req.Secret
+	}
+	webhook.UpdatedDate = time.Now()
+
+	err = wc.Webhooks.Update(c.Request.Context(), webhook)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Webhook not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err == data.ErrRevisionConflict {
+		wc.load(objID).conflict(c, "Webhook not found")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	webhook.Revision++
+	c.Header("ETag", etag(webhook.Revision))
+	if req.Secret == "" {
+		webhook.Secret = ""
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Webhook updated",
+		"data":    webhook,
+	})
+}
+
+// RemoveWebhook deletes a webhook for good. Deliveries still pending to it are
+// failed by the dispatcher; its delivery log is kept.
+func (wc *WebhookController) RemoveWebhook(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	current, revision, ok := wc.load(objID).run(c, "Webhook not found")
+	if !ok || !ifMatch(c, revision, current) {
+		return
+	}
+
+	err = wc.Webhooks.Delete(c.Request.Context(), objID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Webhook not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "Webhook deleted",
+		"data":    map[string]interface{}{},
+	})
+}
+
+// GetWebhookDeliveries retrieves a page of the deliveries to a webhook, newest first
+func (wc *WebhookController) GetWebhookDeliveries(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	opts, err := parseListOptions(c, data.DeliveryFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+	if opts.Sort == "" {
+		opts.Sort = "created_date"
+		opts.Desc = true
+	}
+	opts.Filters = append(opts.Filters, data.Filter{Field: "webhook_id", Op: data.OpEq, Value: objID})
+
+	page, err := wc.Deliveries.List(c.Request.Context(), opts)
+	if err == data.ErrInvalidCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    []interface{}{},
+		})
+		return
+	}
+
+	respondPage(c, opts, page.Items, page.NextCursor, page.Total)
+}
+
+// GetWebhookDelivery retrieves one delivery to a webhook with the log of its tries
+func (wc *WebhookController) GetWebhookDelivery(c *gin.Context) {
+	delivery, ok := wc.delivery(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"data":    delivery,
+	})
+}
+
+// RedeliverWebhookDelivery queues a delivery to be posted again on the next
+// dispatch, whatever became of it, with a fresh set of attempts
+func (wc *WebhookController) RedeliverWebhookDelivery(c *gin.Context) {
+	delivery, ok := wc.delivery(c)
+	if !ok {
+		return
+	}
+
+	webhook, err := wc.Webhooks.FindByID(c.Request.Context(), delivery.WebhookID)
+	if err == data.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Webhook not found",
+			"data":    map[string]interface{}{},
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+	if !webhook.Active {
+		c.JSON(http.StatusConflict, gin.H{
+			"status":  http.StatusConflict,
+			"message": "The webhook is inactive; activate it before redelivering",
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	now := time.Now()
+	delivery.Status = models.DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttempt = now
+	delivery.UpdatedDate = now
+	if err := wc.Deliveries.Update(c.Request.Context(), delivery); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  http.StatusAccepted,
+		"message": "Delivery queued",
+		"data":    delivery,
+	})
+}
+
+// delivery reads the delivery a request names, which must be to the webhook
+// it names. On failure it responds itself and returns false.
+func (wc *WebhookController) delivery(c *gin.Context) (models.Delivery, bool) {
+	webhookID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid ID format",
+			"data":    map[string]interface{}{},
+		})
+		return models.Delivery{}, false
+	}
+	deliveryID, err := primitive.ObjectIDFromHex(c.Param("delivery"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid delivery ID format",
+			"data":    map[string]interface{}{},
+		})
+		return models.Delivery{}, false
+	}
+
+	delivery, err := wc.Deliveries.FindByID(c.Request.Context(), deliveryID)
+	if err == data.ErrNotFound || (err == nil && delivery.WebhookID != webhookID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Delivery not found",
+			"data":    map[string]interface{}{},
+		})
+		return models.Delivery{}, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": err.Error(),
+			"data":    map[string]interface{}{},
+		})
+		return models.Delivery{}, false
+	}
+	return delivery, true
+}
@@ -0,0 +1,504 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"usermanagement/integrity"
+	"usermanagement/models"
+)
+
+// Bulk write kinds
+const (
+	BulkCreate = "create"
+	BulkUpdate = "update"
+	BulkDelete = "delete"
+)
+
+// BulkWrite is one write of a bulk request, already checked the way a single
+// create, update or delete is
+type BulkWrite struct {
+	Kind string
+	// Doc is the document to create, or the whole document an update leaves,
+	// as a value of the resource's model
+	Doc interface{}
+	// ID names the document to update or delete
+	ID primitive.ObjectID
+	// Revision is the revision the document to update or delete must still be at
+	Revision int64
+	// Delete holds the relation policies a delete runs under
+	Delete integrity.Options
+	// Hard deletes the document for good rather than moving it to the trash,
+	// as calls are deleted; relations are left alone
+	Hard bool
+	// Events are the webhook events the write publishes. They go to the
+	// outbox in the transaction of an atomic request, and right after the
+	// write is saved otherwise.
+	Events []models.Event
+	// Transitions are the status moves of a business the write records,
+	// saved the way its Events are
+	Transitions []models.StatusTransition
+}
+
+// BulkOptions controls how a bulk request runs
+type BulkOptions struct {
+	// Ordered runs the writes one after another and stops at the first that
+	// fails; otherwise every write is tried whatever happens to the others
+	Ordered bool
+	// Atomic runs the writes in order in one transaction, so either all of
+	// them are saved or none is
+	Atomic bool
+}
+
+// BulkResult is the outcome of one write of a bulk request
+type BulkResult struct {
+	// Err is why the write was not saved
+	Err error
+	// Report is what a delete trashed and changed
+	Report integrity.Report
+}
+
+var (
+	// ErrSkipped marks the writes an ordered bulk request stopped before
+	ErrSkipped = errors.New("not run because an earlier write failed")
+	// ErrRolledBack marks the writes an atomic bulk request undid because
+	// another one failed
+	ErrRolledBack = errors.New("rolled back because another write failed")
+	// ErrNoTransactions is returned for an atomic bulk request when the
+	// database cannot run transactions
+	ErrNoTransactions = errors.New("all-or-nothing bulk writes need a MongoDB replica set or sharded cluster")
+
+	// errBulkFailed aborts the transaction of an atomic bulk request
+	errBulkFailed = errors.New("a bulk write failed")
+)
+
+// BulkWriter makes many writes to the documents of one resource at once,
+// reporting the outcome of each in the order they were given
+type BulkWriter interface {
+	Bulk(ctx context.Context, resource string, writes []BulkWrite, opts BulkOptions) ([]BulkResult, error)
+}
+
+// skip marks every write from the given one on as not run
+func skip(results []BulkResult, from int) {
+	for i := from; i < len(results); i++ {
+		results[i].Err = ErrSkipped
+	}
+}
+
+// rollBack marks the writes that had succeeded in a failed atomic bulk request
+func rollBack(results []BulkResult) {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i] = BulkResult{Err: ErrRolledBack}
+		}
+	}
+}
+
+// queue saves the transitions of the writes that were saved and puts their
+// events in the outbox. A write whose transitions or events cannot be saved
+// is reported as failed, though it is saved unless ctx carries a transaction
+// that then aborts.
+func queue(ctx context.Context, outbox OutboxRepository, transitions TransitionRepository, writes []BulkWrite, results []BulkResult) {
+	for i, write := range writes {
+		if results[i].Err != nil {
+			continue
+		}
+		for _, transition := range write.Transitions {
+			if err := transitions.Insert(ctx, transition); err != nil {
+				results[i].Err = err
+				break
+			}
+		}
+		if results[i].Err != nil {
+			continue
+		}
+		for _, event := range write.Events {
+			if err := outbox.Insert(ctx, event); err != nil {
+				results[i].Err = err
+				break
+			}
+		}
+	}
+}
+
+// failed reports whether any write failed
+func failed(results []BulkResult) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// MongoBulkWriter sends the creates and updates of a bulk request to Mongo in
+// BulkWrite batches. Deletes run between the batches, each through the trash,
+// since each may take other documents with it.
+type MongoBulkWriter struct {
+	deletes     *MongoDeleter
+	outbox      OutboxRepository
+	transitions TransitionRepository
+}
+
+func NewMongoBulkWriter(deletes *MongoDeleter, outbox OutboxRepository, transitions TransitionRepository) *MongoBulkWriter {
+	return &MongoBulkWriter{deletes: deletes, outbox: outbox, transitions: transitions}
+}
+
+func (w *MongoBulkWriter) Bulk(ctx context.Context, resource string, writes []BulkWrite, opts BulkOptions) ([]BulkResult, error) {
+	coll, err := w.deletes.store.collection(resource)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Atomic {
+		results := w.run(ctx, coll, resource, writes, opts.Ordered, func(ctx context.Context, resource string, id primitive.ObjectID, opts DeleteOptions) (integrity.Report, error) {
+			return w.deletes.Delete(ctx, resource, id, opts)
+		})
+		queue(ctx, w.outbox, w.transitions, writes, results)
+		return results, nil
+	}
+
+	transactions, err := w.deletes.supportsTransactions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !transactions {
+		return nil, ErrNoTransactions
+	}
+	var results []BulkResult
+	err = w.deletes.atomically(ctx, func(ctx context.Context) error {
+		results = w.run(ctx, coll, resource, writes, true, func(ctx context.Context, resource string, id primitive.ObjectID, opts DeleteOptions) (integrity.Report, error) {
+			return trash(ctx, w.deletes.store, resource, id, opts)
+		})
+		queue(ctx, w.outbox, w.transitions, writes, results)
+		if failed(results) {
+			return errBulkFailed
+		}
+		return nil
+	})
+	if err == errBulkFailed {
+		rollBack(results)
+		return results, nil
+	}
+	return results, err
+}
+
+// run makes the writes, batching runs of creates and updates and deleting
+// through del
+func (w *MongoBulkWriter) run(ctx context.Context, coll *mongo.Collection, resource string, writes []BulkWrite, ordered bool,
+	del func(ctx context.Context, resource string, id primitive.ObjectID, opts DeleteOptions) (integrity.Report, error)) []BulkResult {
+	results := make([]BulkResult, len(writes))
+	var batch []int
+	for i, write := range writes {
+		if write.Kind != BulkDelete {
+			batch = append(batch, i)
+			continue
+		}
+		if ok := w.flush(ctx, coll, writes, batch, results, ordered); !ok && ordered {
+			skip(results, i)
+			return results
+		}
+		batch = batch[:0]
+
+		if write.Hard {
+			results[i].Err = removeAt(ctx, coll, write.ID, write.Revision)
+		} else {
+			revision := write.Revision
+			results[i].Report, results[i].Err = del(ctx, resource, write.ID, DeleteOptions{Options: write.Delete, Revision: &revision})
+		}
+		if results[i].Err != nil && ordered {
+			skip(results, i+1)
+			return results
+		}
+	}
+	if ok := w.flush(ctx, coll, writes, batch, results, ordered); !ok && ordered && len(batch) > 0 {
+		skip(results, batch[len(batch)-1]+1)
+	}
+	return results
+}
+
+// flush sends the creates and updates at the given indexes in one BulkWrite,
+// recording their results, and reports whether all of them were saved.
+//
+// An update replaces the document only while it is at the revision the
+// write expects. It upserts so that a document which has moved on fails
+// with a duplicate key, which an ordered batch stops at, rather than
+// silently matching nothing.
+func (w *MongoBulkWriter) flush(ctx context.Context, coll *mongo.Collection, writes []BulkWrite, batch []int, results []BulkResult, ordered bool) bool {
+	if len(batch) == 0 {
+		return true
+	}
+
+	var writeModels []mongo.WriteModel
+	var sent []int
+	ok := true
+	for _, i := range batch {
+		write := writes[i]
+		switch write.Kind {
+		case BulkCreate:
+			writeModels = append(writeModels, mongo.NewInsertOneModel().SetDocument(untrashedValue(write.Doc)))
+		case BulkUpdate:
+			fields, err := fieldsOf(write.Doc)
+			if err != nil {
+				results[i].Err = err
+				ok = false
+				continue
+			}
+			delete(fields, "deleted_at")
+			fields["_id"] = write.ID
+			fields["revision"] = write.Revision + 1
+			filter := bson.M{"_id": write.ID, "deleted_at": nil, "revision": atRevision(write.Revision)}
+			writeModels = append(writeModels, mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(fields).SetUpsert(true))
+		default:
+			results[i].Err = fmt.Errorf("unknown bulk write %q", write.Kind)
+			ok = false
+			continue
+		}
+		sent = append(sent, i)
+	}
+	if len(writeModels) == 0 {
+		return ok
+	}
+	if !ok && ordered {
+		// nothing is sent once a write of an ordered batch cannot even be built
+		for _, i := range sent {
+			results[i].Err = ErrSkipped
+		}
+		return false
+	}
+
+	res, err := coll.BulkWrite(ctx, writeModels, options.BulkWrite().SetOrdered(ordered))
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		for _, i := range sent {
+			results[i].Err = err
+		}
+		return false
+	}
+
+	for _, writeErr := range bulkErr.WriteErrors {
+		i := sent[writeErr.Index]
+		if writes[i].Kind == BulkUpdate && mongo.IsDuplicateKeyError(writeErr) {
+			results[i].Err = liveConflict(ctx, coll, writes[i].ID)
+		} else {
+			results[i].Err = writeErr
+		}
+		ok = false
+		if ordered {
+			for _, later := range sent[writeErr.Index+1:] {
+				results[later].Err = ErrSkipped
+			}
+		}
+	}
+	if bulkErr.WriteConcernError != nil {
+		for _, i := range sent {
+			if results[i].Err == nil {
+				results[i].Err = bulkErr.WriteConcernError
+			}
+		}
+		ok = false
+	}
+
+	// an update that upserted found its document purged since it was read,
+	// so the copy it made is taken back out
+	if res != nil {
+		for index := range res.UpsertedIDs {
+			i := sent[index]
+			if _, err := coll.DeleteOne(ctx, bson.M{"_id": writes[i].ID}); err != nil {
+				results[i].Err = err
+			} else {
+				results[i].Err = ErrNotFound
+			}
+			ok = false
+		}
+	}
+	return ok
+}
+
+// removeAt deletes the document with the given ID for good while it is live
+// at revision
+func removeAt(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, revision int64) error {
+	res, err := coll.DeleteOne(ctx, bson.M{"_id": id, "deleted_at": nil, "revision": atRevision(revision)})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount > 0 {
+		return nil
+	}
+	return liveConflict(ctx, coll, id)
+}
+
+// liveConflict tells why an update found no document at its revision: the
+// document has moved on, or it is in the trash
+func liveConflict(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID) error {
+	err := coll.FindOne(ctx, bson.M{"_id": id, "deleted_at": nil}).Err()
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return ErrRevisionConflict
+}
+
+// untrashedValue is untrashed for a document given as an interface{}
+func untrashedValue(doc interface{}) interface{} {
+	switch d := doc.(type) {
+	case models.User:
+		return untrashed(d)
+	case models.Emoji:
+		return untrashed(d)
+	case models.Contact:
+		return untrashed(d)
+	case models.Business:
+		return untrashed(d)
+	case models.Call:
+		return untrashed(d)
+	}
+	return doc
+}
+
+// MemoryBulkWriter makes the writes of a bulk request one at a time over the
+// in-memory collections, holding off deletes and restores meanwhile. Nothing
+// can be undone, so an atomic request checks every write first and makes
+// them only if all pass; other writes may still interleave, as with
+// MemoryDeleter.
+type MemoryBulkWriter struct {
+	deletes     *MemoryDeleter
+	outbox      OutboxRepository
+	transitions TransitionRepository
+}
+
+func (w *MemoryBulkWriter) Bulk(ctx context.Context, resource string, writes []BulkWrite, opts BulkOptions) ([]BulkResult, error) {
+	coll, err := w.deletes.store.collection(resource)
+	if err != nil {
+		return nil, err
+	}
+	var results []BulkResult
+	err = w.deletes.Atomically(ctx, func(ctx context.Context) error {
+		results = w.run(ctx, coll, resource, writes, opts)
+		queue(ctx, w.outbox, w.transitions, writes, results)
+		return nil
+	})
+	return results, err
+}
+
+// run makes the writes while holding off the deleter's other changes
+func (w *MemoryBulkWriter) run(ctx context.Context, coll memoryTrashable, resource string, writes []BulkWrite, opts BulkOptions) []BulkResult {
+	if opts.Atomic {
+		results := make([]BulkResult, len(writes))
+		for i, write := range writes {
+			results[i].Err = w.check(ctx, coll, resource, write)
+		}
+		if failed(results) {
+			rollBack(results)
+			return results
+		}
+	}
+
+	results := make([]BulkResult, len(writes))
+	for i, write := range writes {
+		switch write.Kind {
+		case BulkCreate:
+			results[i].Err = coll.insertValue(write.Doc)
+		case BulkUpdate:
+			results[i].Err = coll.replaceAt(write.ID, write.Revision, write.Doc)
+		case BulkDelete:
+			if write.Hard {
+				results[i].Err = coll.deleteAt(write.ID, write.Revision)
+				break
+			}
+			revision := write.Revision
+			results[i].Report, results[i].Err = trash(ctx, w.deletes.store, resource, write.ID, DeleteOptions{Options: write.Delete, Revision: &revision})
+		default:
+			results[i].Err = fmt.Errorf("unknown bulk write %q", write.Kind)
+		}
+		if results[i].Err != nil && (opts.Ordered || opts.Atomic) {
+			skip(results, i+1)
+			break
+		}
+	}
+	return results
+}
+
+// check finds whether a write would fail against the documents as they are
+func (w *MemoryBulkWriter) check(ctx context.Context, coll memoryTrashable, resource string, write BulkWrite) error {
+	switch write.Kind {
+	case BulkCreate:
+		raw, err := bson.Marshal(write.Doc)
+		if err != nil {
+			return err
+		}
+		id := bson.Raw(raw).Lookup("_id").ObjectID()
+		if _, err := coll.raw(id); err == nil {
+			return fmt.Errorf("duplicate key: _id %s already exists", id.Hex())
+		}
+		return nil
+	case BulkUpdate:
+		return checkLive(coll, write)
+	case BulkDelete:
+		if write.Hard {
+			return checkLive(coll, write)
+		}
+		revision := write.Revision
+		opts := DeleteOptions{Options: write.Delete, Revision: &revision}
+		opts.DryRun = true
+		_, err := trash(ctx, w.deletes.store, resource, write.ID, opts)
+		return err
+	}
+	return fmt.Errorf("unknown bulk write %q", write.Kind)
+}
+
+// checkLive finds whether the document a write changes is out of the trash
+// at the revision the write expects
+func checkLive(coll memoryTrashable, write BulkWrite) error {
+	raw, err := coll.raw(write.ID)
+	if err != nil {
+		return err
+	}
+	if len(coll.inTrash([]primitive.ObjectID{write.ID})) > 0 {
+		return ErrNotFound
+	}
+	if revision, _ := raw.Lookup("revision").AsInt64OK(); revision != write.Revision {
+		return ErrRevisionConflict
+	}
+	return nil
+}
+
+// insertValue is Insert for a document given as an interface{}
+func (m *memoryCollection[T]) insertValue(doc interface{}) error {
+	typed, ok := doc.(T)
+	if !ok {
+		return fmt.Errorf("cannot store a %T here", doc)
+	}
+	return m.Insert(context.Background(), typed)
+}
+
+// replaceAt replaces the document with the given ID while it is at revision
+func (m *memoryCollection[T]) replaceAt(id primitive.ObjectID, revision int64, doc interface{}) error {
+	typed, ok := doc.(T)
+	if !ok {
+		return fmt.Errorf("cannot store a %T here", doc)
+	}
+	_, err := m.updateAt(id, revision, func(stored *T) { *stored = typed })
+	return err
+}
+
+// deleteAt deletes the document with the given ID for good while it is live
+// at revision
+func (m *memoryCollection[T]) deleteAt(id primitive.ObjectID, revision int64) error {
+	m.mu.RLock()
+	doc, ok := m.docs[id]
+	trashed := m.trashed[id]
+	m.mu.RUnlock()
+	if !ok || trashed {
+		return ErrNotFound
+	}
+	if current := revisionOf(&doc); current != nil && *current != revision {
+		return ErrRevisionConflict
+	}
+	return m.Delete(context.Background(), id)
+}
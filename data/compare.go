@@ -0,0 +1,147 @@
+package data
+
+import (
+	"bytes"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// typeRank follows Mongo's cross-type sort order for the types our models use
+func typeRank(t bsontype.Type) int {
+	switch t {
+	case 0, bsontype.Null, bsontype.Undefined:
+		return 1
+	case bsontype.Double, bsontype.Int32, bsontype.Int64, bsontype.Decimal128:
+		return 2
+	case bsontype.String, bsontype.Symbol:
+		return 3
+	case bsontype.EmbeddedDocument:
+		return 4
+	case bsontype.Array:
+		return 5
+	case bsontype.Binary:
+		return 6
+	case bsontype.ObjectID:
+		return 7
+	case bsontype.Boolean:
+		return 8
+	case bsontype.DateTime:
+		return 9
+	case bsontype.Timestamp:
+		return 10
+	default:
+		return 11
+	}
+}
+
+func toFloat(v bson.RawValue) float64 {
+	switch v.Type {
+	case bsontype.Int32:
+		return float64(v.Int32())
+	case bsontype.Int64:
+		return float64(v.Int64())
+	case bsontype.Double:
+		return v.Double()
+	}
+	return 0
+}
+
+// compareValues orders two bson values the way a Mongo sort would
+func compareValues(a, b bson.RawValue) int {
+	ra, rb := typeRank(a.Type), typeRank(b.Type)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	switch ra {
+	case 2:
+		fa, fb := toFloat(a), toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case 3:
+		return strings.Compare(a.StringValue(), b.StringValue())
+	case 7:
+		oa, ob := a.ObjectID(), b.ObjectID()
+		return bytes.Compare(oa[:], ob[:])
+	case 8:
+		ba, bb := a.Boolean(), b.Boolean()
+		switch {
+		case ba == bb:
+			return 0
+		case !ba:
+			return -1
+		}
+		return 1
+	case 9:
+		da, db := a.DateTime(), b.DateTime()
+		switch {
+		case da < db:
+			return -1
+		case da > db:
+			return 1
+		}
+		return 0
+	}
+	return bytes.Compare(a.Value, b.Value)
+}
+
+// rawValue converts a Go value into a bson value so it can be compared with document fields
+func rawValue(v interface{}) (bson.RawValue, error) {
+	t, data, err := bson.MarshalValue(v)
+	if err != nil {
+		return bson.RawValue{}, err
+	}
+	return bson.RawValue{Type: t, Value: data}, nil
+}
+
+// matches reports whether the document satisfies the filter
+func (f Filter) matches(doc bson.Raw) (bool, error) {
+	value := doc.Lookup(f.Field)
+
+	if f.Op == OpIn {
+		values, _ := f.Value.([]interface{})
+		for _, candidate := range values {
+			target, err := rawValue(candidate)
+			if err != nil {
+				return false, err
+			}
+			if compareValues(value, target) == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	target, err := rawValue(f.Value)
+	if err != nil {
+		return false, err
+	}
+	cmp := compareValues(value, target)
+	sameType := typeRank(value.Type) == typeRank(target.Type)
+
+	switch f.Op {
+	case OpEq:
+		return cmp == 0, nil
+	case OpNe:
+		return cmp != 0, nil
+	case OpGt:
+		return sameType && cmp > 0, nil
+	case OpGte:
+		return sameType && cmp >= 0, nil
+	case OpLt:
+		return sameType && cmp < 0, nil
+	case OpLte:
+		return sameType && cmp <= 0, nil
+	}
+	return false, nil
+}
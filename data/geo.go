@@ -0,0 +1,73 @@
+package data
+
+import (
+	"math"
+	"sort"
+
+	"usermanagement/models"
+)
+
+// earthRadius is the mean Earth radius in meters, as used by Mongo's spherical queries
+const earthRadius = 6378100.0
+
+// GeoQuery selects contacts by location and orders them by distance from Lng, Lat.
+// MaxDistance (meters) and Within (a closed ring of [lng, lat] pairs) are
+// optional and combine when both are given.
+type GeoQuery struct {
+	Lng, Lat    float64
+	MaxDistance float64
+	Within      [][]float64
+	Limit       int
+}
+
+func (q GeoQuery) limit() int {
+	return ListOptions{Limit: q.Limit}.limit()
+}
+
+// haversine returns the great-circle distance in meters between two points
+func haversine(lng1, lat1, lng2, lat2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// inRing reports whether a point lies inside a ring, treating coordinates as planar
+func inRing(lng, lat float64, ring [][]float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// nearest filters and orders contacts the way the Mongo $geoNear stage does
+func (q GeoQuery) nearest(contacts []models.Contact) []models.ContactDistance {
+	var results []models.ContactDistance
+	for _, contact := range contacts {
+		if contact.Location == nil || len(contact.Location.Coordinates) != 2 {
+			continue
+		}
+		lng, lat := contact.Location.Coordinates[0], contact.Location.Coordinates[1]
+		distance := haversine(q.Lng, q.Lat, lng, lat)
+		if q.MaxDistance > 0 && distance > q.MaxDistance {
+			continue
+		}
+		if len(q.Within) > 0 && !inRing(lng, lat, q.Within) {
+			continue
+		}
+		results = append(results, models.ContactDistance{Contact: contact, Distance: distance})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Distance < results[j].Distance })
+	if len(results) > q.limit() {
+		results = results[:q.limit()]
+	}
+	return results
+}
@@ -0,0 +1,161 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"usermanagement/config"
+	"usermanagement/models"
+)
+
+// HistoryRepository keeps every saved state of users, emojis, contacts and
+// businesses as numbered versions
+type HistoryRepository interface {
+	// Record snapshots each of the given documents of change.Resource as it is
+	// stored now, in the trash or not, as its next version. The action, acting
+	// user and reverted version are taken from change. It is for writes that
+	// change many documents at once and cannot return them, such as a delete
+	// and the references it clears.
+	Record(ctx context.Context, change models.Version, ids ...primitive.ObjectID) error
+	// Snapshot records each of docs, documents of change.Resource as the write
+	// that changed them returned them, as their next version. Unlike Record
+	// it cannot catch a later change, so it is what a single write records.
+	Snapshot(ctx context.Context, change models.Version, docs ...interface{}) error
+	// List returns a page of the versions of a document
+	List(ctx context.Context, resource string, id primitive.ObjectID, opts ListOptions) (Page[models.Version], error)
+	// Find returns one version of a document
+	Find(ctx context.Context, resource string, id primitive.ObjectID, version int) (models.Version, error)
+	// After returns a page of the versions of every document recorded after
+	// the version with the given ID, in the order they were recorded. The
+	// zero ID reads from the first.
+	After(ctx context.Context, id primitive.ObjectID, opts ListOptions) (Page[models.Version], error)
+}
+
+// versionAttempts is how many times a version is renumbered when another
+// instance recorded the same number first
+const versionAttempts = 5
+
+// history numbers snapshots of the documents it reads from docs into versions
+type history struct {
+	docs interface {
+		find(ctx context.Context, resource string, id primitive.ObjectID) (bson.Raw, error)
+	}
+	versions interface {
+		List(ctx context.Context, opts ListOptions) (Page[models.Version], error)
+		Insert(ctx context.Context, version models.Version) error
+	}
+
+	// mu numbers this instance's versions one at a time; the unique index on
+	// the version number catches the other instances
+	mu sync.Mutex
+}
+
+// NewMongoHistory keeps versions in the history collection, reading snapshots
+// from the collection of each resource
+func NewMongoHistory(db *mongo.Database, names config.Collections) HistoryRepository {
+	return &history{
+		docs:     newMongoStore(db, names),
+		versions: mongoCollection[models.Version]{coll: db.Collection(names.History)},
+	}
+}
+
+func (h *history) Record(ctx context.Context, change models.Version, ids ...primitive.ObjectID) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, id := range ids {
+		snapshot, err := h.docs.find(ctx, change.Resource, id)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := h.insert(ctx, change, id, snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *history) Snapshot(ctx context.Context, change models.Version, docs ...interface{}) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, doc := range docs {
+		snapshot, err := bson.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("recording version of %s: %w", change.Resource, err)
+		}
+		id, ok := bson.Raw(snapshot).Lookup("_id").ObjectIDOK()
+		if !ok {
+			return fmt.Errorf("recording version of %s: the document has no ID", change.Resource)
+		}
+		if err := h.insert(ctx, change, id, snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insert stores snapshot as the next version of the document with the given
+// ID. The caller holds mu.
+func (h *history) insert(ctx context.Context, change models.Version, id primitive.ObjectID, snapshot bson.Raw) error {
+	version := change
+	version.DocumentID = id
+	version.Snapshot = snapshot
+	version.CreatedDate = time.Now()
+	for attempt := 1; ; attempt++ {
+		latest, err := h.latest(ctx, change.Resource, id)
+		if err != nil {
+			return err
+		}
+		version.ID = primitive.NewObjectID()
+		version.Version = latest + 1
+		err = h.versions.Insert(ctx, version)
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) || attempt == versionAttempts {
+			return fmt.Errorf("recording version of %s %s: %w", change.Resource, id.Hex(), err)
+		}
+	}
+}
+
+// latest returns the number of the last version of a document, 0 when it has none
+func (h *history) latest(ctx context.Context, resource string, id primitive.ObjectID) (int, error) {
+	page, err := h.List(ctx, resource, id, ListOptions{Sort: "version", Desc: true, Limit: 1})
+	if err != nil || len(page.Items) == 0 {
+		return 0, err
+	}
+	return page.Items[0].Version, nil
+}
+
+func (h *history) List(ctx context.Context, resource string, id primitive.ObjectID, opts ListOptions) (Page[models.Version], error) {
+	opts.Filters = append(opts.Filters,
+		Filter{Field: "resource", Op: OpEq, Value: resource},
+		Filter{Field: "document_id", Op: OpEq, Value: id},
+	)
+	return h.versions.List(ctx, opts)
+}
+
+func (h *history) After(ctx context.Context, id primitive.ObjectID, opts ListOptions) (Page[models.Version], error) {
+	opts.Filters = append(opts.Filters, Filter{Field: "_id", Op: OpGt, Value: id})
+	return h.versions.List(ctx, opts)
+}
+
+func (h *history) Find(ctx context.Context, resource string, id primitive.ObjectID, version int) (models.Version, error) {
+	opts := ListOptions{Filters: []Filter{{Field: "version", Op: OpEq, Value: version}}, Limit: 1}
+	page, err := h.List(ctx, resource, id, opts)
+	if err != nil {
+		return models.Version{}, err
+	}
+	if len(page.Items) == 0 {
+		return models.Version{}, ErrNotFound
+	}
+	return page.Items[0], nil
+}
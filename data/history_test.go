@@ -0,0 +1,68 @@
+package data
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/integrity"
+	"usermanagement/models"
+)
+
+// TestHistoryNumbersVersions checks each document's versions are numbered
+// on their own, snapshot what was stored and read back in order
+func TestHistoryNumbersVersions(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemoryRepositories()
+	userID := primitive.NewObjectID()
+	change := models.Version{Resource: integrity.Contacts, Action: models.VersionUpdated, UserID: userID}
+
+	grace := models.Contact{ID: primitive.NewObjectID(), Name: "Grace"}
+	ada := models.Contact{ID: primitive.NewObjectID(), Name: "Ada"}
+	if err := repos.Contacts.Insert(ctx, grace); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.History.Snapshot(ctx, change, grace, ada); err != nil {
+		t.Fatal(err)
+	}
+	grace.Name = "Grace Hopper"
+	if _, err := repos.Contacts.Update(ctx, grace); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.History.Record(ctx, change, grace.ID, primitive.NewObjectID()); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := repos.History.List(ctx, integrity.Contacts, grace.ID, ListOptions{Sort: "version"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 2 || page.Items[0].Version != 1 || page.Items[1].Version != 2 {
+		t.Fatalf("versions of grace: %+v, want 1 and 2", page.Items)
+	}
+	if name := page.Items[1].Snapshot.Lookup("name").StringValue(); name != "Grace Hopper" {
+		t.Errorf("version 2 snapshot has name %q, want the stored one", name)
+	}
+	version, err := repos.History.Find(ctx, integrity.Contacts, ada.ID, 1)
+	if err != nil || version.UserID != userID {
+		t.Errorf("ada version 1: %+v, %v", version, err)
+	}
+	if _, err := repos.History.Find(ctx, integrity.Contacts, ada.ID, 2); err != ErrNotFound {
+		t.Errorf("ada version 2: %v, want %v", err, ErrNotFound)
+	}
+
+	all, err := repos.History.After(ctx, primitive.NilObjectID, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Items) != 3 {
+		t.Fatalf("history holds %d versions, want 3", len(all.Items))
+	}
+	after, err := repos.History.After(ctx, all.Items[0].ID, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after.Items) != 2 || after.Items[0].ID != all.Items[1].ID || after.Items[1].ID != all.Items[2].ID {
+		t.Errorf("after the first version: %+v, want the other two in order", after.Items)
+	}
+}
@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"usermanagement/config"
+	"usermanagement/integrity"
+)
+
+// indexModels returns an index for every sortable field (with _id as the
+// keyset tiebreaker) and for every reference field used in equality filters
+func indexModels(fields Fields) []mongo.IndexModel {
+	var models []mongo.IndexModel
+	for name, field := range fields {
+		switch {
+		case name == "_id":
+		case field.Sortable:
+			models = append(models, mongo.IndexModel{Keys: bson.D{{Key: name, Value: 1}, {Key: "_id", Value: 1}}})
+		case field.Type == ObjectIDField:
+			models = append(models, mongo.IndexModel{Keys: bson.D{{Key: name, Value: 1}}})
+		}
+	}
+	return models
+}
+
+// EnsureIndexes creates the indexes backing list sorting and filtering
+func EnsureIndexes(ctx context.Context, db *mongo.Database, names config.Collections) error {
+	for collection, fields := range map[string]Fields{
+		names.Users:       UserFields,
+		names.Emojis:      EmojiFields,
+		names.Contacts:    ContactFields,
+		names.Businesses:  BusinessFields,
+		names.Calls:       CallFields,
+		names.Activities:  ActivityFields,
+		names.Followups:   FollowupFields,
+		names.Transitions: TransitionFields,
+		names.Trash:       TrashFields,
+		names.Webhooks:    WebhookFields,
+		names.Outbox:      EventFields,
+		names.Deliveries:  DeliveryFields,
+	} {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, indexModels(fields)); err != nil {
+			return fmt.Errorf("creating indexes on %s: %w", collection, err)
+		}
+	}
+
+	for collection, weights := range map[string]SearchWeights{
+		names.Businesses: BusinessSearchWeights,
+		names.Contacts:   ContactSearchWeights,
+	} {
+		if _, err := db.Collection(collection).Indexes().CreateOne(ctx, weights.textIndex()); err != nil {
+			return fmt.Errorf("creating text index on %s: %w", collection, err)
+		}
+	}
+
+	phoneIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "cell_phone_e164", Value: 1}}},
+		{Keys: bson.D{{Key: "work_phone_e164", Value: 1}}},
+	}
+	if _, err := db.Collection(names.Contacts).Indexes().CreateMany(ctx, phoneIndexes); err != nil {
+		return fmt.Errorf("creating phone indexes on %s: %w", names.Contacts, err)
+	}
+
+	// restoring looks up the entry holding a document by its resource
+	var trashIndexes []mongo.IndexModel
+	for _, resource := range []string{integrity.Users, integrity.Emojis, integrity.Contacts, integrity.Businesses, integrity.Calls} {
+		trashIndexes = append(trashIndexes, mongo.IndexModel{Keys: bson.D{{Key: "deleted." + resource, Value: 1}}})
+	}
+	if _, err := db.Collection(names.Trash).Indexes().CreateMany(ctx, trashIndexes); err != nil {
+		return fmt.Errorf("creating indexes on %s: %w", names.Trash, err)
+	}
+
+	// versions are numbered per document, which also orders its history
+	versionIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "resource", Value: 1}, {Key: "document_id", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := db.Collection(names.History).Indexes().CreateOne(ctx, versionIndex); err != nil {
+		return fmt.Errorf("creating version index on %s: %w", names.History, err)
+	}
+
+	// a delivery is queued once per event and webhook, and the dispatcher
+	// looks up the due ones by status and time
+	deliveryIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "event_id", Value: 1}, {Key: "webhook_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt", Value: 1}}},
+	}
+	if _, err := db.Collection(names.Deliveries).Indexes().CreateMany(ctx, deliveryIndexes); err != nil {
+		return fmt.Errorf("creating delivery indexes on %s: %w", names.Deliveries, err)
+	}
+	outboxIndex := mongo.IndexModel{Keys: bson.D{{Key: "dispatched", Value: 1}, {Key: "created_date", Value: 1}}}
+	if _, err := db.Collection(names.Outbox).Indexes().CreateOne(ctx, outboxIndex); err != nil {
+		return fmt.Errorf("creating outbox index on %s: %w", names.Outbox, err)
+	}
+
+	// stream tokens are removed once they can no longer be resumed from
+	tokenIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := db.Collection(names.StreamTokens).Indexes().CreateOne(ctx, tokenIndex); err != nil {
+		return fmt.Errorf("creating expiry index on %s: %w", names.StreamTokens, err)
+	}
+
+	geoIndex := mongo.IndexModel{Keys: bson.D{{Key: "location", Value: "2dsphere"}}}
+	if _, err := db.Collection(names.Contacts).Indexes().CreateOne(ctx, geoIndex); err != nil {
+		return fmt.Errorf("creating geospatial index on %s: %w", names.Contacts, err)
+	}
+	return nil
+}
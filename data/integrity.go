@@ -0,0 +1,623 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"usermanagement/config"
+	"usermanagement/integrity"
+	"usermanagement/models"
+)
+
+// Deleter moves a document to the trash together with whatever the relation
+// policies take with it, and restores or purges what it trashed. Each change
+// is atomic where the database allows it.
+type Deleter interface {
+	Delete(ctx context.Context, resource string, id primitive.ObjectID, opts DeleteOptions) (integrity.Report, error)
+	// Restore brings back the document of resource with the given ID and
+	// everything deleted with it, returning the entry it restored
+	Restore(ctx context.Context, resource string, id primitive.ObjectID) (models.TrashEntry, error)
+	// Purge deletes for good everything trashed before the cutoff, returning how many deletes it purged
+	Purge(ctx context.Context, before time.Time) (int, error)
+}
+
+// DeleteOptions controls one delete
+type DeleteOptions struct {
+	integrity.Options
+	// Revision, when set, is the revision the document must still be at
+	Revision *int64
+}
+
+// MongoDeleter runs each change inside a transaction. Transactions need a
+// replica set or a sharded cluster; on a standalone server changes run without one.
+type MongoDeleter struct {
+	db    *mongo.Database
+	store mongoStore
+
+	mu           sync.Mutex
+	checked      bool
+	transactions bool
+}
+
+func NewMongoDeleter(db *mongo.Database, names config.Collections) *MongoDeleter {
+	return &MongoDeleter{db: db, store: newMongoStore(db, names)}
+}
+
+func (d *MongoDeleter) Delete(ctx context.Context, resource string, id primitive.ObjectID, opts DeleteOptions) (integrity.Report, error) {
+	var report integrity.Report
+	err := d.atomically(ctx, func(ctx context.Context) error {
+		var err error
+		report, err = trash(ctx, d.store, resource, id, opts)
+		return err
+	})
+	return report, err
+}
+
+func (d *MongoDeleter) Restore(ctx context.Context, resource string, id primitive.ObjectID) (models.TrashEntry, error) {
+	var entry models.TrashEntry
+	err := d.atomically(ctx, func(ctx context.Context) error {
+		var err error
+		entry, err = restore(ctx, d.store, resource, id)
+		return err
+	})
+	return entry, err
+}
+
+func (d *MongoDeleter) Purge(ctx context.Context, before time.Time) (int, error) {
+	return purgeBefore(ctx, d.store, before, d.atomically)
+}
+
+func (d *MongoDeleter) Atomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	return d.atomically(ctx, fn)
+}
+
+// atomically runs fn in a transaction when the server supports them, or in
+// the transaction ctx already carries
+func (d *MongoDeleter) atomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	transactions, err := d.supportsTransactions(ctx)
+	if err != nil {
+		return err
+	}
+	if !transactions {
+		return fn(ctx)
+	}
+
+	session, err := d.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// supportsTransactions asks the server whether it is part of a replica set or
+// a sharded cluster, remembering the answer once it gets one
+func (d *MongoDeleter) supportsTransactions(ctx context.Context) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.checked {
+		transactions, err := replicated(ctx, d.db)
+		if err != nil {
+			return false, fmt.Errorf("checking MongoDB topology: %w", err)
+		}
+		if !transactions {
+			log.Println("MongoDB is a standalone server, deleting without transactions")
+		}
+		d.transactions, d.checked = transactions, true
+	}
+	return d.transactions, nil
+}
+
+// replicated asks the server whether it is part of a replica set or a sharded
+// cluster, which transactions and change streams need
+func replicated(ctx context.Context, db *mongo.Database) (bool, error) {
+	server, err := hello(ctx, db)
+	return server.replicated(), err
+}
+
+// topology is what the server says of itself in reply to hello
+type topology struct {
+	SetName        string `bson:"setName"`
+	Msg            string `bson:"msg"`
+	MaxWireVersion int    `bson:"maxWireVersion"`
+}
+
+func hello(ctx context.Context, db *mongo.Database) (topology, error) {
+	var server topology
+	err := db.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&server)
+	return server, err
+}
+
+func (t topology) replicated() bool {
+	return t.SetName != "" || t.Msg == "isdbgrid"
+}
+
+// mongoStore gives the deleter access to the collection of each resource and to the trash
+type mongoStore struct {
+	collections map[string]*mongo.Collection
+	trash       *mongo.Collection
+}
+
+func newMongoStore(db *mongo.Database, names config.Collections) mongoStore {
+	return mongoStore{
+		collections: map[string]*mongo.Collection{
+			integrity.Users:      db.Collection(names.Users),
+			integrity.Emojis:     db.Collection(names.Emojis),
+			integrity.Contacts:   db.Collection(names.Contacts),
+			integrity.Businesses: db.Collection(names.Businesses),
+			integrity.Calls:      db.Collection(names.Calls),
+		},
+		trash: db.Collection(names.Trash),
+	}
+}
+
+func (s mongoStore) collection(resource string) (*mongo.Collection, error) {
+	coll, ok := s.collections[resource]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource %q", resource)
+	}
+	return coll, nil
+}
+
+func (s mongoStore) Exists(ctx context.Context, resource string, id primitive.ObjectID) (bool, error) {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return false, err
+	}
+	n, err := coll.CountDocuments(ctx, bson.M{"_id": id, "deleted_at": nil}, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+func (s mongoStore) Refs(ctx context.Context, resource, field string, ids []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{field: bson.M{"$in": ids}, "deleted_at": nil}
+	return s.ids(ctx, coll, filter)
+}
+
+// ids returns the IDs of the documents of coll matching filter
+func (s mongoStore) ids(ctx context.Context, coll *mongo.Collection, filter bson.M) ([]primitive.ObjectID, error) {
+	cur, err := coll.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var ids []primitive.ObjectID
+	for cur.Next(ctx) {
+		ids = append(ids, cur.Current.Lookup("_id").ObjectID())
+	}
+	return ids, cur.Err()
+}
+
+func (s mongoStore) SetRefs(ctx context.Context, resource, field string, ids []primitive.ObjectID, to primitive.ObjectID) error {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return err
+	}
+	_, err = coll.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{"$set": bson.M{field: to}, "$inc": bson.M{"revision": 1}})
+	return err
+}
+
+func (s mongoStore) DeleteMany(ctx context.Context, resource string, ids []primitive.ObjectID) error {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return err
+	}
+	_, err = coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+func (s mongoStore) find(ctx context.Context, resource string, id primitive.ObjectID) (bson.Raw, error) {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := coll.FindOne(ctx, bson.M{"_id": id}).Raw()
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	return raw, err
+}
+
+func (s mongoStore) targets(ctx context.Context, resource, field string, ids []primitive.ObjectID) (map[primitive.ObjectID]primitive.ObjectID, error) {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := coll.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{field: 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	targets := make(map[primitive.ObjectID]primitive.ObjectID)
+	for cur.Next(ctx) {
+		if target, ok := cur.Current.Lookup(field).ObjectIDOK(); ok {
+			targets[cur.Current.Lookup("_id").ObjectID()] = target
+		}
+	}
+	return targets, cur.Err()
+}
+
+func (s mongoStore) inTrash(ctx context.Context, resource string, ids []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return nil, err
+	}
+	return s.ids(ctx, coll, bson.M{"_id": bson.M{"$in": ids}, "deleted_at": bson.M{"$ne": nil}})
+}
+
+func (s mongoStore) setDeletedAt(ctx context.Context, resource string, ids []primitive.ObjectID, at *time.Time) error {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return err
+	}
+	update := bson.M{"$unset": bson.M{"deleted_at": ""}, "$inc": bson.M{"revision": 1}}
+	if at != nil {
+		update = bson.M{"$set": bson.M{"deleted_at": *at}, "$inc": bson.M{"revision": 1}}
+	}
+	_, err = coll.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, update)
+	return err
+}
+
+func (s mongoStore) insertEntry(ctx context.Context, entry models.TrashEntry) error {
+	_, err := s.trash.InsertOne(ctx, entry)
+	return err
+}
+
+func (s mongoStore) findEntry(ctx context.Context, resource string, id primitive.ObjectID) (models.TrashEntry, error) {
+	var entry models.TrashEntry
+	err := s.trash.FindOne(ctx, bson.M{"deleted." + resource: id}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return entry, ErrNotFound
+	}
+	return entry, err
+}
+
+func (s mongoStore) entriesBefore(ctx context.Context, before time.Time, limit int) ([]models.TrashEntry, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "deleted_at", Value: 1}}).SetLimit(int64(limit))
+	cur, err := s.trash.Find(ctx, bson.M{"deleted_at": bson.M{"$lt": before}}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	var entries []models.TrashEntry
+	return entries, cur.All(ctx, &entries)
+}
+
+func (s mongoStore) deleteEntry(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.trash.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// memoryTrashable is the part of a memory collection the deleter works with
+type memoryTrashable interface {
+	Exists(ctx context.Context, id primitive.ObjectID) (bool, error)
+	refs(field string, ids []primitive.ObjectID) ([]primitive.ObjectID, error)
+	setField(field string, ids []primitive.ObjectID, value interface{}) error
+	deleteMany(ids []primitive.ObjectID)
+	raw(id primitive.ObjectID) (bson.Raw, error)
+	targets(field string, ids []primitive.ObjectID) (map[primitive.ObjectID]primitive.ObjectID, error)
+	inTrash(ids []primitive.ObjectID) []primitive.ObjectID
+	setTrashed(ids []primitive.ObjectID, at *time.Time) error
+	insertValue(doc interface{}) error
+	replaceAt(id primitive.ObjectID, revision int64, doc interface{}) error
+	deleteAt(id primitive.ObjectID, revision int64) error
+}
+
+// MemoryDeleter makes one change at a time over the in-memory collections.
+// Other writes may interleave, so a change is not isolated the way a Mongo
+// transaction is.
+type MemoryDeleter struct {
+	mu    sync.Mutex
+	store memoryStore
+}
+
+func (d *MemoryDeleter) Delete(ctx context.Context, resource string, id primitive.ObjectID, opts DeleteOptions) (integrity.Report, error) {
+	var report integrity.Report
+	err := d.Atomically(ctx, func(ctx context.Context) error {
+		var err error
+		report, err = trash(ctx, d.store, resource, id, opts)
+		return err
+	})
+	return report, err
+}
+
+func (d *MemoryDeleter) Restore(ctx context.Context, resource string, id primitive.ObjectID) (models.TrashEntry, error) {
+	var entry models.TrashEntry
+	err := d.Atomically(ctx, func(ctx context.Context) error {
+		var err error
+		entry, err = restore(ctx, d.store, resource, id)
+		return err
+	})
+	return entry, err
+}
+
+func (d *MemoryDeleter) Purge(ctx context.Context, before time.Time) (int, error) {
+	return purgeBefore(ctx, d.store, before, d.Atomically)
+}
+
+// memoryTransaction marks the context of a change the deleter is making
+type memoryTransaction struct{}
+
+// Atomically runs fn holding off the deleter's other changes. Nothing fn
+// did is undone when it fails.
+func (d *MemoryDeleter) Atomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(memoryTransaction{}) != nil {
+		return fn(ctx)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return fn(context.WithValue(ctx, memoryTransaction{}, true))
+}
+
+type memoryStore struct {
+	collections map[string]memoryTrashable
+	trash       *MemoryTrashRepository
+}
+
+func (s memoryStore) collection(resource string) (memoryTrashable, error) {
+	coll, ok := s.collections[resource]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource %q", resource)
+	}
+	return coll, nil
+}
+
+func (s memoryStore) Exists(ctx context.Context, resource string, id primitive.ObjectID) (bool, error) {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return false, err
+	}
+	return coll.Exists(ctx, id)
+}
+
+func (s memoryStore) Refs(ctx context.Context, resource, field string, ids []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return nil, err
+	}
+	return coll.refs(field, ids)
+}
+
+func (s memoryStore) SetRefs(ctx context.Context, resource, field string, ids []primitive.ObjectID, to primitive.ObjectID) error {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return err
+	}
+	return coll.setField(field, ids, to)
+}
+
+func (s memoryStore) DeleteMany(ctx context.Context, resource string, ids []primitive.ObjectID) error {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return err
+	}
+	coll.deleteMany(ids)
+	return nil
+}
+
+func (s memoryStore) find(ctx context.Context, resource string, id primitive.ObjectID) (bson.Raw, error) {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return nil, err
+	}
+	return coll.raw(id)
+}
+
+func (s memoryStore) targets(ctx context.Context, resource, field string, ids []primitive.ObjectID) (map[primitive.ObjectID]primitive.ObjectID, error) {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return nil, err
+	}
+	return coll.targets(field, ids)
+}
+
+func (s memoryStore) inTrash(ctx context.Context, resource string, ids []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return nil, err
+	}
+	return coll.inTrash(ids), nil
+}
+
+func (s memoryStore) setDeletedAt(ctx context.Context, resource string, ids []primitive.ObjectID, at *time.Time) error {
+	coll, err := s.collection(resource)
+	if err != nil {
+		return err
+	}
+	return coll.setTrashed(ids, at)
+}
+
+func (s memoryStore) insertEntry(ctx context.Context, entry models.TrashEntry) error {
+	return s.trash.Insert(ctx, entry)
+}
+
+func (s memoryStore) findEntry(ctx context.Context, resource string, id primitive.ObjectID) (models.TrashEntry, error) {
+	entries, err := s.trash.FindAll(ctx)
+	if err != nil {
+		return models.TrashEntry{}, err
+	}
+	for _, entry := range entries {
+		if containsID(entry.Deleted[resource], id) {
+			return entry, nil
+		}
+	}
+	return models.TrashEntry{}, ErrNotFound
+}
+
+func (s memoryStore) entriesBefore(ctx context.Context, before time.Time, limit int) ([]models.TrashEntry, error) {
+	entries, err := s.trash.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var due []models.TrashEntry
+	for _, entry := range entries {
+		if entry.DeletedAt.Before(before) {
+			due = append(due, entry)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].DeletedAt.Before(due[j].DeletedAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (s memoryStore) deleteEntry(ctx context.Context, id primitive.ObjectID) error {
+	return s.trash.Delete(ctx, id)
+}
+
+// refs returns the IDs of the documents outside the trash whose field holds one of ids
+func (m *memoryCollection[T]) refs(field string, ids []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var refs []primitive.ObjectID
+	for _, id := range m.live() {
+		raw, err := bson.Marshal(m.docs[id])
+		if err != nil {
+			return nil, err
+		}
+		if ref, ok := bson.Raw(raw).Lookup(field).ObjectIDOK(); ok && containsID(ids, ref) {
+			refs = append(refs, id)
+		}
+	}
+	return refs, nil
+}
+
+// setField sets field of the given documents to value, or removes it when
+// value is nil, going through BSON so any field can be set by its stored name
+func (m *memoryCollection[T]) setField(field string, ids []primitive.ObjectID, value interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		doc, ok := m.docs[id]
+		if !ok {
+			continue
+		}
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		var fields bson.D
+		if err := bson.Unmarshal(raw, &fields); err != nil {
+			return err
+		}
+		updated := fields[:0]
+		for _, f := range fields {
+			if f.Key != field {
+				updated = append(updated, f)
+			}
+		}
+		if value != nil {
+			updated = append(updated, bson.E{Key: field, Value: value})
+		}
+		raw, err = bson.Marshal(updated)
+		if err != nil {
+			return err
+		}
+		var result T
+		if err := bson.Unmarshal(raw, &result); err != nil {
+			return err
+		}
+		if revision := revisionOf(&result); revision != nil {
+			*revision++
+		}
+		m.docs[id] = result
+	}
+	return nil
+}
+
+func (m *memoryCollection[T]) deleteMany(ids []primitive.ObjectID) {
+	for _, id := range ids {
+		m.Delete(context.Background(), id)
+	}
+}
+
+// raw returns a stored document as BSON, in the trash or not
+func (m *memoryCollection[T]) raw(id primitive.ObjectID) (bson.Raw, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	doc, ok := m.docs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return bson.Marshal(doc)
+}
+
+// targets returns what field holds on each of the given documents, in the trash or not
+func (m *memoryCollection[T]) targets(field string, ids []primitive.ObjectID) (map[primitive.ObjectID]primitive.ObjectID, error) {
+	targets := make(map[primitive.ObjectID]primitive.ObjectID)
+	for _, id := range ids {
+		raw, err := m.raw(id)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if target, ok := raw.Lookup(field).ObjectIDOK(); ok {
+			targets[id] = target
+		}
+	}
+	return targets, nil
+}
+
+// inTrash returns which of ids are in the trash
+func (m *memoryCollection[T]) inTrash(ids []primitive.ObjectID) []primitive.ObjectID {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var trashed []primitive.ObjectID
+	for _, id := range ids {
+		if m.trashed[id] {
+			trashed = append(trashed, id)
+		}
+	}
+	return trashed
+}
+
+// setTrashed moves documents to the trash at the given time, or out of it when at is nil
+func (m *memoryCollection[T]) setTrashed(ids []primitive.ObjectID, at *time.Time) error {
+	var value interface{}
+	if at != nil {
+		value = *at
+	}
+	if err := m.setField("deleted_at", ids, value); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := m.docs[id]; !ok {
+			continue
+		}
+		if at != nil {
+			m.trashed[id] = true
+		} else {
+			delete(m.trashed, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,430 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// FilterOp is a comparison operator usable in list filters
+type FilterOp string
+
+const (
+	OpEq  FilterOp = "eq"
+	OpNe  FilterOp = "ne"
+	OpGt  FilterOp = "gt"
+	OpGte FilterOp = "gte"
+	OpLt  FilterOp = "lt"
+	OpLte FilterOp = "lte"
+	OpIn  FilterOp = "in"
+)
+
+// Filter restricts a list to documents whose Field compares to Value with Op.
+// For OpIn, Value is a []interface{}.
+type Filter struct {
+	Field string
+	Op    FilterOp
+	Value interface{}
+}
+
+// ListOptions controls filtering, sorting and keyset pagination of a list.
+// Sort is a bson field name; documents are ordered by it and then by _id.
+// When AnyOf is set a document must also match at least one of its filters.
+// CountTotal asks for the Total of the page, which counts every match.
+type ListOptions struct {
+	Filters    []Filter
+	AnyOf      []Filter
+	Sort       string
+	Desc       bool
+	Limit      int
+	Cursor     string
+	CountTotal bool
+}
+
+// Page is one page of a list together with the data needed to fetch the next
+// one. Total is how many match across every page, when CountTotal asked for it.
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+	Total      int64
+}
+
+func (o ListOptions) sortField() string {
+	if o.Sort == "" {
+		return "_id"
+	}
+	return o.Sort
+}
+
+func (o ListOptions) limit() int {
+	if o.Limit <= 0 {
+		return DefaultListLimit
+	}
+	if o.Limit > MaxListLimit {
+		return MaxListLimit
+	}
+	return o.Limit
+}
+
+// mongoSort orders by the sort field and then by _id, in the list direction
+func (o ListOptions) mongoSort() bson.D {
+	direction := 1
+	if o.Desc {
+		direction = -1
+	}
+	sortField := o.sortField()
+	sort := bson.D{{Key: sortField, Value: direction}}
+	if sortField != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: direction})
+	}
+	return sort
+}
+
+// mongoFilter translates the filters into a Mongo query document. A document
+// must match every filter, so a second one on the same field and operator
+// joins the first under $and rather than replacing it.
+func (o ListOptions) mongoFilter() bson.M {
+	query := bson.M{}
+	var also bson.A
+	for _, f := range o.Filters {
+		ops, ok := query[f.Field].(bson.M)
+		if !ok {
+			ops = bson.M{}
+			query[f.Field] = ops
+		}
+		op := "$" + string(f.Op)
+		if _, taken := ops[op]; taken {
+			also = append(also, bson.M{f.Field: bson.M{op: f.Value}})
+			continue
+		}
+		ops[op] = f.Value
+	}
+	if len(also) > 0 {
+		query["$and"] = also
+	}
+	if len(o.AnyOf) > 0 {
+		var alternatives bson.A
+		for _, f := range o.AnyOf {
+			alternatives = append(alternatives, bson.M{f.Field: bson.M{"$" + string(f.Op): f.Value}})
+		}
+		query["$or"] = alternatives
+	}
+	return query
+}
+
+// cursor marks the position of the last document of a page in a list sorted
+// by Sort in the direction Desc gives
+type cursor struct {
+	Sort  string
+	Desc  bool
+	Value bson.RawValue
+	ID    primitive.ObjectID
+}
+
+func encodeCursor(c cursor) (string, error) {
+	raw, err := bson.Marshal(bson.D{
+		{Key: "s", Value: c.Sort},
+		{Key: "d", Value: c.Desc},
+		{Key: "v", Value: c.Value},
+		{Key: "id", Value: c.ID},
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// decodeCursor reads a cursor of a list sorted as opts sorts it. A cursor of
+// a list sorted another way would skip or repeat documents, so it is invalid.
+func decodeCursor(s string, opts ListOptions) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	doc := bson.Raw(raw)
+	if err := doc.Validate(); err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	sortField, ok := doc.Lookup("s").StringValueOK()
+	if !ok || sortField != opts.sortField() {
+		return cursor{}, ErrInvalidCursor
+	}
+	desc, ok := doc.Lookup("d").BooleanOK()
+	if !ok || desc != opts.Desc {
+		return cursor{}, ErrInvalidCursor
+	}
+	value, err := doc.LookupErr("v")
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	id, ok := doc.Lookup("id").ObjectIDOK()
+	if !ok {
+		return cursor{}, ErrInvalidCursor
+	}
+	return cursor{Sort: sortField, Desc: desc, Value: value, ID: id}, nil
+}
+
+// cursorAfter is the cursor of a list sorted as opts sorts it whose page
+// ends with last
+func cursorAfter(opts ListOptions, last bson.Raw) (string, error) {
+	return encodeCursor(cursor{
+		Sort:  opts.sortField(),
+		Desc:  opts.Desc,
+		Value: last.Lookup(opts.sortField()),
+		ID:    last.Lookup("_id").ObjectID(),
+	})
+}
+
+// mongoFilter selects the documents that sort strictly after the cursor
+func (c cursor) mongoFilter(sortField string, desc bool) bson.M {
+	op := "$gt"
+	if desc {
+		op = "$lt"
+	}
+	if sortField == "_id" {
+		return bson.M{"_id": bson.M{op: c.ID}}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{sortField: bson.M{op: c.Value}},
+		bson.M{sortField: c.Value, "_id": bson.M{op: c.ID}},
+	}}
+}
+
+// FieldType describes how a query string value is parsed for a field
+type FieldType int
+
+const (
+	StringField FieldType = iota
+	IntField
+	FloatField
+	BoolField
+	TimeField
+	ObjectIDField
+)
+
+// Field describes a filterable field; Sortable fields are backed by an index
+type Field struct {
+	Type     FieldType
+	Sortable bool
+}
+
+// Fields maps bson field names to their description
+type Fields map[string]Field
+
+// Parse converts a raw query string value for the named field
+func (f Fields) Parse(name, raw string) (interface{}, error) {
+	field, ok := f[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", name)
+	}
+
+	switch field.Type {
+	case IntField:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an integer", name)
+		}
+		return n, nil
+	case FloatField:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", name)
+		}
+		return n, nil
+	case BoolField:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", name)
+		}
+		return b, nil
+	case TimeField:
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+		}
+		return t, nil
+	case ObjectIDField:
+		id, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a valid ID", name)
+		}
+		return id, nil
+	default:
+		return raw, nil
+	}
+}
+
+// ParseFilter builds a filter from a field, an operator name (empty means eq)
+// and a raw value. The in operator takes a comma-separated list.
+func (f Fields) ParseFilter(name, op, raw string) (Filter, error) {
+	if op == "" {
+		op = string(OpEq)
+	}
+	switch FilterOp(op) {
+	case OpEq, OpNe, OpGt, OpGte, OpLt, OpLte:
+		value, err := f.Parse(name, raw)
+		if err != nil {
+			return Filter{}, err
+		}
+		return Filter{Field: name, Op: FilterOp(op), Value: value}, nil
+	case OpIn:
+		var values []interface{}
+		for _, part := range strings.Split(raw, ",") {
+			value, err := f.Parse(name, part)
+			if err != nil {
+				return Filter{}, err
+			}
+			values = append(values, value)
+		}
+		return Filter{Field: name, Op: OpIn, Value: values}, nil
+	default:
+		return Filter{}, fmt.Errorf("unknown operator %q for %s", op, name)
+	}
+}
+
+var (
+	UserFields = Fields{
+		"_id":         {Type: ObjectIDField, Sortable: true},
+		"name":        {Type: StringField, Sortable: true},
+		"color_code":  {Type: StringField},
+		"createdDate": {Type: TimeField, Sortable: true},
+		"updatedDate": {Type: TimeField, Sortable: true},
+	}
+
+	EmojiFields = Fields{
+		"_id":          {Type: ObjectIDField, Sortable: true},
+		"emoji":        {Type: StringField},
+		"emoji_name":   {Type: StringField, Sortable: true},
+		"emoji_index":  {Type: IntField, Sortable: true},
+		"created_date": {Type: TimeField, Sortable: true},
+	}
+
+	ContactFields = Fields{
+		"_id":        {Type: ObjectIDField, Sortable: true},
+		"name":       {Type: StringField, Sortable: true},
+		"job_title":  {Type: StringField},
+		"email":      {Type: StringField, Sortable: true},
+		"cell_phone": {Type: StringField},
+		"work_phone": {Type: StringField},
+		// the E.164 forms are indexed for caller ID lookups
+		"cell_phone_e164": {Type: StringField},
+		"work_phone_e164": {Type: StringField},
+		"city":            {Type: StringField, Sortable: true},
+		"state":           {Type: StringField, Sortable: true},
+		"zip":             {Type: StringField},
+		"person_index":    {Type: IntField, Sortable: true},
+		"created_date":    {Type: TimeField, Sortable: true},
+		"updated_date":    {Type: TimeField, Sortable: true},
+		"business_id":     {Type: ObjectIDField},
+		"user_id":         {Type: ObjectIDField},
+	}
+
+	BusinessFields = Fields{
+		"_id":                {Type: ObjectIDField, Sortable: true},
+		"business_name":      {Type: StringField, Sortable: true},
+		"website":            {Type: StringField},
+		"status":             {Type: IntField, Sortable: true},
+		"auto_followup":      {Type: BoolField},
+		"last_viewed_date":   {Type: TimeField, Sortable: true},
+		"last_followup_date": {Type: TimeField, Sortable: true},
+		"next_followup_date": {Type: TimeField, Sortable: true},
+		"created_date":       {Type: TimeField, Sortable: true},
+		"emoji_id":           {Type: ObjectIDField},
+		"user_id":            {Type: ObjectIDField},
+		"contact_id":         {Type: ObjectIDField},
+	}
+
+	CallFields = Fields{
+		"_id":          {Type: ObjectIDField, Sortable: true},
+		"direction":    {Type: StringField},
+		"outcome":      {Type: StringField},
+		"start_time":   {Type: TimeField, Sortable: true},
+		"end_time":     {Type: TimeField, Sortable: true},
+		"duration":     {Type: IntField, Sortable: true},
+		"created_date": {Type: TimeField, Sortable: true},
+		"business_id":  {Type: ObjectIDField},
+		"contact_id":   {Type: ObjectIDField},
+		"user_id":      {Type: ObjectIDField},
+	}
+
+	ActivityFields = Fields{
+		"_id":          {Type: ObjectIDField, Sortable: true},
+		"type":         {Type: StringField},
+		"created_date": {Type: TimeField, Sortable: true},
+		"business_id":  {Type: ObjectIDField},
+		"contact_id":   {Type: ObjectIDField},
+		"user_id":      {Type: ObjectIDField},
+	}
+
+	FollowupFields = Fields{
+		"_id":          {Type: ObjectIDField, Sortable: true},
+		"due_date":     {Type: TimeField, Sortable: true},
+		"created_date": {Type: TimeField, Sortable: true},
+		"business_id":  {Type: ObjectIDField},
+		"user_id":      {Type: ObjectIDField},
+	}
+
+	TrashFields = Fields{
+		"_id":         {Type: ObjectIDField, Sortable: true},
+		"resource":    {Type: StringField},
+		"title":       {Type: StringField, Sortable: true},
+		"deleted_at":  {Type: TimeField, Sortable: true},
+		"document_id": {Type: ObjectIDField},
+	}
+
+	VersionFields = Fields{
+		"_id":          {Type: ObjectIDField, Sortable: true},
+		"version":      {Type: IntField, Sortable: true},
+		"action":       {Type: StringField},
+		"created_date": {Type: TimeField, Sortable: true},
+		"user_id":      {Type: ObjectIDField},
+	}
+
+	WebhookFields = Fields{
+		"_id":          {Type: ObjectIDField, Sortable: true},
+		"url":          {Type: StringField, Sortable: true},
+		"active":       {Type: BoolField},
+		"created_date": {Type: TimeField, Sortable: true},
+	}
+
+	EventFields = Fields{
+		"_id":          {Type: ObjectIDField, Sortable: true},
+		"type":         {Type: StringField},
+		"created_date": {Type: TimeField, Sortable: true},
+		"dispatched":   {Type: BoolField},
+	}
+
+	DeliveryFields = Fields{
+		"_id":          {Type: ObjectIDField, Sortable: true},
+		"event_type":   {Type: StringField},
+		"status":       {Type: StringField},
+		"attempts":     {Type: IntField, Sortable: true},
+		"next_attempt": {Type: TimeField, Sortable: true},
+		"created_date": {Type: TimeField, Sortable: true},
+		"webhook_id":   {Type: ObjectIDField},
+		"event_id":     {Type: ObjectIDField},
+	}
+
+	TransitionFields = Fields{
+		"_id":          {Type: ObjectIDField, Sortable: true},
+		"from_status":  {Type: IntField},
+		"to_status":    {Type: IntField},
+		"created_date": {Type: TimeField, Sortable: true},
+		"business_id":  {Type: ObjectIDField},
+		"user_id":      {Type: ObjectIDField},
+	}
+)
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/models"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	opts := ListOptions{Sort: "emoji_name", Desc: true}
+	id := primitive.NewObjectID()
+	last, err := bson.Marshal(bson.M{"_id": id, "emoji_name": "smile"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := cursorAfter(opts, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := decodeCursor(encoded, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Sort != "emoji_name" || !c.Desc || c.ID != id || c.Value.StringValue() != "smile" {
+		t.Errorf("decoded %+v", c)
+	}
+}
+
+// TestDecodeCursorRejects checks a cursor is refused when it is malformed or
+// belongs to a list sorted another way
+func TestDecodeCursorRejects(t *testing.T) {
+	opts := ListOptions{Sort: "emoji_name"}
+	valid, err := encodeCursor(cursor{Sort: "emoji_name", Value: bsonValue(t, "smile"), ID: primitive.NewObjectID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeCursor(valid, opts); err != nil {
+		t.Fatalf("valid cursor: %v", err)
+	}
+
+	encode := func(doc bson.D) string {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	tests := map[string]struct {
+		cursor string
+		opts   ListOptions
+	}{
+		"not base64":         {"!!!", opts},
+		"not bson":           {base64.RawURLEncoding.EncodeToString([]byte("hello, world")), opts},
+		"other sort field":   {valid, ListOptions{Sort: "emoji_index"}},
+		"other direction":    {valid, ListOptions{Sort: "emoji_name", Desc: true}},
+		"default sort":       {valid, ListOptions{}},
+		"missing value":      {encode(bson.D{{Key: "s", Value: "emoji_name"}, {Key: "d", Value: false}, {Key: "id", Value: primitive.NewObjectID()}}), opts},
+		"missing id":         {encode(bson.D{{Key: "s", Value: "emoji_name"}, {Key: "d", Value: false}, {Key: "v", Value: "smile"}}), opts},
+		"id is not an id":    {encode(bson.D{{Key: "s", Value: "emoji_name"}, {Key: "d", Value: false}, {Key: "v", Value: "smile"}, {Key: "id", Value: "abc"}}), opts},
+		"direction not bool": {encode(bson.D{{Key: "s", Value: "emoji_name"}, {Key: "d", Value: 0}, {Key: "v", Value: "smile"}, {Key: "id", Value: primitive.NewObjectID()}}), opts},
+	}
+	for name, tt := range tests {
+		if _, err := decodeCursor(tt.cursor, tt.opts); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: err = %v, want ErrInvalidCursor", name, err)
+		}
+	}
+}
+
+func bsonValue(t *testing.T, v interface{}) bson.RawValue {
+	t.Helper()
+	raw, err := bson.Marshal(bson.M{"v": v})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bson.Raw(raw).Lookup("v")
+}
+
+// TestListPages walks a list sorted on a field with ties page by page and
+// checks every document comes exactly once, in order
+func TestListPages(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryEmojiRepository()
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		emoji := models.Emoji{ID: primitive.NewObjectID(), Emoji_Name: string(rune('a' + i%3)), Emoji_Index: i, Created_Date: created}
+		if err := repo.Insert(ctx, emoji); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, desc := range []bool{false, true} {
+		opts := ListOptions{Sort: "emoji_name", Desc: desc, Limit: 3, CountTotal: true}
+		var names []string
+		seen := make(map[primitive.ObjectID]bool)
+		for pages := 0; ; pages++ {
+			if pages > 4 {
+				t.Fatal("too many pages")
+			}
+			page, err := repo.List(ctx, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// only the first page asks for the total
+			want := int64(0)
+			if pages == 0 {
+				want = 10
+			}
+			if page.Total != want {
+				t.Errorf("page %d: total = %d, want %d", pages, page.Total, want)
+			}
+			for _, emoji := range page.Items {
+				if seen[emoji.ID] {
+					t.Errorf("emoji %d listed twice", emoji.Emoji_Index)
+				}
+				seen[emoji.ID] = true
+				names = append(names, emoji.Emoji_Name)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			opts.Cursor, opts.CountTotal = page.NextCursor, false
+		}
+		if len(seen) != 10 {
+			t.Errorf("desc %v: listed %d emojis, want 10", desc, len(seen))
+		}
+		for i := 1; i < len(names); i++ {
+			if (names[i] < names[i-1]) != desc && names[i] != names[i-1] {
+				t.Errorf("desc %v: names out of order: %v", desc, names)
+				break
+			}
+		}
+	}
+}
+
+// TestMongoFilterKeepsRepeatedFilters checks a second filter on the same
+// field and operator narrows the query instead of replacing the first
+func TestMongoFilterKeepsRepeatedFilters(t *testing.T) {
+	opts := ListOptions{Filters: []Filter{
+		{Field: "status", Op: OpEq, Value: 3},
+		{Field: "status", Op: OpEq, Value: 4},
+		{Field: "status", Op: OpNe, Value: 5},
+	}}
+	got := opts.mongoFilter()
+	want := bson.M{
+		"status": bson.M{"$eq": 3, "$ne": 5},
+		"$and":   bson.A{bson.M{"status": bson.M{"$eq": 4}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mongoFilter = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,727 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/integrity"
+	"usermanagement/models"
+)
+
+// memoryCollection implements the operations shared by every in-memory repository.
+// Documents are kept in insertion order so FindAll behaves like a natural-order Mongo scan.
+// Documents in the trash stay stored but every read skips them.
+type memoryCollection[T any] struct {
+	mu      sync.RWMutex
+	docs    map[primitive.ObjectID]T
+	order   []primitive.ObjectID
+	trashed map[primitive.ObjectID]bool
+	id      func(T) primitive.ObjectID
+}
+
+func newMemoryCollection[T any](id func(T) primitive.ObjectID) *memoryCollection[T] {
+	return &memoryCollection[T]{
+		docs:    make(map[primitive.ObjectID]T),
+		trashed: make(map[primitive.ObjectID]bool),
+		id:      id,
+	}
+}
+
+// live returns the IDs of the documents outside the trash, in insertion order.
+// The caller holds the lock.
+func (m *memoryCollection[T]) live() []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(m.order))
+	for _, id := range m.order {
+		if !m.trashed[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (m *memoryCollection[T]) FindAll(ctx context.Context) ([]T, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var docs []T
+	for _, id := range m.live() {
+		docs = append(docs, m.docs[id])
+	}
+	return docs, nil
+}
+
+func (m *memoryCollection[T]) List(ctx context.Context, opts ListOptions) (Page[T], error) {
+	var page Page[T]
+
+	type entry struct {
+		doc T
+		raw bson.Raw
+	}
+
+	m.mu.RLock()
+	var entries []entry
+	for _, id := range m.live() {
+		doc := m.docs[id]
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			m.mu.RUnlock()
+			return page, err
+		}
+		entries = append(entries, entry{doc: doc, raw: raw})
+	}
+	m.mu.RUnlock()
+
+	matched := entries[:0]
+	for _, e := range entries {
+		keep := true
+		for _, f := range opts.Filters {
+			ok, err := f.matches(e.raw)
+			if err != nil {
+				return page, err
+			}
+			if !ok {
+				keep = false
+				break
+			}
+		}
+		if keep && len(opts.AnyOf) > 0 {
+			keep = false
+			for _, f := range opts.AnyOf {
+				ok, err := f.matches(e.raw)
+				if err != nil {
+					return page, err
+				}
+				if ok {
+					keep = true
+					break
+				}
+			}
+		}
+		if keep {
+			matched = append(matched, e)
+		}
+	}
+	if opts.CountTotal {
+		page.Total = int64(len(matched))
+	}
+
+	sortField := opts.sortField()
+	// position orders a document relative to another sort value and ID, honouring the direction
+	position := func(raw bson.Raw, value bson.RawValue, id primitive.ObjectID) int {
+		cmp := compareValues(raw.Lookup(sortField), value)
+		if cmp == 0 {
+			own := raw.Lookup("_id").ObjectID()
+			cmp = bytes.Compare(own[:], id[:])
+		}
+		if opts.Desc {
+			return -cmp
+		}
+		return cmp
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		other := matched[j].raw
+		return position(matched[i].raw, other.Lookup(sortField), other.Lookup("_id").ObjectID()) < 0
+	})
+
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor, opts)
+		if err != nil {
+			return page, err
+		}
+		start := len(matched)
+		for i, e := range matched {
+			if position(e.raw, c.Value, c.ID) > 0 {
+				start = i
+				break
+			}
+		}
+		matched = matched[start:]
+	}
+
+	limit := opts.limit()
+	if len(matched) > limit {
+		last := matched[limit-1].raw
+		cursor, err := cursorAfter(opts, last)
+		if err != nil {
+			return page, err
+		}
+		page.NextCursor = cursor
+		matched = matched[:limit]
+	}
+	for _, e := range matched {
+		page.Items = append(page.Items, e.doc)
+	}
+	return page, nil
+}
+
+// Each calls fn for every document matching the filters in list order,
+// ignoring the limit and cursor. It stops at the first error fn returns.
+func (m *memoryCollection[T]) Each(ctx context.Context, opts ListOptions, fn func(T) error) error {
+	opts.Limit = MaxListLimit
+	opts.Cursor = ""
+	for {
+		page, err := m.List(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, doc := range page.Items {
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+func (m *memoryCollection[T]) FindByID(ctx context.Context, id primitive.ObjectID) (T, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	doc, ok := m.docs[id]
+	if !ok || m.trashed[id] {
+		var zero T
+		return zero, ErrNotFound
+	}
+	return doc, nil
+}
+
+func (m *memoryCollection[T]) Exists(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.docs[id]
+	return ok && !m.trashed[id], nil
+}
+
+func (m *memoryCollection[T]) Insert(ctx context.Context, doc T) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.id(doc)
+	if _, ok := m.docs[id]; ok {
+		return fmt.Errorf("duplicate key: _id %s already exists", id.Hex())
+	}
+	m.docs[id] = untrashed(doc)
+	m.order = append(m.order, id)
+	return nil
+}
+
+// Delete removes a document for good, bypassing the trash
+func (m *memoryCollection[T]) Delete(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.docs[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.docs, id)
+	delete(m.trashed, id)
+	for i, existing := range m.order {
+		if existing == id {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// update applies fn to the stored document with the given ID, unless it is in the trash
+func (m *memoryCollection[T]) update(id primitive.ObjectID, fn func(doc *T)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	doc, ok := m.docs[id]
+	if !ok || m.trashed[id] {
+		return ErrNotFound
+	}
+	m.apply(id, doc, fn)
+	return nil
+}
+
+// updateAt is update for a document that must still be at revision,
+// returning ErrRevisionConflict when it has moved on. It returns the
+// document as stored.
+func (m *memoryCollection[T]) updateAt(id primitive.ObjectID, revision int64, fn func(doc *T)) (T, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	doc, ok := m.docs[id]
+	if !ok || m.trashed[id] {
+		var zero T
+		return zero, ErrNotFound
+	}
+	if current := revisionOf(&doc); current != nil && *current != revision {
+		var zero T
+		return zero, ErrRevisionConflict
+	}
+	return m.apply(id, doc, fn), nil
+}
+
+// apply stores doc changed by fn at the next revision and returns it as
+// stored. The caller holds the lock.
+func (m *memoryCollection[T]) apply(id primitive.ObjectID, doc T, fn func(doc *T)) T {
+	var revision int64
+	if current := revisionOf(&doc); current != nil {
+		revision = *current
+	}
+	fn(&doc)
+	if next := revisionOf(&doc); next != nil {
+		*next = revision + 1
+	}
+	m.docs[id] = untrashed(doc)
+	return m.docs[id]
+}
+
+// updateWhere applies fn to every stored document matching match, returning
+// the documents it changed. Documents in the trash are included, so they
+// are still consistent when restored.
+func (m *memoryCollection[T]) updateWhere(match func(T) bool, fn func(doc *T)) []T {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changed []T
+	for _, id := range m.order {
+		doc := m.docs[id]
+		if !match(doc) {
+			continue
+		}
+		fn(&doc)
+		if revision := revisionOf(&doc); revision != nil {
+			*revision++
+		}
+		m.docs[id] = doc
+		changed = append(changed, doc)
+	}
+	return changed
+}
+
+// containsID reports whether ids holds id
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Count counts every document, including those in the trash
+func (m *memoryCollection[T]) Count(ctx context.Context) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return int64(len(m.docs)), nil
+}
+
+// MemoryUserRepository keeps users in memory
+type MemoryUserRepository struct {
+	*memoryCollection[models.User]
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{newMemoryCollection(func(u models.User) primitive.ObjectID { return u.ID })}
+}
+
+func (r *MemoryUserRepository) Update(ctx context.Context, user models.User) (models.User, error) {
+	return r.updateAt(user.ID, user.Revision, func(doc *models.User) {
+		user.CalendarSalt = doc.CalendarSalt
+		*doc = user
+	})
+}
+
+func (r *MemoryUserRepository) SetCalendarSalt(ctx context.Context, id primitive.ObjectID, salt string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc, ok := r.docs[id]
+	if !ok || r.trashed[id] {
+		return ErrNotFound
+	}
+	doc.CalendarSalt = salt
+	r.docs[id] = doc
+	return nil
+}
+
+// MemoryEmojiRepository keeps emojis in memory
+type MemoryEmojiRepository struct {
+	*memoryCollection[models.Emoji]
+}
+
+func NewMemoryEmojiRepository() *MemoryEmojiRepository {
+	return &MemoryEmojiRepository{newMemoryCollection(func(e models.Emoji) primitive.ObjectID { return e.ID })}
+}
+
+func (r *MemoryEmojiRepository) Update(ctx context.Context, emoji models.Emoji) (models.Emoji, error) {
+	return r.updateAt(emoji.ID, emoji.Revision, func(doc *models.Emoji) { *doc = emoji })
+}
+
+// MemoryContactRepository keeps contacts in memory
+type MemoryContactRepository struct {
+	*memoryCollection[models.Contact]
+}
+
+func NewMemoryContactRepository() *MemoryContactRepository {
+	return &MemoryContactRepository{newMemoryCollection(func(c models.Contact) primitive.ObjectID { return c.ID })}
+}
+
+func (r *MemoryContactRepository) Near(ctx context.Context, query GeoQuery) ([]models.ContactDistance, error) {
+	contacts, err := r.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return query.nearest(contacts), nil
+}
+
+func (r *MemoryContactRepository) Search(ctx context.Context, userID primitive.ObjectID, query string, limit int) ([]SearchHit[models.Contact], error) {
+	return r.search(ctx, userID, query, limit, ContactSearchWeights)
+}
+
+func (r *MemoryContactRepository) Update(ctx context.Context, contact models.Contact) (models.Contact, error) {
+	return r.updateAt(contact.ID, contact.Revision, func(doc *models.Contact) { *doc = contact })
+}
+
+// MemoryBusinessRepository keeps businesses in memory
+type MemoryBusinessRepository struct {
+	*memoryCollection[models.Business]
+}
+
+func NewMemoryBusinessRepository() *MemoryBusinessRepository {
+	return &MemoryBusinessRepository{newMemoryCollection(func(b models.Business) primitive.ObjectID { return b.ID })}
+}
+
+// Update overwrites every mutable field, leaving the ID and created date untouched
+func (r *MemoryBusinessRepository) Update(ctx context.Context, business models.Business) (models.Business, error) {
+	return r.updateAt(business.ID, business.Revision, func(doc *models.Business) {
+		createdDate := doc.CreatedDate
+		*doc = business
+		doc.CreatedDate = createdDate
+	})
+}
+
+func (r *MemoryBusinessRepository) Search(ctx context.Context, userID primitive.ObjectID, query string, limit int) ([]SearchHit[models.Business], error) {
+	return r.search(ctx, userID, query, limit, BusinessSearchWeights)
+}
+
+func (r *MemoryBusinessRepository) ReassignContact(ctx context.Context, from []primitive.ObjectID, to primitive.ObjectID) ([]models.Business, error) {
+	return r.updateWhere(
+		func(b models.Business) bool { return containsID(from, b.ContactID) },
+		func(doc *models.Business) { doc.ContactID = to },
+	), nil
+}
+
+func (r *MemoryBusinessRepository) RecordFollowup(ctx context.Context, id primitive.ObjectID, due, last, next time.Time) (models.Business, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc, ok := r.docs[id]
+	if !ok || r.trashed[id] || !doc.NextFollowupDate.Equal(due) {
+		return models.Business{}, ErrNotFound
+	}
+	return r.apply(id, doc, func(doc *models.Business) {
+		doc.LastFollowupDate = last
+		doc.NextFollowupDate = next
+	}), nil
+}
+
+func (r *MemoryBusinessRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, from, to int) (models.Business, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc, ok := r.docs[id]
+	if !ok || r.trashed[id] || doc.Status != from {
+		return models.Business{}, ErrNotFound
+	}
+	return r.apply(id, doc, func(doc *models.Business) { doc.Status = to }), nil
+}
+
+func (r *MemoryBusinessRepository) TouchLastFollowup(ctx context.Context, id primitive.ObjectID, at time.Time) (models.Business, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc, ok := r.docs[id]
+	if !ok || r.trashed[id] {
+		return models.Business{}, false, ErrNotFound
+	}
+	// only a later date changes the business, and so its revision
+	if !at.After(doc.LastFollowupDate) {
+		return doc, false, nil
+	}
+	return r.apply(id, doc, func(doc *models.Business) { doc.LastFollowupDate = at }), true, nil
+}
+
+// MemoryCallRepository keeps calls in memory
+type MemoryCallRepository struct {
+	*memoryCollection[models.Call]
+}
+
+func NewMemoryCallRepository() *MemoryCallRepository {
+	return &MemoryCallRepository{newMemoryCollection(func(c models.Call) primitive.ObjectID { return c.ID })}
+}
+
+// Update overwrites every mutable field, leaving the ID and created date untouched
+func (r *MemoryCallRepository) Update(ctx context.Context, call models.Call) (models.Call, error) {
+	return r.updateAt(call.ID, call.Revision, func(doc *models.Call) {
+		createdDate := doc.CreatedDate
+		*doc = call
+		doc.CreatedDate = createdDate
+	})
+}
+
+func (r *MemoryCallRepository) ReassignContact(ctx context.Context, from []primitive.ObjectID, to primitive.ObjectID) (int64, error) {
+	changed := r.updateWhere(
+		func(c models.Call) bool { return containsID(from, c.ContactID) },
+		func(doc *models.Call) { doc.ContactID = to },
+	)
+	return int64(len(changed)), nil
+}
+
+// MemoryActivityRepository keeps the activity feed in memory
+type MemoryActivityRepository struct {
+	*memoryCollection[models.Activity]
+}
+
+func NewMemoryActivityRepository() *MemoryActivityRepository {
+	return &MemoryActivityRepository{newMemoryCollection(func(a models.Activity) primitive.ObjectID { return a.ID })}
+}
+
+// MemoryFollowupRepository keeps follow-up tasks in memory
+type MemoryFollowupRepository struct {
+	*memoryCollection[models.Followup]
+}
+
+func NewMemoryFollowupRepository() *MemoryFollowupRepository {
+	return &MemoryFollowupRepository{newMemoryCollection(func(f models.Followup) primitive.ObjectID { return f.ID })}
+}
+
+// MemoryTransitionRepository keeps business status changes in memory
+type MemoryTransitionRepository struct {
+	*memoryCollection[models.StatusTransition]
+}
+
+func NewMemoryTransitionRepository() *MemoryTransitionRepository {
+	return &MemoryTransitionRepository{newMemoryCollection(func(t models.StatusTransition) primitive.ObjectID { return t.ID })}
+}
+
+// MemoryImportJobRepository keeps CSV import jobs in memory
+type MemoryImportJobRepository struct {
+	*memoryCollection[models.ImportJob]
+}
+
+func NewMemoryImportJobRepository() *MemoryImportJobRepository {
+	return &MemoryImportJobRepository{newMemoryCollection(func(j models.ImportJob) primitive.ObjectID { return j.ID })}
+}
+
+// Update saves the job's progress, leaving its identity and settings untouched
+func (r *MemoryImportJobRepository) Update(ctx context.Context, job models.ImportJob) error {
+	return r.update(job.ID, func(doc *models.ImportJob) {
+		job.Resource = doc.Resource
+		job.DryRun = doc.DryRun
+		job.Background = doc.Background
+		job.CreatedDate = doc.CreatedDate
+		job.Errors = append(make([]models.ImportRowError, 0, len(job.Errors)), job.Errors...)
+		*doc = job
+	})
+}
+
+func (r *MemoryImportJobRepository) FailStale(ctx context.Context, cutoff time.Time, reason string) (int64, error) {
+	now := time.Now()
+	failed := r.updateWhere(
+		func(job models.ImportJob) bool {
+			return job.Status == models.ImportRunning && job.UpdatedDate.Before(cutoff)
+		},
+		func(doc *models.ImportJob) {
+			doc.Status = models.ImportFailed
+			doc.Message = reason
+			doc.UpdatedDate = now
+			doc.FinishedDate = now
+		},
+	)
+	return int64(len(failed)), nil
+}
+
+// MemoryTrashRepository keeps trash entries in memory
+type MemoryTrashRepository struct {
+	*memoryCollection[models.TrashEntry]
+}
+
+func NewMemoryTrashRepository() *MemoryTrashRepository {
+	return &MemoryTrashRepository{newMemoryCollection(func(e models.TrashEntry) primitive.ObjectID { return e.ID })}
+}
+
+// MemoryWebhookRepository keeps webhook subscriptions in memory
+type MemoryWebhookRepository struct {
+	*memoryCollection[models.Webhook]
+}
+
+func NewMemoryWebhookRepository() *MemoryWebhookRepository {
+	return &MemoryWebhookRepository{newMemoryCollection(func(w models.Webhook) primitive.ObjectID { return w.ID })}
+}
+
+// Update saves the webhook's settings as long as it is still at its revision,
+// leaving its creation date untouched
+func (r *MemoryWebhookRepository) Update(ctx context.Context, webhook models.Webhook) error {
+	_, err := r.updateAt(webhook.ID, webhook.Revision, func(doc *models.Webhook) {
+		webhook.CreatedDate = doc.CreatedDate
+		webhook.Events = append([]string(nil), webhook.Events...)
+		*doc = webhook
+	})
+	return err
+}
+
+// MemoryOutboxRepository keeps the webhook outbox in memory
+type MemoryOutboxRepository struct {
+	*memoryCollection[models.Event]
+}
+
+func NewMemoryOutboxRepository() *MemoryOutboxRepository {
+	return &MemoryOutboxRepository{newMemoryCollection(func(e models.Event) primitive.ObjectID { return e.ID })}
+}
+
+func (r *MemoryOutboxRepository) MarkDispatched(ctx context.Context, id primitive.ObjectID) error {
+	return r.update(id, func(doc *models.Event) { doc.Dispatched = true })
+}
+
+// MemoryDeliveryRepository keeps webhook deliveries in memory
+type MemoryDeliveryRepository struct {
+	*memoryCollection[models.Delivery]
+}
+
+func NewMemoryDeliveryRepository() *MemoryDeliveryRepository {
+	return &MemoryDeliveryRepository{newMemoryCollection(func(d models.Delivery) primitive.ObjectID { return d.ID })}
+}
+
+func (r *MemoryDeliveryRepository) Queue(ctx context.Context, delivery models.Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.docs {
+		if existing.EventID == delivery.EventID && existing.WebhookID == delivery.WebhookID {
+			return nil
+		}
+	}
+	r.docs[delivery.ID] = delivery
+	r.order = append(r.order, delivery.ID)
+	return nil
+}
+
+// Update saves the delivery's progress, leaving its identity and payload untouched
+func (r *MemoryDeliveryRepository) Update(ctx context.Context, delivery models.Delivery) error {
+	return r.update(delivery.ID, func(doc *models.Delivery) {
+		doc.Status = delivery.Status
+		doc.Attempts = delivery.Attempts
+		doc.NextAttempt = delivery.NextAttempt
+		doc.Log = append(make([]models.DeliveryAttempt, 0, len(delivery.Log)), delivery.Log...)
+		doc.UpdatedDate = delivery.UpdatedDate
+	})
+}
+
+// MemoryChangeStream stands in for change streams in memory, where there are
+// none, so the live update stream polls instead
+type MemoryChangeStream struct{}
+
+func (MemoryChangeStream) Open(ctx context.Context, userID primitive.ObjectID, resumeAfter bson.Raw) (ChangeCursor, error) {
+	return nil, ErrNoChangeStreams
+}
+
+// MemoryStreamTokenRepository keeps live update stream tokens in memory
+type MemoryStreamTokenRepository struct {
+	*memoryCollection[models.StreamToken]
+}
+
+func NewMemoryStreamTokenRepository() *MemoryStreamTokenRepository {
+	return &MemoryStreamTokenRepository{newMemoryCollection(func(t models.StreamToken) primitive.ObjectID { return t.ID })}
+}
+
+// MemoryLocker implements Locker within a single process
+type MemoryLocker struct {
+	mu    sync.Mutex
+	locks map[string]memoryLock
+}
+
+type memoryLock struct {
+	owner     string
+	expiresAt time.Time
+}
+
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{locks: make(map[string]memoryLock)}
+}
+
+func (l *MemoryLocker) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if held, ok := l.locks[name]; ok && held.owner != owner && held.expiresAt.After(now) {
+		return false, nil
+	}
+	l.locks[name] = memoryLock{owner: owner, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (l *MemoryLocker) Release(ctx context.Context, name, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if held, ok := l.locks[name]; ok && held.owner == owner {
+		delete(l.locks, name)
+	}
+	return nil
+}
+
+// NewMemoryRepositories builds empty in-memory repositories, for tests and local development
+func NewMemoryRepositories() *Repositories {
+	users := NewMemoryUserRepository()
+	emojis := NewMemoryEmojiRepository()
+	contacts := NewMemoryContactRepository()
+	businesses := NewMemoryBusinessRepository()
+	calls := NewMemoryCallRepository()
+	trash := NewMemoryTrashRepository()
+	store := memoryStore{
+		collections: map[string]memoryTrashable{
+			integrity.Users:      users,
+			integrity.Emojis:     emojis,
+			integrity.Contacts:   contacts,
+			integrity.Businesses: businesses,
+			integrity.Calls:      calls,
+		},
+		trash: trash,
+	}
+	deletes := &MemoryDeleter{store: store}
+	outbox := NewMemoryOutboxRepository()
+	transitions := NewMemoryTransitionRepository()
+	return &Repositories{
+		Users:       users,
+		Emojis:      emojis,
+		Contacts:    contacts,
+		Businesses:  businesses,
+		Calls:       calls,
+		Activities:  NewMemoryActivityRepository(),
+		Followups:   NewMemoryFollowupRepository(),
+		Transitions: transitions,
+		Imports:     NewMemoryImportJobRepository(),
+		Locks:       NewMemoryLocker(),
+		Trash:       trash,
+		Deletes:     deletes,
+		Tx:          deletes,
+		History: &history{
+			docs:     store,
+			versions: newMemoryCollection(func(v models.Version) primitive.ObjectID { return v.ID }),
+		},
+		Bulk:         &MemoryBulkWriter{deletes: deletes, outbox: outbox, transitions: transitions},
+		Webhooks:     NewMemoryWebhookRepository(),
+		Outbox:       outbox,
+		Deliveries:   NewMemoryDeliveryRepository(),
+		Changes:      MemoryChangeStream{},
+		StreamTokens: NewMemoryStreamTokenRepository(),
+	}
+}
@@ -0,0 +1,255 @@
+package data
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"usermanagement/config"
+)
+
+// mongoCollection implements the operations shared by every Mongo-backed repository
+type mongoCollection[T any] struct {
+	coll *mongo.Collection
+	// trashable collections keep deleted documents in the trash, which reads skip
+	trashable bool
+}
+
+// live narrows filter to the documents outside the trash
+func (m mongoCollection[T]) live(filter bson.M) bson.M {
+	if m.trashable {
+		filter["deleted_at"] = nil
+	}
+	return filter
+}
+
+func (m mongoCollection[T]) FindAll(ctx context.Context) ([]T, error) {
+	cur, err := m.coll.Find(ctx, m.live(bson.M{}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var docs []T
+	for cur.Next(ctx) {
+		var doc T
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func (m mongoCollection[T]) List(ctx context.Context, opts ListOptions) (Page[T], error) {
+	var page Page[T]
+
+	query := m.live(opts.mongoFilter())
+	if opts.CountTotal {
+		total, err := m.coll.CountDocuments(ctx, query)
+		if err != nil {
+			return page, err
+		}
+		page.Total = total
+	}
+
+	sortField := opts.sortField()
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor, opts)
+		if err != nil {
+			return page, err
+		}
+		query = bson.M{"$and": bson.A{query, c.mongoFilter(sortField, opts.Desc)}}
+	}
+
+	limit := opts.limit()
+	findOptions := options.Find().SetSort(opts.mongoSort()).SetLimit(int64(limit) + 1)
+	cur, err := m.coll.Find(ctx, query, findOptions)
+	if err != nil {
+		return page, err
+	}
+	defer cur.Close(ctx)
+
+	var last bson.Raw
+	for cur.Next(ctx) {
+		if len(page.Items) == limit {
+			page.NextCursor, err = cursorAfter(opts, last)
+			if err != nil {
+				return page, err
+			}
+			break
+		}
+		var doc T
+		if err := cur.Decode(&doc); err != nil {
+			return page, err
+		}
+		page.Items = append(page.Items, doc)
+		last = append(bson.Raw(nil), cur.Current...)
+	}
+	if err := cur.Err(); err != nil {
+		return page, err
+	}
+	return page, nil
+}
+
+// Each streams every document matching the filters to fn in list order,
+// ignoring the limit and cursor. It stops at the first error fn returns.
+func (m mongoCollection[T]) Each(ctx context.Context, opts ListOptions, fn func(T) error) error {
+	cur, err := m.coll.Find(ctx, m.live(opts.mongoFilter()), options.Find().SetSort(opts.mongoSort()))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var doc T
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (m mongoCollection[T]) FindByID(ctx context.Context, id primitive.ObjectID) (T, error) {
+	var doc T
+	err := m.coll.FindOne(ctx, m.live(bson.M{"_id": id})).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return doc, ErrNotFound
+	}
+	return doc, err
+}
+
+func (m mongoCollection[T]) Exists(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	count, err := m.coll.CountDocuments(ctx, m.live(bson.M{"_id": id}))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Count counts every document, including those in the trash
+func (m mongoCollection[T]) Count(ctx context.Context) (int64, error) {
+	return m.coll.CountDocuments(ctx, bson.D{})
+}
+
+func (m mongoCollection[T]) Insert(ctx context.Context, doc T) error {
+	_, err := m.coll.InsertOne(ctx, untrashed(doc))
+	return err
+}
+
+// Delete removes a document for good, bypassing the trash
+func (m mongoCollection[T]) Delete(ctx context.Context, id primitive.ObjectID) error {
+	res, err := m.coll.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// counted reports whether the documents count their changes in a revision
+func (m mongoCollection[T]) counted() bool {
+	var doc T
+	return revisionOf(&doc) != nil
+}
+
+// bump adds moving to the next revision to an update of counted documents
+func (m mongoCollection[T]) bump(update bson.M) bson.M {
+	if m.counted() {
+		update["$inc"] = bson.M{"revision": 1}
+	}
+	return update
+}
+
+// set applies a $set update to the document with the given ID, unless it is in the trash
+func (m mongoCollection[T]) set(ctx context.Context, id primitive.ObjectID, fields interface{}) error {
+	res, err := m.coll.UpdateOne(ctx, m.live(bson.M{"_id": id}), m.bump(bson.M{"$set": fields}))
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// setAt applies update to the document with the given ID as long as it is
+// still at revision, returning ErrRevisionConflict when it has moved on. It
+// returns the document as the update left it.
+func (m mongoCollection[T]) setAt(ctx context.Context, id primitive.ObjectID, revision int64, update bson.M) (T, error) {
+	doc, err := m.updated(ctx, m.live(bson.M{"_id": id, "revision": atRevision(revision)}), update)
+	if err != ErrNotFound {
+		return doc, err
+	}
+	exists, err := m.Exists(ctx, id)
+	if err != nil {
+		return doc, err
+	}
+	if exists {
+		return doc, ErrRevisionConflict
+	}
+	return doc, ErrNotFound
+}
+
+// updated applies update, at the next revision, to the document matching
+// filter and returns the document as the update left it, or ErrNotFound
+// when none matched
+func (m mongoCollection[T]) updated(ctx context.Context, filter bson.M, update bson.M) (T, error) {
+	var doc T
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := m.coll.FindOneAndUpdate(ctx, filter, m.bump(update), opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return doc, ErrNotFound
+	}
+	return doc, err
+}
+
+// reassign points every document whose field holds one of from at to instead,
+// returning how many documents changed. Documents in the trash are reassigned
+// too, so they are still consistent when restored.
+func (m mongoCollection[T]) reassign(ctx context.Context, field string, from []primitive.ObjectID, to primitive.ObjectID) (int64, error) {
+	res, err := m.coll.UpdateMany(ctx, bson.M{field: bson.M{"$in": from}}, m.bump(bson.M{"$set": bson.M{field: to}}))
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// NewMongoRepositories builds Mongo-backed repositories on top of the given database
+func NewMongoRepositories(db *mongo.Database, names config.Collections) *Repositories {
+	deletes := NewMongoDeleter(db, names)
+	outbox := NewMongoOutboxRepository(db.Collection(names.Outbox))
+	transitions := NewMongoTransitionRepository(db.Collection(names.Transitions))
+	return &Repositories{
+		Users:        NewMongoUserRepository(db.Collection(names.Users)),
+		Emojis:       NewMongoEmojiRepository(db.Collection(names.Emojis)),
+		Contacts:     NewMongoContactRepository(db.Collection(names.Contacts)),
+		Businesses:   NewMongoBusinessRepository(db.Collection(names.Businesses)),
+		Calls:        NewMongoCallRepository(db.Collection(names.Calls)),
+		Activities:   NewMongoActivityRepository(db.Collection(names.Activities)),
+		Followups:    NewMongoFollowupRepository(db.Collection(names.Followups)),
+		Transitions:  transitions,
+		Imports:      NewMongoImportJobRepository(db.Collection(names.Imports)),
+		Locks:        NewMongoLocker(db.Collection(names.Locks)),
+		Trash:        NewMongoTrashRepository(db.Collection(names.Trash)),
+		Deletes:      deletes,
+		Tx:           deletes,
+		History:      NewMongoHistory(db, names),
+		Bulk:         NewMongoBulkWriter(deletes, outbox, transitions),
+		Webhooks:     NewMongoWebhookRepository(db.Collection(names.Webhooks)),
+		Outbox:       outbox,
+		Deliveries:   NewMongoDeliveryRepository(db.Collection(names.Deliveries)),
+		Changes:      NewMongoChangeStream(db, names),
+		StreamTokens: NewMongoStreamTokenRepository(db.Collection(names.StreamTokens)),
+	}
+}
@@ -0,0 +1,15 @@
+package data
+
+import (
+	"go.mongodb.org/mongo-driver/mongo"
+	"usermanagement/models"
+)
+
+// MongoActivityRepository stores the activity feed in a Mongo collection
+type MongoActivityRepository struct {
+	mongoCollection[models.Activity]
+}
+
+func NewMongoActivityRepository(coll *mongo.Collection) *MongoActivityRepository {
+	return &MongoActivityRepository{mongoCollection[models.Activity]{coll: coll}}
+}
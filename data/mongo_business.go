@@ -0,0 +1,100 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"usermanagement/models"
+)
+
+// MongoBusinessRepository stores businesses in a Mongo collection
+type MongoBusinessRepository struct {
+	mongoCollection[models.Business]
+}
+
+func NewMongoBusinessRepository(coll *mongo.Collection) *MongoBusinessRepository {
+	return &MongoBusinessRepository{mongoCollection[models.Business]{coll: coll, trashable: true}}
+}
+
+// Update overwrites every mutable field, leaving _id and created_date untouched
+func (r *MongoBusinessRepository) Update(ctx context.Context, business models.Business) (models.Business, error) {
+	return r.setAt(ctx, business.ID, business.Revision, bson.M{"$set": bson.M{
+		"user_id":            business.UserID,
+		"emoji_id":           business.EmojiID,
+		"contact_id":         business.ContactID,
+		"status":             business.Status,
+		"business_name":      business.BusinessName,
+		"business_tagline":   business.BusinessTagline,
+		"website":            business.Website,
+		"auto_followup":      business.AutoFollowup,
+		"last_viewed_date":   business.LastViewedDate,
+		"last_followup_date": business.LastFollowupDate,
+		"next_followup_date": business.NextFollowupDate,
+	}})
+}
+
+func (r *MongoBusinessRepository) Search(ctx context.Context, userID primitive.ObjectID, query string, limit int) ([]SearchHit[models.Business], error) {
+	return r.search(ctx, userID, query, limit)
+}
+
+// ReassignContact updates the businesses one at a time, so each is returned
+// as its own update left it. Businesses in the trash are reassigned too, so
+// they are still consistent when restored.
+func (r *MongoBusinessRepository) ReassignContact(ctx context.Context, from []primitive.ObjectID, to primitive.ObjectID) ([]models.Business, error) {
+	filter := bson.M{"contact_id": bson.M{"$in": from}}
+	cur, err := r.coll.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var ids []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cur.All(ctx, &ids); err != nil {
+		return nil, err
+	}
+
+	var changed []models.Business
+	for _, doc := range ids {
+		business, err := r.updated(ctx, bson.M{"_id": doc.ID, "contact_id": bson.M{"$in": from}}, bson.M{"$set": bson.M{"contact_id": to}})
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return changed, err
+		}
+		changed = append(changed, business)
+	}
+	return changed, nil
+}
+
+func (r *MongoBusinessRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, from, to int) (models.Business, error) {
+	return r.updated(ctx, r.live(bson.M{"_id": id, "status": from}), bson.M{"$set": bson.M{"status": to}})
+}
+
+func (r *MongoBusinessRepository) TouchLastFollowup(ctx context.Context, id primitive.ObjectID, at time.Time) (models.Business, bool, error) {
+	// only a later date changes the business, and so its revision
+	filter := r.live(bson.M{"_id": id, "last_followup_date": bson.M{"$lt": at}})
+	business, err := r.updated(ctx, filter, bson.M{"$set": bson.M{"last_followup_date": at}})
+	if err != ErrNotFound {
+		return business, err == nil, err
+	}
+	exists, err := r.Exists(ctx, id)
+	if err != nil {
+		return business, false, err
+	}
+	if !exists {
+		return business, false, ErrNotFound
+	}
+	return business, false, nil
+}
+
+func (r *MongoBusinessRepository) RecordFollowup(ctx context.Context, id primitive.ObjectID, due, last, next time.Time) (models.Business, error) {
+	filter := r.live(bson.M{"_id": id, "next_followup_date": due})
+	return r.updated(ctx, filter, bson.M{"$set": bson.M{
+		"last_followup_date": last,
+		"next_followup_date": next,
+	}})
+}
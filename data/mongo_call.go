@@ -0,0 +1,40 @@
+package data
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"usermanagement/models"
+)
+
+// MongoCallRepository stores calls in a Mongo collection
+type MongoCallRepository struct {
+	mongoCollection[models.Call]
+}
+
+func NewMongoCallRepository(coll *mongo.Collection) *MongoCallRepository {
+	return &MongoCallRepository{mongoCollection[models.Call]{coll: coll, trashable: true}}
+}
+
+// Update overwrites every mutable field, leaving _id and created_date
+// untouched, and returns the call as stored
+func (r *MongoCallRepository) Update(ctx context.Context, call models.Call) (models.Call, error) {
+	return r.setAt(ctx, call.ID, call.Revision, bson.M{"$set": bson.M{
+		"business_id":  call.BusinessID,
+		"contact_id":   call.ContactID,
+		"user_id":      call.UserID,
+		"direction":    call.Direction,
+		"start_time":   call.StartTime,
+		"end_time":     call.EndTime,
+		"duration":     call.Duration,
+		"outcome":      call.Outcome,
+		"notes":        call.Notes,
+		"updated_date": call.UpdatedDate,
+	}})
+}
+
+func (r *MongoCallRepository) ReassignContact(ctx context.Context, from []primitive.ObjectID, to primitive.ObjectID) (int64, error) {
+	return r.reassign(ctx, "contact_id", from, to)
+}
@@ -0,0 +1,171 @@
+package data
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"usermanagement/models"
+	"usermanagement/phone"
+)
+
+// MongoContactRepository stores contacts in a Mongo collection
+type MongoContactRepository struct {
+	mongoCollection[models.Contact]
+}
+
+func NewMongoContactRepository(coll *mongo.Collection) *MongoContactRepository {
+	return &MongoContactRepository{mongoCollection[models.Contact]{coll: coll, trashable: true}}
+}
+
+func (r *MongoContactRepository) Update(ctx context.Context, contact models.Contact) (models.Contact, error) {
+	fields, err := fieldsOf(untrashed(contact))
+	if err != nil {
+		return models.Contact{}, err
+	}
+	update := bson.M{"$set": fields}
+	if contact.Location == nil {
+		update["$unset"] = bson.M{"location": ""}
+	}
+	return r.setAt(ctx, contact.ID, contact.Revision, update)
+}
+
+func (r *MongoContactRepository) Near(ctx context.Context, query GeoQuery) ([]models.ContactDistance, error) {
+	geoNear := bson.M{
+		"near":          models.NewGeoPoint(query.Lat, query.Lng),
+		"distanceField": "distance",
+		"key":           "location",
+		"spherical":     true,
+	}
+	if query.MaxDistance > 0 {
+		geoNear["maxDistance"] = query.MaxDistance
+	}
+	filter := r.live(bson.M{})
+	if len(query.Within) > 0 {
+		filter["location"] = bson.M{"$geoWithin": bson.M{
+			"$geometry": bson.M{"type": "Polygon", "coordinates": bson.A{query.Within}},
+		}}
+	}
+	geoNear["query"] = filter
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$geoNear", Value: geoNear}},
+		{{Key: "$limit", Value: query.limit()}},
+	}
+	cur, err := r.coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []models.ContactDistance
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *MongoContactRepository) Search(ctx context.Context, userID primitive.ObjectID, query string, limit int) ([]SearchHit[models.Contact], error) {
+	return r.search(ctx, userID, query, limit)
+}
+
+// backfillLocations gives contacts stored before locations existed a GeoJSON
+// point built from their coordinates. Coordinates outside the valid range
+// cannot be indexed, so those contacts are logged and left without a location.
+// The location is derived from the coordinates, so the revision is left as it is.
+func (r *MongoContactRepository) backfillLocations(ctx context.Context) error {
+	missing := bson.M{
+		"location": bson.M{"$exists": false},
+		"$or": bson.A{
+			bson.M{"latitude": bson.M{"$ne": 0}},
+			bson.M{"longitude": bson.M{"$ne": 0}},
+		},
+	}
+	inRange := bson.M{
+		"latitude":  bson.M{"$gte": -90, "$lte": 90},
+		"longitude": bson.M{"$gte": -180, "$lte": 180},
+	}
+
+	cur, err := r.coll.Find(ctx, bson.M{"$and": bson.A{missing, bson.M{"$nor": bson.A{inRange}}}},
+		options.Find().SetProjection(bson.M{"latitude": 1, "longitude": 1}))
+	if err != nil {
+		return err
+	}
+	for cur.Next(ctx) {
+		log.Printf("contact %s: coordinates %v, %v are out of range, not giving it a location",
+			cur.Current.Lookup("_id"), cur.Current.Lookup("latitude"), cur.Current.Lookup("longitude"))
+	}
+	err = cur.Err()
+	cur.Close(ctx)
+	if err != nil {
+		return err
+	}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"location": bson.M{
+				"type":        "Point",
+				"coordinates": bson.A{"$longitude", "$latitude"},
+			},
+		}}},
+	}
+	_, err = r.coll.UpdateMany(ctx, bson.M{"$and": bson.A{missing, inRange}}, update)
+	return err
+}
+
+// backfillPhones stores the E.164 form of numbers saved before it was kept,
+// reading each in its user's region or the default one, which a contact
+// without a user ID also gets. A number that cannot be read gets an empty
+// E.164 form so it is not read again. The E.164 form is derived from the
+// number, so the revision is left as it is.
+func (r *MongoContactRepository) backfillPhones(ctx context.Context, users *mongo.Collection, defaultRegion string) error {
+	regions := make(map[primitive.ObjectID]string)
+	regionOf := func(userID primitive.ObjectID, ok bool) (string, error) {
+		if !ok {
+			return defaultRegion, nil
+		}
+		if region, ok := regions[userID]; ok {
+			return region, nil
+		}
+		var user models.User
+		err := users.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return "", err
+		}
+		region := defaultRegion
+		if user.PhoneRegion != "" {
+			region = user.PhoneRegion
+		}
+		regions[userID] = region
+		return region, nil
+	}
+
+	for field, e164Field := range map[string]string{"cell_phone": "cell_phone_e164", "work_phone": "work_phone_e164"} {
+		filter := bson.M{field: bson.M{"$gt": ""}, e164Field: bson.M{"$exists": false}}
+		cur, err := r.coll.Find(ctx, filter, options.Find().SetProjection(bson.M{"user_id": 1, field: 1}))
+		if err != nil {
+			return err
+		}
+		for cur.Next(ctx) {
+			doc := cur.Current
+			region, err := regionOf(doc.Lookup("user_id").ObjectIDOK())
+			if err != nil {
+				cur.Close(ctx)
+				return err
+			}
+			e164, _ := phone.Parse(doc.Lookup(field).StringValue(), region)
+			if _, err := r.coll.UpdateOne(ctx, bson.M{"_id": doc.Lookup("_id").ObjectID()}, bson.M{"$set": bson.M{e164Field: e164}}); err != nil {
+				cur.Close(ctx)
+				return err
+			}
+		}
+		err = cur.Err()
+		cur.Close(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
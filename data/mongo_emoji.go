@@ -0,0 +1,26 @@
+package data
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"usermanagement/models"
+)
+
+// MongoEmojiRepository stores emojis in a Mongo collection
+type MongoEmojiRepository struct {
+	mongoCollection[models.Emoji]
+}
+
+func NewMongoEmojiRepository(coll *mongo.Collection) *MongoEmojiRepository {
+	return &MongoEmojiRepository{mongoCollection[models.Emoji]{coll: coll, trashable: true}}
+}
+
+func (r *MongoEmojiRepository) Update(ctx context.Context, emoji models.Emoji) (models.Emoji, error) {
+	fields, err := fieldsOf(untrashed(emoji))
+	if err != nil {
+		return models.Emoji{}, err
+	}
+	return r.setAt(ctx, emoji.ID, emoji.Revision, bson.M{"$set": fields})
+}
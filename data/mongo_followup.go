@@ -0,0 +1,15 @@
+package data
+
+import (
+	"go.mongodb.org/mongo-driver/mongo"
+	"usermanagement/models"
+)
+
+// MongoFollowupRepository stores follow-up tasks in a Mongo collection
+type MongoFollowupRepository struct {
+	mongoCollection[models.Followup]
+}
+
+func NewMongoFollowupRepository(coll *mongo.Collection) *MongoFollowupRepository {
+	return &MongoFollowupRepository{mongoCollection[models.Followup]{coll: coll}}
+}
@@ -0,0 +1,50 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"usermanagement/models"
+)
+
+// MongoImportJobRepository stores CSV import jobs in a Mongo collection
+type MongoImportJobRepository struct {
+	mongoCollection[models.ImportJob]
+}
+
+func NewMongoImportJobRepository(coll *mongo.Collection) *MongoImportJobRepository {
+	return &MongoImportJobRepository{mongoCollection[models.ImportJob]{coll: coll}}
+}
+
+// Update saves the job's progress, leaving its identity and settings untouched
+func (r *MongoImportJobRepository) Update(ctx context.Context, job models.ImportJob) error {
+	return r.set(ctx, job.ID, bson.M{
+		"status":           job.Status,
+		"total_rows":       job.TotalRows,
+		"processed":        job.Processed,
+		"succeeded":        job.Succeeded,
+		"failed":           job.Failed,
+		"errors":           job.Errors,
+		"errors_truncated": job.ErrorsTruncated,
+		"message":          job.Message,
+		"updated_date":     job.UpdatedDate,
+		"finished_date":    job.FinishedDate,
+	})
+}
+
+func (r *MongoImportJobRepository) FailStale(ctx context.Context, cutoff time.Time, reason string) (int64, error) {
+	now := time.Now()
+	filter := bson.M{"status": models.ImportRunning, "updated_date": bson.M{"$lt": cutoff}}
+	res, err := r.coll.UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+		"status":        models.ImportFailed,
+		"message":       reason,
+		"updated_date":  now,
+		"finished_date": now,
+	}})
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
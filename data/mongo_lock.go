@@ -0,0 +1,48 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoLocker implements Locker with one document per lock. A lock can be
+// taken when it does not exist, has expired, or is already held by the caller.
+type MongoLocker struct {
+	coll *mongo.Collection
+}
+
+func NewMongoLocker(coll *mongo.Collection) *MongoLocker {
+	return &MongoLocker{coll: coll}
+}
+
+func (l *MongoLocker) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expires_at": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(ttl)}}
+
+	// When another owner holds a live lock the filter misses and the upsert
+	// collides with the existing _id
+	_, err := l.coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *MongoLocker) Release(ctx context.Context, name, owner string) error {
+	_, err := l.coll.DeleteOne(ctx, bson.M{"_id": name, "owner": owner})
+	return err
+}
@@ -0,0 +1,301 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"usermanagement/config"
+	"usermanagement/integrity"
+	"usermanagement/models"
+)
+
+// Server error codes for a change stream that cannot resume
+const (
+	codeChangeStreamFatal       = 280
+	codeChangeStreamHistoryLost = 286
+)
+
+// wireVersionPreImages is the wire version of MongoDB 6.0, the first whose
+// change streams can carry a document as it was before the change
+const wireVersionPreImages = 17
+
+// MongoChangeStream watches the database's users, emojis, contacts and
+// businesses collections. Change streams need a replica set or a sharded
+// cluster; on a standalone server Open returns ErrNoChangeStreams.
+type MongoChangeStream struct {
+	db *mongo.Database
+	// resources maps a collection name to the resource it holds
+	resources map[string]string
+
+	mu sync.Mutex
+	// enabled is whether the server has change streams and preImages whether
+	// they can carry pre-images, once checked is set
+	enabled, preImages, checked bool
+}
+
+func NewMongoChangeStream(db *mongo.Database, names config.Collections) *MongoChangeStream {
+	return &MongoChangeStream{
+		db: db,
+		resources: map[string]string{
+			names.Users:      integrity.Users,
+			names.Emojis:     integrity.Emojis,
+			names.Contacts:   integrity.Contacts,
+			names.Businesses: integrity.Businesses,
+		},
+	}
+}
+
+func (s *MongoChangeStream) Open(ctx context.Context, userID primitive.ObjectID, resumeAfter bson.Raw) (ChangeCursor, error) {
+	enabled, preImages, err := s.supported(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrNoChangeStreams
+	}
+
+	// deletes carry no document, so only those of the user's own record and
+	// of emojis can be told apart as visible; a contact or business has
+	// already been announced deleted when it went to the trash.
+	// A contact or business reassigned away from the user no longer shows
+	// them as its owner, so it is matched on its pre-image, or, where the
+	// server keeps none, on the reassignment alone.
+	visible := bson.A{}
+	for coll, resource := range s.resources {
+		switch resource {
+		case integrity.Users:
+			visible = append(visible, bson.M{"ns.coll": coll, "documentKey._id": userID})
+		case integrity.Emojis:
+			visible = append(visible, bson.M{"ns.coll": coll})
+		default:
+			visible = append(visible,
+				bson.M{"ns.coll": coll, "fullDocument.user_id": userID},
+				bson.M{"ns.coll": coll, "fullDocumentBeforeChange.user_id": userID},
+				bson.M{
+					"ns.coll":                  coll,
+					"fullDocumentBeforeChange": nil,
+					"updateDescription.updatedFields.user_id": bson.M{"$exists": true},
+				},
+			)
+		}
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+		"$or":           visible,
+	}}}}
+
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if preImages {
+		opts.SetFullDocumentBeforeChange(options.WhenAvailable)
+	}
+	if resumeAfter != nil {
+		opts.SetResumeAfter(resumeAfter)
+	}
+	cs, err := s.db.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return nil, changeStreamError(err)
+	}
+	return &mongoChangeCursor{cs: cs, resources: s.resources, userID: userID}, nil
+}
+
+// supported asks the server whether it has change streams and whether they
+// can carry pre-images, remembering the answer once it gets one
+func (s *MongoChangeStream) supported(ctx context.Context) (bool, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.checked {
+		server, err := hello(ctx, s.db)
+		if err != nil {
+			return false, false, fmt.Errorf("checking MongoDB topology: %w", err)
+		}
+		s.enabled = server.replicated()
+		s.preImages = s.enabled && server.MaxWireVersion >= wireVersionPreImages
+		s.checked = true
+	}
+	return s.enabled, s.preImages, nil
+}
+
+// EnablePreImages has the server keep the contacts and businesses as they
+// were before each change, so a change stream can tell the previous owner of
+// a reassigned one from the rest. Servers before MongoDB 6.0 and standalone
+// ones keep none; their change streams then tell every user of a
+// reassignment.
+func EnablePreImages(ctx context.Context, db *mongo.Database, names config.Collections) error {
+	server, err := hello(ctx, db)
+	if err != nil {
+		return fmt.Errorf("checking MongoDB topology: %w", err)
+	}
+	if !server.replicated() || server.MaxWireVersion < wireVersionPreImages {
+		return nil
+	}
+	for _, collection := range []string{names.Contacts, names.Businesses} {
+		err := db.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collection},
+			{Key: "changeStreamPreAndPostImages", Value: bson.M{"enabled": true}},
+		}).Err()
+		if err != nil {
+			return fmt.Errorf("keeping pre-images of %s: %w", collection, err)
+		}
+	}
+	return nil
+}
+
+// changeStreamError reports a resume token the server can no longer resume from as ErrResumeLost
+func changeStreamError(err error) error {
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && (serverErr.HasErrorCode(codeChangeStreamHistoryLost) || serverErr.HasErrorCode(codeChangeStreamFatal)) {
+		return ErrResumeLost
+	}
+	return err
+}
+
+type mongoChangeCursor struct {
+	cs        *mongo.ChangeStream
+	resources map[string]string
+	// userID is who the changes are for
+	userID primitive.ObjectID
+}
+
+// changeEvent is the part of a change stream event a Change is made from
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	NS            struct {
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument      bson.Raw `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields bson.Raw `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+func (c *mongoChangeCursor) TryNext(ctx context.Context) (Change, bool, error) {
+	for c.cs.TryNext(ctx) {
+		var event changeEvent
+		if err := c.cs.Decode(&event); err != nil {
+			return Change{}, false, err
+		}
+		change, ok, err := c.change(event)
+		if err != nil {
+			return Change{}, false, err
+		}
+		if ok {
+			return change, true, nil
+		}
+	}
+	return Change{}, false, changeStreamError(c.cs.Err())
+}
+
+// change turns a change stream event into a Change, reporting false for one
+// the caller does not need to hear of
+func (c *mongoChangeCursor) change(event changeEvent) (Change, bool, error) {
+	change := Change{Resource: c.resources[event.NS.Coll], ID: event.DocumentKey.ID}
+	if event.OperationType == "delete" {
+		change.Op = ChangeDelete
+		return change, true, nil
+	}
+	if event.FullDocument == nil {
+		// deleted before the update could be looked up; its delete follows
+		return change, false, nil
+	}
+
+	trashed := false
+	if deletedAt, err := event.FullDocument.LookupErr("deleted_at"); err == nil && deletedAt.Type != bson.TypeNull {
+		trashed = true
+	}
+	// the update moved the document into or out of the trash
+	trashMoved := false
+	if event.UpdateDescription.UpdatedFields != nil {
+		_, err := event.UpdateDescription.UpdatedFields.LookupErr("deleted_at")
+		trashMoved = err == nil
+	}
+	for _, field := range event.UpdateDescription.RemovedFields {
+		if field == "deleted_at" {
+			trashMoved = true
+		}
+	}
+
+	switch {
+	case trashed && (event.OperationType != "update" || trashMoved):
+		change.Op = ChangeDelete
+		return change, true, nil
+	case trashed:
+		// a change to a document in the trash, such as a reassignment
+		return change, false, nil
+	case !c.owns(change.Resource, event.FullDocument):
+		// reassigned away from the user, so gone from what they see; one
+		// matched on the reassignment alone may never have been theirs, which
+		// a client holding no such document ignores
+		change.Op = ChangeDelete
+		return change, true, nil
+	case event.OperationType == "insert" || trashMoved:
+		change.Op = ChangeInsert
+	default:
+		change.Op = ChangeUpdate
+	}
+
+	doc, err := decodeResource(change.Resource, event.FullDocument)
+	if err != nil {
+		return change, false, err
+	}
+	change.Document = doc
+	return change, true, nil
+}
+
+// owns reports whether doc, a document of resource, is one the user sees as
+// its owner or is not owned at all
+func (c *mongoChangeCursor) owns(resource string, doc bson.Raw) bool {
+	if resource == integrity.Users || resource == integrity.Emojis {
+		return true
+	}
+	owner, _ := doc.Lookup("user_id").ObjectIDOK()
+	return owner == c.userID
+}
+
+func (c *mongoChangeCursor) Token() bson.Raw {
+	return c.cs.ResumeToken()
+}
+
+func (c *mongoChangeCursor) Close(ctx context.Context) error {
+	return c.cs.Close(ctx)
+}
+
+// decodeResource decodes a document of the given resource into its model
+func decodeResource(resource string, raw bson.Raw) (interface{}, error) {
+	var doc interface{}
+	switch resource {
+	case integrity.Users:
+		doc = &models.User{}
+	case integrity.Emojis:
+		doc = &models.Emoji{}
+	case integrity.Contacts:
+		doc = &models.Contact{}
+	case integrity.Businesses:
+		doc = &models.Business{}
+	default:
+		return nil, errors.New("no model for resource " + resource)
+	}
+	if err := bson.Unmarshal(raw, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// MongoStreamTokenRepository stores live update stream tokens in a Mongo collection
+type MongoStreamTokenRepository struct {
+	mongoCollection[models.StreamToken]
+}
+
+func NewMongoStreamTokenRepository(coll *mongo.Collection) *MongoStreamTokenRepository {
+	return &MongoStreamTokenRepository{mongoCollection[models.StreamToken]{coll: coll}}
+}
@@ -0,0 +1,45 @@
+package data
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/integrity"
+)
+
+// TestChangeReassignedAway checks a contact reassigned away from the user
+// reaches them as a delete, and one reassigned to them as an update
+func TestChangeReassignedAway(t *testing.T) {
+	userID, otherID := primitive.NewObjectID(), primitive.NewObjectID()
+	cursor := &mongoChangeCursor{resources: map[string]string{"contacts": integrity.Contacts}, userID: userID}
+	reassign := func(to primitive.ObjectID) changeEvent {
+		var event changeEvent
+		event.OperationType = "update"
+		event.NS.Coll = "contacts"
+		event.DocumentKey.ID = primitive.NewObjectID()
+		event.FullDocument, _ = bson.Marshal(bson.M{"_id": event.DocumentKey.ID, "user_id": to})
+		event.UpdateDescription.UpdatedFields, _ = bson.Marshal(bson.M{"user_id": to})
+		return event
+	}
+
+	for _, test := range []struct {
+		to primitive.ObjectID
+		op string
+	}{
+		{otherID, ChangeDelete},
+		{userID, ChangeUpdate},
+	} {
+		event := reassign(test.to)
+		change, ok, err := cursor.change(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || change.Op != test.op || change.ID != event.DocumentKey.ID {
+			t.Errorf("reassigned to %s: change %+v, %v; want a %s of %s", test.to.Hex(), change, ok, test.op, event.DocumentKey.ID.Hex())
+		}
+		if test.op == ChangeDelete && change.Document != nil {
+			t.Errorf("the delete carries the document %v", change.Document)
+		}
+	}
+}
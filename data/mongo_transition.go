@@ -0,0 +1,15 @@
+package data
+
+import (
+	"go.mongodb.org/mongo-driver/mongo"
+	"usermanagement/models"
+)
+
+// MongoTransitionRepository stores business status changes in a Mongo collection
+type MongoTransitionRepository struct {
+	mongoCollection[models.StatusTransition]
+}
+
+func NewMongoTransitionRepository(coll *mongo.Collection) *MongoTransitionRepository {
+	return &MongoTransitionRepository{mongoCollection[models.StatusTransition]{coll: coll}}
+}
@@ -0,0 +1,15 @@
+package data
+
+import (
+	"go.mongodb.org/mongo-driver/mongo"
+	"usermanagement/models"
+)
+
+// MongoTrashRepository lists the trash entries kept in a Mongo collection
+type MongoTrashRepository struct {
+	mongoCollection[models.TrashEntry]
+}
+
+func NewMongoTrashRepository(coll *mongo.Collection) *MongoTrashRepository {
+	return &MongoTrashRepository{mongoCollection[models.TrashEntry]{coll: coll}}
+}
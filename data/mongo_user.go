@@ -0,0 +1,39 @@
+package data
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"usermanagement/models"
+)
+
+// MongoUserRepository stores users in a Mongo collection
+type MongoUserRepository struct {
+	mongoCollection[models.User]
+}
+
+func NewMongoUserRepository(coll *mongo.Collection) *MongoUserRepository {
+	return &MongoUserRepository{mongoCollection[models.User]{coll: coll, trashable: true}}
+}
+
+func (r *MongoUserRepository) Update(ctx context.Context, user models.User) (models.User, error) {
+	fields, err := fieldsOf(untrashed(user))
+	if err != nil {
+		return models.User{}, err
+	}
+	delete(fields, "calendar_salt")
+	return r.setAt(ctx, user.ID, user.Revision, bson.M{"$set": fields})
+}
+
+func (r *MongoUserRepository) SetCalendarSalt(ctx context.Context, id primitive.ObjectID, salt string) error {
+	res, err := r.coll.UpdateOne(ctx, r.live(bson.M{"_id": id}), bson.M{"$set": bson.M{"calendar_salt": salt}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
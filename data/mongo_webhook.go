@@ -0,0 +1,73 @@
+package data
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"usermanagement/models"
+)
+
+// MongoWebhookRepository stores webhook subscriptions in a Mongo collection
+type MongoWebhookRepository struct {
+	mongoCollection[models.Webhook]
+}
+
+func NewMongoWebhookRepository(coll *mongo.Collection) *MongoWebhookRepository {
+	return &MongoWebhookRepository{mongoCollection[models.Webhook]{coll: coll}}
+}
+
+// Update saves the webhook's settings as long as it is still at its revision,
+// leaving its creation date untouched
+func (r *MongoWebhookRepository) Update(ctx context.Context, webhook models.Webhook) error {
+	_, err := r.setAt(ctx, webhook.ID, webhook.Revision, bson.M{"$set": bson.M{
+		"url":          webhook.URL,
+		"description":  webhook.Description,
+		"secret":       webhook.Secret,
+		"events":       webhook.Events,
+		"active":       webhook.Active,
+		"updated_date": webhook.UpdatedDate,
+	}})
+	return err
+}
+
+// MongoOutboxRepository keeps the webhook outbox in a Mongo collection
+type MongoOutboxRepository struct {
+	mongoCollection[models.Event]
+}
+
+func NewMongoOutboxRepository(coll *mongo.Collection) *MongoOutboxRepository {
+	return &MongoOutboxRepository{mongoCollection[models.Event]{coll: coll}}
+}
+
+func (r *MongoOutboxRepository) MarkDispatched(ctx context.Context, id primitive.ObjectID) error {
+	return r.set(ctx, id, bson.M{"dispatched": true})
+}
+
+// MongoDeliveryRepository stores webhook deliveries in a Mongo collection
+type MongoDeliveryRepository struct {
+	mongoCollection[models.Delivery]
+}
+
+func NewMongoDeliveryRepository(coll *mongo.Collection) *MongoDeliveryRepository {
+	return &MongoDeliveryRepository{mongoCollection[models.Delivery]{coll: coll}}
+}
+
+func (r *MongoDeliveryRepository) Queue(ctx context.Context, delivery models.Delivery) error {
+	filter := bson.M{"event_id": delivery.EventID, "webhook_id": delivery.WebhookID}
+	_, err := r.coll.UpdateOne(ctx, filter, bson.M{"$setOnInsert": delivery}, options.Update().SetUpsert(true))
+	return err
+}
+
+// Update saves the delivery's progress, leaving its identity and payload untouched
+func (r *MongoDeliveryRepository) Update(ctx context.Context, delivery models.Delivery) error {
+	return r.set(ctx, delivery.ID, bson.M{
+		"status":       delivery.Status,
+		"attempts":     delivery.Attempts,
+		"next_attempt": delivery.NextAttempt,
+		"log":          delivery.Log,
+		"updated_date": delivery.UpdatedDate,
+	})
+}
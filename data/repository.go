@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/models"
+)
+
+// ErrNotFound is returned by repositories when no document matches the given ID
+var ErrNotFound = errors.New("document not found")
+
+// UserRepository persists users
+type UserRepository interface {
+	FindAll(ctx context.Context) ([]models.User, error)
+	List(ctx context.Context, opts ListOptions) (Page[models.User], error)
+	// Each streams every user matching the list filters to fn, in list order
+	Each(ctx context.Context, opts ListOptions, fn func(models.User) error) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.User, error)
+	Exists(ctx context.Context, id primitive.ObjectID) (bool, error)
+	Insert(ctx context.Context, user models.User) error
+	// Update saves user, keeping its stored calendar salt, and returns it as
+	// saved
+	Update(ctx context.Context, user models.User) (models.User, error)
+	// SetCalendarSalt replaces the salt of the user's calendar feed token.
+	// It is not a change to the user, so the revision stays.
+	SetCalendarSalt(ctx context.Context, id primitive.ObjectID, salt string) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// EmojiRepository persists emojis
+type EmojiRepository interface {
+	FindAll(ctx context.Context) ([]models.Emoji, error)
+	List(ctx context.Context, opts ListOptions) (Page[models.Emoji], error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Emoji, error)
+	Exists(ctx context.Context, id primitive.ObjectID) (bool, error)
+	Count(ctx context.Context) (int64, error)
+	Insert(ctx context.Context, emoji models.Emoji) error
+	// Update saves emoji and returns it as saved
+	Update(ctx context.Context, emoji models.Emoji) (models.Emoji, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// ContactRepository persists contacts
+type ContactRepository interface {
+	FindAll(ctx context.Context) ([]models.Contact, error)
+	List(ctx context.Context, opts ListOptions) (Page[models.Contact], error)
+	// Each streams every contact matching the list filters to fn, in list order
+	Each(ctx context.Context, opts ListOptions, fn func(models.Contact) error) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Contact, error)
+	Exists(ctx context.Context, id primitive.ObjectID) (bool, error)
+	Insert(ctx context.Context, contact models.Contact) error
+	// Update saves contact and returns it as saved
+	Update(ctx context.Context, contact models.Contact) (models.Contact, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// Near returns the located contacts matching the query, nearest first
+	Near(ctx context.Context, query GeoQuery) ([]models.ContactDistance, error)
+	// Search returns up to limit of the user's contacts matching a text query, best match first
+	Search(ctx context.Context, userID primitive.ObjectID, query string, limit int) ([]SearchHit[models.Contact], error)
+}
+
+// BusinessRepository persists businesses
+type BusinessRepository interface {
+	FindAll(ctx context.Context) ([]models.Business, error)
+	List(ctx context.Context, opts ListOptions) (Page[models.Business], error)
+	// Each streams every business matching the list filters to fn, in list order
+	Each(ctx context.Context, opts ListOptions, fn func(models.Business) error) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Business, error)
+	Exists(ctx context.Context, id primitive.ObjectID) (bool, error)
+	Insert(ctx context.Context, business models.Business) error
+	// Update saves business and returns it as saved
+	Update(ctx context.Context, business models.Business) (models.Business, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// RecordFollowup stamps the last follow-up date and schedules the next one,
+	// only if the next one is still due, returning ErrNotFound otherwise
+	RecordFollowup(ctx context.Context, id primitive.ObjectID, due, last, next time.Time) (models.Business, error)
+	// UpdateStatus moves the business to a new status only if it is still at from,
+	// returning ErrNotFound otherwise
+	UpdateStatus(ctx context.Context, id primitive.ObjectID, from, to int) (models.Business, error)
+	// TouchLastFollowup moves the last follow-up date forward to at, never
+	// backwards. It reports false when the date was not moved, and so the
+	// business is unchanged.
+	TouchLastFollowup(ctx context.Context, id primitive.ObjectID, at time.Time) (models.Business, bool, error)
+	// ReassignContact points every business whose contact is one of from at
+	// to instead, returning the businesses it changed
+	ReassignContact(ctx context.Context, from []primitive.ObjectID, to primitive.ObjectID) ([]models.Business, error)
+	// Search returns up to limit of the user's businesses matching a text query, best match first
+	Search(ctx context.Context, userID primitive.ObjectID, query string, limit int) ([]SearchHit[models.Business], error)
+}
+
+// CallRepository persists logged calls
+type CallRepository interface {
+	List(ctx context.Context, opts ListOptions) (Page[models.Call], error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Call, error)
+	Insert(ctx context.Context, call models.Call) error
+	Update(ctx context.Context, call models.Call) (models.Call, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// ReassignContact points every call with one of the from contacts at to instead
+	ReassignContact(ctx context.Context, from []primitive.ObjectID, to primitive.ObjectID) (int64, error)
+}
+
+// TransitionRepository persists the history of business status changes
+type TransitionRepository interface {
+	List(ctx context.Context, opts ListOptions) (Page[models.StatusTransition], error)
+	Insert(ctx context.Context, transition models.StatusTransition) error
+}
+
+// FollowupRepository persists follow-up tasks emitted by the scheduler
+type FollowupRepository interface {
+	List(ctx context.Context, opts ListOptions) (Page[models.Followup], error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Followup, error)
+	Insert(ctx context.Context, followup models.Followup) error
+}
+
+// ActivityRepository persists the activity feed behind business and contact timelines
+type ActivityRepository interface {
+	List(ctx context.Context, opts ListOptions) (Page[models.Activity], error)
+	Insert(ctx context.Context, activity models.Activity) error
+}
+
+// ImportJobRepository persists CSV import jobs so their progress can be polled
+type ImportJobRepository interface {
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.ImportJob, error)
+	Insert(ctx context.Context, job models.ImportJob) error
+	Update(ctx context.Context, job models.ImportJob) error
+	// FailStale marks the running jobs whose progress was last saved before
+	// cutoff as failed for the given reason, returning how many it marked
+	FailStale(ctx context.Context, cutoff time.Time, reason string) (int64, error)
+}
+
+// Transactor makes changes to several documents as one
+type Transactor interface {
+	// Atomically runs fn in a transaction where the database allows them.
+	// Repository calls take part in it when given the context fn gets, and
+	// an Atomically inside fn joins the transaction already running.
+	Atomically(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Locker grants named, expiring locks so only one instance runs a background job
+type Locker interface {
+	// Acquire takes or renews the lock for owner, reporting false when another owner holds it
+	Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, name, owner string) error
+}
+
+// WebhookRepository persists webhook subscriptions
+type WebhookRepository interface {
+	List(ctx context.Context, opts ListOptions) (Page[models.Webhook], error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Webhook, error)
+	Insert(ctx context.Context, webhook models.Webhook) error
+	Update(ctx context.Context, webhook models.Webhook) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// OutboxRepository holds events until they are queued for delivery to the
+// webhooks subscribed to them
+type OutboxRepository interface {
+	List(ctx context.Context, opts ListOptions) (Page[models.Event], error)
+	Insert(ctx context.Context, event models.Event) error
+	MarkDispatched(ctx context.Context, id primitive.ObjectID) error
+}
+
+// DeliveryRepository persists webhook deliveries and the log of their tries
+type DeliveryRepository interface {
+	List(ctx context.Context, opts ListOptions) (Page[models.Delivery], error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Delivery, error)
+	// Queue saves a delivery unless one of the same event to the same webhook
+	// is already saved, so an event dispatched twice is sent once
+	Queue(ctx context.Context, delivery models.Delivery) error
+	// Update saves the delivery's progress, leaving its identity and payload untouched
+	Update(ctx context.Context, delivery models.Delivery) error
+}
+
+// Repositories bundles every repository the HTTP layer depends on
+type Repositories struct {
+	Users       UserRepository
+	Emojis      EmojiRepository
+	Contacts    ContactRepository
+	Businesses  BusinessRepository
+	Calls       CallRepository
+	Activities  ActivityRepository
+	Followups   FollowupRepository
+	Transitions TransitionRepository
+	Imports     ImportJobRepository
+	Locks       Locker
+	Trash       TrashRepository
+	Deletes     Deleter
+	Tx          Transactor
+	History     HistoryRepository
+	Bulk        BulkWriter
+	Webhooks    WebhookRepository
+	Outbox      OutboxRepository
+	Deliveries  DeliveryRepository
+	Changes     ChangeStream
+	// StreamTokens holds the resume points of the live update stream
+	StreamTokens StreamTokenRepository
+}
@@ -0,0 +1,56 @@
+package data
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"usermanagement/models"
+)
+
+// ErrRevisionConflict is returned by Update when the document was changed
+// since the revision it was read at
+var ErrRevisionConflict = errors.New("document was changed since it was read")
+
+// revisionOf points at the revision of a document, or is nil for documents
+// that do not count their changes
+func revisionOf[T any](doc *T) *int64 {
+	switch d := any(doc).(type) {
+	case *models.User:
+		return &d.Revision
+	case *models.Emoji:
+		return &d.Revision
+	case *models.Contact:
+		return &d.Revision
+	case *models.Business:
+		return &d.Revision
+	case *models.Call:
+		return &d.Revision
+	case *models.Webhook:
+		return &d.Revision
+	}
+	return nil
+}
+
+// atRevision matches documents at revision. Documents saved before revisions
+// were counted have none, which reads as 0.
+func atRevision(revision int64) interface{} {
+	if revision == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return revision
+}
+
+// fieldsOf turns a document into the fields a $set writes, leaving out the
+// revision, which only ever moves forward through $inc
+func fieldsOf(doc interface{}) (bson.M, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "revision")
+	return fields, nil
+}
@@ -0,0 +1,166 @@
+package data
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// searchIndexName names the text index of each searchable collection
+const searchIndexName = "search"
+
+// SearchWeights rank a match by the field it is found in; a heavier field
+// counts for more in the relevance score
+type SearchWeights map[string]int
+
+var (
+	BusinessSearchWeights = SearchWeights{
+		"business_name":    10,
+		"business_tagline": 4,
+		"website":          2,
+	}
+	ContactSearchWeights = SearchWeights{
+		"name":        10,
+		"email":       6,
+		"job_title":   3,
+		"city":        3,
+		"description": 1,
+	}
+)
+
+// Fields lists the weighted fields, heaviest first
+func (w SearchWeights) Fields() []string {
+	fields := make([]string, 0, len(w))
+	for field := range w {
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		if w[fields[i]] != w[fields[j]] {
+			return w[fields[i]] > w[fields[j]]
+		}
+		return fields[i] < fields[j]
+	})
+	return fields
+}
+
+// textIndex is a text index over the weighted fields, prefixed by user_id so
+// a search scoped to one user only reads that user's index entries
+func (w SearchWeights) textIndex() mongo.IndexModel {
+	keys := bson.D{{Key: "user_id", Value: 1}}
+	weights := bson.D{}
+	for _, field := range w.Fields() {
+		keys = append(keys, bson.E{Key: field, Value: "text"})
+		weights = append(weights, bson.E{Key: field, Value: w[field]})
+	}
+	return mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetName(searchIndexName).SetWeights(weights),
+	}
+}
+
+// SearchHit is a document matched by a text search together with its relevance
+type SearchHit[T any] struct {
+	Item  T
+	Score float64
+}
+
+// search runs a $text query over the user's documents, best match first
+func (m mongoCollection[T]) search(ctx context.Context, userID primitive.ObjectID, query string, limit int) ([]SearchHit[T], error) {
+	score := bson.M{"$meta": "textScore"}
+	findOptions := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}}).
+		SetLimit(int64(limit))
+	cur, err := m.coll.Find(ctx, m.live(bson.M{"user_id": userID, "$text": bson.M{"$search": query}}), findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var hits []SearchHit[T]
+	for cur.Next(ctx) {
+		var hit SearchHit[T]
+		if err := cur.Decode(&hit.Item); err != nil {
+			return nil, err
+		}
+		hit.Score = cur.Current.Lookup("score").Double()
+		hits = append(hits, hit)
+	}
+	return hits, cur.Err()
+}
+
+// search approximates a Mongo text search: each query word matches words it
+// is a prefix of, standing in for stemming, and a match scores the weight of
+// its field. Words prefixed with "-" exclude documents that contain them.
+func (m *memoryCollection[T]) search(ctx context.Context, userID primitive.ObjectID, query string, limit int, weights SearchWeights) ([]SearchHit[T], error) {
+	terms, excluded := SearchTerms(query)
+	docs, err := m.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []SearchHit[T]
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		if id, ok := bson.Raw(raw).Lookup("user_id").ObjectIDOK(); !ok || id != userID {
+			continue
+		}
+
+		var score float64
+		skip := false
+		for field, weight := range weights {
+			value, _ := bson.Raw(raw).Lookup(field).StringValueOK()
+			for _, word := range searchWords(value) {
+				for _, term := range excluded {
+					skip = skip || strings.HasPrefix(word, term)
+				}
+				for _, term := range terms {
+					if strings.HasPrefix(word, term) {
+						score += float64(weight)
+					}
+				}
+			}
+		}
+		if score > 0 && !skip {
+			hits = append(hits, SearchHit[T]{Item: doc, Score: score})
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// SearchTerms splits a search query into lower-cased words to match and words
+// to exclude, ignoring quotes
+func SearchTerms(query string) (terms, excluded []string) {
+	for _, field := range strings.Fields(strings.ToLower(query)) {
+		negated := strings.HasPrefix(field, "-")
+		for _, word := range searchWords(field) {
+			if negated {
+				excluded = append(excluded, word)
+			} else {
+				terms = append(terms, word)
+			}
+		}
+	}
+	return terms, excluded
+}
+
+// searchWords splits text into lower-cased words of letters and digits
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -4,48 +4,62 @@ import (
 	"context"
 	"fmt"
 	"log"
-    "os"
-	"github.com/joho/godotenv"
+
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"usermanagement/config"
 )
 
-var (
-	client          *mongo.Client
-	UserCollection  *mongo.Collection
-	EmojiCollection *mongo.Collection
-	ContactCollection *mongo.Collection
-	BusinessCollection *mongo.Collection
-)
+var client *mongo.Client
 
-func InitMongoDB() error {
-	err := godotenv.Load()
-    if err != nil {
-        log.Fatalf("Error loading .env file")
-    }
+// InitMongoDB connects to MongoDB and returns repositories backed by its collections
+func InitMongoDB(ctx context.Context, cfg config.MongoConfig, phoneCfg config.PhoneConfig) (*Repositories, error) {
+	clientOptions := options.Client().
+		ApplyURI(cfg.URI).
+		SetMinPoolSize(cfg.MinPoolSize).
+		SetMaxPoolSize(cfg.MaxPoolSize).
+		SetConnectTimeout(cfg.ConnectTimeout.Duration)
 
-    mongoURI := os.Getenv("MONGODB_URI")
-    if mongoURI == "" {
-        log.Fatalf("MONGODB_URI not set in environment variables")
-    }
+	connectCtx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout.Duration)
+	defer cancel()
 
-    clientOptions := options.Client().ApplyURI(mongoURI)
-	client, err = mongo.Connect(context.TODO(), clientOptions)
+	var err error
+	client, err = mongo.Connect(connectCtx, clientOptions)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("connecting to MongoDB: %w", err)
 	}
 
-	err = client.Ping(context.TODO(), readpref.Primary())
+	err = client.Ping(connectCtx, readpref.Primary())
 	if err != nil {
-		return err
+		client.Disconnect(context.Background())
+		client = nil
+		return nil, fmt.Errorf("pinging MongoDB: %w", err)
 	}
 
-	fmt.Println("Connected to MongoDB!")
-	UserCollection = client.Database("testdb").Collection("users")
-	EmojiCollection = client.Database("testdb").Collection("emojis")
-	ContactCollection = client.Database("testdb").Collection("contacts")
-	BusinessCollection = client.Database("testdb").Collection("businesses")
-	
-	return nil
+	log.Println("Connected to MongoDB!")
+	db := client.Database(cfg.Database)
+	if err := EnsureIndexes(ctx, db, cfg.Collections); err != nil {
+		return nil, err
+	}
+	if err := EnablePreImages(ctx, db, cfg.Collections); err != nil {
+		// change streams still work, telling every user of a reassignment
+		log.Printf("%v; change streams cannot tell who a reassignment was from", err)
+	}
+	contacts := NewMongoContactRepository(db.Collection(cfg.Collections.Contacts))
+	if err := contacts.backfillLocations(ctx); err != nil {
+		return nil, fmt.Errorf("backfilling contact locations: %w", err)
+	}
+	if err := contacts.backfillPhones(ctx, db.Collection(cfg.Collections.Users), phoneCfg.DefaultRegion); err != nil {
+		return nil, fmt.Errorf("backfilling contact phone numbers: %w", err)
+	}
+	return NewMongoRepositories(db, cfg.Collections), nil
+}
+
+// Disconnect closes the client opened by InitMongoDB
+func Disconnect(ctx context.Context) error {
+	if client == nil {
+		return nil
+	}
+	return client.Disconnect(ctx)
 }
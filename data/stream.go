@@ -0,0 +1,63 @@
+package data
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/models"
+)
+
+// Change operations
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+var (
+	// ErrNoChangeStreams is returned by ChangeStream.Open when the server is
+	// standalone, which has no change streams
+	ErrNoChangeStreams = errors.New("change streams need a MongoDB replica set or sharded cluster")
+	// ErrResumeLost is returned when a change stream can no longer be resumed
+	// from a token, because the changes after it have left the oplog
+	ErrResumeLost = errors.New("the changes since the resume token are no longer available")
+)
+
+// Change is a user, emoji, contact or business being inserted, updated or
+// deleted. A document moving to the trash is a delete and one restored from
+// it an insert.
+type Change struct {
+	// Resource names the collection, as integrity names it
+	Resource string
+	Op       string
+	ID       primitive.ObjectID
+	// Document is the document after the change, or nil for a delete
+	Document interface{}
+}
+
+// ChangeStream watches the users, emojis, contacts and businesses collections
+type ChangeStream interface {
+	// Open starts watching the changes userID may see: their own user, every
+	// emoji and the contacts and businesses they own. One reassigned away
+	// from them is a delete. It resumes after the resume token when one is
+	// given and otherwise starts from now.
+	Open(ctx context.Context, userID primitive.ObjectID, resumeAfter bson.Raw) (ChangeCursor, error)
+}
+
+// ChangeCursor reads the changes of an open ChangeStream
+type ChangeCursor interface {
+	// TryNext returns the next change, or false when none arrived within the
+	// server's wait
+	TryNext(ctx context.Context) (Change, bool, error)
+	// Token is the resume token after the last change read
+	Token() bson.Raw
+	Close(ctx context.Context) error
+}
+
+// StreamTokenRepository persists where clients of the live update stream have read up to
+type StreamTokenRepository interface {
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.StreamToken, error)
+	Insert(ctx context.Context, token models.StreamToken) error
+}
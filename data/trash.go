@@ -0,0 +1,273 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/integrity"
+	"usermanagement/models"
+)
+
+// TrashRepository lists what is in the trash. Entries are written, restored
+// and purged through the Deleter.
+type TrashRepository interface {
+	List(ctx context.Context, opts ListOptions) (Page[models.TrashEntry], error)
+}
+
+// DeletedWithError is returned when restoring a document that was deleted
+// along with another one, which is the one to restore
+type DeletedWithError struct {
+	Resource string
+	ID       primitive.ObjectID
+}
+
+func (e *DeletedWithError) Error() string {
+	return fmt.Sprintf("it was deleted along with %s %s; restore that instead", e.Resource, e.ID.Hex())
+}
+
+// TrashedRefError is returned when a document to restore references one that
+// is still in the trash from another delete
+type TrashedRefError struct {
+	Relation string
+	Resource string
+	ID       primitive.ObjectID
+}
+
+func (e *TrashedRefError) Error() string {
+	return fmt.Sprintf("%s refers to %s %s, which is in the trash; restore that first", e.Relation, e.Resource, e.ID.Hex())
+}
+
+// trashStore is the access to stored documents that deleting, restoring and
+// purging need. Exists and Refs only see documents outside the trash and
+// DeleteMany deletes for good.
+type trashStore interface {
+	integrity.Store
+	// find returns a stored document, in the trash or not
+	find(ctx context.Context, resource string, id primitive.ObjectID) (bson.Raw, error)
+	// targets returns what field holds on each of the given documents, in the trash or not
+	targets(ctx context.Context, resource, field string, ids []primitive.ObjectID) (map[primitive.ObjectID]primitive.ObjectID, error)
+	// inTrash returns which of ids are in the trash
+	inTrash(ctx context.Context, resource string, ids []primitive.ObjectID) ([]primitive.ObjectID, error)
+	// setDeletedAt moves documents to the trash at the given time, or out of it when at is nil
+	setDeletedAt(ctx context.Context, resource string, ids []primitive.ObjectID, at *time.Time) error
+
+	insertEntry(ctx context.Context, entry models.TrashEntry) error
+	// findEntry returns the entry that took the document of resource with the given ID to the trash
+	findEntry(ctx context.Context, resource string, id primitive.ObjectID) (models.TrashEntry, error)
+	// entriesBefore returns up to limit entries deleted before the cutoff, oldest first
+	entriesBefore(ctx context.Context, before time.Time, limit int) ([]models.TrashEntry, error)
+	deleteEntry(ctx context.Context, id primitive.ObjectID) error
+}
+
+// trashing carries out a planned delete by moving documents to the trash,
+// recording into entry everything it does
+type trashing struct {
+	trashStore
+	entry *models.TrashEntry
+}
+
+// SetRefs remembers what each reference pointed at before changing it
+func (t trashing) SetRefs(ctx context.Context, resource, field string, ids []primitive.ObjectID, to primitive.ObjectID) error {
+	from, err := t.targets(ctx, resource, field, ids)
+	if err != nil {
+		return err
+	}
+	relation := integrity.Relation{Resource: resource, Field: field}.Name()
+	for _, id := range ids {
+		t.entry.Changed = append(t.entry.Changed, models.ChangedRef{Relation: relation, ID: id, From: from[id], To: to})
+	}
+	return t.trashStore.SetRefs(ctx, resource, field, ids, to)
+}
+
+func (t trashing) DeleteMany(ctx context.Context, resource string, ids []primitive.ObjectID) error {
+	t.entry.Deleted[resource] = append(t.entry.Deleted[resource], ids...)
+	return t.setDeletedAt(ctx, resource, ids, &t.entry.DeletedAt)
+}
+
+// trash plans a delete and, unless it is a dry run, moves everything it
+// involves to the trash under one entry
+func trash(ctx context.Context, store trashStore, resource string, id primitive.ObjectID, opts DeleteOptions) (integrity.Report, error) {
+	report, err := integrity.Plan(ctx, store, resource, id, opts.Options)
+	if err != nil {
+		return report, err
+	}
+
+	root, err := store.find(ctx, resource, id)
+	if err != nil {
+		return report, err
+	}
+	if opts.Revision != nil {
+		// documents saved before revisions were counted have none, which reads as 0
+		revision, _ := root.Lookup("revision").AsInt64OK()
+		if revision != *opts.Revision {
+			return report, ErrRevisionConflict
+		}
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+
+	entry := models.TrashEntry{
+		ID:         primitive.NewObjectID(),
+		Resource:   resource,
+		DocumentID: id,
+		Title:      title(root),
+		// Mongo keeps milliseconds; truncating keeps the entry and the documents equal
+		DeletedAt: time.Now().UTC().Truncate(time.Millisecond),
+		Deleted:   map[string][]primitive.ObjectID{},
+		Changed:   []models.ChangedRef{},
+	}
+	if err := integrity.Apply(ctx, trashing{store, &entry}, report); err != nil {
+		return report, err
+	}
+	return report, store.insertEntry(ctx, entry)
+}
+
+// title names a document by the first of its name fields that is set
+func title(doc bson.Raw) string {
+	for _, field := range []string{"business_name", "name", "emoji_name", "emoji"} {
+		if value, ok := doc.Lookup(field).StringValueOK(); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// restore takes everything the delete of the document of resource with the
+// given ID trashed back out of the trash and points the references it changed
+// back, except those changed again since
+func restore(ctx context.Context, store trashStore, resource string, id primitive.ObjectID) (models.TrashEntry, error) {
+	entry, err := store.findEntry(ctx, resource, id)
+	if err != nil {
+		return entry, err
+	}
+	if entry.Resource != resource || entry.DocumentID != id {
+		return entry, &DeletedWithError{Resource: entry.Resource, ID: entry.DocumentID}
+	}
+
+	// A restored document must not refer to one that another delete trashed
+	for _, relation := range integrity.Relations {
+		ids := entry.Deleted[relation.Resource]
+		if len(ids) == 0 {
+			continue
+		}
+		targets, err := store.targets(ctx, relation.Resource, relation.Field, ids)
+		if err != nil {
+			return entry, err
+		}
+		var outside []primitive.ObjectID
+		for _, target := range targets {
+			if !target.IsZero() && !containsID(entry.Deleted[relation.Target], target) && !containsID(outside, target) {
+				outside = append(outside, target)
+			}
+		}
+		if len(outside) == 0 {
+			continue
+		}
+		trashed, err := store.inTrash(ctx, relation.Target, outside)
+		if err != nil {
+			return entry, err
+		}
+		if len(trashed) > 0 {
+			return entry, &TrashedRefError{Relation: relation.Name(), Resource: relation.Target, ID: trashed[0]}
+		}
+	}
+
+	for resource, ids := range entry.Deleted {
+		if err := store.setDeletedAt(ctx, resource, ids, nil); err != nil {
+			return entry, fmt.Errorf("restoring %s: %w", resource, err)
+		}
+	}
+
+	byRelation := make(map[string][]models.ChangedRef)
+	for _, ref := range entry.Changed {
+		byRelation[ref.Relation] = append(byRelation[ref.Relation], ref)
+	}
+	for name, refs := range byRelation {
+		relation, _ := integrity.RelationNamed(name)
+		ids := make([]primitive.ObjectID, len(refs))
+		for i, ref := range refs {
+			ids[i] = ref.ID
+		}
+		current, err := store.targets(ctx, relation.Resource, relation.Field, ids)
+		if err != nil {
+			return entry, err
+		}
+		back := make(map[primitive.ObjectID][]primitive.ObjectID)
+		for _, ref := range refs {
+			if target, ok := current[ref.ID]; ok && target == ref.To {
+				back[ref.From] = append(back[ref.From], ref.ID)
+			}
+		}
+		for from, ids := range back {
+			if err := store.SetRefs(ctx, relation.Resource, relation.Field, ids, from); err != nil {
+				return entry, fmt.Errorf("restoring %s: %w", name, err)
+			}
+		}
+	}
+
+	return entry, store.deleteEntry(ctx, entry.ID)
+}
+
+// purge deletes the documents of an entry for good, along with the entry
+func purge(ctx context.Context, store trashStore, entry models.TrashEntry) error {
+	for resource, ids := range entry.Deleted {
+		trashed, err := store.inTrash(ctx, resource, ids)
+		if err != nil {
+			return err
+		}
+		if len(trashed) == 0 {
+			continue
+		}
+		if err := store.DeleteMany(ctx, resource, trashed); err != nil {
+			return fmt.Errorf("purging %s: %w", resource, err)
+		}
+	}
+	return store.deleteEntry(ctx, entry.ID)
+}
+
+// purgeBatch is how many entries are read at a time while purging
+const purgeBatch = 100
+
+// purgeBefore purges every entry deleted before the cutoff, each on its own
+// through atomically, returning how many it purged
+func purgeBefore(ctx context.Context, store trashStore, before time.Time, atomically func(context.Context, func(context.Context) error) error) (int, error) {
+	purged := 0
+	for {
+		entries, err := store.entriesBefore(ctx, before, purgeBatch)
+		if err != nil {
+			return purged, err
+		}
+		for _, entry := range entries {
+			err := atomically(ctx, func(ctx context.Context) error { return purge(ctx, store, entry) })
+			if err != nil {
+				return purged, fmt.Errorf("purging trash entry %s: %w", entry.ID.Hex(), err)
+			}
+			purged++
+		}
+		if len(entries) < purgeBatch {
+			return purged, nil
+		}
+	}
+}
+
+// untrashed clears the trash marker of a document about to be saved, which
+// only a delete sets
+func untrashed[T any](doc T) T {
+	switch d := any(&doc).(type) {
+	case *models.User:
+		d.DeletedAt = nil
+	case *models.Emoji:
+		d.DeletedAt = nil
+	case *models.Contact:
+		d.DeletedAt = nil
+	case *models.Business:
+		d.DeletedAt = nil
+	case *models.Call:
+		d.DeletedAt = nil
+	}
+	return doc
+}
@@ -0,0 +1,273 @@
+// Package dedupe finds contacts under the same business that are likely the same person
+package dedupe
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/models"
+)
+
+// DefaultMinScore is the score from which a pair is reported as a duplicate:
+// an email match or an identical name on its own, or a phone match backed by
+// a similar name
+const DefaultMinScore = 0.5
+
+const (
+	emailWeight = 0.6
+	phoneWeight = 0.4
+	nameWeight  = 0.5
+	// minNameSimilarity is the Jaro-Winkler similarity below which names count as different
+	minNameSimilarity = 0.85
+	// minPhoneDigits is the shortest number compared; shorter ones are extensions or typos
+	minPhoneDigits = 7
+)
+
+// Score rates how likely a and b are the same person, between 0 and 1, and
+// lists the fields that matched
+func Score(a, b models.Contact) (float64, []string) {
+	var score float64
+	reasons := []string{}
+
+	if email := NormalizeEmail(a.Email); email != "" && email == NormalizeEmail(b.Email) {
+		score += emailWeight
+		reasons = append(reasons, models.DuplicateEmail)
+	}
+	if phonesMatch(a, b) {
+		score += phoneWeight
+		reasons = append(reasons, models.DuplicatePhone)
+	}
+	if sim := NameSimilarity(a.Name, b.Name); sim >= minNameSimilarity {
+		score += nameWeight * sim
+		reasons = append(reasons, models.DuplicateName)
+	}
+
+	if score > 1 {
+		score = 1
+	}
+	return score, reasons
+}
+
+// Clusters groups the contacts of each business into clusters of likely
+// duplicates, linking every pair scoring at least minScore. Clusters are
+// returned strongest first.
+func Clusters(contacts []models.Contact, minScore float64) []models.DuplicateCluster {
+	byBusiness := make(map[primitive.ObjectID][]models.Contact)
+	var businesses []primitive.ObjectID
+	for _, contact := range contacts {
+		if _, ok := byBusiness[contact.BusinessID]; !ok {
+			businesses = append(businesses, contact.BusinessID)
+		}
+		byBusiness[contact.BusinessID] = append(byBusiness[contact.BusinessID], contact)
+	}
+
+	clusters := []models.DuplicateCluster{}
+	for _, businessID := range businesses {
+		clusters = append(clusters, clusterBusiness(businessID, byBusiness[businessID], minScore)...)
+	}
+	sort.SliceStable(clusters, func(i, j int) bool { return clusters[i].Score > clusters[j].Score })
+	return clusters
+}
+
+// clusterBusiness links the pairs of one business's contacts with a union-find
+func clusterBusiness(businessID primitive.ObjectID, contacts []models.Contact, minScore float64) []models.DuplicateCluster {
+	parent := make([]int, len(contacts))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	type scoredPair struct {
+		i, j int
+		pair models.DuplicatePair
+	}
+	var pairs []scoredPair
+	for i := range contacts {
+		for j := i + 1; j < len(contacts); j++ {
+			score, reasons := Score(contacts[i], contacts[j])
+			if score < minScore {
+				continue
+			}
+			pairs = append(pairs, scoredPair{i, j, models.DuplicatePair{
+				A:       contacts[i].ID,
+				B:       contacts[j].ID,
+				Score:   score,
+				Reasons: reasons,
+			}})
+			parent[find(i)] = find(j)
+		}
+	}
+
+	byRoot := make(map[int]*models.DuplicateCluster)
+	var roots []int
+	for _, p := range pairs {
+		root := find(p.i)
+		cluster, ok := byRoot[root]
+		if !ok {
+			cluster = &models.DuplicateCluster{BusinessID: businessID}
+			byRoot[root] = cluster
+			roots = append(roots, root)
+		}
+		cluster.Pairs = append(cluster.Pairs, p.pair)
+		if p.pair.Score > cluster.Score {
+			cluster.Score = p.pair.Score
+		}
+	}
+	for i, contact := range contacts {
+		if cluster, ok := byRoot[find(i)]; ok {
+			cluster.Contacts = append(cluster.Contacts, contact)
+		}
+	}
+
+	clusters := make([]models.DuplicateCluster, 0, len(roots))
+	for _, root := range roots {
+		cluster := byRoot[root]
+		sort.SliceStable(cluster.Contacts, func(i, j int) bool {
+			return cluster.Contacts[i].CreatedDate.Before(cluster.Contacts[j].CreatedDate)
+		})
+		sort.SliceStable(cluster.Pairs, func(i, j int) bool { return cluster.Pairs[i].Score > cluster.Pairs[j].Score })
+		clusters = append(clusters, *cluster)
+	}
+	return clusters
+}
+
+// NormalizeEmail lower-cases an address and drops any +tag from its local part
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return email
+	}
+	local, domain := email[:at], email[at:]
+	if plus := strings.Index(local, "+"); plus > 0 {
+		local = local[:plus]
+	}
+	return local + domain
+}
+
+// NormalizePhone keeps only the digits of a number, returning "" when too few
+// remain to compare
+func NormalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	if len(digits) < minPhoneDigits {
+		return ""
+	}
+	return digits
+}
+
+// phonesMatch reports whether any number of a is the same as any number of b.
+// Numbers are compared in E.164 form when it is known; a number saved without
+// one matches the full form when written without its country or area code.
+func phonesMatch(a, b models.Contact) bool {
+	for _, x := range phoneDigits(a) {
+		for _, y := range phoneDigits(b) {
+			if x == "" || y == "" {
+				continue
+			}
+			if strings.HasSuffix(x, y) || strings.HasSuffix(y, x) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// phoneDigits lists the normalized numbers of a contact
+func phoneDigits(contact models.Contact) []string {
+	digits := func(display, e164 string) string {
+		if e164 != "" {
+			return NormalizePhone(e164)
+		}
+		return NormalizePhone(display)
+	}
+	return []string{
+		digits(contact.CellPhone, contact.CellPhoneE164),
+		digits(contact.WorkPhone, contact.WorkPhoneE164),
+	}
+}
+
+// NormalizeName lower-cases a name, drops punctuation and sorts its words, so
+// "Smith, Jane" and "jane smith" compare equal
+func NormalizeName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// NameSimilarity is the Jaro-Winkler similarity of two normalized names,
+// between 0 and 1
+func NameSimilarity(a, b string) float64 {
+	a, b = NormalizeName(a), NormalizeName(b)
+	if a == "" || b == "" {
+		return 0
+	}
+	return jaroWinkler([]rune(a), []rune(b))
+}
+
+func jaroWinkler(a, b []rune) float64 {
+	sim := jaro(a, b)
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && prefix < 4 && a[prefix] == b[prefix] {
+		prefix++
+	}
+	return sim + float64(prefix)*0.1*(1-sim)
+}
+
+func jaro(a, b []rune) float64 {
+	if string(a) == string(b) {
+		return 1
+	}
+	window := max(len(a), len(b))/2 - 1
+	if window < 0 {
+		window = 0
+	}
+
+	matchedA := make([]bool, len(a))
+	matchedB := make([]bool, len(b))
+	matches := 0
+	for i := range a {
+		lo, hi := max(0, i-window), min(len(b), i+window+1)
+		for j := lo; j < hi; j++ {
+			if !matchedB[j] && a[i] == b[j] {
+				matchedA[i], matchedB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions, j := 0, 0
+	for i := range a {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if a[i] != b[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	return (m/float64(len(a)) + m/float64(len(b)) + (m-float64(transpositions)/2)/m) / 3
+}
@@ -0,0 +1,109 @@
+package dedupe
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/models"
+)
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		a, b      models.Contact
+		duplicate bool
+		reasons   []string
+	}{
+		{
+			models.Contact{Name: "Jane Smith", Email: "Jane.Smith+work@Example.com"},
+			models.Contact{Name: "Bob Jones", Email: "jane.smith@example.com"},
+			true, []string{models.DuplicateEmail},
+		},
+		{
+			models.Contact{Name: "Smith, Jane"},
+			models.Contact{Name: "jane smith"},
+			true, []string{models.DuplicateName},
+		},
+		{
+			models.Contact{Name: "Jane Smith", CellPhone: "(202) 555-0143"},
+			models.Contact{Name: "Jane Smyth", WorkPhone: "+1 202 555 0143"},
+			true, []string{models.DuplicatePhone, models.DuplicateName},
+		},
+		{
+			models.Contact{Name: "Jane Smith", CellPhone: "020 7946 0958", CellPhoneE164: "+442079460958"},
+			models.Contact{Name: "Bob Jones", CellPhone: "07946 0958", CellPhoneE164: "+442079460958"},
+			false, []string{models.DuplicatePhone},
+		},
+		{
+			models.Contact{Name: "Jane Smith", CellPhone: "555-01"},
+			models.Contact{Name: "Bob Jones", CellPhone: "555-01"},
+			false, []string{},
+		},
+		{
+			models.Contact{Name: "Jane Smith"},
+			models.Contact{Name: "Bob Jones"},
+			false, []string{},
+		},
+	}
+	for _, tt := range tests {
+		score, reasons := Score(tt.a, tt.b)
+		if (score >= DefaultMinScore) != tt.duplicate || score > 1 {
+			t.Errorf("Score(%q, %q) = %v, want a duplicate: %v", tt.a.Name, tt.b.Name, score, tt.duplicate)
+		}
+		if !reflect.DeepEqual(reasons, tt.reasons) {
+			t.Errorf("Score(%q, %q) reasons = %v, want %v", tt.a.Name, tt.b.Name, reasons, tt.reasons)
+		}
+	}
+
+	all, _ := Score(
+		models.Contact{Name: "Jane Smith", Email: "jane@example.com", CellPhone: "202 555 0143"},
+		models.Contact{Name: "Jane Smith", Email: "jane@example.com", CellPhone: "202 555 0143"},
+	)
+	if all != 1 {
+		t.Errorf("a contact matching on every field scores %v, want 1", all)
+	}
+}
+
+// TestClusters checks pairs link into clusters transitively, strongest
+// first, and never across businesses
+func TestClusters(t *testing.T) {
+	business, other := primitive.NewObjectID(), primitive.NewObjectID()
+	created := time.Now()
+	contact := func(businessID primitive.ObjectID, name, email string) models.Contact {
+		created = created.Add(time.Minute)
+		return models.Contact{ID: primitive.NewObjectID(), BusinessID: businessID, Name: name, Email: email, CreatedDate: created}
+	}
+	jane := contact(business, "Jane Smith", "jane@example.com")
+	janeWork := contact(business, "J. Smith", "jane@example.com")
+	smith := contact(business, "J Smith", "")
+	bob := contact(business, "Bob Jones", "bob@example.com")
+	bobs := contact(business, "Bob Jones", "")
+	janeElsewhere := contact(other, "Jane Smith", "jane@example.com")
+
+	clusters := Clusters([]models.Contact{bobs, smith, jane, bob, janeWork, janeElsewhere}, DefaultMinScore)
+	if len(clusters) != 2 {
+		t.Fatalf("got %d clusters, want 2: %+v", len(clusters), clusters)
+	}
+	ids := func(cluster models.DuplicateCluster) []primitive.ObjectID {
+		var ids []primitive.ObjectID
+		for _, contact := range cluster.Contacts {
+			ids = append(ids, contact.ID)
+		}
+		return ids
+	}
+	if got, want := ids(clusters[0]), []primitive.ObjectID{jane.ID, janeWork.ID, smith.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first cluster holds %v, want %v oldest first", got, want)
+	}
+	if got, want := ids(clusters[1]), []primitive.ObjectID{bob.ID, bobs.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second cluster holds %v, want %v", got, want)
+	}
+	if clusters[0].Score < clusters[1].Score {
+		t.Errorf("clusters scored %v then %v, want the strongest first", clusters[0].Score, clusters[1].Score)
+	}
+	for _, cluster := range clusters {
+		if cluster.BusinessID != business {
+			t.Errorf("a cluster belongs to business %s, want %s", cluster.BusinessID.Hex(), business.Hex())
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Package exporter writes streams of models as CSV, NDJSON or XLSX. Columns
+// are named after the JSON fields of the row type, so a CSV export can be
+// imported again without a column mapping.
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Export formats
+const (
+	CSV    = "csv"
+	NDJSON = "ndjson"
+	XLSX   = "xlsx"
+)
+
+// ContentTypes maps each export format to the media type it is served as
+var ContentTypes = map[string]string{
+	CSV:    "text/csv; charset=utf-8",
+	NDJSON: "application/x-ndjson",
+	XLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// Writer writes rows of type T. Close must be called to finish the file.
+type Writer[T any] interface {
+	Write(row T) error
+	Close() error
+}
+
+// NewWriter returns a writer for format on top of w
+func NewWriter[T any](format string, w io.Writer) (Writer[T], error) {
+	switch format {
+	case CSV:
+		return newCSVWriter[T](w)
+	case NDJSON:
+		return &ndjsonWriter[T]{enc: json.NewEncoder(w)}, nil
+	case XLSX:
+		return newXLSXWriter[T](w)
+	}
+	return nil, fmt.Errorf("format must be one of %s, %s or %s", CSV, NDJSON, XLSX)
+}
+
+// ndjsonWriter writes each row as one line of JSON, keeping every field
+type ndjsonWriter[T any] struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter[T]) Write(row T) error {
+	return n.enc.Encode(row)
+}
+
+func (n *ndjsonWriter[T]) Close() error {
+	return nil
+}
+
+type csvWriter[T any] struct {
+	w       *csv.Writer
+	columns []column
+}
+
+func newCSVWriter[T any](w io.Writer) (*csvWriter[T], error) {
+	cw := &csvWriter[T]{w: csv.NewWriter(w), columns: columnsOf(reflect.TypeOf((*T)(nil)).Elem())}
+	header := make([]string, len(cw.columns))
+	for i, col := range cw.columns {
+		header[i] = col.name
+	}
+	if err := cw.w.Write(header); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter[T]) Write(row T) error {
+	v := reflect.ValueOf(row)
+	record := make([]string, len(cw.columns))
+	for i, col := range cw.columns {
+		record[i] = format(v.FieldByIndex(col.index))
+		if col.kind.Kind() == reflect.String {
+			record[i] = escapeFormula(record[i])
+		}
+	}
+	return cw.w.Write(record)
+}
+
+// formulaPrefixes are the characters that make a spreadsheet read a cell as
+// a formula
+const formulaPrefixes = "=+-@\t\r"
+
+// escapeFormula prefixes text a spreadsheet would run as a formula with a
+// quote, so an exported name such as =HYPERLINK(...) opens as plain text.
+// The importer drops the quote again.
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune(formulaPrefixes, rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (cw *csvWriter[T]) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+// column is one exported struct field
+type column struct {
+	name  string
+	index []int
+	kind  reflect.Type
+}
+
+// columnsOf lists the exportable fields of t in declaration order, flattening
+// embedded structs the way encoding/json does. Fields without a flat text
+// form, such as nested objects, are left out.
+func columnsOf(t reflect.Type) []column {
+	var columns []column
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for _, inner := range columnsOf(f.Type) {
+				inner.index = append([]int{i}, inner.index...)
+				columns = append(columns, inner)
+			}
+			continue
+		}
+		if !f.IsExported() || name == "-" || !supported(f.Type) {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		columns = append(columns, column{name: name, index: []int{i}, kind: f.Type})
+	}
+	return columns
+}
+
+func supported(t reflect.Type) bool {
+	switch t {
+	case timeType, objectIDType:
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int32, reflect.Int64, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// format renders a field as text. Zero dates and IDs become empty cells.
+func format(v reflect.Value) string {
+	switch v.Type() {
+	case timeType:
+		t := v.Interface().(time.Time)
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	case objectIDType:
+		id := v.Interface().(primitive.ObjectID)
+		if id.IsZero() {
+			return ""
+		}
+		return id.Hex()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	}
+	return ""
+}
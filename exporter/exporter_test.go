@@ -0,0 +1,144 @@
+package exporter
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/importer"
+)
+
+type base struct {
+	ID primitive.ObjectID `json:"id"`
+}
+
+type row struct {
+	base
+	Name    string             `json:"name"`
+	Count   int                `json:"count"`
+	Active  bool               `json:"active"`
+	Born    time.Time          `json:"born"`
+	OwnerID primitive.ObjectID `json:"owner_id"`
+	Tags    []string           `json:"tags"`
+	Secret  string             `json:"-"`
+}
+
+func write(t *testing.T, format string, rows ...row) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := NewWriter[row](format, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// TestCSVImportsBack checks a CSV export flattens embedded fields, leaves out
+// what has no flat form, quotes formulas and imports again unchanged
+func TestCSVImportsBack(t *testing.T) {
+	exported := row{
+		base:   base{ID: primitive.NewObjectID()},
+		Name:   "=HYPERLINK(\"http://example.com\")",
+		Count:  -3,
+		Active: true,
+		Born:   time.Date(1815, 12, 10, 0, 0, 0, 0, time.UTC),
+		Tags:   []string{"a"},
+		Secret: "hidden",
+	}
+	records, err := csv.NewReader(bytes.NewReader(write(t, CSV, exported))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"id", "name", "count", "active", "born", "owner_id"},
+		{exported.ID.Hex(), "'" + exported.Name, "-3", "true", "1815-12-10T00:00:00Z", ""},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("CSV = %q, want %q", records, want)
+	}
+
+	type imported struct {
+		Name   string    `json:"name"`
+		Count  int       `json:"count"`
+		Active bool      `json:"active"`
+		Born   time.Time `json:"born"`
+	}
+	d, err := importer.NewDecoder[imported](records[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Decode(records[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != exported.Name || got.Count != exported.Count || got.Active != exported.Active || !got.Born.Equal(exported.Born) {
+		t.Errorf("imported %+v from %q", got, records[1])
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	out := string(write(t, NDJSON, row{Name: "Ada"}, row{Name: "Grace"}))
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"name":"Ada"`) || !strings.Contains(lines[1], `"tags":null`) {
+		t.Errorf("NDJSON = %q", out)
+	}
+}
+
+// TestXLSX checks the workbook holds a sheet with typed number and boolean
+// cells and escaped text
+func TestXLSX(t *testing.T) {
+	out := write(t, XLSX, row{Name: "Ada & <Grace>", Count: 7, Active: true})
+	archive, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sheet string
+	for _, f := range archive.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sheet = string(body)
+	}
+	for _, cell := range []string{
+		`<t xml:space="preserve">owner_id</t>`,
+		`<t xml:space="preserve">Ada &amp; &lt;Grace&gt;</t>`,
+		`<c><v>7</v></c>`,
+		`<c t="b"><v>1</v></c>`,
+	} {
+		if !strings.Contains(sheet, cell) {
+			t.Errorf("sheet has no %s in %s", cell, sheet)
+		}
+	}
+	if !strings.HasSuffix(sheet, xlsxSheetEnd) {
+		t.Error("the sheet is not closed")
+	}
+}
+
+func TestNewWriterFormats(t *testing.T) {
+	if _, err := NewWriter[row]("pdf", io.Discard); err == nil {
+		t.Error("an unknown format was accepted")
+	}
+}
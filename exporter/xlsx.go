@@ -0,0 +1,133 @@
+package exporter
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+)
+
+// The fixed parts of a single-sheet workbook. Cells use inline strings so
+// rows can be written as they arrive instead of collecting a shared string
+// table first.
+const (
+	xlsxContentTypes = xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`
+	xlsxRootRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+	xlsxWorkbook = xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Export" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`
+	xlsxSheetStart = xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd   = `</sheetData></worksheet>`
+)
+
+// xlsxWriter streams rows into the worksheet entry of a zip archive, which
+// is written straight through to the underlying writer
+type xlsxWriter[T any] struct {
+	zip     *zip.Writer
+	sheet   io.Writer
+	columns []column
+}
+
+func newXLSXWriter[T any](w io.Writer) (*xlsxWriter[T], error) {
+	xw := &xlsxWriter[T]{zip: zip.NewWriter(w), columns: columnsOf(reflect.TypeOf((*T)(nil)).Elem())}
+	for _, part := range []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	} {
+		f, err := xw.zip.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := xw.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	xw.sheet = sheet
+	if _, err := io.WriteString(sheet, xlsxSheetStart); err != nil {
+		return nil, err
+	}
+
+	header := make([]string, len(xw.columns))
+	for i, col := range xw.columns {
+		header[i] = col.name
+	}
+	if err := xw.writeRow(header, nil); err != nil {
+		return nil, err
+	}
+	return xw, nil
+}
+
+func (xw *xlsxWriter[T]) Write(row T) error {
+	v := reflect.ValueOf(row)
+	cells := make([]string, len(xw.columns))
+	kinds := make([]reflect.Kind, len(xw.columns))
+	for i, col := range xw.columns {
+		field := v.FieldByIndex(col.index)
+		cells[i] = format(field)
+		if col.kind != timeType && col.kind != objectIDType {
+			kinds[i] = col.kind.Kind()
+		}
+	}
+	return xw.writeRow(cells, kinds)
+}
+
+// writeRow writes numbers and booleans as typed cells and everything else,
+// including the header, as inline strings
+func (xw *xlsxWriter[T]) writeRow(cells []string, kinds []reflect.Kind) error {
+	buf := []byte("<row>")
+	for i, cell := range cells {
+		var kind reflect.Kind
+		if kinds != nil {
+			kind = kinds[i]
+		}
+		switch kind {
+		case reflect.Int, reflect.Int32, reflect.Int64, reflect.Float64:
+			buf = append(buf, `<c><v>`...)
+			buf = append(buf, cell...)
+			buf = append(buf, `</v></c>`...)
+		case reflect.Bool:
+			b := "0"
+			if cell == "true" {
+				b = "1"
+			}
+			buf = append(buf, `<c t="b"><v>`+b+`</v></c>`...)
+		default:
+			if cell == "" {
+				buf = append(buf, `<c/>`...)
+				continue
+			}
+			buf = append(buf, `<c t="inlineStr"><is><t xml:space="preserve">`...)
+			var escaped bytes.Buffer
+			xml.EscapeText(&escaped, []byte(cell))
+			buf = append(buf, escaped.Bytes()...)
+			buf = append(buf, `</t></is></c>`...)
+		}
+	}
+	buf = append(buf, "</row>"...)
+	_, err := xw.sheet.Write(buf)
+	return err
+}
+
+func (xw *xlsxWriter[T]) Close() error {
+	if _, err := io.WriteString(xw.sheet, xlsxSheetEnd); err != nil {
+		return err
+	}
+	return xw.zip.Close()
+}
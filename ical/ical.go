@@ -0,0 +1,108 @@
+// Package ical writes iCalendar (RFC 5545) feeds of timed events
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLineOctets is the folding limit from RFC 5545 section 3.1
+const maxLineOctets = 75
+
+// utcLayout is the UTC DATE-TIME form
+const utcLayout = "20060102T150405Z"
+
+// Calendar is a named collection of events
+type Calendar struct {
+	ProdID string
+	Name   string
+	Events []Event
+}
+
+// Event is one VEVENT. UID must stay the same across feed refreshes so
+// calendar apps update the event instead of adding a copy.
+type Event struct {
+	UID          string
+	Start        time.Time
+	Duration     time.Duration
+	Summary      string
+	Description  string
+	URL          string
+	LastModified time.Time
+}
+
+// Write encodes the calendar, stamping every event with now
+func Write(w io.Writer, cal Calendar, now time.Time) error {
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeFolded(bw, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", cal.ProdID)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if cal.Name != "" {
+		line("X-WR-CALNAME", escape(cal.Name))
+	}
+	for _, e := range cal.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", e.UID)
+		line("DTSTAMP", now.UTC().Format(utcLayout))
+		line("DTSTART", e.Start.UTC().Format(utcLayout))
+		if e.Duration > 0 {
+			line("DURATION", duration(e.Duration))
+		}
+		line("SUMMARY", escape(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", escape(e.Description))
+		}
+		if e.URL != "" {
+			line("URL", e.URL)
+		}
+		if !e.LastModified.IsZero() {
+			line("LAST-MODIFIED", e.LastModified.UTC().Format(utcLayout))
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+// duration formats d as an RFC 5545 DURATION in whole minutes, such as PT30M
+func duration(d time.Duration) string {
+	minutes := int(d.Round(time.Minute) / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	return "PT" + strconv.Itoa(minutes) + "M"
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+
+// escape makes text safe for a TEXT property value
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// writeFolded writes a content line with CRLF, folding it at 75 octets
+// without splitting a UTF-8 sequence
+func writeFolded(w *bufio.Writer, l string) {
+	limit := maxLineOctets
+	for len(l) > limit {
+		cut := limit
+		for cut > 0 && l[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(l[:cut])
+		w.WriteString("\r\n ")
+		l = l[cut:]
+		limit = maxLineOctets - 1
+	}
+	w.WriteString(l)
+	w.WriteString("\r\n")
+}
@@ -0,0 +1,115 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unfold reads a written feed back into content lines, undoing folding
+func unfold(t *testing.T, feed string) []string {
+	t.Helper()
+	if !strings.HasSuffix(feed, "\r\n") {
+		t.Fatalf("feed does not end with CRLF: %q", feed)
+	}
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSuffix(feed, "\r\n"), "\r\n") {
+		if len(l) > maxLineOctets {
+			t.Errorf("line of %d octets is not folded: %q", len(l), l)
+		}
+		if strings.HasPrefix(l, " ") {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func TestWrite(t *testing.T) {
+	now := time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)
+	local := time.FixedZone("EST", -5*60*60)
+	summary := "Call back: Ada, about the engine; " + strings.Repeat("très urgent ", 8)
+	cal := Calendar{
+		ProdID: "-//Example//Followups//EN",
+		Name:   "Follow-ups, Ada's",
+		Events: []Event{
+			{
+				UID:          "abc@example.com",
+				Start:        time.Date(2024, 5, 3, 9, 30, 0, 0, local),
+				Duration:     29*time.Minute + 40*time.Second,
+				Summary:      summary,
+				Description:  "line one\nline two \\ done",
+				URL:          "https://example.com/calls/abc",
+				LastModified: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+			},
+			{UID: "def@example.com", Start: now, Duration: 10 * time.Second, Summary: "Short"},
+			{UID: "ghi@example.com", Start: now, Summary: "Untimed"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, cal, now); err != nil {
+		t.Fatal(err)
+	}
+	got := unfold(t, buf.String())
+	want := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Example//Followups//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		`X-WR-CALNAME:Follow-ups\, Ada's`,
+		"BEGIN:VEVENT",
+		"UID:abc@example.com",
+		"DTSTAMP:20240502T080000Z",
+		"DTSTART:20240503T143000Z",
+		"DURATION:PT30M",
+		`SUMMARY:Call back: Ada\, about the engine\; ` + strings.Repeat("très urgent ", 8),
+		`DESCRIPTION:line one\nline two \\ done`,
+		"URL:https://example.com/calls/abc",
+		"LAST-MODIFIED:20240501T120000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:def@example.com",
+		"DTSTAMP:20240502T080000Z",
+		"DTSTART:20240502T080000Z",
+		"DURATION:PT1M",
+		"SUMMARY:Short",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:ghi@example.com",
+		"DTSTAMP:20240502T080000Z",
+		"DTSTART:20240502T080000Z",
+		"SUMMARY:Untimed",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Write gave\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestWriteFoldsRunes checks folding never splits a UTF-8 sequence
+func TestWriteFoldsRunes(t *testing.T) {
+	var buf bytes.Buffer
+	cal := Calendar{ProdID: "-//Example//EN", Name: strings.Repeat("€", 60)}
+	if err := Write(&buf, cal, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range strings.Split(buf.String(), "\r\n") {
+		if !strings.ContainsRune(l, '€') {
+			continue
+		}
+		if !strings.HasPrefix(l, "X-WR-CALNAME:") && !strings.HasPrefix(l, " ") {
+			t.Errorf("line %q is not part of the folded name", l)
+		}
+		if strings.ContainsRune(l, '�') || !strings.HasSuffix(l, "€") {
+			t.Errorf("line %q splits a rune", l)
+		}
+	}
+	if lines := unfold(t, buf.String()); lines[5] != "X-WR-CALNAME:"+strings.Repeat("€", 60) {
+		t.Errorf("name unfolds to %q", lines[5])
+	}
+}
@@ -0,0 +1,187 @@
+// Package importer turns CSV records into models, using a mapping from model
+// fields to CSV columns.
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Mapping maps a model field, by its JSON name, to the header of the CSV
+// column holding it
+type Mapping map[string]string
+
+// dateLayouts are the date formats accepted for time fields
+var dateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+// column binds one CSV column to one struct field
+type column struct {
+	name  string
+	index int
+	field int
+	kind  reflect.Type
+}
+
+// Decoder builds documents of type T from CSV records
+type Decoder[T any] struct {
+	columns []column
+}
+
+// NewDecoder matches the header against the fields of T. With an empty
+// mapping every header named after a field (ignoring case) is imported and
+// other headers are ignored; otherwise only the mapped fields are imported
+// and every mapped column must be present. Fields named in exclude, such as
+// IDs and timestamps the server assigns, can never be imported.
+func NewDecoder[T any](header []string, mapping Mapping, exclude ...string) (*Decoder[T], error) {
+	fields := importableFields(reflect.TypeOf((*T)(nil)).Elem(), exclude)
+
+	positions := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		positions[strings.ToLower(name)] = i
+	}
+
+	d := &Decoder[T]{}
+	if len(mapping) == 0 {
+		for name, f := range fields {
+			if i, ok := positions[name]; ok {
+				d.columns = append(d.columns, column{name: name, index: i, field: f.Index[0], kind: f.Type})
+			}
+		}
+	} else {
+		for name, header := range mapping {
+			f, ok := fields[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("mapping: %s is not an importable field", name)
+			}
+			i, ok := positions[strings.ToLower(strings.TrimSpace(header))]
+			if !ok {
+				return nil, fmt.Errorf("mapping: column %q for %s is not in the header", header, name)
+			}
+			d.columns = append(d.columns, column{name: name, index: i, field: f.Index[0], kind: f.Type})
+		}
+	}
+	if len(d.columns) == 0 {
+		return nil, errors.New("no column matches an importable field")
+	}
+	sort.Slice(d.columns, func(i, j int) bool { return d.columns[i].index < d.columns[j].index })
+	return d, nil
+}
+
+// Decode builds a document from one record. Empty cells leave the field at
+// its zero value.
+func (d *Decoder[T]) Decode(record []string) (T, error) {
+	var doc T
+	v := reflect.ValueOf(&doc).Elem()
+	for _, col := range d.columns {
+		if col.index >= len(record) {
+			return doc, fmt.Errorf("row has %d columns, %s is in column %d", len(record), col.name, col.index+1)
+		}
+		raw := strings.TrimSpace(record[col.index])
+		if raw == "" {
+			continue
+		}
+		value, err := parse(raw, col.kind)
+		if err != nil {
+			return doc, fmt.Errorf("%s: %v", col.name, err)
+		}
+		v.Field(col.field).Set(value)
+	}
+	return doc, nil
+}
+
+// importableFields indexes the top-level fields of t that parse knows how to
+// fill by their lower-cased JSON names
+func importableFields(t reflect.Type, exclude []string) map[string]reflect.StructField {
+	skip := make(map[string]bool, len(exclude))
+	for _, name := range exclude {
+		skip[name] = true
+	}
+
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "" || name == "-" || skip[name] || !supported(f.Type) {
+			continue
+		}
+		fields[strings.ToLower(name)] = f
+	}
+	return fields
+}
+
+func supported(t reflect.Type) bool {
+	switch t {
+	case timeType, objectIDType:
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int32, reflect.Int64, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func parse(raw string, t reflect.Type) (reflect.Value, error) {
+	switch t {
+	case timeType:
+		for _, layout := range dateLayouts {
+			if parsed, err := time.Parse(layout, raw); err == nil {
+				return reflect.ValueOf(parsed), nil
+			}
+		}
+		return reflect.Value{}, fmt.Errorf("%q is not a date, use YYYY-MM-DD or RFC 3339", raw)
+	case objectIDType:
+		id, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not a valid ID", raw)
+		}
+		return reflect.ValueOf(id), nil
+	}
+
+	value := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		value.SetString(unescapeFormula(raw))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not true or false", raw)
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not a whole number", raw)
+		}
+		value.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not a number", raw)
+		}
+		value.SetFloat(f)
+	}
+	return value, nil
+}
+
+// unescapeFormula drops the quote the exporter puts before text a
+// spreadsheet would otherwise run as a formula, so exports import unchanged
+func unescapeFormula(raw string) string {
+	if len(raw) > 1 && raw[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(raw[1])) {
+		return raw[1:]
+	}
+	return raw
+}
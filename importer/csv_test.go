@@ -0,0 +1,81 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type row struct {
+	ID     primitive.ObjectID `json:"id"`
+	Name   string             `json:"name"`
+	Count  int                `json:"count"`
+	Score  float64            `json:"score"`
+	Active bool               `json:"active"`
+	Born   time.Time          `json:"born"`
+	Tags   []string           `json:"tags"`
+}
+
+func TestDecodeByHeader(t *testing.T) {
+	d, err := NewDecoder[row]([]string{"\ufeffName", " COUNT ", "score", "active", "born", "tags", "id", "notes"}, nil, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := primitive.NewObjectID().Hex()
+	got, err := d.Decode([]string{"'=SUM(A1)", "3", "", "true", "2024-02-29", "a,b", id, "ignored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := row{Name: "=SUM(A1)", Count: 3, Active: true, Born: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)}
+	if got.Name != want.Name || got.Count != want.Count || got.Score != 0 || got.Active != want.Active ||
+		!got.Born.Equal(want.Born) || got.Tags != nil || !got.ID.IsZero() {
+		t.Errorf("Decode = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeByMapping(t *testing.T) {
+	header := []string{"Full name", "Visits"}
+	if _, err := NewDecoder[row](header, Mapping{"name": "Full name", "count": "Calls"}); err == nil {
+		t.Error("a mapping to a missing column was accepted")
+	}
+	if _, err := NewDecoder[row](header, Mapping{"tags": "Visits"}); err == nil {
+		t.Error("a mapping to a field that cannot be imported was accepted")
+	}
+	if _, err := NewDecoder[row]([]string{"nickname"}, nil); err == nil {
+		t.Error("a header matching no field was accepted")
+	}
+
+	d, err := NewDecoder[row](header, Mapping{"Name": "full name", "count": "Visits"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Decode([]string{"Ada", "12"})
+	if err != nil || got.Name != "Ada" || got.Count != 12 {
+		t.Errorf("Decode = %+v, %v", got, err)
+	}
+}
+
+func TestDecodeFails(t *testing.T) {
+	d, err := NewDecoder[row]([]string{"name", "count", "score", "active", "born", "id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		record  []string
+		message string
+	}{
+		{[]string{"Ada", "three", "", "", "", ""}, "count:"},
+		{[]string{"Ada", "", "high", "", "", ""}, "score:"},
+		{[]string{"Ada", "", "", "yes", "", ""}, "active:"},
+		{[]string{"Ada", "", "", "", "29/02/2024", ""}, "born:"},
+		{[]string{"Ada", "", "", "", "", "42"}, "id:"},
+		{[]string{"Ada", "1"}, "row has 2 columns"},
+	}
+	for _, tt := range tests {
+		if _, err := d.Decode(tt.record); err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("Decode(%q): %v, want %q", tt.record, err, tt.message)
+		}
+	}
+}
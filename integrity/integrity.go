@@ -0,0 +1,315 @@
+// Package integrity decides what deleting a document does to the documents
+// that reference it. Each relation has a policy: restrict refuses the delete,
+// cascade deletes the referencing documents too, set_null clears their
+// reference to the zero ID the models use for "none" and reassign hands it to
+// another user.
+package integrity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Policy is what happens to the documents referencing a deleted document
+type Policy string
+
+const (
+	Restrict Policy = "restrict"
+	Cascade  Policy = "cascade"
+	SetNull  Policy = "set_null"
+	Reassign Policy = "reassign"
+)
+
+// Resources whose documents take part in relations
+const (
+	Users      = "users"
+	Emojis     = "emojis"
+	Contacts   = "contacts"
+	Businesses = "businesses"
+	Calls      = "calls"
+)
+
+// Relation is a reference field on the documents of Resource pointing at a document of Target
+type Relation struct {
+	Resource string
+	Field    string
+	Target   string
+}
+
+// Name identifies the relation in configuration and reports, as in businesses.user_id
+func (r Relation) Name() string {
+	return r.Resource + "." + r.Field
+}
+
+// Relations lists every reference between resources. The activity history
+// keeps its references to deleted documents, so it is not listed.
+var Relations = []Relation{
+	{Resource: Businesses, Field: "user_id", Target: Users},
+	{Resource: Contacts, Field: "user_id", Target: Users},
+	{Resource: Calls, Field: "user_id", Target: Users},
+	{Resource: Businesses, Field: "emoji_id", Target: Emojis},
+	{Resource: Businesses, Field: "contact_id", Target: Contacts},
+	{Resource: Calls, Field: "contact_id", Target: Contacts},
+	{Resource: Contacts, Field: "business_id", Target: Businesses},
+	{Resource: Calls, Field: "business_id", Target: Businesses},
+}
+
+// Policies maps relation names to their policy
+type Policies map[string]Policy
+
+// DefaultPolicies keep a user's data from being deleted by accident, take a
+// business's contacts and calls with it and clear every other reference
+var DefaultPolicies = Policies{
+	"businesses.user_id":    Restrict,
+	"contacts.user_id":      Restrict,
+	"calls.user_id":         Restrict,
+	"businesses.emoji_id":   SetNull,
+	"businesses.contact_id": SetNull,
+	"calls.contact_id":      SetNull,
+	"contacts.business_id":  Cascade,
+	"calls.business_id":     Cascade,
+}
+
+// With returns the default policies overridden by overrides, checking that
+// every override names a relation and a policy it can take
+func (p Policies) With(overrides Policies) (Policies, error) {
+	merged := make(Policies, len(p))
+	for name, policy := range p {
+		merged[name] = policy
+	}
+
+	var errs []error
+	for name, policy := range overrides {
+		relation, ok := RelationNamed(name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown relation %q", name))
+			continue
+		}
+		switch policy {
+		case Restrict, Cascade, SetNull:
+		case Reassign:
+			if relation.Target != Users {
+				errs = append(errs, fmt.Errorf("%s cannot use reassign; only references to users can be reassigned", name))
+				continue
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown policy %q, use restrict, cascade, set_null or reassign", name, policy))
+			continue
+		}
+		merged[name] = policy
+	}
+	return merged, errors.Join(errs...)
+}
+
+// RelationNamed looks up a relation by its name
+func RelationNamed(name string) (Relation, bool) {
+	for _, r := range Relations {
+		if r.Name() == name {
+			return r, true
+		}
+	}
+	return Relation{}, false
+}
+
+// Store is the access to the stored documents a delete needs
+type Store interface {
+	Exists(ctx context.Context, resource string, id primitive.ObjectID) (bool, error)
+	// Refs returns the IDs of the documents of resource whose field holds one of ids
+	Refs(ctx context.Context, resource, field string, ids []primitive.ObjectID) ([]primitive.ObjectID, error)
+	// SetRefs points field of the given documents at to
+	SetRefs(ctx context.Context, resource, field string, ids []primitive.ObjectID, to primitive.ObjectID) error
+	DeleteMany(ctx context.Context, resource string, ids []primitive.ObjectID) error
+}
+
+// Options controls one delete
+type Options struct {
+	Policies Policies
+	// ReassignTo is the user that relations with the reassign policy hand their references to
+	ReassignTo primitive.ObjectID
+	DryRun     bool
+}
+
+// Report lists every document a delete removed or changed, or would have when
+// DryRun is set. Deleted is keyed by resource and includes the document asked
+// for; SetNull and Reassigned are keyed by relation.
+type Report struct {
+	DryRun       bool                            `json:"dry_run"`
+	Deleted      map[string][]primitive.ObjectID `json:"deleted"`
+	SetNull      map[string][]primitive.ObjectID `json:"set_null"`
+	Reassigned   map[string][]primitive.ObjectID `json:"reassigned"`
+	ReassignedTo *primitive.ObjectID             `json:"reassigned_to,omitempty"`
+}
+
+// RestrictError is returned when a relation with the restrict policy still
+// has documents referencing what would be deleted
+type RestrictError struct {
+	Relation string
+	Count    int
+}
+
+func (e *RestrictError) Error() string {
+	resource := strings.SplitN(e.Relation, ".", 2)[0]
+	return fmt.Sprintf("%d %s still reference it and %s is restricted", e.Count, resource, e.Relation)
+}
+
+// ErrNotFound is returned when the document to delete does not exist
+var ErrNotFound = errors.New("document not found")
+
+// ErrNoReassignTarget is returned when a reassign relation has references but no user to hand them to
+var ErrNoReassignTarget = errors.New("choose a user to reassign to with reassign_to")
+
+// ErrReassignTarget is returned when the user to reassign to is missing or is being deleted itself
+var ErrReassignTarget = errors.New("the user to reassign to does not exist or is being deleted")
+
+// Plan works out everything deleting the document of resource with the given
+// ID involves, following cascades to any depth, without changing anything
+func Plan(ctx context.Context, store Store, resource string, id primitive.ObjectID, opts Options) (Report, error) {
+	report := Report{
+		DryRun:     opts.DryRun,
+		Deleted:    map[string][]primitive.ObjectID{},
+		SetNull:    map[string][]primitive.ObjectID{},
+		Reassigned: map[string][]primitive.ObjectID{},
+	}
+
+	exists, err := store.Exists(ctx, resource, id)
+	if err != nil {
+		return report, err
+	}
+	if !exists {
+		return report, ErrNotFound
+	}
+
+	deleted := map[string]map[primitive.ObjectID]bool{}
+	type batch struct {
+		resource string
+		ids      []primitive.ObjectID
+	}
+	type pending struct {
+		relation Relation
+		ids      []primitive.ObjectID
+	}
+	var held []pending
+
+	queue := []batch{{resource, []primitive.ObjectID{id}}}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+
+		if deleted[next.resource] == nil {
+			deleted[next.resource] = map[primitive.ObjectID]bool{}
+		}
+		var ids []primitive.ObjectID
+		for _, id := range next.ids {
+			if !deleted[next.resource][id] {
+				deleted[next.resource][id] = true
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		report.Deleted[next.resource] = append(report.Deleted[next.resource], ids...)
+
+		for _, relation := range Relations {
+			if relation.Target != next.resource {
+				continue
+			}
+			refs, err := store.Refs(ctx, relation.Resource, relation.Field, ids)
+			if err != nil {
+				return report, err
+			}
+			if len(refs) == 0 {
+				continue
+			}
+			if opts.Policies[relation.Name()] == Cascade {
+				queue = append(queue, batch{relation.Resource, refs})
+			} else {
+				held = append(held, pending{relation, refs})
+			}
+		}
+	}
+
+	// A reference only matters if its document survives the cascades, which
+	// are only all known once the queue is drained
+	for _, p := range held {
+		var survivors []primitive.ObjectID
+		for _, ref := range p.ids {
+			if !deleted[p.relation.Resource][ref] {
+				survivors = append(survivors, ref)
+			}
+		}
+		if len(survivors) == 0 {
+			continue
+		}
+
+		name := p.relation.Name()
+		switch opts.Policies[name] {
+		case SetNull:
+			report.SetNull[name] = append(report.SetNull[name], survivors...)
+		case Reassign:
+			if opts.ReassignTo.IsZero() {
+				return report, ErrNoReassignTarget
+			}
+			if deleted[Users][opts.ReassignTo] {
+				return report, ErrReassignTarget
+			}
+			report.Reassigned[name] = append(report.Reassigned[name], survivors...)
+			report.ReassignedTo = &opts.ReassignTo
+		default:
+			return report, &RestrictError{Relation: name, Count: len(survivors)}
+		}
+	}
+
+	if report.ReassignedTo != nil {
+		exists, err := store.Exists(ctx, Users, *report.ReassignedTo)
+		if err != nil {
+			return report, err
+		}
+		if !exists {
+			return report, ErrReassignTarget
+		}
+	}
+
+	for _, ids := range report.Deleted {
+		sortIDs(ids)
+	}
+	for _, ids := range report.SetNull {
+		sortIDs(ids)
+	}
+	for _, ids := range report.Reassigned {
+		sortIDs(ids)
+	}
+	return report, nil
+}
+
+// Apply carries out a planned delete, clearing and reassigning references
+// before removing documents
+func Apply(ctx context.Context, store Store, report Report) error {
+	for name, ids := range report.SetNull {
+		relation, _ := RelationNamed(name)
+		if err := store.SetRefs(ctx, relation.Resource, relation.Field, ids, primitive.NilObjectID); err != nil {
+			return fmt.Errorf("clearing %s: %w", name, err)
+		}
+	}
+	for name, ids := range report.Reassigned {
+		relation, _ := RelationNamed(name)
+		if err := store.SetRefs(ctx, relation.Resource, relation.Field, ids, *report.ReassignedTo); err != nil {
+			return fmt.Errorf("reassigning %s: %w", name, err)
+		}
+	}
+	for resource, ids := range report.Deleted {
+		if err := store.DeleteMany(ctx, resource, ids); err != nil {
+			return fmt.Errorf("deleting %s: %w", resource, err)
+		}
+	}
+	return nil
+}
+
+func sortIDs(ids []primitive.ObjectID) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Hex() < ids[j].Hex() })
+}
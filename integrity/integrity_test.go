@@ -0,0 +1,195 @@
+package integrity
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// store keeps documents as their reference fields, by resource and ID
+type store map[string]map[primitive.ObjectID]map[string]primitive.ObjectID
+
+func (s store) add(resource string, refs map[string]primitive.ObjectID) primitive.ObjectID {
+	id := primitive.NewObjectID()
+	if s[resource] == nil {
+		s[resource] = map[primitive.ObjectID]map[string]primitive.ObjectID{}
+	}
+	if refs == nil {
+		refs = map[string]primitive.ObjectID{}
+	}
+	s[resource][id] = refs
+	return id
+}
+
+func (s store) Exists(ctx context.Context, resource string, id primitive.ObjectID) (bool, error) {
+	_, ok := s[resource][id]
+	return ok, nil
+}
+
+func (s store) Refs(ctx context.Context, resource, field string, ids []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	var refs []primitive.ObjectID
+	for id, doc := range s[resource] {
+		for _, target := range ids {
+			if doc[field] == target {
+				refs = append(refs, id)
+			}
+		}
+	}
+	return refs, nil
+}
+
+func (s store) SetRefs(ctx context.Context, resource, field string, ids []primitive.ObjectID, to primitive.ObjectID) error {
+	for _, id := range ids {
+		s[resource][id][field] = to
+	}
+	return nil
+}
+
+func (s store) DeleteMany(ctx context.Context, resource string, ids []primitive.ObjectID) error {
+	for _, id := range ids {
+		delete(s[resource], id)
+	}
+	return nil
+}
+
+func sorted(ids ...primitive.ObjectID) []primitive.ObjectID {
+	sortIDs(ids)
+	return ids
+}
+
+// TestCascade checks deleting a business takes its contacts and their calls
+// with it, at any depth, and clears the references left behind
+func TestCascade(t *testing.T) {
+	ctx := context.Background()
+	s := store{}
+	user := s.add(Users, nil)
+	business := s.add(Businesses, map[string]primitive.ObjectID{"user_id": user})
+	contact := s.add(Contacts, map[string]primitive.ObjectID{"user_id": user, "business_id": business})
+	call := s.add(Calls, map[string]primitive.ObjectID{"user_id": user, "contact_id": contact})
+	other := s.add(Businesses, map[string]primitive.ObjectID{"user_id": user, "contact_id": contact})
+
+	policies := DefaultPolicies
+	report, err := Plan(ctx, s, Businesses, business, Options{Policies: policies, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDeleted := map[string][]primitive.ObjectID{Businesses: {business}, Contacts: {contact}}
+	if !reflect.DeepEqual(report.Deleted, wantDeleted) {
+		t.Errorf("deleted %v, want %v", report.Deleted, wantDeleted)
+	}
+	wantSetNull := map[string][]primitive.ObjectID{"calls.contact_id": {call}, "businesses.contact_id": {other}}
+	if !reflect.DeepEqual(report.SetNull, wantSetNull) {
+		t.Errorf("set null %v, want %v", report.SetNull, wantSetNull)
+	}
+	if _, ok := s[Businesses][business]; !ok {
+		t.Error("planning deleted the business")
+	}
+
+	policies, err = DefaultPolicies.With(Policies{"calls.contact_id": Cascade})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = Plan(ctx, s, Businesses, business, Options{Policies: policies})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(ctx, s, report); err != nil {
+		t.Fatal(err)
+	}
+	for resource, id := range map[string]primitive.ObjectID{Businesses: business, Contacts: contact, Calls: call} {
+		if _, ok := s[resource][id]; ok {
+			t.Errorf("%s %s was not deleted", resource, id.Hex())
+		}
+	}
+	if ref := s[Businesses][other]["contact_id"]; !ref.IsZero() {
+		t.Errorf("the other business still references contact %s", ref.Hex())
+	}
+}
+
+// TestRestrict checks a restricted reference refuses the delete unless the
+// document holding it is deleted by the same cascade
+func TestRestrict(t *testing.T) {
+	ctx := context.Background()
+	s := store{}
+	user := s.add(Users, nil)
+	s.add(Contacts, map[string]primitive.ObjectID{"user_id": user})
+	s.add(Contacts, map[string]primitive.ObjectID{"user_id": user})
+
+	_, err := Plan(ctx, s, Users, user, Options{Policies: DefaultPolicies})
+	var restricted *RestrictError
+	if !errors.As(err, &restricted) || restricted.Relation != "contacts.user_id" || restricted.Count != 2 {
+		t.Fatalf("err = %v, want contacts.user_id restricting the delete with 2 contacts", err)
+	}
+
+	contact := s.add(Contacts, map[string]primitive.ObjectID{"user_id": user})
+	call := s.add(Calls, map[string]primitive.ObjectID{"user_id": user, "contact_id": contact})
+	policies, err := DefaultPolicies.With(Policies{"contacts.user_id": Cascade, "calls.contact_id": Cascade})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Plan(ctx, s, Users, user, Options{Policies: policies})
+	if err != nil {
+		t.Fatalf("a call cascading away with its contact restricted the delete: %v", err)
+	}
+	if want := []primitive.ObjectID{call}; !reflect.DeepEqual(report.Deleted[Calls], want) {
+		t.Errorf("deleted calls %v, want %v", report.Deleted[Calls], want)
+	}
+}
+
+// TestReassign checks references to a deleted user are handed to the user
+// asked for, who must exist and survive the delete
+func TestReassign(t *testing.T) {
+	ctx := context.Background()
+	s := store{}
+	user := s.add(Users, nil)
+	heir := s.add(Users, nil)
+	first := s.add(Contacts, map[string]primitive.ObjectID{"user_id": user})
+	second := s.add(Contacts, map[string]primitive.ObjectID{"user_id": user})
+	policies, err := DefaultPolicies.With(Policies{"contacts.user_id": Reassign})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Plan(ctx, s, Users, user, Options{Policies: policies}); !errors.Is(err, ErrNoReassignTarget) {
+		t.Errorf("without a user to reassign to: %v, want %v", err, ErrNoReassignTarget)
+	}
+	for _, to := range []primitive.ObjectID{user, primitive.NewObjectID()} {
+		if _, err := Plan(ctx, s, Users, user, Options{Policies: policies, ReassignTo: to}); !errors.Is(err, ErrReassignTarget) {
+			t.Errorf("reassigning to %s: %v, want %v", to.Hex(), err, ErrReassignTarget)
+		}
+	}
+
+	report, err := Plan(ctx, s, Users, user, Options{Policies: policies, ReassignTo: heir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sorted(first, second); !reflect.DeepEqual(report.Reassigned["contacts.user_id"], want) {
+		t.Errorf("reassigned %v, want %v", report.Reassigned, want)
+	}
+	if err := Apply(ctx, s, report); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []primitive.ObjectID{first, second} {
+		if owner := s[Contacts][id]["user_id"]; owner != heir {
+			t.Errorf("contact %s belongs to %s, want %s", id.Hex(), owner.Hex(), heir.Hex())
+		}
+	}
+	if _, ok := s[Users][user]; ok {
+		t.Error("the user was not deleted")
+	}
+}
+
+func TestPoliciesWith(t *testing.T) {
+	for _, overrides := range []Policies{
+		{"contacts.nickname": Cascade},
+		{"contacts.user_id": "archive"},
+		{"contacts.business_id": Reassign},
+	} {
+		if _, err := DefaultPolicies.With(overrides); err == nil {
+			t.Errorf("With(%v) accepted the override", overrides)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package leader runs a background job on one instance at a time: the one
+// holding a named, expiring lock. The lock is renewed while the job runs, and
+// the job is told to stop as soon as it can no longer be renewed, so a job
+// that outlives the lock's TTL never runs alongside the next leader's.
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/data"
+)
+
+// Loop runs a job every Interval while this instance holds the lock Name
+type Loop struct {
+	Name  string
+	Locks data.Locker
+
+	Interval time.Duration
+	// TTL bounds how long a crashed leader blocks the other instances. The
+	// lock is renewed every third of it while the job runs.
+	TTL time.Duration
+
+	// Owner identifies this instance in the lock document
+	Owner string
+}
+
+func New(name string, locks data.Locker, interval, ttl time.Duration) *Loop {
+	host, _ := os.Hostname()
+	return &Loop{
+		Name:     name,
+		Locks:    locks,
+		Interval: interval,
+		TTL:      ttl,
+		Owner:    fmt.Sprintf("%s-%d-%s", host, os.Getpid(), primitive.NewObjectID().Hex()),
+	}
+}
+
+// Run calls job every Interval while this instance leads, until ctx is
+// cancelled, then gives up leadership. The context job gets is cancelled
+// when the lock is lost.
+func (l *Loop) Run(ctx context.Context, job func(ctx context.Context)) {
+	ticker := time.NewTicker(l.Interval)
+	defer ticker.Stop()
+
+	defer func() {
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := l.Locks.Release(releaseCtx, l.Name, l.Owner); err != nil {
+			log.Printf("%s: releasing lock: %v", l.Name, err)
+		}
+	}()
+
+	for {
+		l.tick(ctx, job)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick runs job once if this instance can take the lock, renewing the lock
+// until job returns
+func (l *Loop) tick(ctx context.Context, job func(ctx context.Context)) {
+	leader, err := l.Locks.Acquire(ctx, l.Name, l.Owner, l.TTL)
+	if err != nil {
+		log.Printf("%s: acquiring lock: %v", l.Name, err)
+		return
+	}
+	if !leader {
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		l.renew(jobCtx, cancel)
+	}()
+	job(jobCtx)
+	cancel()
+	<-renewed
+}
+
+// renew extends the lock every third of its TTL until ctx is done. When the
+// lock cannot be extended, because another instance took it or the renewal
+// failed, it calls lost and stops.
+func (l *Loop) renew(ctx context.Context, lost context.CancelFunc) {
+	ticker := time.NewTicker(l.TTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// a renewal still pending when the lock expires is one that failed
+		renewCtx, cancel := context.WithTimeout(ctx, l.TTL/3)
+		held, err := l.Locks.Acquire(renewCtx, l.Name, l.Owner, l.TTL)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("%s: renewing lock: %v", l.Name, err)
+		} else if !held {
+			log.Printf("%s: lost the lock to another instance", l.Name)
+		}
+		if err != nil || !held {
+			lost()
+			return
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"usermanagement/data"
+)
+
+// TestOneLeader checks a second instance does not run the job while the
+// first holds the lock, and does once it is released
+func TestOneLeader(t *testing.T) {
+	ctx := context.Background()
+	locks := data.NewMemoryLocker()
+	first := New("job", locks, time.Hour, time.Minute)
+	second := New("job", locks, time.Hour, time.Minute)
+
+	ran := false
+	first.tick(ctx, func(ctx context.Context) {
+		second.tick(ctx, func(context.Context) { t.Error("the second instance ran the job while the first led") })
+		ran = true
+	})
+	if !ran {
+		t.Fatal("the first instance did not run the job")
+	}
+
+	if err := locks.Release(ctx, first.Name, first.Owner); err != nil {
+		t.Fatal(err)
+	}
+	ran = false
+	second.tick(ctx, func(context.Context) { ran = true })
+	if !ran {
+		t.Error("the second instance did not run the job once the lock was free")
+	}
+}
+
+// losing takes the lock the first time and refuses every renewal
+type losing struct {
+	mu       sync.Mutex
+	acquired int
+}
+
+func (l *losing) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.acquired++
+	return l.acquired == 1, nil
+}
+
+func (l *losing) Release(ctx context.Context, name, owner string) error {
+	return nil
+}
+
+// TestLostLockStopsJob checks the job is cancelled once the lock cannot be renewed
+func TestLostLockStopsJob(t *testing.T) {
+	loop := New("job", &losing{}, time.Hour, 30*time.Millisecond)
+	loop.tick(context.Background(), func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Error("the job was not cancelled when the lock was lost")
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Activity types
+const (
+	ActivityBusinessCreated  = "business.created"
+	ActivityBusinessUpdated  = "business.updated"
+	ActivityBusinessDeleted  = "business.deleted"
+	ActivityBusinessRestored = "business.restored"
+	ActivityStatusChanged    = "business.status_changed"
+	ActivityContactCreated   = "contact.created"
+	ActivityContactUpdated   = "contact.updated"
+	ActivityContactDeleted   = "contact.deleted"
+	ActivityContactRestored  = "contact.restored"
+	ActivityContactMerged    = "contact.merged"
+	ActivityCallLogged       = "call.logged"
+	ActivityCallUpdated      = "call.updated"
+	ActivityFollowupFired    = "followup.fired"
+)
+
+// ActivityTypes lists every activity type, which are also the events
+// webhooks subscribe to
+var ActivityTypes = []string{
+	ActivityBusinessCreated,
+	ActivityBusinessUpdated,
+	ActivityBusinessDeleted,
+	ActivityBusinessRestored,
+	ActivityStatusChanged,
+	ActivityContactCreated,
+	ActivityContactUpdated,
+	ActivityContactDeleted,
+	ActivityContactRestored,
+	ActivityContactMerged,
+	ActivityCallLogged,
+	ActivityCallUpdated,
+	ActivityFollowupFired,
+}
+
+// Activity is one entry in the timeline of a business and, when ContactID is set, of a contact
+type Activity struct {
+	ID          primitive.ObjectID     `json:"id,omitempty" bson:"_id,omitempty"`
+	Type        string                 `json:"type" bson:"type"`
+	BusinessID  primitive.ObjectID     `json:"business_id" bson:"business_id"`
+	ContactID   primitive.ObjectID     `json:"contact_id" bson:"contact_id"`
+	UserID      primitive.ObjectID     `json:"user_id" bson:"user_id"`
+	Summary     string                 `json:"summary" bson:"summary"`
+	Details     map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+	CreatedDate time.Time              `json:"created_date" bson:"created_date"`
+}
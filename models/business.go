@@ -20,4 +20,8 @@ type Business struct {
 	CreatedDate         time.Time          `json:"created_date" bson:"created_date"`
 	UserID              primitive.ObjectID `json:"user_id" bson:"user_id"`
 	ContactID           primitive.ObjectID `json:"contact_id" bson:"contact_id"`
+	// DeletedAt is set while the business is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	// Revision counts the changes to the business and is served as its ETag
+	Revision int64 `json:"revision" bson:"revision"`
 }
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Call directions
+const (
+	CallInbound  = "inbound"
+	CallOutbound = "outbound"
+)
+
+// CallOutcomes lists the accepted dispositions of a call
+var CallOutcomes = []string{
+	"connected",
+	"no_answer",
+	"voicemail",
+	"busy",
+	"wrong_number",
+	"callback_requested",
+	"not_interested",
+	"interested",
+}
+
+type Call struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	BusinessID  primitive.ObjectID `json:"business_id" bson:"business_id"`
+	ContactID   primitive.ObjectID `json:"contact_id" bson:"contact_id"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Direction   string             `json:"direction" bson:"direction"`
+	StartTime   time.Time          `json:"start_time" bson:"start_time"`
+	EndTime     time.Time          `json:"end_time" bson:"end_time"`
+	Duration    int                `json:"duration" bson:"duration"` // seconds
+	Outcome     string             `json:"outcome" bson:"outcome"`
+	Notes       string             `json:"notes" bson:"notes"`
+	CreatedDate time.Time          `json:"created_date" bson:"created_date"`
+	UpdatedDate time.Time          `json:"updated_date" bson:"updated_date"`
+	// DeletedAt is set while the call is in the trash, taken there with its business
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	// Revision counts the changes to the call and is served as its ETag
+	Revision int64 `json:"revision" bson:"revision"`
+}
@@ -12,10 +12,13 @@ type Contact struct {
 	Description    string             `json:"description" bson:"description"`
 	Name           string             `json:"name" bson:"name"`
 	CellPhone      string             `json:"cell_phone" bson:"cell_phone"`
+	CellPhoneE164  string             `json:"cell_phone_e164" bson:"cell_phone_e164"`
 	WorkPhone      string             `json:"work_phone" bson:"work_phone"`
+	WorkPhoneE164  string             `json:"work_phone_e164" bson:"work_phone_e164"`
 	Email          string             `json:"email" bson:"email"`
 	Latitude       float64            `json:"latitude" bson:"latitude"`
 	Longitude      float64            `json:"longitude" bson:"longitude"`
+	Location       *GeoPoint          `json:"location,omitempty" bson:"location,omitempty"`
 	Street         string             `json:"street" bson:"street"`
 	City           string             `json:"city" bson:"city"`
 	State          string             `json:"state" bson:"state"`
@@ -25,4 +28,19 @@ type Contact struct {
 	BusinessID     primitive.ObjectID `json:"business_id" bson:"business_id"`
 	PersonIndex    int                `json:"person_index" bson:"person_index"`
 	UserID         primitive.ObjectID `json:"user_id" bson:"user_id"`
+	// DeletedAt is set while the contact is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	// Revision counts the changes to the contact and is served as its ETag
+	Revision int64 `json:"revision" bson:"revision"`
+}
+
+// SyncLocation derives Location from Latitude and Longitude. A contact at
+// exactly 0,0 is treated as having no location, which is how contacts
+// created without coordinates are stored.
+func (c *Contact) SyncLocation() {
+	if c.Latitude == 0 && c.Longitude == 0 {
+		c.Location = nil
+		return
+	}
+	c.Location = NewGeoPoint(c.Latitude, c.Longitude)
 }
@@ -0,0 +1,27 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Reasons two contacts are considered the same person
+const (
+	DuplicateEmail = "email"
+	DuplicatePhone = "phone"
+	DuplicateName  = "name"
+)
+
+// DuplicatePair scores how likely two contacts are the same person, between 0 and 1
+type DuplicatePair struct {
+	A       primitive.ObjectID `json:"a"`
+	B       primitive.ObjectID `json:"b"`
+	Score   float64            `json:"score"`
+	Reasons []string           `json:"reasons"`
+}
+
+// DuplicateCluster is a group of contacts under one business linked by
+// likely-duplicate pairs. Score is that of the strongest pair.
+type DuplicateCluster struct {
+	BusinessID primitive.ObjectID `json:"business_id"`
+	Score      float64            `json:"score"`
+	Contacts   []Contact          `json:"contacts"`
+	Pairs      []DuplicatePair    `json:"pairs"`
+}
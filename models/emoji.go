@@ -12,4 +12,8 @@ type Emoji struct {
 	Emoji_Name   string             `json:"emoji_name" bson:"emoji_name"`
 	Emoji_Index   int          `json:"emoji_index" bson:"emoji_index"`
 	Created_Date time.Time          `json:"created_date" bson:"created_date"`
+	// DeletedAt is set while the emoji is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	// Revision counts the changes to the emoji and is served as its ETag
+	Revision int64 `json:"revision" bson:"revision"`
 }
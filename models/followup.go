@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Followup is a task emitted for a user when one of their businesses is due for a follow-up
+type Followup struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	BusinessID  primitive.ObjectID `json:"business_id" bson:"business_id"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	DueDate     time.Time          `json:"due_date" bson:"due_date"`
+	CreatedDate time.Time          `json:"created_date" bson:"created_date"`
+}
@@ -0,0 +1,18 @@
+package models
+
+// GeoPoint is a GeoJSON point, stored so Mongo can index it with 2dsphere
+type GeoPoint struct {
+	Type        string    `json:"type" bson:"type"`
+	Coordinates []float64 `json:"coordinates" bson:"coordinates"` // longitude, latitude
+}
+
+// NewGeoPoint returns the GeoJSON point for a latitude and longitude
+func NewGeoPoint(lat, lng float64) *GeoPoint {
+	return &GeoPoint{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
+// ContactDistance is a contact returned by a geospatial query with its distance from the query point
+type ContactDistance struct {
+	Contact  `bson:",inline"`
+	Distance float64 `json:"distance_m" bson:"distance"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Import job statuses
+const (
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+// ImportRowError explains why one row was rejected. CSV rows are numbered
+// like a spreadsheet, so the header is row 1 and the first record is row 2;
+// the cards of a vCard file are numbered from 1.
+type ImportRowError struct {
+	Row     int    `json:"row" bson:"row"`
+	Message string `json:"message" bson:"message"`
+}
+
+// ImportJob tracks the progress and outcome of a CSV import
+type ImportJob struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Resource   string             `json:"resource" bson:"resource"`
+	Status     string             `json:"status" bson:"status"`
+	DryRun     bool               `json:"dry_run" bson:"dry_run"`
+	Background bool               `json:"background" bson:"background"`
+	TotalRows  int                `json:"total_rows" bson:"total_rows"`
+	Processed  int                `json:"processed" bson:"processed"`
+	// Succeeded counts the rows saved, or in a dry run the rows that would have been
+	Succeeded       int              `json:"succeeded" bson:"succeeded"`
+	Failed          int              `json:"failed" bson:"failed"`
+	Errors          []ImportRowError `json:"errors" bson:"errors"`
+	ErrorsTruncated bool             `json:"errors_truncated" bson:"errors_truncated"`
+	// Message explains why a failed job stopped early
+	Message      string    `json:"message,omitempty" bson:"message,omitempty"`
+	CreatedDate  time.Time `json:"created_date" bson:"created_date"`
+	UpdatedDate  time.Time `json:"updated_date" bson:"updated_date"`
+	FinishedDate time.Time `json:"finished_date" bson:"finished_date"`
+}
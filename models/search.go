@@ -0,0 +1,20 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Search result types
+const (
+	SearchBusiness = "business"
+	SearchContact  = "contact"
+)
+
+// SearchResult is one business or contact matched by a search. Highlights
+// maps each matching field to an HTML snippet with the matches in <em>.
+type SearchResult struct {
+	Type       string             `json:"type"`
+	ID         primitive.ObjectID `json:"id"`
+	Title      string             `json:"title"`
+	Score      float64            `json:"score"`
+	Highlights map[string]string  `json:"highlights"`
+	Document   interface{}        `json:"document"`
+}
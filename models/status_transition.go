@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StatusTransition records a business moving from one pipeline stage to another
+type StatusTransition struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	BusinessID  primitive.ObjectID `json:"business_id" bson:"business_id"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	FromStatus  int                `json:"from_status" bson:"from_status"`
+	ToStatus    int                `json:"to_status" bson:"to_status"`
+	Reason      string             `json:"reason" bson:"reason"`
+	CreatedDate time.Time          `json:"created_date" bson:"created_date"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StreamToken records how far a client of the live update stream has read,
+// so a reconnect naming it in Last-Event-ID picks up where it left off
+type StreamToken struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id"`
+	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
+	// Resume is the change stream resume token, when changes are read from a
+	// change stream
+	Resume bson.Raw `json:"-" bson:"resume,omitempty"`
+	// After is the last version of the history the user has been sent the
+	// changes up to, when changes are found by polling
+	After       *primitive.ObjectID `json:"-" bson:"after,omitempty"`
+	CreatedDate time.Time           `json:"created_date" bson:"created_date"`
+	// ExpiresAt is when the token is removed and can no longer be resumed from
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TrashEntry records one delete: the document asked for, every document its
+// relations took to the trash with it and the references it cleared or
+// reassigned, so that restoring it undoes the whole delete
+type TrashEntry struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	Resource   string             `json:"resource" bson:"resource"`
+	DocumentID primitive.ObjectID `json:"document_id" bson:"document_id"`
+	// Title names the deleted document, such as a business name
+	Title     string    `json:"title" bson:"title"`
+	DeletedAt time.Time `json:"deleted_at" bson:"deleted_at"`
+	// PurgeAt is when the entry is deleted for good, from the configured retention
+	PurgeAt *time.Time `json:"purge_at,omitempty" bson:"-"`
+	// Deleted lists the trashed documents by resource, including the one asked for
+	Deleted map[string][]primitive.ObjectID `json:"deleted" bson:"deleted"`
+	Changed []ChangedRef                    `json:"changed" bson:"changed"`
+}
+
+// ChangedRef is a reference a delete pointed from a trashed document to
+// another one, or to none
+type ChangedRef struct {
+	// Relation is named like businesses.emoji_id
+	Relation string             `json:"relation" bson:"relation"`
+	ID       primitive.ObjectID `json:"id" bson:"id"`
+	From     primitive.ObjectID `json:"from" bson:"from"`
+	To       primitive.ObjectID `json:"to" bson:"to"`
+}
@@ -10,6 +10,15 @@ type User struct {
 	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Name        string             `json:"name" bson:"name"`
 	Color_Code   string             `json:"color_code" bson:"color_code"`
+	// PhoneRegion overrides the configured region for the numbers of the user's contacts
+	PhoneRegion string             `json:"phone_region,omitempty" bson:"phone_region,omitempty"`
+	// CalendarSalt is mixed into the user's calendar feed token; replacing it
+	// revokes the feed URLs handed out so far
+	CalendarSalt string `json:"-" bson:"calendar_salt,omitempty"`
 	CreatedDate time.Time          `json:"createdDate" bson:"createdDate"`
 	UpdatedDate time.Time          `json:"updatedDate" bson:"updatedDate"`
+	// DeletedAt is set while the user is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	// Revision counts the changes to the user and is served as its ETag
+	Revision int64 `json:"revision" bson:"revision"`
 }
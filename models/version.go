@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Version actions
+const (
+	VersionCreated  = "created"
+	VersionUpdated  = "updated"
+	VersionDeleted  = "deleted"
+	VersionRestored = "restored"
+	VersionReverted = "reverted"
+)
+
+// Version is the stored state of a user, emoji, contact or business right
+// after one change to it. Versions of a document are numbered from 1.
+type Version struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	Resource   string             `json:"resource" bson:"resource"`
+	DocumentID primitive.ObjectID `json:"document_id" bson:"document_id"`
+	Version    int                `json:"version" bson:"version"`
+	Action     string             `json:"action" bson:"action"`
+	// UserID is who made the change, from the X-User-ID header; zero when it was not sent
+	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
+	// RevertedFrom is the version a revert went back to
+	RevertedFrom int `json:"reverted_from,omitempty" bson:"reverted_from,omitempty"`
+	// Snapshot is the document as stored; the API shows it decoded into its model
+	Snapshot    bson.Raw  `json:"-" bson:"snapshot"`
+	CreatedDate time.Time `json:"created_date" bson:"created_date"`
+}
+
+// FieldChange is a field that differs between two versions, named as in the API
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
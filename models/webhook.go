@@ -0,0 +1,91 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// AllEvents subscribes a webhook to every event type
+const AllEvents = "*"
+
+// Webhook is a subscription that has the events it filters for posted to its URL
+type Webhook struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	URL         string             `json:"url" bson:"url"`
+	Description string             `json:"description" bson:"description"`
+	// Secret signs every payload sent to the URL. It is only shown when the
+	// webhook is created or given a new one.
+	Secret string `json:"secret,omitempty" bson:"secret"`
+	// Events lists the activity types sent, such as business.updated, or * for all of them
+	Events      []string  `json:"events" bson:"events"`
+	Active      bool      `json:"active" bson:"active"`
+	Revision    int64     `json:"revision" bson:"revision"`
+	CreatedDate time.Time `json:"created_date" bson:"created_date"`
+	UpdatedDate time.Time `json:"updated_date" bson:"updated_date"`
+}
+
+// Wants reports whether the webhook is sent events of the given type
+func (w Webhook) Wants(eventType string) bool {
+	for _, event := range w.Events {
+		if event == AllEvents || event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is an activity waiting in the outbox to be queued for delivery to
+// the webhooks subscribed to its type
+type Event struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Type        string             `json:"type" bson:"type"`
+	Data        Activity           `json:"data" bson:"data"`
+	CreatedDate time.Time          `json:"created_date" bson:"created_date"`
+	// Dispatched is set once a delivery is queued for every subscribed webhook
+	Dispatched bool `json:"-" bson:"dispatched"`
+}
+
+// NewEvent is the outbox event that publishes an activity
+func NewEvent(activity Activity) Event {
+	return Event{
+		ID:          primitive.NewObjectID(),
+		Type:        activity.Type,
+		Data:        activity,
+		CreatedDate: activity.CreatedDate,
+	}
+}
+
+// Delivery is an event on its way to one webhook, with a log of every try
+type Delivery struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	WebhookID primitive.ObjectID `json:"webhook_id" bson:"webhook_id"`
+	EventID   primitive.ObjectID `json:"event_id" bson:"event_id"`
+	EventType string             `json:"event_type" bson:"event_type"`
+	// Payload is the body posted, exactly as signed
+	Payload string `json:"payload" bson:"payload"`
+	Status  string `json:"status" bson:"status"`
+	// Attempts counts the tries since the delivery was last queued
+	Attempts    int               `json:"attempts" bson:"attempts"`
+	NextAttempt time.Time         `json:"next_attempt" bson:"next_attempt"`
+	Log         []DeliveryAttempt `json:"log" bson:"log"`
+	CreatedDate time.Time         `json:"created_date" bson:"created_date"`
+	UpdatedDate time.Time         `json:"updated_date" bson:"updated_date"`
+}
+
+// DeliveryAttempt is one try at posting a delivery
+type DeliveryAttempt struct {
+	At         time.Time `json:"at" bson:"at"`
+	StatusCode int       `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	// Response is the start of the response body
+	Response   string `json:"response,omitempty" bson:"response,omitempty"`
+	Error      string `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms" bson:"duration_ms"`
+}
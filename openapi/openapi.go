@@ -0,0 +1,419 @@
+// Package openapi describes the API as an OpenAPI 3.1 document. Its paths are
+// the routes the router registered and its schemas are reflected from the Go
+// types the handlers read and write, so the document follows the code; a
+// route with no Endpoint documenting it is reported rather than guessed at.
+//
+// Every JSON response is an envelope:
+//
+//	{"status": <HTTP status>, "message": "<what happened>", "data": <the result>}
+//
+// Lists add next_cursor and total, and an error carries its reason in message.
+package openapi
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files/v2"
+	"usermanagement/data"
+)
+
+// Version is the OpenAPI version of the documents built
+const Version = "3.1.0"
+
+// Page is the HTML page that renders the document served at /openapi.json.
+// It loads its script and styles from Assets, so nothing comes from a CDN.
+//
+//go:embed docs.html
+var Page []byte
+
+// Assets holds the Swagger UI bundle the page loads, built into the binary
+var Assets = swaggerFiles.FS
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Tags       []Tag               `json:"tags,omitempty"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Tag groups operations
+type Tag struct {
+	Name string `json:"name"`
+}
+
+// PathItem holds the operations on one path, keyed by lower-case method
+type PathItem map[string]*Operation
+
+// Operation is one method on one path
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Tags        []string             `json:"tags,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter is a path, query or header parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is the body an operation reads
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// MediaType is the schema of a body of one media type
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Response is the response of one status, or a reference to a shared one
+type Response struct {
+	Ref         string               `json:"$ref,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// Header is a response header
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// Components holds what operations refer to
+type Components struct {
+	Schemas   map[string]*Schema   `json:"schemas"`
+	Responses map[string]*Response `json:"responses"`
+}
+
+// Endpoint documents one route
+type Endpoint struct {
+	Tag         string
+	Summary     string
+	Description string
+	// Path describes the path parameters that are not document IDs
+	Path []Parameter
+	// Query lists the query parameters besides those of Filters
+	Query []Parameter
+	// Headers lists the request headers besides If-Match and If-None-Match,
+	// which follow from Errors
+	Headers []Parameter
+	// Filters are the fields a list can be filtered and sorted by, which also
+	// gives it limit and cursor
+	Filters data.Fields
+	// Body maps each media type the request body may have to a value of the
+	// type it holds; a string stands for text and a []byte for a file
+	Body map[string]interface{}
+	// Status lists the statuses of success, 200 when empty
+	Status []int
+	// Data is a value of the type of the envelope's data on success
+	Data interface{}
+	// Paged adds next_cursor and total to the envelope, Counted just total
+	Paged, Counted bool
+	// Produces lists the media types served on success instead of an envelope
+	Produces []string
+	// Errors lists the statuses of failure besides 500. 304 also documents
+	// If-None-Match and 412 If-Match.
+	Errors []int
+}
+
+// pathParam matches a gin path parameter such as :id
+var pathParam = regexp.MustCompile(`[:*]([A-Za-z_]+)`)
+
+// Key is how endpoints are keyed: the method and path as gin registers them,
+// such as "GET /users/:id"
+func Key(method, path string) string {
+	return method + " " + path
+}
+
+// Build describes routes with the endpoints documenting them. Routes no
+// endpoint documents are left out and returned as undocumented, as are
+// endpoints documenting no route.
+func Build(info Info, routes gin.RoutesInfo, endpoints map[string]Endpoint) (*Document, []string) {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]PathItem{},
+	}
+	s := newSchemas()
+	responses := map[string]*Response{}
+	tags := map[string]bool{}
+	var undocumented []string
+
+	routed := map[string]bool{}
+	for _, route := range routes {
+		key := Key(route.Method, route.Path)
+		routed[key] = true
+		endpoint, ok := endpoints[key]
+		if !ok {
+			undocumented = append(undocumented, key)
+			continue
+		}
+
+		op := endpoint.operation(s, responses, route)
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = PathItem{}
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = op
+		if endpoint.Tag != "" {
+			tags[endpoint.Tag] = true
+		}
+	}
+	for key := range endpoints {
+		if !routed[key] {
+			undocumented = append(undocumented, key+" (no such route)")
+		}
+	}
+	sort.Strings(undocumented)
+
+	for tag := range tags {
+		doc.Tags = append(doc.Tags, Tag{Name: tag})
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool { return doc.Tags[i].Name < doc.Tags[j].Name })
+	doc.Components = Components{Schemas: s.named, Responses: responses}
+	return doc, undocumented
+}
+
+// operation describes the endpoint served at route
+func (e Endpoint) operation(s *schemas, responses map[string]*Response, route gin.RouteInfo) *Operation {
+	op := &Operation{
+		OperationID: handlerName(route.Handler),
+		Summary:     e.Summary,
+		Description: e.Description,
+		Responses:   map[string]*Response{},
+	}
+	if e.Tag != "" {
+		op.Tags = []string{e.Tag}
+	}
+
+	for _, match := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+		op.Parameters = append(op.Parameters, e.pathParameter(match[1]))
+	}
+	op.Parameters = append(op.Parameters, params("query", e.Query)...)
+	if e.Filters != nil {
+		op.Parameters = append(op.Parameters, listParameters(e.Filters)...)
+	}
+	op.Parameters = append(op.Parameters, params("header", e.Headers)...)
+	if e.fails(http.StatusNotModified) {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name: "If-None-Match", In: "header",
+			Description: "The ETag of the copy held; 304 answers when it is still current",
+			Schema:      &Schema{Type: "string"},
+		})
+	}
+	if e.fails(http.StatusPreconditionFailed) {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name: "If-Match", In: "header",
+			Description: "The ETag of the revision the change is made against; 412 answers when it is no longer current",
+			Schema:      &Schema{Type: "string"},
+		})
+	}
+
+	if len(e.Body) > 0 {
+		op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{}}
+		for mediaType, v := range e.Body {
+			op.RequestBody.Content[mediaType] = MediaType{Schema: s.of(v)}
+		}
+	}
+
+	success := e.success(s)
+	statuses := e.Status
+	if len(statuses) == 0 {
+		statuses = []int{http.StatusOK}
+	}
+	for _, status := range statuses {
+		response := *success
+		response.Description = http.StatusText(status)
+		op.Responses[strconv.Itoa(status)] = &response
+	}
+	for _, status := range append(e.Errors, http.StatusInternalServerError) {
+		op.Responses[strconv.Itoa(status)] = errorResponse(responses, status)
+	}
+	return op
+}
+
+// success is the response of the endpoint when it succeeds
+func (e Endpoint) success(s *schemas) *Response {
+	response := &Response{Content: map[string]MediaType{}}
+	if e.fails(http.StatusNotModified) || e.fails(http.StatusPreconditionFailed) {
+		response.Headers = map[string]Header{"ETag": {
+			Description: "The revision of the document, for If-None-Match and If-Match",
+			Schema:      &Schema{Type: "string"},
+		}}
+	}
+	if len(e.Produces) > 0 {
+		for _, mediaType := range e.Produces {
+			schema := &Schema{Type: "string"}
+			if mediaType == "application/json" {
+				schema = &Schema{Type: "object"}
+			}
+			response.Content[mediaType] = MediaType{Schema: schema}
+		}
+		return response
+	}
+
+	envelope := envelopeSchema(s.of(e.Data))
+	if e.Paged {
+		envelope.Properties["next_cursor"] = &Schema{Type: "string", Description: "Pass as cursor for the next page; empty on the last page"}
+		envelope.Required = append(envelope.Required, "next_cursor")
+	}
+	switch {
+	case e.Paged:
+		envelope.Properties["total"] = &Schema{Type: "integer", Format: "int64", Description: "How many match, across every page; only on the first page"}
+	case e.Counted:
+		envelope.Properties["total"] = &Schema{Type: "integer", Format: "int64", Description: "How many match, across every page"}
+		envelope.Required = append(envelope.Required, "total")
+	}
+	response.Content["application/json"] = MediaType{Schema: envelope}
+	return response
+}
+
+func (e Endpoint) fails(status int) bool {
+	for _, s := range e.Errors {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// pathParameter describes a path parameter, which is a document ID unless
+// the endpoint says otherwise
+func (e Endpoint) pathParameter(name string) Parameter {
+	for _, p := range e.Path {
+		if p.Name == name {
+			p.In, p.Required = "path", true
+			return p
+		}
+	}
+	return Parameter{Name: name, In: "path", Required: true, Schema: ref(objectIDSchema)}
+}
+
+func params(in string, list []Parameter) []Parameter {
+	out := make([]Parameter, len(list))
+	for i, p := range list {
+		p.In = in
+		out[i] = p
+	}
+	return out
+}
+
+// listParameters describes the limit, cursor, sort and filter parameters of a
+// list, as parseListOptions reads them
+func listParameters(fields data.Fields) []Parameter {
+	names := make([]string, 0, len(fields))
+	var sortable []interface{}
+	for name, field := range fields {
+		names = append(names, name)
+		if field.Sortable {
+			sortable = append(sortable, name)
+		}
+	}
+	sort.Strings(names)
+	sort.Slice(sortable, func(i, j int) bool { return sortable[i].(string) < sortable[j].(string) })
+
+	list := []Parameter{
+		{Name: "limit", In: "query", Description: fmt.Sprintf("Page size, %d by default and at most %d", data.DefaultListLimit, data.MaxListLimit),
+			Schema: &Schema{Type: "integer"}},
+		{Name: "cursor", In: "query", Description: "The next_cursor of the page before", Schema: &Schema{Type: "string"}},
+		{Name: "sort", In: "query", Description: "A field to sort by, prefixed with - for descending order: " + joinNames(sortable),
+			Schema: &Schema{Type: "string"}},
+	}
+	for _, name := range names {
+		list = append(list, Parameter{
+			Name: name, In: "query",
+			Description: "Keeps documents whose " + name + " equals the value. " + name +
+				"[ne], [gt], [gte], [lt], [lte] and [in] (a comma-separated list) compare otherwise.",
+			Schema: fieldSchema(fields[name].Type),
+		})
+	}
+	return list
+}
+
+func joinNames(names []interface{}) string {
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name.(string)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// fieldSchema is how a filter value of the given type is written
+func fieldSchema(t data.FieldType) *Schema {
+	switch t {
+	case data.IntField:
+		return &Schema{Type: "integer"}
+	case data.FloatField:
+		return &Schema{Type: "number"}
+	case data.BoolField:
+		return &Schema{Type: "boolean"}
+	case data.TimeField:
+		return &Schema{Type: "string", Format: "date-time"}
+	case data.ObjectIDField:
+		return ref(objectIDSchema)
+	}
+	return &Schema{Type: "string"}
+}
+
+// envelopeSchema is the schema of a response carrying data
+func envelopeSchema(data *Schema) *Schema {
+	return &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"status":  {Type: "integer", Description: "The HTTP status"},
+			"message": {Type: "string"},
+			"data":    data,
+		},
+		Required: []string{"status", "message", "data"},
+	}
+}
+
+// errorResponse refers to the shared response of an error status, adding it
+// to responses the first time
+func errorResponse(responses map[string]*Response, status int) *Response {
+	name := strings.ReplaceAll(http.StatusText(status), " ", "")
+	if name == "" {
+		name = "Status" + strconv.Itoa(status)
+	}
+	if _, ok := responses[name]; !ok {
+		response := &Response{Description: http.StatusText(status)}
+		if status != http.StatusNotModified {
+			response.Content = map[string]MediaType{"application/json": {Schema: ref(errorSchema)}}
+		}
+		responses[name] = response
+	}
+	return &Response{Ref: "#/components/responses/" + name}
+}
+
+// handlerName is the name of the method or function a handler is, such as
+// GetUsers for (*UserController).GetUsers
+func handlerName(handler string) string {
+	name := handler[strings.LastIndex(handler, ".")+1:]
+	return strings.TrimSuffix(name, "-fm")
+}
@@ -0,0 +1,208 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Schema is a JSON Schema, as OpenAPI 3.1 uses them
+type Schema struct {
+	Ref         string `json:"$ref,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Type is a type name, or a list of them for a value that may be null
+	Type                 interface{}        `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+}
+
+// Names of the schemas every document has
+const (
+	objectIDSchema = "ObjectID"
+	errorSchema    = "Error"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	rawJSONType  = reflect.TypeOf(json.RawMessage{})
+	jsonType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// ObjectID is the schema of a document ID
+func ObjectID() *Schema {
+	return ref(objectIDSchema)
+}
+
+// ref is a reference to the named component schema
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// nullable is s for a value that may also be null
+func nullable(s *Schema) *Schema {
+	if name, ok := s.Type.(string); ok {
+		copied := *s
+		copied.Type = []string{name, "null"}
+		return &copied
+	}
+	return &Schema{AnyOf: []*Schema{s, {Type: "null"}}}
+}
+
+// schemas describes Go types as JSON Schemas, collecting a named schema for
+// each struct type it meets so each is described once
+type schemas struct {
+	named map[string]*Schema
+	names map[reflect.Type]string
+}
+
+func newSchemas() *schemas {
+	return &schemas{
+		named: map[string]*Schema{
+			objectIDSchema: {Type: "string", Pattern: "^[0-9a-f]{24}$", Description: "A document ID"},
+			// data is empty, or says more about what went wrong
+			errorSchema: envelopeSchema(&Schema{}),
+		},
+		names: map[reflect.Type]string{objectIDType: objectIDSchema},
+	}
+}
+
+// of describes the type of v as encoding/json writes it
+func (s *schemas) of(v interface{}) *Schema {
+	if v == nil {
+		return &Schema{}
+	}
+	return s.schema(reflect.TypeOf(v))
+}
+
+func (s *schemas) schema(t reflect.Type) *Schema {
+	if name, ok := s.names[t]; ok {
+		return ref(name)
+	}
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawJSONType:
+		return &Schema{}
+	case t.Implements(jsonType) || reflect.PointerTo(t).Implements(jsonType):
+		// a custom encoding can be anything
+		return &Schema{}
+	case t.Implements(textType):
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(s.schema(t.Elem()))
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json writes bytes as base64; a form takes them as a file
+			return &Schema{Type: "string", Format: "binary"}
+		}
+		return &Schema{Type: "array", Items: s.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schema(t.Elem())}
+	case reflect.Struct:
+		return s.object(t)
+	}
+	// interfaces hold any value
+	return &Schema{}
+}
+
+// object names the schema of a struct type and returns a reference to it
+func (s *schemas) object(t reflect.Type) *Schema {
+	name := s.name(t)
+	s.names[t] = name
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	s.named[name] = schema
+	s.fields(t, schema)
+	return ref(name)
+}
+
+// fields adds the properties encoding/json writes for the fields of t,
+// including those of embedded structs
+func (s *schemas) fields(t reflect.Type, schema *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			s.fields(field.Type, schema)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if field.Type.Kind() == reflect.Pointer && strings.Contains(opts, "omitempty") {
+			// left out rather than null when unset
+			schema.Properties[name] = s.schema(field.Type.Elem())
+			continue
+		}
+		schema.Properties[name] = s.schema(field.Type)
+	}
+}
+
+// name picks the component name of a struct type: its Go name, capitalized,
+// or prefixed with its package when another type has it already
+func (s *schemas) name(t reflect.Type) string {
+	name := exported(t.Name())
+	if name == "" {
+		name = "Object"
+	}
+	if _, taken := s.named[name]; !taken {
+		return name
+	}
+	pkg := t.PkgPath()
+	pkg = exported(pkg[strings.LastIndex(pkg, "/")+1:])
+	base := pkg + name
+	name = base
+	for n := 2; ; n++ {
+		if _, taken := s.named[name]; !taken {
+			return name
+		}
+		name = fmt.Sprintf("%s%d", base, n)
+	}
+}
+
+// exported capitalizes name and drops anything a component name cannot hold
+func exported(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			if b.Len() == 0 {
+				r = unicode.ToUpper(r)
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,89 @@
+// Package phone parses phone numbers as people type them into the E.164 form
+// (+15551234567). Numbers are checked against the numbering plans of
+// libphonenumber, which covers every region.
+package phone
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// Errors read as the end of a sentence naming the number, as in
+// "cell_phone is too short for US"
+var (
+	ErrInvalidCharacters = errors.New("contains characters that are not part of a phone number")
+	ErrUnknownRegion     = errors.New("cannot be read in unknown region")
+	ErrUnknownCountry    = errors.New("has an unknown country code")
+)
+
+// extension matches a trailing extension, which E.164 has no place for
+var extension = regexp.MustCompile(`(?i)\s*(?:ext\.?|extension|x|#)\s*\d+$`)
+
+// KnownRegion reports whether region is an ISO 3166 code there is a
+// numbering plan for
+func KnownRegion(region string) bool {
+	return phonenumbers.GetSupportedRegions()[strings.ToUpper(region)]
+}
+
+// Parse validates a phone number and returns its E.164 form. Numbers starting
+// with + or the region's international prefix are read as international;
+// any other number is read as dialed within region.
+func Parse(raw, region string) (string, error) {
+	raw = extension.ReplaceAllString(strings.TrimSpace(raw), "")
+
+	digits := 0
+	for i, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r == '+' && i == 0:
+		case strings.ContainsRune(" -.()/", r):
+		default:
+			return "", ErrInvalidCharacters
+		}
+	}
+	if digits == 0 {
+		return "", errors.New("has no digits")
+	}
+
+	region = strings.ToUpper(region)
+	if !strings.HasPrefix(raw, "+") && !KnownRegion(region) {
+		return "", fmt.Errorf("%w %q", ErrUnknownRegion, region)
+	}
+	where := region
+	if strings.HasPrefix(raw, "+") {
+		where = "its country code"
+	}
+	number, err := phonenumbers.Parse(raw, region)
+	switch {
+	case errors.Is(err, phonenumbers.ErrInvalidCountryCode):
+		return "", ErrUnknownCountry
+	case errors.Is(err, phonenumbers.ErrTooShortNSN), errors.Is(err, phonenumbers.ErrTooShortAfterIDD):
+		return "", fmt.Errorf("is too short for %s", where)
+	case errors.Is(err, phonenumbers.ErrNumTooLong):
+		return "", fmt.Errorf("is too long for %s", where)
+	case err != nil:
+		return "", errors.New("is not a phone number")
+	}
+
+	// an international number is checked against the plan of its country code
+	if number.GetCountryCode() != int32(phonenumbers.GetCountryCodeForRegion(region)) {
+		where = fmt.Sprintf("+%d", number.GetCountryCode())
+	}
+	switch phonenumbers.IsPossibleNumberWithReason(number) {
+	case phonenumbers.INVALID_COUNTRY_CODE:
+		return "", ErrUnknownCountry
+	case phonenumbers.TOO_SHORT:
+		return "", fmt.Errorf("is too short for %s", where)
+	case phonenumbers.TOO_LONG:
+		return "", fmt.Errorf("is too long for %s", where)
+	}
+	if !phonenumbers.IsValidNumber(number) {
+		return "", fmt.Errorf("is not a possible number in %s", where)
+	}
+	return phonenumbers.Format(number, phonenumbers.E164), nil
+}
@@ -0,0 +1,67 @@
+package phone
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct{ raw, region, want string }{
+		{"(202) 555-0143", "US", "+12025550143"},
+		{"202.555.0143", "us", "+12025550143"},
+		{"1 202 555 0143", "US", "+12025550143"},
+		{"+1 202 555 0143", "", "+12025550143"},
+		{"+1 202 555 0143 ext. 12", "US", "+12025550143"},
+		{"202-555-0143 x7", "US", "+12025550143"},
+		{"020 7946 0958", "GB", "+442079460958"},
+		{"+44 20 7946 0958", "US", "+442079460958"},
+		{"011 44 20 7946 0958", "US", "+442079460958"},
+		{"0044 20 7946 0958", "DE", "+442079460958"},
+		{"030 901820", "DE", "+4930901820"},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.raw, tt.region)
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q, %q) = %q, %v, want %q", tt.raw, tt.region, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseFails(t *testing.T) {
+	tests := []struct {
+		raw, region string
+		want        error
+		message     string
+	}{
+		{"555-CALL-NOW", "US", ErrInvalidCharacters, ""},
+		{"+1 202 555 0143 +", "US", ErrInvalidCharacters, ""},
+		{"()", "US", nil, "has no digits"},
+		{"202 555 0143", "XX", ErrUnknownRegion, ""},
+		{"202 555 0143", "", ErrUnknownRegion, ""},
+		{"+999 1234 5678", "", ErrUnknownCountry, ""},
+		{"123", "US", nil, "is too short for US"},
+		{"202 555 0143 9999 99", "US", nil, "is too long for US"},
+		{"+44 20", "US", nil, "is too short for +44"},
+		{"+1 555 555 1234", "", nil, "is not a possible number in +1"},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.raw, tt.region)
+		switch {
+		case err == nil:
+			t.Errorf("Parse(%q, %q) = %q, want an error", tt.raw, tt.region, got)
+		case tt.want != nil && !errors.Is(err, tt.want):
+			t.Errorf("Parse(%q, %q): %v, want %v", tt.raw, tt.region, err, tt.want)
+		case tt.message != "" && !strings.Contains(err.Error(), tt.message):
+			t.Errorf("Parse(%q, %q): %v, want %q", tt.raw, tt.region, err, tt.message)
+		}
+	}
+}
+
+func TestKnownRegion(t *testing.T) {
+	for region, want := range map[string]bool{"US": true, "gb": true, "KE": true, "XX": false, "": false} {
+		if got := KnownRegion(region); got != want {
+			t.Errorf("KnownRegion(%q) = %v, want %v", region, got, want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Package pipeline gives names to Business.Status values and decides which
+// moves between them are allowed.
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Stage is a step of the sales pipeline, stored as Business.Status
+type Stage int
+
+const (
+	New Stage = iota
+	Contacted
+	Qualified
+	Interested
+	Meeting
+	Proposal
+	Negotiation
+	Won
+	Lost
+	OnHold
+)
+
+var stageNames = []string{
+	New:         "new",
+	Contacted:   "contacted",
+	Qualified:   "qualified",
+	Interested:  "interested",
+	Meeting:     "meeting",
+	Proposal:    "proposal",
+	Negotiation: "negotiation",
+	Won:         "won",
+	Lost:        "lost",
+	OnHold:      "on_hold",
+}
+
+// Stages lists every stage in pipeline order
+func Stages() []Stage {
+	stages := make([]Stage, len(stageNames))
+	for i := range stageNames {
+		stages[i] = Stage(i)
+	}
+	return stages
+}
+
+// Valid reports whether s is a known stage
+func (s Stage) Valid() bool {
+	return s >= 0 && int(s) < len(stageNames)
+}
+
+func (s Stage) String() string {
+	if !s.Valid() {
+		return fmt.Sprintf("stage(%d)", int(s))
+	}
+	return stageNames[s]
+}
+
+// MarshalText encodes the stage by name
+func (s Stage) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ParseStage looks a stage up by name
+func ParseStage(name string) (Stage, error) {
+	for i, n := range stageNames {
+		if n == name {
+			return Stage(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown stage %q", name)
+}
+
+// DefaultTransitions is the transition table used when none is configured
+var DefaultTransitions = map[string][]string{
+	"new":         {"contacted", "lost", "on_hold"},
+	"contacted":   {"qualified", "interested", "lost", "on_hold"},
+	"qualified":   {"interested", "meeting", "lost", "on_hold"},
+	"interested":  {"meeting", "proposal", "lost", "on_hold"},
+	"meeting":     {"interested", "proposal", "lost", "on_hold"},
+	"proposal":    {"negotiation", "won", "lost", "on_hold"},
+	"negotiation": {"proposal", "won", "lost", "on_hold"},
+	"won":         {},
+	"lost":        {"new"},
+	"on_hold":     {"new", "contacted", "qualified", "interested", "meeting", "proposal", "negotiation", "lost"},
+}
+
+// TransitionError explains why a move between two stages was rejected
+type TransitionError struct {
+	From, To Stage
+	Allowed  []Stage
+}
+
+func (e *TransitionError) Error() string {
+	if len(e.Allowed) == 0 {
+		return fmt.Sprintf("cannot move from %s to %s: %s is a final stage", e.From, e.To, e.From)
+	}
+	return fmt.Sprintf("cannot move from %s to %s, allowed next stages are %v", e.From, e.To, e.Allowed)
+}
+
+// Machine enforces a transition table between stages
+type Machine struct {
+	next map[Stage][]Stage
+}
+
+// NewMachine builds a machine from a table keyed by stage name. An empty table
+// selects DefaultTransitions.
+func NewMachine(transitions map[string][]string) (*Machine, error) {
+	if len(transitions) == 0 {
+		transitions = DefaultTransitions
+	}
+
+	m := &Machine{next: make(map[Stage][]Stage)}
+	for fromName, toNames := range transitions {
+		from, err := ParseStage(fromName)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline transitions: %w", err)
+		}
+		for _, toName := range toNames {
+			to, err := ParseStage(toName)
+			if err != nil {
+				return nil, fmt.Errorf("pipeline transitions from %s: %w", fromName, err)
+			}
+			m.next[from] = append(m.next[from], to)
+		}
+		sort.Slice(m.next[from], func(i, j int) bool { return m.next[from][i] < m.next[from][j] })
+	}
+	return m, nil
+}
+
+// Next returns the stages reachable from s in one move
+func (m *Machine) Next(s Stage) []Stage {
+	return m.next[s]
+}
+
+// Check returns a *TransitionError unless moving from one stage to the other is
+// allowed. Staying on the same stage is always allowed.
+func (m *Machine) Check(from, to Stage) error {
+	if !to.Valid() {
+		return fmt.Errorf("unknown stage %d", int(to))
+	}
+	if from == to {
+		return nil
+	}
+	for _, next := range m.next[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: from, To: to, Allowed: m.next[from]}
+}
@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseStage(t *testing.T) {
+	for _, s := range Stages() {
+		got, err := ParseStage(s.String())
+		if err != nil || got != s {
+			t.Errorf("ParseStage(%q) = %v, %v, want %v", s.String(), got, err, s)
+		}
+		text, _ := s.MarshalText()
+		if string(text) != s.String() {
+			t.Errorf("%v.MarshalText() = %q", s, text)
+		}
+	}
+	if _, err := ParseStage("closed"); err == nil {
+		t.Error(`ParseStage("closed"): want an error`)
+	}
+	if s := Stage(len(stageNames)); s.Valid() || s.String() != "stage(10)" {
+		t.Errorf("Stage(10) is valid %v and named %q", s.Valid(), s.String())
+	}
+}
+
+func TestDefaultTransitions(t *testing.T) {
+	m, err := NewMachine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range Stages() {
+		if _, ok := DefaultTransitions[s.String()]; !ok {
+			t.Errorf("DefaultTransitions has no entry for %s", s)
+		}
+	}
+	if got, want := m.Next(Contacted), []Stage{Qualified, Interested, Lost, OnHold}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Next(contacted) = %v, want %v in pipeline order", got, want)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	m, err := NewMachine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		from, to Stage
+		allowed  bool
+	}{
+		{New, Contacted, true},
+		{New, Won, false},
+		{Proposal, Won, true},
+		{Won, Lost, false},
+		{Won, Won, true},
+		{Lost, New, true},
+		{OnHold, Negotiation, true},
+	}
+	for _, tt := range tests {
+		err := m.Check(tt.from, tt.to)
+		if (err == nil) != tt.allowed {
+			t.Errorf("Check(%s, %s) = %v, want allowed %v", tt.from, tt.to, err, tt.allowed)
+		}
+		var terr *TransitionError
+		if err != nil && !errors.As(err, &terr) {
+			t.Errorf("Check(%s, %s) = %v, want a *TransitionError", tt.from, tt.to, err)
+		}
+	}
+
+	err = m.Check(Won, Lost)
+	if want := "cannot move from won to lost: won is a final stage"; err == nil || err.Error() != want {
+		t.Errorf("Check(won, lost) = %v, want %q", err, want)
+	}
+	if err := m.Check(New, Stage(42)); err == nil {
+		t.Error("Check to an unknown stage: want an error")
+	}
+}
+
+func TestNewMachine(t *testing.T) {
+	m, err := NewMachine(map[string][]string{"new": {"won"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(New, Won); err != nil {
+		t.Errorf("configured move: %v", err)
+	}
+	if err := m.Check(New, Contacted); err == nil {
+		t.Error("move left out of the configured table: want an error")
+	}
+
+	for _, table := range []map[string][]string{
+		{"closed": {"new"}},
+		{"new": {"closed"}},
+	} {
+		if _, err := NewMachine(table); err == nil {
+			t.Errorf("NewMachine(%v): want an error", table)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// TestBulkStatusMoveRecordsTransition checks a bulk update that moves a
+// business to another stage saves the transition with it
+func TestBulkStatusMoveRecordsTransition(t *testing.T) {
+	r := newTestRouter(t)
+	userID := create(t, r, "/users", `{"name":"Ada"}`, http.StatusCreated)
+	id := create(t, r, "/businesses", `{"business_name":"Engines","user_id":"`+userID+`"}`, http.StatusOK)
+
+	w, env := call(t, r, http.MethodPost, "/businesses/bulk",
+		`{"operations":[{"op":"update","id":"`+id+`","document":{"status":1}}]}`, "X-User-ID", userID)
+	expect(t, w, env, http.StatusOK)
+
+	w, env = call(t, r, http.MethodGet, "/businesses/"+id+"/transitions", "")
+	expect(t, w, env, http.StatusOK)
+	var transitions []map[string]interface{}
+	if err := json.Unmarshal(env.Data, &transitions); err != nil {
+		t.Fatal(err)
+	}
+	if len(transitions) != 1 || transitions[0]["from_status"] != 0.0 || transitions[0]["to_status"] != 1.0 || transitions[0]["user_id"] != userID {
+		t.Errorf("transitions = %v, want one from 0 to 1 by %s", transitions, userID)
+	}
+
+	w, env = call(t, r, http.MethodPost, "/businesses/bulk",
+		`{"operations":[{"op":"update","id":"`+id+`","document":{"website":"https://example.com"}}]}`)
+	expect(t, w, env, http.StatusOK)
+	w, env = call(t, r, http.MethodGet, "/businesses/"+id+"/transitions", "")
+	expect(t, w, env, http.StatusOK)
+	if env.Total != 1 {
+		t.Errorf("an update keeping the stage recorded a transition: total %d", env.Total)
+	}
+}
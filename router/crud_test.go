@@ -0,0 +1,273 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// envelope is a decoded JSON response
+type envelope struct {
+	Status     int             `json:"status"`
+	Message    string          `json:"message"`
+	Data       json.RawMessage `json:"data"`
+	NextCursor string          `json:"next_cursor"`
+	Total      int64           `json:"total"`
+}
+
+// call sends a request to r and decodes its envelope. headers are name and
+// value pairs; a body is sent as JSON unless a Content-Type header says
+// otherwise.
+func call(t *testing.T, r *gin.Engine, method, path, body string, headers ...string) (*httptest.ResponseRecorder, envelope) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var env envelope
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+			t.Fatalf("%s %s: %v in %s", method, path, err, w.Body.String())
+		}
+		if env.Status != w.Code {
+			t.Errorf("%s %s: envelope status %d, response status %d", method, path, env.Status, w.Code)
+		}
+	}
+	return w, env
+}
+
+// expect fails the test unless the response has the given status
+func expect(t *testing.T, w *httptest.ResponseRecorder, env envelope, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status %d (%s), want %d", w.Code, env.Message, status)
+	}
+}
+
+// document decodes the data of an envelope into a map
+func document(t *testing.T, env envelope) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := json.Unmarshal(env.Data, &doc); err != nil {
+		t.Fatalf("data %s: %v", env.Data, err)
+	}
+	return doc
+}
+
+// create posts a document and returns its ID
+func create(t *testing.T, r *gin.Engine, path, body string, status int) string {
+	t.Helper()
+	w, env := call(t, r, http.MethodPost, path, body)
+	expect(t, w, env, status)
+	if tag := w.Header().Get("ETag"); tag != `"1"` {
+		t.Errorf("POST %s: ETag %s, want \"1\"", path, tag)
+	}
+	id, _ := document(t, env)["id"].(string)
+	if id == "" {
+		t.Fatalf("POST %s: no id in %s", path, env.Data)
+	}
+	return id
+}
+
+func TestUserCRUD(t *testing.T) {
+	r := newTestRouter(t)
+
+	w, env := call(t, r, http.MethodPost, "/users", `{"name":"Ada","phone_region":"XX"}`)
+	expect(t, w, env, http.StatusBadRequest)
+	id := create(t, r, "/users", `{"name":"Ada","color_code":"#ff0000","phone_region":"gb"}`, http.StatusCreated)
+
+	w, env = call(t, r, http.MethodGet, "/users/"+id, "")
+	expect(t, w, env, http.StatusOK)
+	if user := document(t, env); user["name"] != "Ada" || user["phone_region"] != "GB" {
+		t.Errorf("GET: %v", user)
+	}
+	w, _ = call(t, r, http.MethodGet, "/users/"+id, "", "If-None-Match", `"1"`)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("GET If-None-Match current: status %d, want 304", w.Code)
+	}
+
+	w, env = call(t, r, http.MethodGet, "/users", "")
+	expect(t, w, env, http.StatusOK)
+	if env.Total != 1 {
+		t.Errorf("list total %d, want 1", env.Total)
+	}
+
+	w, env = call(t, r, http.MethodPut, "/users/"+id, `{"name":"Ada King"}`, "If-Match", `"7"`)
+	expect(t, w, env, http.StatusPreconditionFailed)
+	w, env = call(t, r, http.MethodPut, "/users/"+id, `{"name":"Ada King"}`, "If-Match", `"1"`)
+	expect(t, w, env, http.StatusOK)
+	if user := document(t, env); user["name"] != "Ada King" || user["color_code"] != "#ff0000" || w.Header().Get("ETag") != `"2"` {
+		t.Errorf("PUT: %v, ETag %s", user, w.Header().Get("ETag"))
+	}
+
+	w, env = call(t, r, http.MethodPatch, "/users/"+id, `{"color_code":null}`, "Content-Type", "application/merge-patch+json")
+	expect(t, w, env, http.StatusOK)
+	if user := document(t, env); user["color_code"] != "" || user["name"] != "Ada King" {
+		t.Errorf("merge patch: %v", user)
+	}
+	w, env = call(t, r, http.MethodPatch, "/users/"+id, `[{"op":"test","path":"/name","value":"Ada"}]`, "Content-Type", "application/json-patch+json")
+	expect(t, w, env, http.StatusConflict)
+	w, env = call(t, r, http.MethodPatch, "/users/"+id, `{"name":"Ada"}`)
+	expect(t, w, env, http.StatusUnsupportedMediaType)
+
+	w, env = call(t, r, http.MethodDelete, "/users/"+id, "")
+	expect(t, w, env, http.StatusOK)
+	w, env = call(t, r, http.MethodGet, "/users/"+id, "")
+	expect(t, w, env, http.StatusNotFound)
+	w, env = call(t, r, http.MethodPost, "/users/"+id+"/restore", "")
+	expect(t, w, env, http.StatusOK)
+	w, env = call(t, r, http.MethodGet, "/users/"+id, "")
+	expect(t, w, env, http.StatusOK)
+
+	w, env = call(t, r, http.MethodGet, "/users/not-an-id", "")
+	expect(t, w, env, http.StatusBadRequest)
+}
+
+func TestEmojiCRUD(t *testing.T) {
+	r := newTestRouter(t)
+
+	id := create(t, r, "/emojis", `{"emoji":"🙂","emoji_name":"smile","emoji_index":1}`, http.StatusOK)
+	create(t, r, "/emojis", `{"emoji":"🙃","emoji_name":"upside down","emoji_index":2}`, http.StatusOK)
+
+	w, env := call(t, r, http.MethodGet, "/emojis?limit=1&sort=emoji_index", "")
+	expect(t, w, env, http.StatusOK)
+	if env.Total != 2 || env.NextCursor == "" {
+		t.Fatalf("first page: total %d, cursor %q", env.Total, env.NextCursor)
+	}
+	w, env = call(t, r, http.MethodGet, "/emojis?limit=1&sort=emoji_index&cursor="+env.NextCursor, "")
+	expect(t, w, env, http.StatusOK)
+	var page []map[string]interface{}
+	if err := json.Unmarshal(env.Data, &page); err != nil || len(page) != 1 || page[0]["emoji_name"] != "upside down" {
+		t.Errorf("second page: %s", env.Data)
+	}
+	if strings.Contains(w.Body.String(), `"total"`) {
+		t.Errorf("second page counted the total again: %s", w.Body)
+	}
+	w, env = call(t, r, http.MethodGet, "/emojis?cursor=bogus", "")
+	expect(t, w, env, http.StatusBadRequest)
+
+	w, env = call(t, r, http.MethodPut, "/emojis/"+id, `{"emoji_name":"grin"}`)
+	expect(t, w, env, http.StatusOK)
+	w, env = call(t, r, http.MethodGet, "/emojis/"+id, "")
+	expect(t, w, env, http.StatusOK)
+	if emoji := document(t, env); emoji["emoji_name"] != "grin" || emoji["emoji"] != "🙂" {
+		t.Errorf("GET after PUT: %v", emoji)
+	}
+
+	w, env = call(t, r, http.MethodDelete, "/emojis/"+id, "")
+	expect(t, w, env, http.StatusOK)
+	w, env = call(t, r, http.MethodGet, "/emojis/"+id, "")
+	expect(t, w, env, http.StatusNotFound)
+}
+
+func TestContactCRUD(t *testing.T) {
+	r := newTestRouter(t)
+	userID := create(t, r, "/users", `{"name":"Ada"}`, http.StatusCreated)
+	businessID := create(t, r, "/businesses", `{"business_name":"Engines","user_id":"`+userID+`"}`, http.StatusOK)
+	owned := `"user_id":"` + userID + `","business_id":"` + businessID + `"`
+
+	w, env := call(t, r, http.MethodPost, "/contacts", `{"name":"Grace",`+owned+`,"cell_phone":"12"}`)
+	expect(t, w, env, http.StatusBadRequest)
+	w, env = call(t, r, http.MethodPost, "/contacts", `{"name":"Grace","user_id":"000000000000000000000000","business_id":"`+businessID+`"}`)
+	expect(t, w, env, http.StatusBadRequest)
+
+	id := create(t, r, "/contacts", `{"name":"Grace",`+owned+`,"cell_phone":"(202) 555-0143"}`, http.StatusCreated)
+	w, env = call(t, r, http.MethodGet, "/contacts/"+id, "")
+	expect(t, w, env, http.StatusOK)
+	if contact := document(t, env); contact["cell_phone_e164"] != "+12025550143" {
+		t.Errorf("GET: %v", contact)
+	}
+
+	w, env = call(t, r, http.MethodPatch, "/contacts/"+id, `[{"op":"replace","path":"/work_phone","value":"+44 20 7946 0958"}]`,
+		"Content-Type", "application/json-patch+json", "If-Match", `"1"`)
+	expect(t, w, env, http.StatusOK)
+	if contact := document(t, env); contact["work_phone_e164"] != "+442079460958" || contact["revision"] != 2.0 {
+		t.Errorf("JSON Patch: %v", contact)
+	}
+
+	w, env = call(t, r, http.MethodGet, "/contacts?user_id="+userID, "")
+	expect(t, w, env, http.StatusOK)
+	if env.Total != 1 {
+		t.Errorf("list total %d, want 1", env.Total)
+	}
+
+	w, env = call(t, r, http.MethodDelete, "/contacts/"+id, "")
+	expect(t, w, env, http.StatusOK)
+	w, env = call(t, r, http.MethodGet, "/contacts?user_id="+userID, "")
+	expect(t, w, env, http.StatusOK)
+	if env.Total != 0 {
+		t.Errorf("list total after delete %d, want 0", env.Total)
+	}
+	w, env = call(t, r, http.MethodPost, "/contacts/"+id+"/restore", "")
+	expect(t, w, env, http.StatusOK)
+	w, env = call(t, r, http.MethodGet, "/contacts/"+id, "")
+	expect(t, w, env, http.StatusOK)
+}
+
+func TestBusinessCRUD(t *testing.T) {
+	r := newTestRouter(t)
+	userID := create(t, r, "/users", `{"name":"Ada"}`, http.StatusCreated)
+
+	w, env := call(t, r, http.MethodPost, "/businesses", `{"business_name":"Engines"}`)
+	expect(t, w, env, http.StatusBadRequest)
+	w, env = call(t, r, http.MethodPost, "/businesses", `{"business_name":"Engines","user_id":"`+userID+`","status":42}`)
+	expect(t, w, env, http.StatusBadRequest)
+
+	id := create(t, r, "/businesses", `{"business_name":"Engines","user_id":"`+userID+`"}`, http.StatusOK)
+	w, env = call(t, r, http.MethodPatch, "/businesses/"+id, `{"website":"https://example.com"}`, "Content-Type", "application/merge-patch+json")
+	expect(t, w, env, http.StatusOK)
+	w, env = call(t, r, http.MethodGet, "/businesses/"+id, "")
+	expect(t, w, env, http.StatusOK)
+	if business := document(t, env); business["website"] != "https://example.com" || business["business_name"] != "Engines" {
+		t.Errorf("GET after PATCH: %v", business)
+	}
+
+	w, env = call(t, r, http.MethodGet, "/businesses?status=0", "")
+	expect(t, w, env, http.StatusOK)
+	if env.Total != 1 {
+		t.Errorf("list total %d, want 1", env.Total)
+	}
+	w, env = call(t, r, http.MethodGet, "/businesses?status=3&status=0", "")
+	expect(t, w, env, http.StatusOK)
+	if env.Total != 1 {
+		t.Errorf("list of either status: total %d, want 1", env.Total)
+	}
+	w, env = call(t, r, http.MethodGet, "/businesses?status[gte]=0&status[gte]=3", "")
+	expect(t, w, env, http.StatusBadRequest)
+
+	w, env = call(t, r, http.MethodDelete, "/businesses/"+id, "")
+	expect(t, w, env, http.StatusOK)
+	w, env = call(t, r, http.MethodGet, "/businesses/"+id, "")
+	expect(t, w, env, http.StatusNotFound)
+}
+
+// TestCallUpdateReturnsStoredRevision checks PUT and PATCH answer with the
+// call as stored and its ETag
+func TestCallUpdateReturnsStoredRevision(t *testing.T) {
+	r := newTestRouter(t)
+	userID := create(t, r, "/users", `{"name":"Ada"}`, http.StatusCreated)
+	businessID := create(t, r, "/businesses", `{"business_name":"Engines","user_id":"`+userID+`"}`, http.StatusOK)
+	id := create(t, r, "/calls", `{"user_id":"`+userID+`","business_id":"`+businessID+`","direction":"inbound","start_time":"2024-05-01T10:00:00Z","duration":60}`, http.StatusCreated)
+
+	w, env := call(t, r, http.MethodPut, "/calls/"+id, `{"user_id":"`+userID+`","business_id":"`+businessID+`","direction":"inbound","start_time":"2024-05-01T10:00:00Z","duration":90}`, "If-Match", `"1"`)
+	expect(t, w, env, http.StatusOK)
+	if saved := document(t, env); saved["revision"] != 2.0 || saved["created_date"] == "0001-01-01T00:00:00Z" || w.Header().Get("ETag") != `"2"` {
+		t.Errorf("PUT: %v, ETag %s", saved, w.Header().Get("ETag"))
+	}
+
+	w, env = call(t, r, http.MethodPatch, "/calls/"+id, `{"notes":"call back"}`, "Content-Type", "application/merge-patch+json", "If-Match", `"2"`)
+	expect(t, w, env, http.StatusOK)
+	if saved := document(t, env); saved["revision"] != 3.0 || saved["notes"] != "call back" || w.Header().Get("ETag") != `"3"` {
+		t.Errorf("PATCH: %v, ETag %s", saved, w.Header().Get("ETag"))
+	}
+}
@@ -1,39 +1,158 @@
 package router
 
 import (
+	"context"
+	"sync"
+
 	"github.com/gin-gonic/gin"
+	"usermanagement/config"
 	"usermanagement/controllers"
+	"usermanagement/data"
+	"usermanagement/pipeline"
 )
 
-func InitRouter() *gin.Engine {
-	r := gin.Default()
+// InitRouter registers every route. Work a request leaves running, such as a
+// background import, stops when ctx is cancelled and is counted in
+// background until it has.
+func InitRouter(ctx context.Context, background *sync.WaitGroup, cfg *config.Config, repos *data.Repositories, machine *pipeline.Machine) *gin.Engine {
+	engine := gin.Default()
+	if cfg.Features.RequireIfMatch {
+		engine.Use(controllers.RequireIfMatch)
+	}
+	// every route but the streamed ones gets the query timeout
+	r := engine.Group("/", controllers.QueryTimeout(cfg.Mongo.QueryTimeout.Duration))
+
+	users := controllers.NewUserController(repos, cfg.Integrity)
+	r.GET("/users", users.GetUsers)
+	r.GET("/users/:id", users.GetUsersByID)
+	r.POST("/users", users.PostUser)
+	r.POST("/users/bulk", users.BulkUsers)
+	r.DELETE("/users/:id", users.RemoveUser)
+	r.POST("/users/:id/restore", users.RestoreUser)
+	r.PUT("/users/:id", users.UpdateUser)
+	r.PATCH("/users/:id", users.PatchUser)
+	r.GET("/users/:id/history", users.GetUserHistory)
+	r.GET("/users/:id/history/:version/diff", users.GetUserVersionDiff)
+	r.POST("/users/:id/revert/:version", users.RevertUser)
 
-	r.GET("/users", controllers.GetUsers)
-	r.GET("/users/:id", controllers.GetUsersByID)
-	r.POST("/users", controllers.PostUser)
-	r.DELETE("/users/:id", controllers.RemoveUser)
-	r.PUT("/users/:id", controllers.UpdateUser)
+	calendars := controllers.NewCalendarController(repos, cfg.Calendar)
+	r.GET("/users/:id/calendar", calendars.GetFollowupFeedLink)
+	r.DELETE("/users/:id/calendar", calendars.RevokeFollowupFeedLink)
+	r.GET("/users/:id/followups.ics", calendars.GetFollowupFeed)
 
 	// Emoji routes
-	r.GET("/emojis", controllers.GetEmojis)
-	r.GET("/emojis/:id", controllers.GetEmojiByID)
-	r.POST("/emojis", controllers.PostEmoji)
-	r.DELETE("/emojis/:id", controllers.RemoveEmoji)
-	r.PUT("/emojis/:id", controllers.UpdateEmoji)
+	emojis := controllers.NewEmojiController(repos, cfg.Integrity)
+	r.GET("/emojis", emojis.GetEmojis)
+	r.GET("/emojis/:id", emojis.GetEmojiByID)
+	r.POST("/emojis", emojis.PostEmoji)
+	r.POST("/emojis/bulk", emojis.BulkEmojis)
+	r.DELETE("/emojis/:id", emojis.RemoveEmoji)
+	r.POST("/emojis/:id/restore", emojis.RestoreEmoji)
+	r.PUT("/emojis/:id", emojis.UpdateEmoji)
+	r.PATCH("/emojis/:id", emojis.PatchEmoji)
+	r.GET("/emojis/:id/history", emojis.GetEmojiHistory)
+	r.GET("/emojis/:id/history/:version/diff", emojis.GetEmojiVersionDiff)
+	r.POST("/emojis/:id/revert/:version", emojis.RevertEmoji)
 
 	// Contact routes
-	r.GET("/contacts", controllers.GetContacts)
-	r.POST("/contacts", controllers.PostContact)
-	r.GET("/contacts/:id", controllers.GetContactByID)
-	r.PUT("/contacts/:id", controllers.UpdateContact)
-	r.DELETE("/contacts/:id", controllers.RemoveContact)
+	contacts := controllers.NewContactController(repos, cfg.Phone, cfg.Integrity)
+	r.GET("/contacts", contacts.GetContacts)
+	r.POST("/contacts", contacts.PostContact)
+	r.POST("/contacts/bulk", contacts.BulkContacts)
+	r.GET("/contacts/near", contacts.GetNearbyContacts)
+	r.GET("/contacts/within", contacts.GetContactsWithin)
+	r.GET("/contacts/duplicates", contacts.GetDuplicateContacts)
+	r.POST("/contacts/merge", contacts.MergeContacts)
+	r.GET("/contacts/:id", contacts.GetContactByID)
+	r.GET("/contacts/:id/vcard", contacts.GetContactVCard)
+	r.PUT("/contacts/:id", contacts.UpdateContact)
+	r.PATCH("/contacts/:id", contacts.PatchContact)
+	r.DELETE("/contacts/:id", contacts.RemoveContact)
+	r.POST("/contacts/:id/restore", contacts.RestoreContact)
+	r.GET("/contacts/:id/history", contacts.GetContactHistory)
+	r.GET("/contacts/:id/history/:version/diff", contacts.GetContactVersionDiff)
+	r.POST("/contacts/:id/revert/:version", contacts.RevertContact)
 
 	// Business routes
-	r.GET("/businesses", controllers.GetBusinesses)
-	r.POST("/businesses", controllers.PostBusiness)
-	r.GET("/businesses/:id", controllers.GetBusinessByID)
-	r.PUT("/businesses/:id", controllers. UpdateBusiness)
-	r.DELETE("/businesses/:id", controllers.RemoveBusiness)
+	businesses := controllers.NewBusinessController(repos, machine, cfg.Integrity)
+	r.GET("/businesses", businesses.GetBusinesses)
+	r.POST("/businesses", businesses.PostBusiness)
+	r.POST("/businesses/bulk", businesses.BulkBusinesses)
+	r.GET("/businesses/:id", businesses.GetBusinessByID)
+	r.PUT("/businesses/:id", businesses.UpdateBusiness)
+	r.PATCH("/businesses/:id", businesses.PatchBusiness)
+	r.DELETE("/businesses/:id", businesses.RemoveBusiness)
+	r.POST("/businesses/:id/restore", businesses.RestoreBusiness)
+	r.GET("/businesses/:id/history", businesses.GetBusinessHistory)
+	r.GET("/businesses/:id/history/:version/diff", businesses.GetBusinessVersionDiff)
+	r.POST("/businesses/:id/revert/:version", businesses.RevertBusiness)
+	r.GET("/businesses/:id/vcard", contacts.GetBusinessVCards)
+	r.POST("/businesses/:id/transition", businesses.TransitionBusiness)
+	r.GET("/businesses/:id/transitions", businesses.GetBusinessTransitions)
+	r.GET("/pipeline", businesses.GetPipeline)
+
+	// Call routes
+	calls := controllers.NewCallController(repos)
+	r.GET("/calls", calls.GetCalls)
+	r.POST("/calls", calls.PostCall)
+	r.POST("/calls/bulk", calls.BulkCalls)
+	r.GET("/calls/:id", calls.GetCallByID)
+	r.PUT("/calls/:id", calls.UpdateCall)
+	r.PATCH("/calls/:id", calls.PatchCall)
+	r.DELETE("/calls/:id", calls.RemoveCall)
+	r.GET("/businesses/:id/calls", calls.GetBusinessCalls)
+	r.GET("/contacts/:id/calls", calls.GetContactCalls)
+
+	// Timeline routes
+	timelines := controllers.NewTimelineController(repos)
+	r.GET("/businesses/:id/timeline", timelines.GetBusinessTimeline)
+	r.GET("/contacts/:id/timeline", timelines.GetContactTimeline)
+
+	// Import routes
+	imports := controllers.NewImportController(ctx, background, repos, contacts, businesses)
+	r.POST("/import/contacts", imports.ImportContacts)
+	r.POST("/import/businesses", imports.ImportBusinesses)
+	r.POST("/import/vcard", imports.ImportVCard)
+	r.GET("/import/jobs/:id", imports.GetImportJob)
+
+	// Export routes
+	exports := controllers.NewExportController(repos)
+	engine.GET("/export/:resource", exports.Export)
+
+	// Search routes
+	search := controllers.NewSearchController(repos)
+	r.GET("/search", search.Search)
+
+	// Followup routes
+	followups := controllers.NewFollowupController(repos)
+	r.GET("/followups", followups.GetFollowups)
+	r.GET("/followups/:id", followups.GetFollowupByID)
+
+	// Trash routes
+	trash := controllers.NewTrashController(repos, cfg.Trash)
+	r.GET("/trash", trash.GetTrash)
+
+	// Webhook routes
+	webhooks := controllers.NewWebhookController(repos)
+	r.GET("/webhooks", webhooks.GetWebhooks)
+	r.POST("/webhooks", webhooks.PostWebhook)
+	r.GET("/webhooks/:id", webhooks.GetWebhookByID)
+	r.PUT("/webhooks/:id", webhooks.UpdateWebhook)
+	r.DELETE("/webhooks/:id", webhooks.RemoveWebhook)
+	r.GET("/webhooks/:id/deliveries", webhooks.GetWebhookDeliveries)
+	r.GET("/webhooks/:id/deliveries/:delivery", webhooks.GetWebhookDelivery)
+	r.POST("/webhooks/:id/deliveries/:delivery/redeliver", webhooks.RedeliverWebhookDelivery)
+
+	// Live update routes
+	streams := controllers.NewStreamController(repos, cfg.Stream)
+	engine.GET("/stream", streams.Stream)
+
+	// API description routes, described last so they see every route
+	docs := controllers.NewDocsController()
+	r.GET("/openapi.json", docs.GetOpenAPI)
+	r.GET("/docs", docs.GetDocs)
+	r.GET("/docs/assets/*filepath", docs.GetDocsAsset)
+	docs.Describe(engine.Routes())
 
-	return r
+	return engine
 }
@@ -0,0 +1,127 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"usermanagement/config"
+	"usermanagement/data"
+	"usermanagement/pipeline"
+)
+
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg := config.Default()
+	machine, err := pipeline.NewMachine(cfg.Pipeline.Transitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return InitRouter(context.Background(), &sync.WaitGroup{}, &cfg, data.NewMemoryRepositories(), machine)
+}
+
+// getSpec fetches the OpenAPI document the router serves
+func getSpec(t *testing.T, r *gin.Engine) map[string]interface{} {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json: status %d", w.Code)
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("GET /openapi.json: %v", err)
+	}
+	return spec
+}
+
+// specPath is a gin route path as OpenAPI writes it, {id} for :id
+func specPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// TestOpenAPICoversRoutes fails when a route is registered without an
+// endpoint documenting it in controllers/endpoints.go
+func TestOpenAPICoversRoutes(t *testing.T) {
+	r := newTestRouter(t)
+	spec := getSpec(t, r)
+
+	if spec["openapi"] != "3.1.0" {
+		t.Errorf("openapi = %v, want 3.1.0", spec["openapi"])
+	}
+	paths, _ := spec["paths"].(map[string]interface{})
+	documented := 0
+	for _, route := range r.Routes() {
+		item, _ := paths[specPath(route.Path)].(map[string]interface{})
+		if _, ok := item[strings.ToLower(route.Method)]; !ok {
+			t.Errorf("%s %s is not in the OpenAPI document; document it in controllers/endpoints.go", route.Method, route.Path)
+			continue
+		}
+		documented++
+	}
+
+	operations := 0
+	for _, item := range paths {
+		operations += len(item.(map[string]interface{}))
+	}
+	if operations != documented {
+		t.Errorf("the document has %d operations for %d routes", operations, documented)
+	}
+}
+
+// TestOpenAPIRefsResolve fails when the document refers to a schema or
+// response it does not define
+func TestOpenAPIRefsResolve(t *testing.T) {
+	spec := getSpec(t, newTestRouter(t))
+
+	var walk func(node interface{})
+	walk = func(node interface{}) {
+		switch node := node.(type) {
+		case map[string]interface{}:
+			if ref, ok := node["$ref"].(string); ok {
+				if !resolves(spec, ref) {
+					t.Errorf("$ref %s does not resolve", ref)
+				}
+			}
+			for _, child := range node {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range node {
+				walk(child)
+			}
+		}
+	}
+	walk(spec)
+}
+
+// resolves reports whether a local reference such as
+// #/components/schemas/User names something in spec
+func resolves(spec map[string]interface{}, ref string) bool {
+	if !strings.HasPrefix(ref, "#/") {
+		return false
+	}
+	var node interface{} = spec
+	for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if node, ok = object[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package router
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestRestoreCascadedChildren checks restoring a business brings back the
+// contacts its delete cascaded to and the references it cleared, and that a
+// cascaded contact is only restored along with it
+func TestRestoreCascadedChildren(t *testing.T) {
+	r := newTestRouter(t)
+	userID := create(t, r, "/users", `{"name":"Ada"}`, http.StatusCreated)
+	businessID := create(t, r, "/businesses", `{"business_name":"Engines","user_id":"`+userID+`"}`, http.StatusOK)
+	contactID := create(t, r, "/contacts", `{"name":"Grace","user_id":"`+userID+`","business_id":"`+businessID+`"}`, http.StatusCreated)
+	otherID := create(t, r, "/businesses", `{"business_name":"Looms","user_id":"`+userID+`","contact_id":"`+contactID+`"}`, http.StatusOK)
+
+	w, env := call(t, r, http.MethodDelete, "/businesses/"+businessID, "")
+	expect(t, w, env, http.StatusOK)
+	w, env = call(t, r, http.MethodGet, "/contacts/"+contactID, "")
+	expect(t, w, env, http.StatusNotFound)
+	w, env = call(t, r, http.MethodGet, "/businesses/"+otherID, "")
+	expect(t, w, env, http.StatusOK)
+	if other := document(t, env); other["contact_id"] != "000000000000000000000000" {
+		t.Errorf("the other business kept contact_id %v", other["contact_id"])
+	}
+	w, env = call(t, r, http.MethodGet, "/trash", "")
+	expect(t, w, env, http.StatusOK)
+	if env.Total != 1 {
+		t.Errorf("trash total %d, want one entry for the business and its contact", env.Total)
+	}
+
+	w, env = call(t, r, http.MethodPost, "/contacts/"+contactID+"/restore", "")
+	expect(t, w, env, http.StatusConflict)
+	if with := document(t, env); with["resource"] != "businesses" || with["id"] != businessID {
+		t.Errorf("restoring the contact alone: %v, want it pointed at business %s", with, businessID)
+	}
+
+	w, env = call(t, r, http.MethodPost, "/businesses/"+businessID+"/restore", "")
+	expect(t, w, env, http.StatusOK)
+	w, env = call(t, r, http.MethodGet, "/contacts/"+contactID, "")
+	expect(t, w, env, http.StatusOK)
+	if contact := document(t, env); contact["business_id"] != businessID {
+		t.Errorf("restored contact belongs to business %v, want %s", contact["business_id"], businessID)
+	}
+	w, env = call(t, r, http.MethodGet, "/businesses/"+otherID, "")
+	expect(t, w, env, http.StatusOK)
+	if other := document(t, env); other["contact_id"] != contactID {
+		t.Errorf("the other business has contact_id %v after the restore, want %s", other["contact_id"], contactID)
+	}
+	w, env = call(t, r, http.MethodGet, "/trash", "")
+	expect(t, w, env, http.StatusOK)
+	if env.Total != 0 {
+		t.Errorf("trash total %d after the restore, want 0", env.Total)
+	}
+}
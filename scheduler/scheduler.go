@@ -0,0 +1,169 @@
+// Package scheduler fires follow-ups for businesses with auto_followup enabled
+// once their next_followup_date has passed.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/config"
+	"usermanagement/data"
+	"usermanagement/integrity"
+	"usermanagement/leader"
+	"usermanagement/models"
+)
+
+// lockName identifies the leader lock shared by every instance
+const lockName = "followup-scheduler"
+
+// Scheduler periodically emits follow-up tasks for due businesses. Only the
+// instance holding the leader lock does any work.
+type Scheduler struct {
+	Businesses data.BusinessRepository
+	Followups  data.FollowupRepository
+	Activities data.ActivityRepository
+	Outbox     data.OutboxRepository
+	History    data.HistoryRepository
+	Tx         data.Transactor
+	Leader     *leader.Loop
+
+	Cadence   time.Duration
+	BatchSize int
+
+	Now func() time.Time
+}
+
+func New(repos *data.Repositories, cfg config.SchedulerConfig) *Scheduler {
+	return &Scheduler{
+		Businesses: repos.Businesses,
+		Followups:  repos.Followups,
+		Activities: repos.Activities,
+		Outbox:     repos.Outbox,
+		History:    repos.History,
+		Tx:         repos.Tx,
+		Leader:     leader.New(lockName, repos.Locks, cfg.Interval.Duration, cfg.LockTTL.Duration),
+		Cadence:    cfg.Cadence.Duration,
+		BatchSize:  cfg.BatchSize,
+		Now:        time.Now,
+	}
+}
+
+// Run fires due follow-ups while this instance leads, until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	s.Leader.Run(ctx, s.tick)
+}
+
+func (s *Scheduler) tick(ctx context.Context) {
+	fired, err := s.RunOnce(ctx)
+	if err != nil {
+		log.Printf("scheduler: %v", err)
+	}
+	if fired > 0 {
+		log.Printf("scheduler: fired %d follow-ups", fired)
+	}
+}
+
+// RunOnce fires every due follow-up and returns how many were fired. It stops
+// between pages once ctx is cancelled, as it is when leadership is lost.
+func (s *Scheduler) RunOnce(ctx context.Context) (int, error) {
+	now := s.Now()
+	opts := data.ListOptions{
+		Filters: []data.Filter{
+			{Field: "auto_followup", Op: data.OpEq, Value: true},
+			{Field: "next_followup_date", Op: data.OpLte, Value: now},
+		},
+		Sort:  "next_followup_date",
+		Limit: s.BatchSize,
+	}
+
+	fired := 0
+	for {
+		page, err := s.Businesses.List(ctx, opts)
+		if err != nil {
+			return fired, fmt.Errorf("listing due businesses: %w", err)
+		}
+		for _, business := range page.Items {
+			ok, err := s.fire(ctx, business, now)
+			if err != nil {
+				return fired, fmt.Errorf("business %s: %w", business.ID.Hex(), err)
+			}
+			if ok {
+				fired++
+			}
+		}
+		if page.NextCursor == "" {
+			return fired, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return fired, err
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// fire emits the follow-up a business is due. The business is moved on to its
+// next follow-up first, and only if it still has the due date it was listed
+// with, so a follow-up another instance fired or a user rescheduled in the
+// meantime is not fired again. The move, the follow-up and its webhook event
+// are saved in one transaction. It reports whether the follow-up was fired.
+func (s *Scheduler) fire(ctx context.Context, business models.Business, now time.Time) (bool, error) {
+	next := s.nextDate(business.NextFollowupDate, now)
+	followup := models.Followup{
+		ID:          primitive.NewObjectID(),
+		BusinessID:  business.ID,
+		UserID:      business.UserID,
+		DueDate:     business.NextFollowupDate,
+		CreatedDate: now,
+	}
+	activity := models.Activity{
+		ID:          primitive.NewObjectID(),
+		Type:        models.ActivityFollowupFired,
+		BusinessID:  business.ID,
+		UserID:      business.UserID,
+		Summary:     "Follow-up due, next one scheduled for " + next.Format("2006-01-02"),
+		Details:     map[string]interface{}{"followup_id": followup.ID, "next_followup_date": next},
+		CreatedDate: now,
+	}
+
+	var moved models.Business
+	err := s.Tx.Atomically(ctx, func(ctx context.Context) error {
+		var err error
+		if moved, err = s.Businesses.RecordFollowup(ctx, business.ID, business.NextFollowupDate, now, next); err != nil {
+			return err
+		}
+		if err := s.Followups.Insert(ctx, followup); err != nil {
+			return err
+		}
+		return s.Outbox.Insert(ctx, models.NewEvent(activity))
+	})
+	if err == data.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// the scheduler acts as no user; a failure is logged as the move is saved
+	change := models.Version{Resource: integrity.Businesses, Action: models.VersionUpdated}
+	if err := s.History.Snapshot(ctx, change, moved); err != nil {
+		log.Printf("scheduler: recording businesses versions: %v", err)
+	}
+
+	return true, s.Activities.Insert(ctx, activity)
+}
+
+// nextDate advances from the previous due date by whole cadences until it is
+// in the future, so a long outage fires once instead of once per missed cadence
+func (s *Scheduler) nextDate(previous, now time.Time) time.Time {
+	next := previous
+	if next.IsZero() {
+		next = now
+	}
+	if next.After(now) {
+		return next
+	}
+	missed := now.Sub(next)/s.Cadence + 1
+	return next.Add(missed * s.Cadence)
+}
@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/config"
+	"usermanagement/data"
+	"usermanagement/models"
+)
+
+// TestRunOnce checks only due businesses with automatic follow-ups fire,
+// once each however long they were overdue, and not again on the next run
+func TestRunOnce(t *testing.T) {
+	ctx := context.Background()
+	repos := data.NewMemoryRepositories()
+	s := New(repos, config.SchedulerConfig{BatchSize: 1, Cadence: config.Duration{Duration: 7 * 24 * time.Hour}})
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.Now = func() time.Time { return now }
+
+	business := func(auto bool, next time.Time) models.Business {
+		b := models.Business{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID(), BusinessName: "Engines", AutoFollowup: auto, NextFollowupDate: next}
+		if err := repos.Businesses.Insert(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	due := business(true, now.Add(-time.Hour))
+	overdue := business(true, now.Add(-20*24*time.Hour))
+	business(true, now.Add(time.Hour))
+	business(false, now.Add(-time.Hour))
+
+	fired, err := s.RunOnce(ctx)
+	if err != nil || fired != 2 {
+		t.Fatalf("RunOnce = %d, %v; want 2 follow-ups fired", fired, err)
+	}
+	for b, next := range map[primitive.ObjectID]time.Time{
+		due.ID:     due.NextFollowupDate.Add(s.Cadence),
+		overdue.ID: overdue.NextFollowupDate.Add(3 * s.Cadence),
+	} {
+		moved, err := repos.Businesses.FindByID(ctx, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !moved.NextFollowupDate.Equal(next) || !moved.LastFollowupDate.Equal(now) {
+			t.Errorf("business %s: next %v, last %v; want next %v, last %v", b.Hex(), moved.NextFollowupDate, moved.LastFollowupDate, next, now)
+		}
+	}
+	followups, err := repos.Followups.List(ctx, data.ListOptions{Limit: 10})
+	if err != nil || len(followups.Items) != 2 {
+		t.Errorf("follow-ups %v, %v; want 2", followups.Items, err)
+	}
+
+	if fired, err := s.RunOnce(ctx); err != nil || fired != 0 {
+		t.Errorf("second RunOnce = %d, %v; want nothing fired", fired, err)
+	}
+}
@@ -0,0 +1,267 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/data"
+	"usermanagement/integrity"
+	"usermanagement/models"
+)
+
+// watched lists the resources whose changes a poll reports
+var watched = map[string]bool{
+	integrity.Users:      true,
+	integrity.Emojis:     true,
+	integrity.Contacts:   true,
+	integrity.Businesses: true,
+}
+
+// versioned names one document in the history
+type versioned struct {
+	resource string
+	id       primitive.ObjectID
+}
+
+// latest returns the ID of the last version in the history, zero when it is
+// empty, which is where a new poll starts reading
+func (s *Streamer) latest(ctx context.Context) (primitive.ObjectID, error) {
+	page, err := s.History.After(ctx, primitive.NilObjectID, data.ListOptions{Desc: true, Limit: 1})
+	if err != nil || len(page.Items) == 0 {
+		return primitive.NilObjectID, err
+	}
+	return page.Items[0].ID, nil
+}
+
+// window returns the versions up to after that a poll past it reads again,
+// which a new feed has not been sent
+func (s *Streamer) window(ctx context.Context, after primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	seen := make(map[primitive.ObjectID]bool)
+	opts := data.ListOptions{Limit: data.MaxListLimit}
+	for {
+		page, err := s.History.After(ctx, s.since(after), opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range page.Items {
+			if bytes.Compare(version.ID[:], after[:]) <= 0 {
+				seen[version.ID] = true
+			}
+		}
+		if page.NextCursor == "" {
+			return seen, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// since is where a poll past the version after starts reading. Versions
+// are numbered by the clock of the instance recording them once their write
+// is saved, so one recorded late or by an instance whose clock is behind can
+// sort before after; the last PollLookback of the history is read again to
+// find those.
+func (s *Streamer) since(after primitive.ObjectID) primitive.ObjectID {
+	if after.IsZero() || s.PollLookback <= 0 {
+		return after
+	}
+	return primitive.NewObjectIDFromTimestamp(after.Timestamp().Add(-s.PollLookback))
+}
+
+// changes reads the history from since(after), skipping the versions in
+// seen, and returns a change for each document userID can see, or could see
+// before, that it shows changed, in the order their first versions were
+// recorded. It also returns the last version read, which the next poll reads
+// past, and the versions it read.
+func (s *Streamer) changes(ctx context.Context, userID, after primitive.ObjectID, seen map[primitive.ObjectID]bool) ([]Event, primitive.ObjectID, []primitive.ObjectID, error) {
+	var order []versioned
+	var read []primitive.ObjectID
+	versions := make(map[versioned][]models.Version)
+	opts := data.ListOptions{Limit: data.MaxListLimit}
+	from := s.since(after)
+	for {
+		page, err := s.History.After(ctx, from, opts)
+		if err != nil {
+			return nil, after, nil, err
+		}
+		for _, version := range page.Items {
+			if seen[version.ID] {
+				continue
+			}
+			read = append(read, version.ID)
+			if bytes.Compare(version.ID[:], after[:]) > 0 {
+				after = version.ID
+			}
+			if !watched[version.Resource] {
+				continue
+			}
+			doc := versioned{version.Resource, version.DocumentID}
+			if _, ok := versions[doc]; !ok {
+				order = append(order, doc)
+			}
+			versions[doc] = append(versions[doc], version)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+
+	var events []Event
+	for _, doc := range order {
+		event, ok, err := s.change(ctx, userID, doc, versions[doc])
+		if err != nil {
+			return nil, after, nil, err
+		}
+		if ok {
+			events = append(events, event)
+		}
+	}
+	return events, after, read, nil
+}
+
+// change tells what the versions of a document recorded since the last poll
+// mean to userID. A document the user can see is inserted when the first of
+// them created or restored it, and updated otherwise. One they cannot see,
+// because it is gone or belongs to someone else now, is deleted if any of
+// them showed it to the user.
+func (s *Streamer) change(ctx context.Context, userID primitive.ObjectID, doc versioned, versions []models.Version) (Event, bool, error) {
+	current, owner, err := s.find(ctx, doc)
+	if err != nil && !errors.Is(err, data.ErrNotFound) {
+		return Event{}, false, err
+	}
+	change := data.Change{Resource: doc.resource, ID: doc.id}
+	if err == nil && sees(userID, doc.resource, owner) {
+		change.Op = data.ChangeUpdate
+		if action := versions[0].Action; action == models.VersionCreated || action == models.VersionRestored {
+			change.Op = data.ChangeInsert
+		}
+		change.Document = current
+		return Event{Type: change.Op, Change: change}, true, nil
+	}
+
+	for _, version := range versions {
+		if sees(userID, doc.resource, ownerOf(doc.resource, version.Snapshot)) {
+			change.Op = data.ChangeDelete
+			return Event{Type: change.Op, Change: change}, true, nil
+		}
+	}
+	return Event{}, false, nil
+}
+
+// find reads a live document and the user it belongs to
+func (s *Streamer) find(ctx context.Context, doc versioned) (interface{}, primitive.ObjectID, error) {
+	switch doc.resource {
+	case integrity.Users:
+		user, err := s.Users.FindByID(ctx, doc.id)
+		return user, user.ID, err
+	case integrity.Emojis:
+		emoji, err := s.Emojis.FindByID(ctx, doc.id)
+		return emoji, primitive.NilObjectID, err
+	case integrity.Contacts:
+		contact, err := s.Contacts.FindByID(ctx, doc.id)
+		return contact, contact.UserID, err
+	case integrity.Businesses:
+		business, err := s.Businesses.FindByID(ctx, doc.id)
+		return business, business.UserID, err
+	}
+	return nil, primitive.NilObjectID, data.ErrNotFound
+}
+
+// ownerOf is the user a stored snapshot of a document belongs to
+func ownerOf(resource string, snapshot bson.Raw) primitive.ObjectID {
+	field := "user_id"
+	if resource == integrity.Users {
+		field = "_id"
+	}
+	owner, _ := snapshot.Lookup(field).ObjectIDOK()
+	return owner
+}
+
+// sees reports whether userID sees a document of resource belonging to
+// owner: their own user record, every emoji and the contacts and businesses
+// they own
+func sees(userID primitive.ObjectID, resource string, owner primitive.ObjectID) bool {
+	return resource == integrity.Emojis || owner == userID
+}
+
+// pollFeed finds changes by reading the history, where every change to a
+// watched document records a version, past the last version it has read,
+// less the lookback window, skipping the versions it has already read. A
+// token only holds that last version's ID. Only the last event of a poll
+// carries an ID, as its token points past the whole poll; a client cut off
+// part way resumes from the poll before and is sent the rest again, as is one
+// resuming after anything in the lookback window.
+//
+// A version recorded later than the lookback window allows, after a poll has
+// read past newer ones, is not seen.
+type pollFeed struct {
+	streamer *Streamer
+	userID   primitive.ObjectID
+	after    primitive.ObjectID
+	// seen holds the versions in the lookback window already read
+	seen     map[primitive.ObjectID]bool
+	nextPoll time.Time
+}
+
+func (f *pollFeed) Next(ctx context.Context, wait time.Duration) ([]Event, error) {
+	now := f.streamer.Now()
+	until := now.Add(wait)
+	if f.nextPoll.Before(until) {
+		until = f.nextPoll
+	}
+	if d := until.Sub(now); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if f.streamer.Now().Before(f.nextPoll) {
+		return nil, nil
+	}
+	f.nextPoll = f.streamer.Now().Add(f.streamer.PollInterval)
+
+	events, after, read, err := f.streamer.changes(ctx, f.userID, f.after, f.seen)
+	if err != nil {
+		return nil, err
+	}
+	var id string
+	if len(events) > 0 {
+		if id, err = f.streamer.save(ctx, models.StreamToken{UserID: f.userID, After: &after}); err != nil {
+			return nil, err
+		}
+		events[len(events)-1].ID = id
+	}
+	// with no events, nothing the user sees changed, so a client resuming
+	// from the last token finds nothing more for them in what was read
+	f.advance(after, read)
+	return events, nil
+}
+
+// advance moves the feed past after, remembering the versions read and
+// forgetting those the lookback window has left behind
+func (f *pollFeed) advance(after primitive.ObjectID, read []primitive.ObjectID) {
+	f.after = after
+	if f.seen == nil {
+		f.seen = make(map[primitive.ObjectID]bool)
+	}
+	for _, id := range read {
+		f.seen[id] = true
+	}
+	from := f.streamer.since(after)
+	for id := range f.seen {
+		if bytes.Compare(id[:], from[:]) <= 0 {
+			delete(f.seen, id)
+		}
+	}
+}
+
+func (f *pollFeed) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,106 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/config"
+	"usermanagement/data"
+	"usermanagement/integrity"
+	"usermanagement/models"
+)
+
+// versions is a history whose versions are numbered by the test, as
+// instances with clocks apart would number them
+type versions struct {
+	data.HistoryRepository
+	items []models.Version
+}
+
+func (h *versions) After(ctx context.Context, id primitive.ObjectID, opts data.ListOptions) (data.Page[models.Version], error) {
+	var page data.Page[models.Version]
+	for _, version := range h.items {
+		if bytes.Compare(version.ID[:], id[:]) > 0 {
+			page.Items = append(page.Items, version)
+		}
+	}
+	sort.Slice(page.Items, func(i, j int) bool {
+		less := bytes.Compare(page.Items[i].ID[:], page.Items[j].ID[:]) < 0
+		return less != opts.Desc
+	})
+	if opts.Limit > 0 && len(page.Items) > opts.Limit {
+		page.Items = page.Items[:opts.Limit]
+	}
+	return page, nil
+}
+
+// record adds a version of contact numbered at the given time
+func (h *versions) record(at time.Time, contact models.Contact) {
+	h.items = append(h.items, models.Version{
+		ID:         primitive.NewObjectIDFromTimestamp(at),
+		Resource:   integrity.Contacts,
+		DocumentID: contact.ID,
+		Action:     models.VersionUpdated,
+	})
+}
+
+// TestPollReadsLateVersions checks a poll finds a version numbered before
+// the last one it read, as one from an instance whose clock is behind is,
+// and does not send the others again
+func TestPollReadsLateVersions(t *testing.T) {
+	ctx := context.Background()
+	repos := data.NewMemoryRepositories()
+	history := &versions{}
+	repos.History = history
+	streamer := NewStreamer(repos, config.StreamConfig{
+		PollInterval:   config.Duration{Duration: time.Second},
+		PollLookback:   config.Duration{Duration: time.Minute},
+		TokenRetention: config.Duration{Duration: time.Hour},
+	})
+	now := time.Now()
+	streamer.Now = func() time.Time { return now }
+
+	userID := primitive.NewObjectID()
+	var contacts []models.Contact
+	for i := 0; i < 3; i++ {
+		contact := models.Contact{ID: primitive.NewObjectID(), UserID: userID}
+		if err := repos.Contacts.Insert(ctx, contact); err != nil {
+			t.Fatal(err)
+		}
+		contacts = append(contacts, contact)
+	}
+	history.record(now.Add(-10*time.Second), contacts[0])
+
+	feed, _, err := streamer.Open(ctx, userID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll := func() []Event {
+		t.Helper()
+		now = now.Add(time.Second)
+		events, err := feed.Next(ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return events
+	}
+
+	if events := poll(); len(events) != 0 {
+		t.Errorf("the first poll sent %v, from before the feed opened", events)
+	}
+	history.record(now, contacts[1])
+	if events := poll(); len(events) != 1 || events[0].Change.ID != contacts[1].ID {
+		t.Errorf("events = %v, want an update of %s", events, contacts[1].ID.Hex())
+	}
+	history.record(now.Add(-20*time.Second), contacts[2])
+	if events := poll(); len(events) != 1 || events[0].Change.ID != contacts[2].ID {
+		t.Errorf("events = %v, want the late update of %s alone", events, contacts[2].ID.Hex())
+	}
+	if events := poll(); len(events) != 0 {
+		t.Errorf("a poll with nothing new sent %v", events)
+	}
+}
@@ -0,0 +1,230 @@
+// Package stream feeds the live update stream: the changes to the users,
+// emojis, contacts and businesses a user may see. Changes are read from a
+// MongoDB change stream where the deployment has them and found by polling
+// where it does not, as on a standalone server.
+//
+// A user sees their own user record, every emoji and the contacts and
+// businesses they own. Each event carries an ID to resume after; the point it
+// names is stored as a token, so a client reconnecting with Last-Event-ID
+// picks up where it left off.
+package stream
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/config"
+	"usermanagement/data"
+	"usermanagement/models"
+)
+
+// Event types besides the change operations
+const (
+	// EventReady is sent once the feed is open
+	EventReady = "ready"
+	// EventReset is sent when Last-Event-ID cannot be resumed from, so the
+	// client reloads what it shows instead of waiting for the changes it missed
+	EventReset = "reset"
+)
+
+// Event is one event of the live update stream
+type Event struct {
+	// ID is what a client sends as Last-Event-ID to resume after the event.
+	// It is empty for an event that can only be resumed after along with the
+	// ones following it.
+	ID string
+	// Type is ready, reset or the operation of Change
+	Type   string
+	Change data.Change
+}
+
+// Feed produces the events of one client's stream
+type Feed interface {
+	// Next waits up to wait for changes and returns them, or none when there
+	// were none
+	Next(ctx context.Context, wait time.Duration) ([]Event, error)
+	Close(ctx context.Context) error
+}
+
+// Streamer opens feeds
+type Streamer struct {
+	Changes    data.ChangeStream
+	Tokens     data.StreamTokenRepository
+	Users      data.UserRepository
+	Emojis     data.EmojiRepository
+	Contacts   data.ContactRepository
+	Businesses data.BusinessRepository
+	History    data.HistoryRepository
+
+	PollInterval   time.Duration
+	PollLookback   time.Duration
+	TokenRetention time.Duration
+	Now            func() time.Time
+}
+
+func NewStreamer(repos *data.Repositories, cfg config.StreamConfig) *Streamer {
+	return &Streamer{
+		Changes:        repos.Changes,
+		Tokens:         repos.StreamTokens,
+		Users:          repos.Users,
+		Emojis:         repos.Emojis,
+		Contacts:       repos.Contacts,
+		Businesses:     repos.Businesses,
+		History:        repos.History,
+		PollInterval:   cfg.PollInterval.Duration,
+		PollLookback:   cfg.PollLookback.Duration,
+		TokenRetention: cfg.TokenRetention.Duration,
+		Now:            time.Now,
+	}
+}
+
+// Open starts a feed of the changes userID may see after the event
+// lastEventID names, or from now on when it is empty. It also returns the
+// events to send before any change: reset when lastEventID cannot be resumed
+// from, then ready.
+func (s *Streamer) Open(ctx context.Context, userID primitive.ObjectID, lastEventID string) (Feed, []Event, error) {
+	var first []Event
+	token, resumed, err := s.resumePoint(ctx, userID, lastEventID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if lastEventID != "" && !resumed {
+		first = append(first, Event{Type: EventReset})
+	}
+
+	cursor, err := s.Changes.Open(ctx, userID, token.Resume)
+	if err == data.ErrResumeLost {
+		first = append(first, Event{Type: EventReset})
+		resumed = false
+		cursor, err = s.Changes.Open(ctx, userID, nil)
+	}
+	if err == data.ErrNoChangeStreams {
+		return s.openPolling(ctx, userID, token, resumed, first)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if resumed && token.Resume == nil {
+		// the token is a polling position from before the deployment had
+		// change streams
+		first = append(first, Event{Type: EventReset})
+		resumed = false
+	}
+	feed := &changeFeed{streamer: s, userID: userID, cursor: cursor}
+	ready := Event{Type: EventReady}
+	if !resumed {
+		if ready.ID, err = feed.save(ctx); err != nil {
+			cursor.Close(ctx)
+			return nil, nil, err
+		}
+	}
+	return feed, append(first, ready), nil
+}
+
+func (s *Streamer) openPolling(ctx context.Context, userID primitive.ObjectID, token models.StreamToken, resumed bool, first []Event) (Feed, []Event, error) {
+	if resumed && token.After == nil {
+		// the token is a change stream position the deployment can no longer read from
+		first = append(first, Event{Type: EventReset})
+		resumed = false
+	}
+
+	feed := &pollFeed{streamer: s, userID: userID}
+	ready := Event{Type: EventReady}
+	if resumed {
+		// look for what changed while the client was away straight away
+		feed.after = *token.After
+		feed.nextPoll = s.Now()
+	} else {
+		after, err := s.latest(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if feed.seen, err = s.window(ctx, after); err != nil {
+			return nil, nil, err
+		}
+		feed.after = after
+		feed.nextPoll = s.Now().Add(s.PollInterval)
+		if ready.ID, err = s.save(ctx, models.StreamToken{UserID: userID, After: &after}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return feed, append(first, ready), nil
+}
+
+// resumePoint finds the token lastEventID names, reporting false when there
+// is none the user can resume from
+func (s *Streamer) resumePoint(ctx context.Context, userID primitive.ObjectID, lastEventID string) (models.StreamToken, bool, error) {
+	if lastEventID == "" {
+		return models.StreamToken{}, false, nil
+	}
+	id, err := primitive.ObjectIDFromHex(lastEventID)
+	if err != nil {
+		return models.StreamToken{}, false, nil
+	}
+	token, err := s.Tokens.FindByID(ctx, id)
+	if errors.Is(err, data.ErrNotFound) {
+		return models.StreamToken{}, false, nil
+	} else if err != nil {
+		return models.StreamToken{}, false, err
+	}
+	if token.UserID != userID || !token.ExpiresAt.After(s.Now()) {
+		return models.StreamToken{}, false, nil
+	}
+	return token, true, nil
+}
+
+// save stores a token for the user to resume from and returns its event ID
+func (s *Streamer) save(ctx context.Context, token models.StreamToken) (string, error) {
+	now := s.Now()
+	token.ID = primitive.NewObjectID()
+	token.CreatedDate = now
+	token.ExpiresAt = now.Add(s.TokenRetention)
+	if err := s.Tokens.Insert(ctx, token); err != nil {
+		return "", err
+	}
+	return token.ID.Hex(), nil
+}
+
+// changeFeed reads a change stream
+type changeFeed struct {
+	streamer *Streamer
+	userID   primitive.ObjectID
+	cursor   data.ChangeCursor
+}
+
+func (f *changeFeed) Next(ctx context.Context, wait time.Duration) ([]Event, error) {
+	deadline := f.streamer.Now().Add(wait)
+	var events []Event
+	for {
+		change, ok, err := f.cursor.TryNext(ctx)
+		if err != nil {
+			return events, err
+		}
+		if ok {
+			id, err := f.save(ctx)
+			if err != nil {
+				return events, err
+			}
+			events = append(events, Event{ID: id, Type: change.Op, Change: change})
+			continue
+		}
+		if len(events) > 0 || !f.streamer.Now().Before(deadline) {
+			return events, nil
+		}
+	}
+}
+
+// save stores the stream's position after the last change read
+func (f *changeFeed) save(ctx context.Context) (string, error) {
+	resume := f.cursor.Token()
+	if resume == nil {
+		return "", nil
+	}
+	return f.streamer.save(ctx, models.StreamToken{UserID: f.userID, Resume: resume})
+}
+
+func (f *changeFeed) Close(ctx context.Context) error {
+	return f.cursor.Close(ctx)
+}
@@ -0,0 +1,56 @@
+// Package trash purges deletes for good once they have been in the trash
+// longer than the retention.
+package trash
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"usermanagement/config"
+	"usermanagement/data"
+	"usermanagement/leader"
+)
+
+// lockName identifies the leader lock shared by every instance
+const lockName = "trash-purger"
+
+// Purger periodically purges the trash. Only the instance holding the leader
+// lock does any work.
+type Purger struct {
+	Deletes data.Deleter
+	Leader  *leader.Loop
+
+	Retention time.Duration
+
+	Now func() time.Time
+}
+
+func NewPurger(repos *data.Repositories, cfg config.TrashConfig) *Purger {
+	return &Purger{
+		Deletes:   repos.Deletes,
+		Leader:    leader.New(lockName, repos.Locks, cfg.PurgeInterval.Duration, cfg.LockTTL.Duration),
+		Retention: cfg.Retention.Duration,
+		Now:       time.Now,
+	}
+}
+
+// Run purges the trash while this instance leads, until ctx is cancelled
+func (p *Purger) Run(ctx context.Context) {
+	p.Leader.Run(ctx, p.tick)
+}
+
+func (p *Purger) tick(ctx context.Context) {
+	purged, err := p.RunOnce(ctx)
+	if err != nil {
+		log.Printf("trash purger: %v", err)
+	}
+	if purged > 0 {
+		log.Printf("trash purger: purged %d deletes", purged)
+	}
+}
+
+// RunOnce purges every delete past the retention and returns how many were purged
+func (p *Purger) RunOnce(ctx context.Context) (int, error) {
+	return p.Deletes.Purge(ctx, p.Now().Add(-p.Retention))
+}
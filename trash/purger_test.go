@@ -0,0 +1,42 @@
+package trash
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/data"
+	"usermanagement/integrity"
+	"usermanagement/models"
+)
+
+// TestRunOnce checks a delete is only purged once it is past the retention,
+// and can no longer be restored after
+func TestRunOnce(t *testing.T) {
+	ctx := context.Background()
+	repos := data.NewMemoryRepositories()
+	contact := models.Contact{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID(), Name: "Grace"}
+	if err := repos.Contacts.Insert(ctx, contact); err != nil {
+		t.Fatal(err)
+	}
+	deleted := time.Now()
+	opts := data.DeleteOptions{Options: integrity.Options{Policies: integrity.DefaultPolicies}}
+	if _, err := repos.Deletes.Delete(ctx, integrity.Contacts, contact.ID, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	purger := &Purger{Deletes: repos.Deletes, Retention: time.Hour}
+	purger.Now = func() time.Time { return deleted.Add(59 * time.Minute) }
+	if purged, err := purger.RunOnce(ctx); err != nil || purged != 0 {
+		t.Errorf("within the retention: purged %d, %v; want none", purged, err)
+	}
+	purger.Now = func() time.Time { return deleted.Add(61 * time.Minute) }
+	if purged, err := purger.RunOnce(ctx); err != nil || purged != 1 {
+		t.Errorf("past the retention: purged %d, %v; want 1", purged, err)
+	}
+	if _, err := repos.Deletes.Restore(ctx, integrity.Contacts, contact.ID); !errors.Is(err, data.ErrNotFound) {
+		t.Errorf("restoring a purged contact: %v, want %v", err, data.ErrNotFound)
+	}
+}
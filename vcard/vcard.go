@@ -0,0 +1,351 @@
+// Package vcard converts contacts to and from vCard 4.0 (RFC 6350). Decoding
+// also accepts the common parts of vCard 3.0 and 2.1, which is what most
+// phones and address books still export.
+package vcard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"usermanagement/models"
+)
+
+// maxLineOctets is the folding limit from RFC 6350 section 3.2
+const maxLineOctets = 75
+
+// revLayout is the timestamp form used by REV
+const revLayout = "20060102T150405Z"
+
+// Encode writes contact as one vCard. org, when set, is written as the
+// organization the contact belongs to.
+func Encode(w io.Writer, contact models.Contact, org string) error {
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeFolded(bw, name+":"+value)
+	}
+
+	line("BEGIN", "VCARD")
+	line("VERSION", "4.0")
+	line("UID;VALUE=text", contact.ID.Hex())
+	line("FN", escape(contact.Name))
+	family, given := splitName(contact.Name)
+	line("N", escape(family)+";"+escape(given)+";;;")
+	if contact.JobTitle != "" {
+		line("TITLE", escape(contact.JobTitle))
+	}
+	if org != "" {
+		line("ORG", escape(org))
+	}
+	if contact.CellPhone != "" {
+		line("TEL;TYPE=cell", escape(contact.CellPhone))
+	}
+	if contact.WorkPhone != "" {
+		line("TEL;TYPE=work", escape(contact.WorkPhone))
+	}
+	if contact.Email != "" {
+		line("EMAIL", escape(contact.Email))
+	}
+	if contact.Street != "" || contact.City != "" || contact.State != "" || contact.Zip != "" {
+		line("ADR", ";;"+escape(contact.Street)+";"+escape(contact.City)+";"+escape(contact.State)+";"+escape(contact.Zip)+";")
+	}
+	if contact.Latitude != 0 || contact.Longitude != 0 {
+		line("GEO", "geo:"+strconv.FormatFloat(contact.Latitude, 'f', -1, 64)+","+strconv.FormatFloat(contact.Longitude, 'f', -1, 64))
+	}
+	if contact.Description != "" {
+		line("NOTE", escape(contact.Description))
+	}
+	if !contact.UpdatedDate.IsZero() {
+		line("REV", contact.UpdatedDate.UTC().Format(revLayout))
+	}
+	line("END", "VCARD")
+	return bw.Flush()
+}
+
+// Card is one decoded vCard. Err is set when the card could not be read, so
+// one broken card does not hide the others in the file.
+type Card struct {
+	Contact models.Contact
+	Err     error
+}
+
+// Decode reads every card in r. Only the properties a contact can hold are
+// kept; UID, ORG and anything else are ignored.
+func Decode(r io.Reader) ([]Card, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var cards []Card
+	var current *Card
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		p, err := parseLine(l)
+		if err != nil {
+			if current != nil && current.Err == nil {
+				current.Err = err
+			}
+			continue
+		}
+
+		switch {
+		case p.name == "BEGIN" && strings.EqualFold(p.value, "VCARD"):
+			if current != nil {
+				current.Err = errors.New("card is missing END:VCARD")
+				cards = append(cards, *current)
+			}
+			current = &Card{}
+		case p.name == "END" && strings.EqualFold(p.value, "VCARD"):
+			if current == nil {
+				continue
+			}
+			if current.Err == nil && current.Contact.Name == "" {
+				current.Err = errors.New("card has no FN or N")
+			}
+			cards = append(cards, *current)
+			current = nil
+		case current != nil && current.Err == nil:
+			current.Err = apply(&current.Contact, p)
+		}
+	}
+	if current != nil {
+		current.Err = errors.New("card is missing END:VCARD")
+		cards = append(cards, *current)
+	}
+	if len(cards) == 0 {
+		return nil, errors.New("no BEGIN:VCARD found")
+	}
+	return cards, nil
+}
+
+// property is one content line: its name, TYPE parameter values and raw value
+type property struct {
+	name  string
+	types []string
+	value string
+}
+
+func (p property) hasType(t string) bool {
+	for _, have := range p.types {
+		if strings.EqualFold(have, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply copies a property into the contact
+func apply(c *models.Contact, p property) error {
+	switch p.name {
+	case "FN":
+		c.Name = unescape(p.value)
+	case "N":
+		if c.Name == "" {
+			parts := splitComponents(p.value)
+			var names []string
+			for _, i := range []int{3, 1, 2, 0, 4} { // prefix, given, additional, family, suffix
+				if i < len(parts) && parts[i] != "" {
+					names = append(names, parts[i])
+				}
+			}
+			c.Name = strings.Join(names, " ")
+		}
+	case "TITLE":
+		c.JobTitle = unescape(p.value)
+	case "EMAIL":
+		if c.Email == "" {
+			c.Email = unescape(p.value)
+		}
+	case "TEL":
+		phone := strings.TrimPrefix(unescape(p.value), "tel:")
+		switch {
+		case p.hasType("cell") && c.CellPhone == "":
+			c.CellPhone = phone
+		case p.hasType("work") && c.WorkPhone == "":
+			c.WorkPhone = phone
+		case c.CellPhone == "":
+			c.CellPhone = phone
+		case c.WorkPhone == "":
+			c.WorkPhone = phone
+		}
+	case "ADR":
+		parts := splitComponents(p.value)
+		for len(parts) < 7 {
+			parts = append(parts, "")
+		}
+		c.Street, c.City, c.State, c.Zip = parts[2], parts[3], parts[4], parts[5]
+	case "GEO":
+		return applyGeo(c, p.value)
+	case "NOTE":
+		c.Description = unescape(p.value)
+	case "REV":
+		if rev, err := time.Parse(revLayout, p.value); err == nil {
+			c.UpdatedDate = rev
+		}
+	}
+	return nil
+}
+
+// applyGeo reads a 4.0 geo: URI or a 3.0 "lat;lng" pair
+func applyGeo(c *models.Contact, value string) error {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "geo:")
+	if i := strings.IndexByte(value, ';'); i >= 0 && strings.Contains(value, ",") {
+		value = value[:i] // drop geo URI parameters such as ;u=35
+	}
+	lat, lng, ok := strings.Cut(value, ",")
+	if !ok {
+		lat, lng, ok = strings.Cut(value, ";")
+	}
+	if !ok {
+		return fmt.Errorf("GEO %q is not a latitude and longitude", value)
+	}
+	var errLat, errLng error
+	c.Latitude, errLat = strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	c.Longitude, errLng = strconv.ParseFloat(strings.TrimSpace(lng), 64)
+	if errLat != nil || errLng != nil {
+		return fmt.Errorf("GEO %q is not a latitude and longitude", value)
+	}
+	return nil
+}
+
+// unfold reads r into logical lines, joining folded continuation lines
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var lines []string
+	for scanner.Scan() {
+		l := strings.TrimSuffix(scanner.Text(), "\r")
+		if len(lines) == 0 {
+			l = strings.TrimPrefix(l, "\ufeff")
+		}
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines, scanner.Err()
+}
+
+// parseLine splits "group.NAME;PARAM=a,b:value" into its parts. Parameter
+// values may be quoted, so the value starts at the first unquoted colon.
+func parseLine(l string) (property, error) {
+	quoted := false
+	colon := -1
+	for i, r := range l {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return property{}, fmt.Errorf("line %q has no value", l)
+	}
+
+	p := property{value: l[colon+1:]}
+	head := strings.Split(l[:colon], ";")
+	name := head[0]
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	p.name = strings.ToUpper(name)
+
+	for _, param := range head[1:] {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			// vCard 2.1 writes bare types, as in TEL;CELL:...
+			p.types = append(p.types, param)
+			continue
+		}
+		if strings.EqualFold(key, "TYPE") {
+			p.types = append(p.types, strings.Split(strings.Trim(value, `"`), ",")...)
+		}
+	}
+	return p, nil
+}
+
+// splitComponents splits a structured value on unescaped semicolons and
+// unescapes each component
+func splitComponents(value string) []string {
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value):
+			b.WriteByte(value[i])
+			b.WriteByte(value[i+1])
+			i++
+		case value[i] == ';':
+			parts = append(parts, unescape(b.String()))
+			b.Reset()
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return append(parts, unescape(b.String()))
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+
+// escape makes text safe for a property value
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// unescape reverses escape, also accepting \N for a newline
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// splitName guesses family and given names, treating the last word as the family name
+func splitName(name string) (family, given string) {
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		return "", ""
+	}
+	return words[len(words)-1], strings.Join(words[:len(words)-1], " ")
+}
+
+// writeFolded writes a content line, folding it at 75 octets without
+// splitting a UTF-8 sequence
+func writeFolded(w *bufio.Writer, l string) {
+	limit := maxLineOctets
+	for len(l) > limit {
+		cut := limit
+		for cut > 0 && l[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(l[:cut])
+		w.WriteString("\r\n ")
+		l = l[cut:]
+		limit = maxLineOctets - 1
+	}
+	w.WriteString(l)
+	w.WriteString("\r\n")
+}
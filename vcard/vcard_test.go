@@ -0,0 +1,128 @@
+package vcard
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/models"
+)
+
+func TestRoundTrip(t *testing.T) {
+	contact := models.Contact{
+		ID:          primitive.NewObjectID(),
+		Name:        "Ada Augusta King",
+		JobTitle:    "Analyst; engines, mostly",
+		CellPhone:   "+12025550143",
+		WorkPhone:   "+442079460958",
+		Email:       "ada@example.com",
+		Street:      "12 St. James's Square",
+		City:        "London",
+		State:       "",
+		Zip:         "SW1Y 4JH",
+		Latitude:    51.5074,
+		Longitude:   -0.1346,
+		Description: "Met at the Royal Society.\nFollow up about the notes \\ diagrams. " + strings.Repeat("Ünïcödé ", 12),
+		UpdatedDate: time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC),
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, contact, "Analytical Engines, Ltd"); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(l) > maxLineOctets {
+			t.Errorf("line of %d octets is not folded: %q", len(l), l)
+		}
+	}
+
+	cards, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].Err != nil {
+		t.Fatalf("Decode = %+v, want one card", cards)
+	}
+	got := cards[0].Contact
+	contact.ID = primitive.NilObjectID // UID is not decoded
+	if got != contact {
+		t.Errorf("round trip gave\n%+v\nwant\n%+v", got, contact)
+	}
+}
+
+// TestDecodeOlderVersions reads the vCard 3.0 and 2.1 forms phones export
+func TestDecodeOlderVersions(t *testing.T) {
+	input := strings.Join([]string{
+		"\ufeffBEGIN:VCARD",
+		"VERSION:3.0",
+		"N:Lovelace;Ada;;Countess;",
+		"item1.EMAIL;TYPE=INTERNET:ada@example.com",
+		"TEL;TYPE=\"work,voice\":+442079460958",
+		"TEL;TYPE=cell:+12025550143",
+		"ADR;TYPE=home:;;1 Main St;Springfield;IL;62701;USA",
+		"GEO:39.78;-89.65",
+		"NOTE:a long note that was folded",
+		"  across two lines",
+		"END:VCARD",
+		"BEGIN:VCARD",
+		"VERSION:2.1",
+		"FN:Charles Babbage",
+		"TEL;CELL:555 0100",
+		"TEL:555 0101",
+		"END:VCARD",
+	}, "\r\n")
+
+	cards, err := Decode(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 2 {
+		t.Fatalf("got %d cards, want 2", len(cards))
+	}
+	want := models.Contact{
+		Name: "Countess Ada Lovelace", Email: "ada@example.com",
+		CellPhone: "+12025550143", WorkPhone: "+442079460958",
+		Street: "1 Main St", City: "Springfield", State: "IL", Zip: "62701",
+		Latitude: 39.78, Longitude: -89.65, Description: "a long note that was folded across two lines",
+	}
+	if cards[0].Err != nil || cards[0].Contact != want {
+		t.Errorf("3.0 card = %+v, %v, want %+v", cards[0].Contact, cards[0].Err, want)
+	}
+	want = models.Contact{Name: "Charles Babbage", CellPhone: "555 0100", WorkPhone: "555 0101"}
+	if cards[1].Err != nil || cards[1].Contact != want {
+		t.Errorf("2.1 card = %+v, %v, want %+v", cards[1].Contact, cards[1].Err, want)
+	}
+}
+
+// TestDecodeBrokenCards checks a broken card is reported without hiding the
+// cards around it
+func TestDecodeBrokenCards(t *testing.T) {
+	input := strings.Join([]string{
+		"BEGIN:VCARD", "FN:No End",
+		"BEGIN:VCARD", "TEL:+12025550143", "END:VCARD",
+		"BEGIN:VCARD", "FN:Bad Geo", "GEO:somewhere", "END:VCARD",
+		"BEGIN:VCARD", "FN:Fine", "END:VCARD",
+	}, "\n")
+
+	cards, err := Decode(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 4 {
+		t.Fatalf("got %d cards, want 4", len(cards))
+	}
+	for i, wantErr := range []bool{true, true, true, false} {
+		if (cards[i].Err != nil) != wantErr {
+			t.Errorf("card %d: err = %v, want an error: %v", i, cards[i].Err, wantErr)
+		}
+	}
+	if cards[3].Contact.Name != "Fine" {
+		t.Errorf("card 3 name = %q, want Fine", cards[3].Contact.Name)
+	}
+
+	if _, err := Decode(strings.NewReader("FN:Nobody\n")); err == nil {
+		t.Error("Decode without BEGIN:VCARD: want an error")
+	}
+}
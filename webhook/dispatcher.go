@@ -0,0 +1,295 @@
+// Package webhook posts the events in the outbox to the webhooks subscribed to
+// them. Each post is signed so the receiver can tell it came from us:
+//
+//	X-Webhook-Timestamp: the Unix time of the try
+//	X-Webhook-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>" keyed by the webhook secret>
+//
+// A post is delivered when the receiver answers 2xx. Failed posts are retried
+// with exponential backoff until the attempts run out.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"usermanagement/config"
+	"usermanagement/data"
+	"usermanagement/leader"
+	"usermanagement/models"
+)
+
+// lockName identifies the leader lock shared by every instance
+const lockName = "webhook-dispatcher"
+
+// workers bounds how many posts are in flight at once
+const workers = 8
+
+// maxResponse is how much of a response body the delivery log keeps
+const maxResponse = 1024
+
+// Sign returns the X-Webhook-Signature header value for a payload posted at timestamp
+func Sign(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher periodically queues a delivery of each new event to every webhook
+// subscribed to it and posts the deliveries that are due. Only the instance
+// holding the leader lock does any work.
+type Dispatcher struct {
+	Webhooks   data.WebhookRepository
+	Outbox     data.OutboxRepository
+	Deliveries data.DeliveryRepository
+	Leader     *leader.Loop
+	Client     *http.Client
+
+	// BatchSize bounds how many deliveries are posted per tick
+	BatchSize   int
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+
+	Now func() time.Time
+}
+
+func NewDispatcher(repos *data.Repositories, cfg config.WebhookConfig) *Dispatcher {
+	return &Dispatcher{
+		Webhooks:    repos.Webhooks,
+		Outbox:      repos.Outbox,
+		Deliveries:  repos.Deliveries,
+		Leader:      leader.New(lockName, repos.Locks, cfg.Interval.Duration, cfg.LockTTL.Duration),
+		Client:      NewClient(cfg.Timeout.Duration),
+		BatchSize:   cfg.BatchSize,
+		MaxAttempts: cfg.MaxAttempts,
+		Backoff:     cfg.Backoff.Duration,
+		MaxBackoff:  cfg.MaxBackoff.Duration,
+		Now:         time.Now,
+	}
+}
+
+// Run posts deliveries while this instance leads, until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.Leader.Run(ctx, d.tick)
+}
+
+func (d *Dispatcher) tick(ctx context.Context) {
+	sent, err := d.RunOnce(ctx)
+	if err != nil {
+		log.Printf("webhooks: %v", err)
+	}
+	if sent > 0 {
+		log.Printf("webhooks: posted %d deliveries", sent)
+	}
+}
+
+// RunOnce queues deliveries for every event in the outbox, then posts up to
+// BatchSize due deliveries and returns how many were posted
+func (d *Dispatcher) RunOnce(ctx context.Context) (int, error) {
+	webhooks, err := d.activeWebhooks(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("listing webhooks: %w", err)
+	}
+	if err := d.dispatch(ctx, webhooks); err != nil {
+		return 0, err
+	}
+	return d.send(ctx, webhooks)
+}
+
+func (d *Dispatcher) activeWebhooks(ctx context.Context) (map[primitive.ObjectID]models.Webhook, error) {
+	webhooks := make(map[primitive.ObjectID]models.Webhook)
+	opts := data.ListOptions{
+		Filters: []data.Filter{{Field: "active", Op: data.OpEq, Value: true}},
+		Limit:   d.BatchSize,
+	}
+	for {
+		page, err := d.Webhooks.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, webhook := range page.Items {
+			webhooks[webhook.ID] = webhook
+		}
+		if page.NextCursor == "" {
+			return webhooks, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// dispatch queues a delivery of each event in the outbox to every webhook
+// subscribed to its type. An event is only marked dispatched once all of its
+// deliveries are queued, and queueing is idempotent, so a crash part way
+// through queues the rest on the next tick.
+func (d *Dispatcher) dispatch(ctx context.Context, webhooks map[primitive.ObjectID]models.Webhook) error {
+	opts := data.ListOptions{
+		Filters: []data.Filter{{Field: "dispatched", Op: data.OpEq, Value: false}},
+		Sort:    "created_date",
+		Limit:   d.BatchSize,
+	}
+	for {
+		page, err := d.Outbox.List(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("listing the outbox: %w", err)
+		}
+		for _, event := range page.Items {
+			if err := d.queue(ctx, event, webhooks); err != nil {
+				return fmt.Errorf("event %s: %w", event.ID.Hex(), err)
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+func (d *Dispatcher) queue(ctx context.Context, event models.Event, webhooks map[primitive.ObjectID]models.Webhook) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	now := d.Now()
+	for _, webhook := range webhooks {
+		if !webhook.Wants(event.Type) {
+			continue
+		}
+		err := d.Deliveries.Queue(ctx, models.Delivery{
+			ID:          primitive.NewObjectID(),
+			WebhookID:   webhook.ID,
+			EventID:     event.ID,
+			EventType:   event.Type,
+			Payload:     string(payload),
+			Status:      models.DeliveryPending,
+			NextAttempt: now,
+			Log:         []models.DeliveryAttempt{},
+			CreatedDate: now,
+			UpdatedDate: now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return d.Outbox.MarkDispatched(ctx, event.ID)
+}
+
+// send posts the deliveries that are due, a few at a time
+func (d *Dispatcher) send(ctx context.Context, webhooks map[primitive.ObjectID]models.Webhook) (int, error) {
+	page, err := d.Deliveries.List(ctx, data.ListOptions{
+		Filters: []data.Filter{
+			{Field: "status", Op: data.OpEq, Value: models.DeliveryPending},
+			{Field: "next_attempt", Op: data.OpLte, Value: d.Now()},
+		},
+		Sort:  "next_attempt",
+		Limit: d.BatchSize,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("listing due deliveries: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	slots := make(chan struct{}, workers)
+	for _, delivery := range page.Items {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(delivery models.Delivery) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := d.deliver(ctx, delivery, webhooks); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("delivery %s: %w", delivery.ID.Hex(), err)
+				}
+				mu.Unlock()
+			}
+		}(delivery)
+	}
+	wg.Wait()
+	return len(page.Items), firstErr
+}
+
+// deliver makes one try at posting a delivery and records its outcome
+func (d *Dispatcher) deliver(ctx context.Context, delivery models.Delivery, webhooks map[primitive.ObjectID]models.Webhook) error {
+	var attempt models.DeliveryAttempt
+	webhook, ok := webhooks[delivery.WebhookID]
+	if ok {
+		attempt = d.post(ctx, webhook, delivery)
+	} else {
+		attempt = models.DeliveryAttempt{At: d.Now(), Error: "the webhook was deleted or deactivated"}
+	}
+
+	delivery.Attempts++
+	delivery.Log = append(delivery.Log, attempt)
+	delivery.UpdatedDate = attempt.At
+	switch {
+	case attempt.Error == "" && attempt.StatusCode >= 200 && attempt.StatusCode < 300:
+		delivery.Status = models.DeliverySucceeded
+	case !ok || delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = models.DeliveryFailed
+	default:
+		delivery.NextAttempt = attempt.At.Add(d.backoff(delivery.Attempts))
+	}
+	return d.Deliveries.Update(ctx, delivery)
+}
+
+func (d *Dispatcher) post(ctx context.Context, webhook models.Webhook, delivery models.Delivery) (attempt models.DeliveryAttempt) {
+	start := d.Now()
+	attempt.At = start
+	defer func() { attempt.DurationMS = d.Now().Sub(start).Milliseconds() }()
+
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "usermanagement-webhooks")
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID.Hex())
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", Sign(webhook.Secret, timestamp, payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+	// the status decides the outcome, so a body cut short is kept as it is
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	attempt.Response = string(body)
+	return attempt
+}
+
+// backoff is the wait before the next try once attempts tries have failed,
+// doubling each time up to MaxBackoff
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.Backoff
+	for i := 1; i < attempts && wait < d.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.MaxBackoff {
+		wait = d.MaxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,22 @@
+package webhook
+
+import "testing"
+
+func TestSign(t *testing.T) {
+	payload := []byte(`{"type":"contact.created"}`)
+	// computed with: printf '1700000000.<payload>' | openssl dgst -sha256 -hmac whsec_test
+	want := "sha256=efb10519197fe6f82cee9cbbea5de3ec803db1cbdac2137304e8d7cc2c816e8a"
+	if got := Sign("whsec_test", "1700000000", payload); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+
+	for name, got := range map[string]string{
+		"secret":    Sign("whsec_other", "1700000000", payload),
+		"timestamp": Sign("whsec_test", "1700000001", payload),
+		"payload":   Sign("whsec_test", "1700000000", []byte(`{"type":"contact.deleted"}`)),
+	} {
+		if got == want {
+			t.Errorf("another %s gives the same signature", name)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateTarget is returned for a webhook URL that reaches, or resolves
+// to, an address that is not on the public internet
+var ErrPrivateTarget = errors.New("url must reach a public address, not a loopback, link-local or private one")
+
+// sharedAddresses is the carrier-grade NAT range, private in all but name
+var sharedAddresses = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// public reports whether ip is a unicast address on the public internet.
+// Loopback, link-local, private, shared, unspecified, multicast and
+// broadcast addresses are not.
+func public(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddresses.Contains(ip)
+}
+
+// CheckURL resolves the host of a webhook URL and fails with
+// ErrPrivateTarget unless every address it has is public. The dispatcher
+// checks again when it dials, since the host may resolve differently then.
+func CheckURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !public(ip) {
+			return ErrPrivateTarget
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return errors.New("url host " + host + " cannot be resolved")
+	}
+	for _, addr := range addrs {
+		if !public(addr.IP) {
+			return ErrPrivateTarget
+		}
+	}
+	return nil
+}
+
+// NewClient returns the client deliveries are posted with. It only connects
+// to public addresses, checking each one as it dials so a host cannot pass
+// CheckURL and then resolve to an internal one, and it goes through no
+// proxy. A redirect is not followed: the 3xx answer fails the try.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !public(ip) {
+				return ErrPrivateTarget
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}